@@ -1,24 +1,52 @@
 package config
 
 import (
-	"encoding/json"
+	"crypto/tls"
+	"fmt"
 	"log"
+	"math"
+	"net/netip"
 	"os"
+	"strings"
 )
 
 // LogConfig contains configuration for the logging system
 type LogConfig struct {
-	DBPath string `json:"db_path"` // Path to the SQLite database file
+	DBPath string `json:"db_path"`         // Path to the SQLite database file
+	Level  string `json:"level,omitempty"` // Minimum log level: debug, info, warn, error
+
+	CompactIntervalHours int `json:"compact_interval_hours,omitempty"` // VACUUM the database on this schedule and after large deletes, 0 = disabled
+
+	JournalMode               string `json:"journal_mode,omitempty"`                // SQLite journal mode: WAL (default), DELETE, TRUNCATE, PERSIST, MEMORY, OFF
+	Synchronous               string `json:"synchronous,omitempty"`                 // SQLite synchronous level: OFF, NORMAL (default), FULL, EXTRA
+	CheckpointIntervalSeconds int    `json:"checkpoint_interval_seconds,omitempty"` // Checkpoint the WAL on this schedule instead of after every write, 0 = after every write
+
+	AccessLogPath        string `json:"access_log_path,omitempty"`         // File with one line per finished session, empty = disabled
+	AccessLogFormat      string `json:"access_log_format,omitempty"`       // combined (default) or json
+	AccessLogMaxSizeMB   int    `json:"access_log_max_size_mb,omitempty"`  // Rotate the access log once it reaches this size, 0 = no size limit
+	AccessLogRotateHours int    `json:"access_log_rotate_hours,omitempty"` // Rotate the access log after this many hours, 0 = no time limit
+	AccessLogMaxBackups  int    `json:"access_log_max_backups,omitempty"`  // Rotated access logs kept, 0 = keep all
+	AccessLogSourcePort  bool   `json:"access_log_source_port,omitempty"`  // Record the client's source port next to its IP in the access log
 }
 
 // ControlPanelConfig contains configuration for the web control panel
 type ControlPanelConfig struct {
 	Username string `json:"username"` // Username for authentication
 	Password string `json:"password"` // Password for authentication
+
+	APITokens []string `json:"api_tokens,omitempty"` // Bearer tokens that authorize /api/* requests without a session
+
+	ReadHeaderTimeout int `json:"read_header_timeout,omitempty"` // Seconds allowed to send request headers, defaults to 10
+	ReadTimeout       int `json:"read_timeout,omitempty"`        // Seconds allowed to read a whole request, defaults to 30
+	WriteTimeout      int `json:"write_timeout,omitempty"`       // Seconds allowed to write a response, defaults to 60
+	IdleTimeout       int `json:"idle_timeout,omitempty"`        // Seconds an idle keep-alive connection stays open, defaults to 120
+	MaxHeaderBytes    int `json:"max_header_bytes,omitempty"`    // Largest accepted request header, defaults to 64 KiB
 }
 
 type ProxyConfig struct {
 	Listen      string   `json:"listen"`
+	DisplayName string   `json:"display_name,omitempty"` // Label shown in the control panel, defaults to the description
+	Group       string   `json:"group,omitempty"`        // Community or group used to organize proxies and connections in the control panel
 	Description string   `json:"description"`
 	Remote      string   `json:"remote"`
 	LocalAddr   string   `json:"local_addr"` // Local address for outgoing connections
@@ -31,6 +59,75 @@ type ProxyConfig struct {
 	Auth        string   `json:"auth"` // none, whitelist, blacklist
 	Whitelist   []string `json:"whitelist"`
 	Blacklist   []string `json:"blacklist"`
+
+	MaxConnectionsPerClientIP int  `json:"max_connections_per_client_ip,omitempty"` // Limit of concurrent logins per client IP, 0 = unlimited
+	SlowConnectThresholdMs    int  `json:"slow_connect_threshold_ms,omitempty"`     // Warn when dial and login handshake take longer, 0 = disabled
+	KickDuplicateLogin        bool `json:"kick_duplicate_login,omitempty"`          // Disconnect an older session with the same username on login
+	RejectTransfers           bool `json:"reject_transfers,omitempty"`              // Refuse handshakes with the 1.20.5+ transfer intent instead of treating them as logins
+	LoginGraceMs              int  `json:"login_grace_ms,omitempty"`                // Time allowed for the login start after the handshake, defaults to 5000
+	LoginTimeoutMs            int  `json:"login_timeout_ms,omitempty"`              // Time from accept until the backend answers the login, 0 = unlimited
+	FirstByteTimeoutMs        int  `json:"first_byte_timeout_ms,omitempty"`         // Time the backend has to send anything after the login is forwarded, 0 = unlimited
+	KeepAliveIntervalMs       int  `json:"keepalive_interval_ms,omitempty"`         // Inject a keep-alive after this much idle time in the play phase, 0 = disabled
+	AutoReconnect             bool `json:"auto_reconnect,omitempty"`                // Redial the backend and replay the login when it fails before the login finished
+	ResolveViaLocalAddr       bool `json:"resolve_via_local_addr,omitempty"`        // Send DNS lookups for the remote from local_addr as well
+	StatusPoolSize            int  `json:"status_pool_size,omitempty"`              // Pre-dialed connections kept for real mode pings, 0 = disabled
+	StatusPoolIdleTimeoutMs   int  `json:"status_pool_idle_timeout_ms,omitempty"`   // How long a pre-dialed connection is kept, defaults to 10000
+	PingTimeoutMs             int  `json:"ping_timeout_ms,omitempty"`               // Time allowed for a real mode ping before the proxy's own status is served, 0 = unlimited
+	MaxHostnameLength         int  `json:"max_hostname_length,omitempty"`           // Longest accepted handshake hostname, defaults to 255
+	TruncateLongHostnames     bool `json:"truncate_long_hostnames,omitempty"`       // Truncate longer hostnames with a warning instead of closing the connection
+	MaxConcurrentLogins       int  `json:"max_concurrent_logins,omitempty"`         // Logins dialing the backend or waiting for its answer at once, 0 = unlimited
+	LoginQueueMs              int  `json:"login_queue_ms,omitempty"`                // How long a login waits for a free slot before it is rejected as busy, 0 = rejected at once
+	ReservedSlots             int  `json:"reserved_slots,omitempty"`                // Slots of max_player only players in the whitelist may fill
+	PlayReconnectTimeoutMs    int  `json:"play_reconnect_timeout_ms,omitempty"`     // Hold players with keep-alives while logging in to a lost backend again for up to this long, 0 = disconnect them
+
+	FullPingDisplay string `json:"full_ping_display,omitempty"` // Ping players shown at capacity: real, motd, overflow, defaults to real
+	FullMotd        string `json:"full_motd,omitempty"`         // MOTD shown at capacity with full_ping_display motd
+
+	OnlineCountSource string `json:"online_count_source,omitempty"` // Online count shown in pings: proxy, backend (real ping_mode only), defaults to backend in real mode
+
+	UsernamePolicy string `json:"username_policy,omitempty"` // Usernames outside the Minecraft charset: allow, sanitize, reject, defaults to sanitize
+
+	StatusOverride []string `json:"status_override,omitempty"` // Fields of real pings replaced with the proxy's: description, favicon, version; empty = pass through
+	VersionName    string   `json:"version_name,omitempty"`    // Version name shown in pings, auto = the release of the ping's protocol, defaults to gomcproxy
+
+	LogVerbosity    string `json:"log_verbosity,omitempty"`     // Per-connection log lines: quiet, normal, verbose, defaults to normal
+	AcceptLogSample int    `json:"accept_log_sample,omitempty"` // Log the accept and close of 1 in N connections that never log in, 0 or 1 = all
+
+	ListenAllowlist []string `json:"listen_allowlist,omitempty"` // CIDRs or IPs allowed to connect, others are closed right after accept, empty = everyone
+
+	ProxyProtocolTrustedProxies []string `json:"proxy_protocol_trusted_proxies,omitempty"` // CIDRs or IPs whose PROXY protocol header is trusted, headers from others are rejected
+
+	TLSCertFile string `json:"tls_cert_file,omitempty"` // PEM certificate the listener terminates TLS with, a PROXY header is then read inside TLS; empty = plain TCP
+	TLSKeyFile  string `json:"tls_key_file,omitempty"`  // PEM private key of tls_cert_file
+
+	PacketCapture bool `json:"packet_capture,omitempty"` // Follow packet frames so the control panel can capture a connection's packet IDs and lengths
+
+	ChatInjection bool `json:"chat_injection,omitempty"` // Show broadcasts and drain notices in the chat of players in play
+
+	HandshakeConnectionID string `json:"handshake_connection_id,omitempty"` // Appended to the forwarded handshake address with {id} replaced by the connection ID, empty = disabled
+	StrictHandshake       bool   `json:"strict_handshake,omitempty"`        // Close connections whose handshake has an unknown next state, protocol out of range, port 0 or no hostname
+
+	ClientCompressionThreshold int `json:"client_compression_threshold,omitempty"` // Compress packets to the client of at least this many bytes when the backend does not, offline mode backends only, 0 = disabled
+
+	PublicHostname string `json:"public_hostname,omitempty"` // Shown instead of the looked up public IP in the control panel and the fake ping MOTD, e.g. na.example.com
+
+	FullOverflow int `json:"full_overflow,omitempty"` // Logins allowed beyond max_player before the proxy is full, a full counter is then checked against the open connections, 0 = none
+
+	OverflowRemote    string `json:"overflow_remote,omitempty"`     // Backend logins are forwarded to while the proxy is full instead of being disconnected, empty = disconnect
+	OverflowMaxPlayer int    `json:"overflow_max_player,omitempty"` // Players forwarded to overflow_remote at once, 0 = unlimited
+
+	CaptureLocale bool `json:"capture_locale,omitempty"` // Read the locale from the client's first Client Settings packet for the control panel's locale statistics
+
+	Mode              string `json:"mode,omitempty"`                // status_only answers pings and rejects every login without a backend, defaults to forwarding
+	StatusOnlyMessage string `json:"status_only_message,omitempty"` // Disconnect message of logins to a status_only proxy
+}
+
+// Label returns the name used for the proxy in the control panel
+func (c ProxyConfig) Label() string {
+	if c.DisplayName != "" {
+		return c.DisplayName
+	}
+	return c.Description
 }
 
 // Config represents the root configuration that can contain multiple proxy configurations
@@ -38,75 +135,409 @@ type Config struct {
 	Proxies      []ProxyConfig      `json:"proxies"`
 	Logging      LogConfig          `json:"logging"`
 	ControlPanel ControlPanelConfig `json:"control_panel"`
+	ReusePort    bool               `json:"reuse_port,omitempty"` // Set SO_REUSEPORT on proxy and balancer listeners
+
+	MaxTotalConnections int `json:"max_total_connections,omitempty"` // Players connected through all proxies together, 0 = unlimited
+
+	PausedLoginMessage string `json:"paused_login_message,omitempty"` // Disconnect message of logins while they are paused from the control panel
+
+	DisablePublicIPLookup bool   `json:"disable_public_ip_lookup,omitempty"` // Skip the ipinfo.io lookup for outbound interfaces
+	PublicIPLabel         string `json:"public_ip_label,omitempty"`          // Value reported as public IP when the lookup is disabled
+
+	HostOverrides map[string]string `json:"host_overrides,omitempty"` // Hostnames pinned to an IP or host[:port], checked before SRV and DNS lookups
+
+	BalancerOnAllUnhealthy   string   `json:"balancer_on_all_unhealthy,omitempty"`   // reject or besteffort (default) when every proxy is unhealthy
+	BalancerListenAllowlist  []string `json:"balancer_listen_allowlist,omitempty"`   // listen_allowlist of the load balancer
+	BalancerNoServersMessage string   `json:"balancer_no_servers_message,omitempty"` // MOTD and disconnect message when the balancer has no proxy for a client
+
+	BalancerProxyProtocolTrustedProxies []string `json:"balancer_proxy_protocol_trusted_proxies,omitempty"` // proxy_protocol_trusted_proxies of the load balancer
+
+	BalancerLoadWeight     float64  `json:"balancer_load_weight,omitempty"`     // Share of a proxy's free room in its balancer score, defaults to 0.6
+	BalancerCapacityWeight float64  `json:"balancer_capacity_weight,omitempty"` // Share of a proxy's part of the total max_player in its score, defaults to 0.4
+	BalancerCandidateBand  *float64 `json:"balancer_candidate_band,omitempty"`  // Proxies scoring within this fraction of the best are picked at random, 0 = always the best, defaults to 0.1
+
+	DisconnectWriteTimeoutMs int `json:"disconnect_write_timeout_ms,omitempty"` // Deadline for writing a disconnect message, defaults to 1000
+	DisconnectGraceMs        int `json:"disconnect_grace_ms,omitempty"`         // Longest wait for a client to close after a disconnect message, defaults to 200
+
+	ConnectionRateAlert         int    `json:"connection_rate_alert,omitempty"`          // New connections per minute that trigger an alert, 0 = disabled
+	ConnectionRateAlertCooldown int    `json:"connection_rate_alert_cooldown,omitempty"` // Seconds between alerts, defaults to 300
+	ConnectionRateWebhook       string `json:"connection_rate_webhook,omitempty"`        // URL that receives a JSON POST for each alert
+
+	HandshakeRateLimit         int `json:"handshake_rate_limit,omitempty"`          // Handshakes one client IP may send within the window before it is blocked, 0 = unlimited
+	HandshakeRateWindowSeconds int `json:"handshake_rate_window_seconds,omitempty"` // Sliding window of handshake_rate_limit, defaults to 10
+	HandshakeBlockSeconds      int `json:"handshake_block_seconds,omitempty"`       // How long an IP over handshake_rate_limit is blocked, defaults to 60
+
+	FlapThreshold     int `json:"flap_threshold,omitempty"`      // Connections from one client host:port within the window that flag it as flapping, 0 = disabled
+	FlapWindowSeconds int `json:"flap_window_seconds,omitempty"` // Sliding window of flap_threshold, defaults to 10
+
+	SelfTestOnStartup bool `json:"self_test_on_startup,omitempty"` // Run the -selftest checks at startup and log the results without exiting
 }
 
 // For backward compatibility
 type LegacyConfig ProxyConfig
 
+// ParseConfig reads the configuration from a file, or from the files of a
+// split configuration when path is a directory or a glob
 func ParseConfig(path string) *Config {
+	config, err := loadConfig(path)
+	if err != nil {
+		log.Fatalf("[ERROR] %s", err)
+		return nil
+	}
+	return config
+}
+
+// loadConfig reads, completes and validates the configuration at path
+func loadConfig(path string) (*Config, error) {
+	var config *Config
+	var err error
+	if isConfigSet(path) {
+		config, err = parseConfigSet(path)
+	} else {
+		config, err = parseConfigFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+	applyDefaults(config)
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return config, nil
+}
 
+// parseConfigFile reads a single config file in the multi-proxy or the legacy
+// single-proxy format. A multi-proxy config without proxies is an error.
+func parseConfigFile(path string) (*Config, error) {
 	bytes, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to read config %s: %s", path, err)
-		return nil
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
 	}
 
 	// First try to parse as new multi-proxy config
 	config := Config{}
-	err = json.Unmarshal(bytes, &config)
+	err = decodeConfig(bytes, &config)
+
+	// A config without proxies is only read as a legacy single-proxy config
+	// when it has the proxy's listen address at the top level, otherwise the
+	// legacy checks would report a misleading error
+	if err == nil && len(config.Proxies) == 0 && !isLegacyConfig(bytes) {
+		return nil, fmt.Errorf("no proxies configured in %s, add at least one to \"proxies\"", path)
+	}
 
 	// If no proxies defined or error occurred, try to parse as legacy single-proxy config
 	if err != nil || len(config.Proxies) == 0 {
 		var legacyConfig LegacyConfig
-		err = json.Unmarshal(bytes, &legacyConfig)
+		err = decodeConfig(bytes, &legacyConfig)
 		if err != nil {
-			log.Fatalf("[ERROR] Invalid JSON in config file: %s", err)
-			return nil
+			return nil, fmt.Errorf("invalid JSON in config file %s: %w", path, err)
 		}
 
 		// Convert legacy config to new format
 		proxyConfig := ProxyConfig(legacyConfig)
-		validateProxyConfig(&proxyConfig)
+		if err := proxyConfig.Validate(); err != nil {
+			return nil, err
+		}
 		config.Proxies = []ProxyConfig{proxyConfig}
 		log.Printf("[INFO] Loaded legacy config format with single proxy: listen=%s, remote=%s", proxyConfig.Listen, proxyConfig.Remote)
+
+		// The legacy format may still carry the top-level logging and control panel sections
+		var sections struct {
+			Logging      LogConfig          `json:"logging"`
+			ControlPanel ControlPanelConfig `json:"control_panel"`
+		}
+		if err := decodeConfig(bytes, &sections); err != nil {
+			log.Printf("[WARN] Ignoring logging and control_panel sections in legacy config: %s", err)
+		}
+		config.Logging = sections.Logging
+		config.ControlPanel = sections.ControlPanel
 	} else {
- 	// Validate each proxy config in the new format
- 	for i := range config.Proxies {
- 		validateProxyConfig(&config.Proxies[i])
- 		log.Printf("[INFO] Loaded proxy %d: listen=%s, remote=%s, auth=%s", 
- 			i+1, config.Proxies[i].Listen, config.Proxies[i].Remote, config.Proxies[i].Auth)
- 	}
-
- 	// Set default logging configuration if not provided
- 	if config.Logging.DBPath == "" {
- 		config.Logging.DBPath = "logs/mcproxy.db"
- 		log.Printf("[INFO] Using default logging database path: %s", config.Logging.DBPath)
- 	} else {
- 		log.Printf("[INFO] Using configured logging database path: %s", config.Logging.DBPath)
- 	}
-
- 	// Set default control panel configuration if not provided
- 	if config.ControlPanel.Username == "" {
- 		config.ControlPanel.Username = "admin"
- 		log.Printf("[INFO] Using default control panel username: %s", config.ControlPanel.Username)
- 	}
-
- 	if config.ControlPanel.Password == "" {
- 		config.ControlPanel.Password = "admin"
- 		log.Printf("[WARN] Using default control panel password. Please change it in the configuration file.")
- 	}
-	}
-
-	return &config
+		// Validate each proxy config in the new format
+		for i := range config.Proxies {
+			if err := config.Proxies[i].Validate(); err != nil {
+				return nil, err
+			}
+			log.Printf("[INFO] Loaded proxy %d: listen=%s, remote=%s, auth=%s",
+				i+1, config.Proxies[i].Listen, config.Proxies[i].Remote, config.Proxies[i].Auth)
+		}
+	}
+
+	// The access log is opened at startup, a bad option would otherwise only
+	// show up as a failed write once the first session ends
+	if err := config.Logging.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// isLegacyConfig reports whether data is a single-proxy config, which has
+// the proxy's listen address at the top level
+func isLegacyConfig(data []byte) bool {
+	var legacy struct {
+		Listen *string `json:"listen"`
+	}
+	return decodeConfig(data, &legacy) == nil && legacy.Listen != nil
 }
 
-// validateProxyConfig validates a single proxy configuration
-func validateProxyConfig(config *ProxyConfig) {
-	if config.PingMode != "fake" && config.PingMode != "real" {
-		log.Fatalf("[ERROR] Invalid ping_mode in config: %s", config.PingMode)
+// applyDefaults fills in the logging and control panel settings left out of the config
+func applyDefaults(config *Config) {
+	// Set default logging configuration if not provided
+	if config.Logging.DBPath == "" {
+		config.Logging.DBPath = "logs/mcproxy.db"
+		log.Printf("[INFO] Using default logging database path: %s", config.Logging.DBPath)
+	} else {
+		log.Printf("[INFO] Using configured logging database path: %s", config.Logging.DBPath)
 	}
 
-	if config.Auth != "none" && config.Auth != "blacklist" && config.Auth != "whitelist" {
-		log.Fatalf("[ERROR] Invalid auth in config: %s", config.Auth)
+	// Set default control panel configuration if not provided
+	if config.ControlPanel.Username == "" {
+		config.ControlPanel.Username = "admin"
+		log.Printf("[INFO] Using default control panel username: %s", config.ControlPanel.Username)
 	}
+
+	if config.ControlPanel.Password == "" {
+		config.ControlPanel.Password = "admin"
+		log.Printf("[WARN] Using default control panel password. Please change it in the configuration file.")
+	}
+}
+
+// ParseAllowlist parses allowlist entries, CIDRs or single IP addresses
+func ParseAllowlist(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Validate checks the options of a single proxy
+func (c ProxyConfig) Validate() error {
+	if c.PingMode != "fake" && c.PingMode != "real" {
+		return fmt.Errorf("invalid ping_mode in config: %s", c.PingMode)
+	}
+
+	if c.Auth != "none" && c.Auth != "blacklist" && c.Auth != "whitelist" {
+		return fmt.Errorf("invalid auth in config: %s", c.Auth)
+	}
+
+	switch c.Mode {
+	case "":
+	case "status_only":
+		if c.PingMode != "fake" {
+			return fmt.Errorf("mode status_only requires ping_mode fake")
+		}
+	default:
+		return fmt.Errorf("invalid mode in config: %s", c.Mode)
+	}
+
+	switch c.FullPingDisplay {
+	case "", "real", "motd", "overflow":
+	default:
+		return fmt.Errorf("invalid full_ping_display in config: %s", c.FullPingDisplay)
+	}
+
+	switch c.OnlineCountSource {
+	case "", "proxy":
+	case "backend":
+		if c.PingMode != "real" {
+			return fmt.Errorf("online_count_source backend requires ping_mode real")
+		}
+	default:
+		return fmt.Errorf("invalid online_count_source in config: %s", c.OnlineCountSource)
+	}
+
+	switch c.UsernamePolicy {
+	case "", "allow", "sanitize", "reject":
+	default:
+		return fmt.Errorf("invalid username_policy in config: %s", c.UsernamePolicy)
+	}
+
+	for _, field := range c.StatusOverride {
+		switch field {
+		case "description", "favicon", "version":
+		default:
+			return fmt.Errorf("invalid status_override field in config: %s", field)
+		}
+	}
+	if len(c.StatusOverride) > 0 && c.PingMode != "real" {
+		return fmt.Errorf("status_override requires ping_mode real")
+	}
+
+	if _, err := ParseAllowlist(c.ListenAllowlist); err != nil {
+		return fmt.Errorf("invalid listen_allowlist in config: %w", err)
+	}
+	if _, err := ParseAllowlist(c.ProxyProtocolTrustedProxies); err != nil {
+		return fmt.Errorf("invalid proxy_protocol_trusted_proxies in config: %w", err)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+	if c.TLSCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile); err != nil {
+			return fmt.Errorf("invalid tls_cert_file in config: %w", err)
+		}
+	}
+
+	if c.AcceptLogSample < 0 {
+		return fmt.Errorf("invalid accept_log_sample in config: %d", c.AcceptLogSample)
+	}
+
+	if c.PingTimeoutMs < 0 {
+		return fmt.Errorf("invalid ping_timeout_ms in config: %d", c.PingTimeoutMs)
+	}
+	if c.MaxConcurrentLogins < 0 {
+		return fmt.Errorf("invalid max_concurrent_logins in config: %d", c.MaxConcurrentLogins)
+	}
+	if c.LoginQueueMs < 0 {
+		return fmt.Errorf("invalid login_queue_ms in config: %d", c.LoginQueueMs)
+	}
+	if c.ReservedSlots < 0 || c.ReservedSlots > c.MaxPlayer {
+		return fmt.Errorf("invalid reserved_slots in config: %d", c.ReservedSlots)
+	}
+	if c.FullOverflow < 0 {
+		return fmt.Errorf("invalid full_overflow in config: %d", c.FullOverflow)
+	}
+	if c.OverflowMaxPlayer < 0 {
+		return fmt.Errorf("invalid overflow_max_player in config: %d", c.OverflowMaxPlayer)
+	}
+	if c.PlayReconnectTimeoutMs < 0 {
+		return fmt.Errorf("invalid play_reconnect_timeout_ms in config: %d", c.PlayReconnectTimeoutMs)
+	}
+	if c.PlayReconnectTimeoutMs > 0 && c.KeepAliveIntervalMs <= 0 {
+		return fmt.Errorf("play_reconnect_timeout_ms requires keepalive_interval_ms")
+	}
+	if c.HandshakeConnectionID != "" && !strings.Contains(c.HandshakeConnectionID, "{id}") {
+		return fmt.Errorf("invalid handshake_connection_id in config: %q has no {id}", c.HandshakeConnectionID)
+	}
+	if c.ClientCompressionThreshold < 0 {
+		return fmt.Errorf("invalid client_compression_threshold in config: %d", c.ClientCompressionThreshold)
+	}
+
+	switch c.LogVerbosity {
+	case "", "quiet", "normal", "verbose":
+	default:
+		return fmt.Errorf("invalid log_verbosity in config: %s", c.LogVerbosity)
+	}
+
+	return nil
+}
+
+// Validate checks the access log options
+func (l LogConfig) Validate() error {
+	switch l.AccessLogFormat {
+	case "", "combined", "json":
+	default:
+		return fmt.Errorf("invalid access_log_format: %s", l.AccessLogFormat)
+	}
+	if l.AccessLogMaxSizeMB < 0 {
+		return fmt.Errorf("invalid access_log_max_size_mb: %d", l.AccessLogMaxSizeMB)
+	}
+	if l.AccessLogRotateHours < 0 {
+		return fmt.Errorf("invalid access_log_rotate_hours: %d", l.AccessLogRotateHours)
+	}
+	if l.AccessLogMaxBackups < 0 {
+		return fmt.Errorf("invalid access_log_max_backups: %d", l.AccessLogMaxBackups)
+	}
+	return nil
+}
+
+// Validate checks a complete configuration, such as one submitted through the
+// control panel, without terminating the process
+func (c Config) Validate() error {
+	if len(c.Proxies) == 0 {
+		return fmt.Errorf("no proxies configured")
+	}
+
+	switch c.BalancerOnAllUnhealthy {
+	case "", "reject", "besteffort":
+	default:
+		return fmt.Errorf("invalid balancer_on_all_unhealthy: %s", c.BalancerOnAllUnhealthy)
+	}
+
+	if _, err := ParseAllowlist(c.BalancerListenAllowlist); err != nil {
+		return fmt.Errorf("invalid balancer_listen_allowlist: %w", err)
+	}
+	if _, err := ParseAllowlist(c.BalancerProxyProtocolTrustedProxies); err != nil {
+		return fmt.Errorf("invalid balancer_proxy_protocol_trusted_proxies: %w", err)
+	}
+	if c.BalancerLoadWeight < 0 {
+		return fmt.Errorf("invalid balancer_load_weight: %g", c.BalancerLoadWeight)
+	}
+	if c.BalancerCapacityWeight < 0 {
+		return fmt.Errorf("invalid balancer_capacity_weight: %g", c.BalancerCapacityWeight)
+	}
+	// the weights are shares of the score, leaving both unset keeps the defaults
+	if sum := c.BalancerLoadWeight + c.BalancerCapacityWeight; sum != 0 && math.Abs(sum-1) > 0.001 {
+		return fmt.Errorf("balancer_load_weight and balancer_capacity_weight must add up to 1, got %g", sum)
+	}
+	if band := c.BalancerCandidateBand; band != nil && (*band < 0 || *band >= 1) {
+		return fmt.Errorf("invalid balancer_candidate_band: %g", *band)
+	}
+
+	if err := c.Logging.Validate(); err != nil {
+		return err
+	}
+
+	if c.DisconnectWriteTimeoutMs < 0 {
+		return fmt.Errorf("invalid disconnect_write_timeout_ms: %d", c.DisconnectWriteTimeoutMs)
+	}
+	if c.DisconnectGraceMs < 0 {
+		return fmt.Errorf("invalid disconnect_grace_ms: %d", c.DisconnectGraceMs)
+	}
+	if c.MaxTotalConnections < 0 {
+		return fmt.Errorf("invalid max_total_connections: %d", c.MaxTotalConnections)
+	}
+	if c.HandshakeRateLimit < 0 {
+		return fmt.Errorf("invalid handshake_rate_limit: %d", c.HandshakeRateLimit)
+	}
+	if c.HandshakeRateWindowSeconds < 0 {
+		return fmt.Errorf("invalid handshake_rate_window_seconds: %d", c.HandshakeRateWindowSeconds)
+	}
+	if c.HandshakeBlockSeconds < 0 {
+		return fmt.Errorf("invalid handshake_block_seconds: %d", c.HandshakeBlockSeconds)
+	}
+	if c.FlapThreshold < 0 {
+		return fmt.Errorf("invalid flap_threshold: %d", c.FlapThreshold)
+	}
+	if c.FlapWindowSeconds < 0 {
+		return fmt.Errorf("invalid flap_window_seconds: %d", c.FlapWindowSeconds)
+	}
+
+	for host, target := range c.HostOverrides {
+		if host == "" || target == "" {
+			return fmt.Errorf("invalid host_overrides entry %q: %q", host, target)
+		}
+	}
+
+	listens := make(map[string]bool, len(c.Proxies))
+	for i, proxy := range c.Proxies {
+		if proxy.Listen == "" {
+			return fmt.Errorf("proxy %d: listen is required", i+1)
+		}
+		if proxy.Remote == "" && proxy.Mode != "status_only" {
+			return fmt.Errorf("proxy %d: remote is required", i+1)
+		}
+		if listens[proxy.Listen] {
+			return fmt.Errorf("proxy %d: duplicate listen address %s", i+1, proxy.Listen)
+		}
+		listens[proxy.Listen] = true
+
+		if err := proxy.Validate(); err != nil {
+			return fmt.Errorf("proxy %d: %w", i+1, err)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,328 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseLegacyConfigSections(t *testing.T) {
+	path := writeConfig(t, `{
+		"listen": "0.0.0.0:25565",
+		"remote": "mc.example.com:25565",
+		"ping_mode": "fake",
+		"auth": "none",
+		"logging": {"db_path": "/var/lib/mcproxy/logs.db"},
+		"control_panel": {"username": "operator", "password": "secret"}
+	}`)
+
+	cfg := ParseConfig(path)
+	if len(cfg.Proxies) != 1 || cfg.Proxies[0].Remote != "mc.example.com:25565" {
+		t.Fatalf("proxies = %+v", cfg.Proxies)
+	}
+	if cfg.Logging.DBPath != "/var/lib/mcproxy/logs.db" {
+		t.Errorf("db path = %q", cfg.Logging.DBPath)
+	}
+	if cfg.ControlPanel.Username != "operator" || cfg.ControlPanel.Password != "secret" {
+		t.Errorf("control panel = %+v", cfg.ControlPanel)
+	}
+}
+
+func TestParseLegacyConfigDefaults(t *testing.T) {
+	path := writeConfig(t, `{
+		"listen": "0.0.0.0:25565",
+		"remote": "mc.example.com:25565",
+		"ping_mode": "fake",
+		"auth": "none"
+	}`)
+
+	cfg := ParseConfig(path)
+	if cfg.Logging.DBPath != "logs/mcproxy.db" {
+		t.Errorf("db path = %q", cfg.Logging.DBPath)
+	}
+	if cfg.ControlPanel.Username != "admin" || cfg.ControlPanel.Password != "admin" {
+		t.Errorf("control panel = %+v", cfg.ControlPanel)
+	}
+}
+
+func TestParseConfigDirectory(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("00-base.json", `{
+		"proxies": [{"listen": "0.0.0.0:25565", "remote": "a.example.com:25565", "ping_mode": "fake", "auth": "none"}],
+		"logging": {"db_path": "/var/lib/mcproxy/logs.db"},
+		"control_panel": {"username": "operator", "password": "secret"}
+	}`)
+	write("10-more.json", `{
+		"proxies": [{"listen": "0.0.0.0:25566", "remote": "b.example.com:25565", "ping_mode": "real", "auth": "none"}]
+	}`)
+	write("notes.txt", `not a config`)
+
+	for _, path := range []string{dir, filepath.Join(dir, "*.json")} {
+		cfg := ParseConfig(path)
+		if len(cfg.Proxies) != 2 || cfg.Proxies[0].Remote != "a.example.com:25565" || cfg.Proxies[1].Remote != "b.example.com:25565" {
+			t.Fatalf("%s: proxies = %+v", path, cfg.Proxies)
+		}
+		if cfg.Logging.DBPath != "/var/lib/mcproxy/logs.db" || cfg.ControlPanel.Username != "operator" {
+			t.Errorf("%s: globals = %+v %+v", path, cfg.Logging, cfg.ControlPanel)
+		}
+	}
+
+	// saving keeps every proxy in its own file
+	cfg := ParseConfig(dir)
+	cfg.Proxies[1].Remote = "c.example.com:25565"
+	cfg.Proxies = append(cfg.Proxies, ProxyConfig{Listen: "0.0.0.0:25567", Remote: "d.example.com:25565", PingMode: "fake", Auth: "none"})
+	if err := Save(cfg, dir); err != nil {
+		t.Fatal(err)
+	}
+	base, err := readConfigSetFile(filepath.Join(dir, "00-base.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, err := readConfigSetFile(filepath.Join(dir, "10-more.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(base.config.Proxies) != 2 || base.config.Proxies[1].Listen != "0.0.0.0:25567" || base.config.ControlPanel.Username != "operator" {
+		t.Errorf("base file = %+v", base.config)
+	}
+	if len(more.config.Proxies) != 1 || more.config.Proxies[0].Remote != "c.example.com:25565" || more.globals {
+		t.Errorf("second file = %+v", more.config)
+	}
+}
+
+func TestParseConfigSetErrors(t *testing.T) {
+	proxy := func(listen string) string {
+		return `{"listen": "` + listen + `", "remote": "mc.example.com:25565", "ping_mode": "fake", "auth": "none"}`
+	}
+	tests := map[string][2]string{
+		"duplicate listen": {
+			`{"proxies": [` + proxy("0.0.0.0:25565") + `]}`,
+			`{"proxies": [` + proxy("0.0.0.0:25565") + `]}`,
+		},
+		"conflicting globals": {
+			`{"proxies": [` + proxy("0.0.0.0:25565") + `], "logging": {"db_path": "a.db"}}`,
+			`{"proxies": [` + proxy("0.0.0.0:25566") + `], "logging": {"db_path": "b.db"}}`,
+		},
+	}
+
+	for name, contents := range tests {
+		dir := t.TempDir()
+		for i, content := range contents {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.json", i)), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := parseConfigSet(dir); err == nil {
+			t.Errorf("%s: merged without an error", name)
+		}
+	}
+}
+
+func TestLoadConfigValidates(t *testing.T) {
+	proxy := func(listen string) string {
+		return `{"listen": "` + listen + `", "remote": "mc.example.com:25565", "ping_mode": "fake", "auth": "none"}`
+	}
+	_, err := loadConfig(writeConfig(t, `{"proxies": [`+proxy("0.0.0.0:25565")+`, `+proxy("0.0.0.0:25565")+`]}`))
+	if err == nil || !strings.Contains(err.Error(), "duplicate listen address") {
+		t.Errorf("single file: error = %v, want duplicate listen address", err)
+	}
+
+	dir := t.TempDir()
+	content := `{"proxies": [` + proxy("0.0.0.0:25565") + `], "max_total_connections": -1}`
+	if err := os.WriteFile(filepath.Join(dir, "00-base.json"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadConfig(dir)
+	if err == nil || !strings.Contains(err.Error(), "max_total_connections") {
+		t.Errorf("directory: error = %v, want invalid max_total_connections", err)
+	}
+}
+
+func TestValidateStatusOnly(t *testing.T) {
+	proxy := ProxyConfig{Listen: "0.0.0.0:25565", PingMode: "fake", Auth: "none", Mode: "status_only"}
+	if err := (Config{Proxies: []ProxyConfig{proxy}}).Validate(); err != nil {
+		t.Errorf("status_only proxy without a remote: %v", err)
+	}
+
+	proxy.PingMode = "real"
+	if err := (Config{Proxies: []ProxyConfig{proxy}}).Validate(); err == nil {
+		t.Error("status_only proxy with ping_mode real should be rejected")
+	}
+
+	proxy.PingMode, proxy.Mode = "fake", ""
+	if err := (Config{Proxies: []ProxyConfig{proxy}}).Validate(); err == nil {
+		t.Error("forwarding proxy without a remote should be rejected")
+	}
+}
+
+func TestValidateTLSFiles(t *testing.T) {
+	proxy := ProxyConfig{Listen: "0.0.0.0:25565", Remote: "127.0.0.1:25566", PingMode: "fake", Auth: "none"}
+
+	proxy.TLSCertFile = "cert.pem"
+	if err := proxy.Validate(); err == nil {
+		t.Error("tls_cert_file without tls_key_file should be rejected")
+	}
+
+	dir := t.TempDir()
+	proxy.TLSCertFile, proxy.TLSKeyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(proxy.TLSCertFile, []byte("not a certificate"), 0644)
+	os.WriteFile(proxy.TLSKeyFile, []byte("not a key"), 0644)
+	if err := proxy.Validate(); err == nil || !strings.Contains(err.Error(), "tls_cert_file") {
+		t.Errorf("unreadable certificate: err = %v", err)
+	}
+}
+
+func TestValidateBalancerWeights(t *testing.T) {
+	proxy := ProxyConfig{Listen: "0.0.0.0:25565", Remote: "127.0.0.1:25566", PingMode: "fake", Auth: "none"}
+	tests := []struct {
+		load, capacity, band float64
+		ok                   bool
+	}{
+		{0, 0, 0, true},
+		{0.6, 0.4, 0.1, true},
+		{1, 0, 0, true},
+		{0.5, 0.4, 0, false},
+		{1.2, -0.2, 0, false},
+		{0, 0, 1, false},
+		{0, 0, -0.1, false},
+	}
+	for _, tt := range tests {
+		band := tt.band
+		cfg := Config{Proxies: []ProxyConfig{proxy}, BalancerLoadWeight: tt.load, BalancerCapacityWeight: tt.capacity, BalancerCandidateBand: &band}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("load %g, capacity %g, band %g: err = %v", tt.load, tt.capacity, tt.band, err)
+		}
+	}
+}
+
+func TestParseConfigSecretFile(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfig(t, fmt.Sprintf(`{
+		"proxies": [{"listen": "0.0.0.0:25565", "remote": "mc.example.com:25565", "ping_mode": "fake", "auth": "none"}],
+		"control_panel": {"username": "operator", "password": "file:%s", "api_tokens": ["plain-token"]}
+	}`, secret))
+
+	cfg := ParseConfig(path)
+	if cfg.ControlPanel.Password != "s3cret" {
+		t.Errorf("password = %q, want the file's contents", cfg.ControlPanel.Password)
+	}
+	if len(cfg.ControlPanel.APITokens) != 1 || cfg.ControlPanel.APITokens[0] != "plain-token" {
+		t.Errorf("api tokens = %q, want the plain value unchanged", cfg.ControlPanel.APITokens)
+	}
+
+	// saving writes the reference back instead of the secret
+	if err := Save(cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret") || !strings.Contains(string(data), "file:"+secret) {
+		t.Errorf("saved config:\n%s", data)
+	}
+	if cfg.ControlPanel.Password != "s3cret" {
+		t.Errorf("saving changed the loaded password to %q", cfg.ControlPanel.Password)
+	}
+}
+
+func TestParseConfigComments(t *testing.T) {
+	path := writeConfig(t, `{
+		// the public proxy
+		"proxies": [
+			{
+				"listen": "0.0.0.0:25565", /* players connect here */
+				"remote": "mc.example.com:25565",
+				"description": "// not a comment, /* nor this */",
+				"ping_mode": "fake",
+				"auth": "none",
+			},
+		],
+		/*
+		"logging": {"db_path": "unused.db"},
+		*/
+	}`)
+
+	cfg := ParseConfig(path)
+	if len(cfg.Proxies) != 1 || cfg.Proxies[0].Remote != "mc.example.com:25565" {
+		t.Fatalf("proxies = %+v", cfg.Proxies)
+	}
+	if cfg.Proxies[0].Description != "// not a comment, /* nor this */" {
+		t.Errorf("description = %q", cfg.Proxies[0].Description)
+	}
+	if cfg.Logging.DBPath != "logs/mcproxy.db" {
+		t.Errorf("db path = %q, the commented out logging section was read", cfg.Logging.DBPath)
+	}
+}
+
+func TestDecodeConfigErrors(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		want    string
+	}{
+		"missing comma":         {"{\n  \"listen\": \"a\"\n  \"remote\": \"b\"\n}", "line 3, column 3"},
+		"unterminated comment":  {"{\n  /* listen\n}", "line 2, column 3: unterminated comment"},
+		"wrong type":            {"{\n  // a comment\n  \"max_player\": \"ten\"\n}", "line 3"},
+		"comma without a value": {"{\"listen\": \"a\",, }", "line 1"},
+	}
+
+	for name, tt := range tests {
+		var cfg ProxyConfig
+		err := decodeConfig([]byte(tt.content), &cfg)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want it to contain %q", name, err, tt.want)
+		}
+	}
+}
+
+func TestParseConfigFileNoProxies(t *testing.T) {
+	for name, content := range map[string]string{
+		"empty proxies": `{"proxies": [], "control_panel": {"username": "operator"}}`,
+		"no proxies":    `{"logging": {"db_path": "logs.db"}}`,
+	} {
+		_, err := parseConfigFile(writeConfig(t, content))
+		if err == nil || !strings.Contains(err.Error(), "no proxies configured") {
+			t.Errorf("%s: error = %v, want no proxies configured", name, err)
+		}
+	}
+
+	// the legacy format is still recognized by its listen address
+	cfg, err := parseConfigFile(writeConfig(t, `{"listen": "0.0.0.0:25565", "remote": "mc.example.com:25565", "ping_mode": "fake", "auth": "none"}`))
+	if err != nil || len(cfg.Proxies) != 1 {
+		t.Errorf("legacy config: %+v, %v", cfg, err)
+	}
+}
+
+func TestParseConfigFileAccessLog(t *testing.T) {
+	const proxy = `"proxies": [{"listen": "0.0.0.0:25565", "remote": "mc.example.com:25565", "ping_mode": "fake", "auth": "none"}]`
+	_, err := parseConfigFile(writeConfig(t, `{`+proxy+`, "logging": {"access_log_format": "xml"}}`))
+	if err == nil || !strings.Contains(err.Error(), "access_log_format") {
+		t.Errorf("error = %v, want invalid access_log_format", err)
+	}
+
+	// the global options are checked once the config is loaded
+	_, err = loadConfig(writeConfig(t, `{`+proxy+`, "balancer_candidate_band": 2}`))
+	if err == nil || !strings.Contains(err.Error(), "balancer_candidate_band") {
+		t.Errorf("error = %v, want invalid balancer_candidate_band", err)
+	}
+}
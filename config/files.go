@@ -0,0 +1,197 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A configuration can be split across files, like nginx's conf.d. The config
+// path is then a directory, whose *.json files are read, or a glob. Every file
+// holds a "proxies" array and the arrays are merged in file name order. The
+// global sections (logging, control_panel and the other top-level options)
+// come from a single base file, the only file that sets any of them.
+
+// isConfigSet reports whether path names a split configuration
+func isConfigSet(path string) bool {
+	if strings.ContainsAny(path, "*?[") {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// configSetFiles returns the files of a split configuration in name order
+func configSetFiles(path string) ([]string, error) {
+	pattern := path
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		pattern = filepath.Join(path, "*.json")
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config pattern %s: %w", path, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no config files match %s", pattern)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// configSetFile is one file of a split configuration
+type configSetFile struct {
+	path    string
+	config  Config
+	globals bool // the file sets top-level options besides proxies
+}
+
+// readConfigSetFile reads one file of a split configuration
+func readConfigSetFile(path string) (configSetFile, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return configSetFile{}, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	var keys map[string]json.RawMessage
+	if err := decodeConfig(bytes, &keys); err != nil {
+		return configSetFile{}, fmt.Errorf("invalid JSON in config file %s: %w", path, err)
+	}
+	file := configSetFile{path: path}
+	for key := range keys {
+		if key != "proxies" {
+			file.globals = true
+		}
+	}
+
+	if err := decodeConfig(bytes, &file.config); err != nil {
+		return configSetFile{}, fmt.Errorf("invalid JSON in config file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// readConfigSet reads every file of a split configuration
+func readConfigSet(path string) ([]configSetFile, error) {
+	paths, err := configSetFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]configSetFile, 0, len(paths))
+	for _, p := range paths {
+		file, err := readConfigSetFile(p)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// parseConfigSet reads and merges a split configuration, proxies that are
+// defined twice and global sections in more than one file are an error
+func parseConfigSet(path string) (*Config, error) {
+	files, err := readConfigSet(path)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := Config{}
+	base := ""
+	listens := make(map[string]string)
+	for _, file := range files {
+		if file.globals {
+			if base != "" {
+				return nil, fmt.Errorf("conflicting global settings in %s and %s, only one file may set options besides proxies", base, file.path)
+			}
+			base = file.path
+			proxies := merged.Proxies
+			merged = file.config
+			merged.Proxies = proxies
+		}
+
+		for _, proxy := range file.config.Proxies {
+			if other, ok := listens[proxy.Listen]; ok {
+				return nil, fmt.Errorf("duplicate listen address %s in %s and %s", proxy.Listen, other, file.path)
+			}
+			listens[proxy.Listen] = file.path
+			merged.Proxies = append(merged.Proxies, proxy)
+			log.Printf("[INFO] Loaded proxy %d from %s: listen=%s, remote=%s, auth=%s",
+				len(merged.Proxies), file.path, proxy.Listen, proxy.Remote, proxy.Auth)
+		}
+	}
+
+	return &merged, nil
+}
+
+// Save writes the configuration to path. A split configuration is written
+// back to its files: each proxy stays in the file that defines its listen
+// address, new proxies and the global sections go to the base file. Secrets
+// read from files are written as their references.
+func Save(config *Config, path string) error {
+	withRefs := config.WithSecretRefs()
+	config = &withRefs
+
+	if !isConfigSet(path) {
+		jsonData, err := json.MarshalIndent(config, "", "    ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal config: %w", err)
+		}
+		if err := os.WriteFile(path, jsonData, 0644); err != nil {
+			return fmt.Errorf("failed to write config file: %w", err)
+		}
+		return nil
+	}
+
+	files, err := readConfigSet(path)
+	if err != nil {
+		return err
+	}
+
+	base := 0
+	owners := make(map[string]int)
+	for i, file := range files {
+		if file.globals {
+			base = i
+		}
+		for _, proxy := range file.config.Proxies {
+			owners[proxy.Listen] = i
+		}
+	}
+
+	proxies := make([][]ProxyConfig, len(files))
+	for i := range proxies {
+		proxies[i] = []ProxyConfig{}
+	}
+	for _, proxy := range config.Proxies {
+		i, ok := owners[proxy.Listen]
+		if !ok {
+			i = base
+		}
+		proxies[i] = append(proxies[i], proxy)
+	}
+
+	for i, file := range files {
+		var content any = struct {
+			Proxies []ProxyConfig `json:"proxies"`
+		}{proxies[i]}
+		if i == base {
+			baseConfig := *config
+			baseConfig.Proxies = proxies[i]
+			content = baseConfig
+		}
+
+		jsonData, err := json.MarshalIndent(content, "", "    ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal config: %w", err)
+		}
+		if err := os.WriteFile(file.path, jsonData, 0644); err != nil {
+			return fmt.Errorf("failed to write config file: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Config files may be annotated with // and /* */ comments and may have
+// trailing commas. Both are blanked out before the file is decoded, which
+// keeps every other byte at its offset so errors point at the right line.
+// The control panel saves the configuration as plain JSON, comments are lost
+// then.
+
+// decodeConfig decodes a config file into v, errors carry the line and
+// column they were found at
+func decodeConfig(data []byte, v any) error {
+	clean, err := stripJSONC(data)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(clean, v)
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		// the offset is just past the invalid character
+		line, column := position(data, syntaxErr.Offset-1)
+		return fmt.Errorf("line %d, column %d: %w", line, column, err)
+	case errors.As(err, &typeErr):
+		line, column := position(data, typeErr.Offset)
+		return fmt.Errorf("line %d, column %d: %w", line, column, err)
+	}
+	return err
+}
+
+// position returns the 1-based line and column of offset in data
+func position(data []byte, offset int64) (int, int) {
+	offset = min(max(offset, 0), int64(len(data)))
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// stripJSONC returns a copy of data with comments and trailing commas
+// replaced by spaces
+func stripJSONC(data []byte) ([]byte, error) {
+	out := bytes.Clone(data)
+
+	// comments, line breaks inside block comments are kept
+	inString := false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		if inString {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+			continue
+		}
+		if c != '/' || i+1 >= len(out) {
+			continue
+		}
+
+		switch out[i+1] {
+		case '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end < 0 {
+				line, column := position(data, int64(i))
+				return nil, fmt.Errorf("line %d, column %d: unterminated comment", line, column)
+			}
+			end += i + 4
+			for ; i < end; i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			i--
+		}
+	}
+
+	// trailing commas, a comma followed only by white space up to a closing
+	// bracket
+	inString = false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		if inString {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+			continue
+		}
+		if c != ',' {
+			continue
+		}
+		j := i + 1
+		for j < len(out) && isJSONSpace(out[j]) {
+			j++
+		}
+		if j < len(out) && (out[j] == '}' || out[j] == ']') {
+			out[i] = ' '
+		}
+	}
+	return out, nil
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Secret fields (the control panel password, API tokens and the connection
+// rate webhook) may hold a reference like "file:/run/secrets/password"
+// instead of the value, which is then read from the file when the config is
+// loaded. Saving the config writes the reference back, never the value.
+
+// secretFilePrefix starts a reference to a file holding a secret
+const secretFilePrefix = "file:"
+
+// secretRefs maps the values read from secret files to their references
+var secretRefs = struct {
+	sync.Mutex
+	refs map[string]string
+}{refs: make(map[string]string)}
+
+// secretFields returns the secret fields of the config
+func (c *Config) secretFields() []*string {
+	fields := []*string{&c.ControlPanel.Password, &c.ConnectionRateWebhook}
+	for i := range c.ControlPanel.APITokens {
+		fields = append(fields, &c.ControlPanel.APITokens[i])
+	}
+	return fields
+}
+
+// ResolveSecrets replaces references to secret files with the contents of
+// the files, without trailing newlines
+func (c *Config) ResolveSecrets() error {
+	for _, field := range c.secretFields() {
+		path, ok := strings.CutPrefix(*field, secretFilePrefix)
+		if !ok {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read secret: %w", err)
+		}
+		secret := strings.TrimRight(string(data), "\r\n")
+		if secret == "" {
+			return fmt.Errorf("secret file %s is empty", path)
+		}
+
+		secretRefs.Lock()
+		secretRefs.refs[secret] = *field
+		secretRefs.Unlock()
+		*field = secret
+	}
+	return nil
+}
+
+// WithSecretRefs returns a copy of the config with the values read from
+// secret files replaced by their references again
+func (c *Config) WithSecretRefs() Config {
+	out := *c
+	out.ControlPanel.APITokens = slices.Clone(c.ControlPanel.APITokens)
+
+	secretRefs.Lock()
+	defer secretRefs.Unlock()
+	for _, field := range out.secretFields() {
+		if ref, ok := secretRefs.refs[*field]; ok && *field != "" {
+			*field = ref
+		}
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"log"
+	"mcproxy/config"
+	"mcproxy/logger"
+	"sync/atomic"
+	"time"
+)
+
+// accessLog gets a line for every finished session, nil when disabled
+var accessLog atomic.Pointer[logger.AccessLog]
+
+// SetAccessLog opens the access log configured in cfg and closes the previous
+// one, an empty access_log_path disables it
+func SetAccessLog(cfg config.LogConfig) error {
+	var next *logger.AccessLog
+	if cfg.AccessLogPath != "" {
+		var err error
+		next, err = logger.OpenAccessLog(cfg.AccessLogPath, logger.AccessLogOptions{
+			Format:      cfg.AccessLogFormat,
+			MaxSize:     int64(cfg.AccessLogMaxSizeMB) << 20,
+			RotateEvery: time.Duration(cfg.AccessLogRotateHours) * time.Hour,
+			MaxBackups:  cfg.AccessLogMaxBackups,
+			SourcePort:  cfg.AccessLogSourcePort,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if prev := accessLog.Swap(next); prev != nil {
+		prev.Close()
+	}
+	return nil
+}
+
+// logAccess writes the session of conn, which ended at now, to the access log
+func logAccess(conn *Connection, now time.Time) {
+	al := accessLog.Load()
+	if al == nil {
+		return
+	}
+
+	activeConnections.RLock()
+	username := conn.Username
+	activeConnections.RUnlock()
+
+	host, port := splitHostPort(conn.ClientAddr)
+	err := al.Write(logger.AccessEntry{
+		Time:       now,
+		ClientIP:   host,
+		ClientPort: port,
+		Username:   username,
+		Proxy:      conn.ProxyAddr,
+		Backend:    conn.RemoteAddr,
+		BytesIn:    conn.bytesIn.Load(),
+		BytesOut:   conn.bytesOut.Load(),
+		Duration:   now.Sub(conn.ConnectedAt),
+		Reason:     conn.endReason(),
+	})
+	if err != nil {
+		log.Printf("[WARN] Failed to write access log: %v", err)
+	}
+}
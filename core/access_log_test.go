@@ -0,0 +1,100 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mcproxy/config"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAccessLogSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := SetAccessLog(config.LogConfig{AccessLogPath: path, AccessLogFormat: "json"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetAccessLog(config.LogConfig{}) })
+
+	success := frame(t, false, loginSuccess, String("00000000-0000-0000-0000-000000000000"), String("Steve"))
+	data := frame(t, false, 0x24, String("chunk data"))
+	chat := frame(t, false, 0x03, String("hello"))
+
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer backendSide.Close()
+			ReadPacket(backendSide)
+			ReadPacket(backendSide)
+			ReadPacket(backendSide) // the client's chat message
+			backendSide.Write(success)
+			backendSide.Write(data)
+		}()
+		return proxySide, nil
+	}
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40151", Remote: "backend.example.com:25565", Auth: "none"}
+	registerProxyStats(t, cfg)
+	RegisterConnection(&Connection{ID: "conn-access", ClientAddr: "pipe", ProxyAddr: cfg.Listen,
+		RemoteAddr: cfg.Remote, ConnectedAt: time.Now()})
+
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+	received := make(chan int, 1)
+	writeLoginStart(t, client, "Steve")
+	go func() {
+		n, _ := io.Copy(io.Discard, client)
+		received <- int(n)
+	}()
+	client.Write(chat)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleForward did not return")
+	}
+	client.Close()
+	UnregisterConnection("conn-access")
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("access log has %d lines, want 1:\n%s", len(lines), content)
+	}
+	var entry struct {
+		ClientIP   string `json:"client_ip"`
+		Username   string `json:"username"`
+		Proxy      string `json:"proxy"`
+		Backend    string `json:"backend"`
+		BytesIn    int64  `json:"bytes_in"`
+		BytesOut   int64  `json:"bytes_out"`
+		DurationMs *int64 `json:"duration_ms"`
+		Reason     string `json:"reason"`
+	}
+	if err := json.Unmarshal(lines[0], &entry); err != nil {
+		t.Fatalf("invalid JSON %s: %v", lines[0], err)
+	}
+
+	if entry.ClientIP != "pipe" || entry.Username != "Steve" || entry.Proxy != cfg.Listen || entry.Backend != cfg.Remote {
+		t.Errorf("entry = %+v", entry)
+	}
+	if entry.BytesIn != int64(len(chat)) {
+		t.Errorf("bytes in = %d, want %d", entry.BytesIn, len(chat))
+	}
+	if want := int64(len(success) + len(data)); entry.BytesOut != want || int64(<-received) != want {
+		t.Errorf("bytes out = %d, want %d", entry.BytesOut, want)
+	}
+	if entry.DurationMs == nil || entry.Reason != "server closed" {
+		t.Errorf("duration = %v, reason = %q, want server closed", entry.DurationMs, entry.Reason)
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// splitHostPort splits an address into host and port. Unlike net.SplitHostPort
+// it also accepts addresses without a port, including bare and bracketed IPv6
+// literals, in which case the port is empty. IPv6 hosts are returned without
+// brackets.
+func splitHostPort(addr string) (host, port string) {
+	if h, p, err := net.SplitHostPort(addr); err == nil {
+		return h, p
+	}
+	if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		return addr[1 : len(addr)-1], ""
+	}
+	return addr, ""
+}
+
+// hostOnly returns the host part of an address, see splitHostPort
+func hostOnly(addr string) string {
+	host, _ := splitHostPort(addr)
+	return host
+}
+
+// normalizeIP returns IPv4-mapped IPv6 addresses such as ::ffff:1.2.3.4 in
+// their IPv4 form, so clients of a dual-stack listener are counted and logged
+// the same as over IPv4. Other hosts are returned unchanged.
+func normalizeIP(host string) string {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return host
+}
+
+// normalizeClientAddr returns a client address with its IP normalized, see
+// normalizeIP
+func normalizeClientAddr(addr string) string {
+	host, port := splitHostPort(addr)
+	if port == "" {
+		return normalizeIP(host)
+	}
+	return net.JoinHostPort(normalizeIP(host), port)
+}
+
+// formatAddr joins a host and port for logging and dialing, bracketing IPv6 hosts
+func formatAddr(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"mcproxy/config"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		addr, host, port string
+	}{
+		{"127.0.0.1:25565", "127.0.0.1", "25565"},
+		{"[2001:db8::1]:25565", "2001:db8::1", "25565"},
+		{"[2001:db8::1]", "2001:db8::1", ""},
+		{"2001:db8::1", "2001:db8::1", ""},
+		{"mc.example.com", "mc.example.com", ""},
+		{"mc.example.com:25566", "mc.example.com", "25566"},
+	}
+	for _, tt := range tests {
+		host, port := splitHostPort(tt.addr)
+		if host != tt.host || port != tt.port {
+			t.Errorf("splitHostPort(%q) = %q, %q, want %q, %q", tt.addr, host, port, tt.host, tt.port)
+		}
+	}
+
+	if got := formatAddr("2001:db8::1", 25565); got != "[2001:db8::1]:25565" {
+		t.Errorf("formatAddr = %s", got)
+	}
+}
+
+func TestNormalizeClientAddr(t *testing.T) {
+	tests := []struct {
+		addr, want string
+	}{
+		{"[::ffff:198.51.100.10]:25565", "198.51.100.10:25565"},
+		{"198.51.100.10:25565", "198.51.100.10:25565"},
+		{"[2001:db8::1]:25565", "[2001:db8::1]:25565"},
+		{"::ffff:198.51.100.10", "198.51.100.10"},
+		{"pipe", "pipe"},
+	}
+	for _, tt := range tests {
+		if got := normalizeClientAddr(tt.addr); got != tt.want {
+			t.Errorf("normalizeClientAddr(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestClientIPSlotsIPv4Mapped(t *testing.T) {
+	v4 := clientIPFromAddr("198.51.100.20:50000")
+	mapped := clientIPFromAddr("[::ffff:198.51.100.20]:50001")
+	if v4 != mapped {
+		t.Fatalf("client IPs differ: %s and %s", v4, mapped)
+	}
+
+	acquireClientIPSlot(v4, 0)
+	defer releaseClientIPSlot(v4)
+	if _, ok := acquireClientIPSlot(mapped, 1); ok {
+		releaseClientIPSlot(mapped)
+		t.Error("the IPv4-mapped form got a slot of its own")
+	}
+	if n := GetConnectionCountForClientIP("::ffff:198.51.100.20"); n != 1 {
+		t.Errorf("count of the IPv4-mapped form = %d, want 1", n)
+	}
+}
+
+func TestResolveIPLiterals(t *testing.T) {
+	tests := map[string]string{
+		"2001:db8::1":         "[2001:db8::1]:25565",
+		"[2001:db8::1]":       "[2001:db8::1]:25565",
+		"[2001:db8::1]:25566": "[2001:db8::1]:25566",
+		"203.0.113.5":         "203.0.113.5:25565",
+	}
+	for in, want := range tests {
+		if got, err := Resolve(in); err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestResolveHostOverrides(t *testing.T) {
+	SetHostOverrides(map[string]string{
+		"play.example.com":  "10.0.0.5:25565",
+		"Lobby.Example.com": "10.0.0.6",
+	})
+	defer SetHostOverrides(nil)
+
+	// a resolver that records its lookups and fails them
+	lookups := 0
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			lookups++
+			return nil, errors.New("no DNS in tests")
+		},
+	}
+
+	tests := map[string]string{
+		"play.example.com":        "10.0.0.5:25565",
+		"play.example.com:25570":  "10.0.0.5:25565",
+		"lobby.example.com.":      "10.0.0.6:25565",
+		"lobby.example.com:25570": "10.0.0.6:25570",
+	}
+	for in, want := range tests {
+		if got, err := resolve(in, resolver); err != nil || got != want {
+			t.Errorf("resolve(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if lookups != 0 {
+		t.Errorf("overridden hosts made %d DNS lookups", lookups)
+	}
+
+	// other hosts still go through the SRV lookup
+	if got, err := resolve("other.example.com", resolver); err != nil || got != "other.example.com:25565" {
+		t.Errorf("resolve(other.example.com) = %q, %v", got, err)
+	}
+	if lookups == 0 {
+		t.Error("a host without an override was not looked up")
+	}
+}
+
+func TestIPv6EndToEnd(t *testing.T) {
+	backend, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer backend.Close()
+
+	// The backend reads the login handshake and stays open until the test ends
+	received := make(chan string, 1)
+	release := make(chan struct{})
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		pkt, err := ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var protocol VarInt
+		var host String
+		pkt.Scan(&protocol, &host)
+		received <- string(host)
+		ReadPacket(conn)
+		<-release
+	}()
+
+	origLookup := lookupPublicIP
+	defer func() { lookupPublicIP = origLookup }()
+	var looked string
+	lookupPublicIP = func(ip string) (string, error) {
+		looked = ip
+		return "2001:db8::99", nil
+	}
+
+	cfg := config.ProxyConfig{
+		Listen:      "[::1]:40060",
+		Remote:      backend.Addr().String(),
+		LocalAddr:   "[::1]:0",
+		Auth:        "none",
+		RewirteHost: "2001:db8::10",
+		RewirtePort: 25565,
+	}
+	registerProxyStats(t, cfg)
+
+	if ip := GetPublicIP(cfg.LocalAddr); ip != "2001:db8::99" || looked != "::1" {
+		t.Errorf("GetPublicIP = %s after looking up %q", ip, looked)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	RegisterConnection(&Connection{
+		ID:         "ipv6-client",
+		ClientAddr: server.RemoteAddr().String(),
+		ProxyAddr:  cfg.Listen,
+		ClientConn: server,
+	})
+	defer UnregisterConnection("ipv6-client")
+
+	go handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil)
+	writeLoginStart(t, client, "Steve")
+
+	select {
+	case host := <-received:
+		if host != cfg.RewirteHost {
+			t.Errorf("backend received host %q", host)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend did not receive the handshake")
+	}
+
+	// The outbound connection is counted against its IPv6 interface address
+	if n := GetConnectionCountForIP("::1"); n != 1 {
+		t.Errorf("GetConnectionCountForIP(::1) = %d, want 1", n)
+	}
+	close(release)
+}
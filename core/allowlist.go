@@ -0,0 +1,35 @@
+package core
+
+import (
+	"net"
+	"net/netip"
+)
+
+// listenAllowlist holds the networks allowed to connect to a listener, an
+// empty list allows everyone
+type listenAllowlist []netip.Prefix
+
+// allows reports whether a connection from addr may be handled
+func (a listenAllowlist) allows(addr net.Addr) bool {
+	return len(a) == 0 || a.contains(addr)
+}
+
+// contains reports whether addr is in one of the networks, never for an
+// empty list
+func (a listenAllowlist) contains(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range a {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mcproxy/config"
+)
+
+// syncBuffer is a bytes.Buffer safe to use as log output from several goroutines
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestListenAllowlistAllows(t *testing.T) {
+	allowlist := listenAllowlist{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.5/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.5"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.6"), Port: 1}, false},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db9::1"), Port: 1}, false},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, false},
+	}
+	for _, tt := range tests {
+		if got := allowlist.allows(tt.addr); got != tt.want {
+			t.Errorf("allows(%v) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	if !listenAllowlist(nil).allows(&net.TCPAddr{IP: net.ParseIP("203.0.113.1")}) {
+		t.Error("empty allowlist should allow everyone")
+	}
+}
+
+// dialAllowlistedProxy starts a proxy with the allowlist and connects to it
+// from 127.0.0.1, returning the log output of the attempt
+func dialAllowlistedProxy(t *testing.T, allowlist []string, wantAllowed bool) string {
+	t.Helper()
+
+	var buf syncBuffer
+	origOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(origOutput)
+
+	cfg := config.ProxyConfig{
+		Listen:          "127.0.0.1:0",
+		Remote:          "backend.example.com:25565",
+		Auth:            "none",
+		ListenAllowlist: allowlist,
+	}
+	registerProxyStats(t, cfg)
+	startProxy(0, cfg, false)
+
+	proxyMutex.Lock()
+	proxy := activeProxies[cfg.Listen]
+	proxyMutex.Unlock()
+	defer close(proxy.stopChan)
+
+	conn, err := net.Dial("tcp", proxy.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// the handler waits for a handshake, an allowed connection stays open
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		t.Fatal("expected no data from the proxy")
+	}
+	if wantAllowed {
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("expected allowed connection to stay open, got %v", err)
+		}
+	} else if err != io.EOF {
+		t.Errorf("expected disallowed connection to be closed, got %v", err)
+	}
+	return buf.String()
+}
+
+func TestStartProxyListenAllowlist(t *testing.T) {
+	out := dialAllowlistedProxy(t, []string{"10.0.0.0/8"}, false)
+	if strings.Contains(out, "New connection") {
+		t.Errorf("handler ran for a connection outside the allowlist:\n%s", out)
+	}
+
+	out = dialAllowlistedProxy(t, []string{"127.0.0.0/8"}, true)
+	if !strings.Contains(out, "New connection") {
+		t.Errorf("handler did not run for an allowed connection:\n%s", out)
+	}
+}
@@ -10,10 +10,8 @@ func allowJoin(username string, cfg config.ProxyConfig) (bool, string, error) {
 	}
 
 	if cfg.Auth == "whitelist" {
-		for _, v := range cfg.Whitelist {
-			if v == username {
-				return true, "", nil
-			}
+		if whitelisted(username, cfg) {
+			return true, "", nil
 		}
 		return false, "You are not in the whitelist", nil
 	}
@@ -30,3 +28,14 @@ func allowJoin(username string, cfg config.ProxyConfig) (bool, string, error) {
 	// should never reach here
 	return false, "", nil
 }
+
+// whitelisted reports whether username is in the proxy's whitelist, which
+// also grants the reserved slots whatever the auth mode
+func whitelisted(username string, cfg config.ProxyConfig) bool {
+	for _, v := range cfg.Whitelist {
+		if v == username {
+			return true
+		}
+	}
+	return false
+}
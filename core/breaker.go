@@ -0,0 +1,142 @@
+package core
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states of a balancer backend
+const (
+	BreakerClosed   = "closed"    // connections are sent to the backend
+	BreakerOpen     = "open"      // the backend is failing and is skipped without dialing
+	BreakerHalfOpen = "half-open" // one probe connection decides whether the backend is back
+)
+
+// breakerFailureThreshold is how many consecutive failed dials open the breaker
+const breakerFailureThreshold = 5
+
+// breakerCooldown is how long an open breaker waits before letting a probe through
+const breakerCooldown = 30 * time.Second
+
+// circuitBreaker follows the dial results of one backend. Consecutive failures
+// open it, after the cooldown a single probe is let through and its result
+// closes the breaker again or reopens it for another cooldown.
+type circuitBreaker struct {
+	name string
+
+	mutex    sync.Mutex
+	state    string
+	failures int       // consecutive failures
+	openedAt time.Time // when the breaker last opened
+	probing  bool      // a half-open probe is in flight
+}
+
+// newCircuitBreaker returns a closed breaker, name is used in log messages
+func newCircuitBreaker(name string) *circuitBreaker {
+	return &circuitBreaker{name: name, state: BreakerClosed}
+}
+
+// ready reports whether a connection would be let through at now, without
+// claiming the probe
+func (b *circuitBreaker) ready(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		return now.Sub(b.openedAt) >= breakerCooldown
+	case BreakerHalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// acquire lets a connection through, an open breaker past its cooldown turns
+// half-open and the connection becomes its probe. It reports whether the
+// connection may dial and whether it is the probe.
+func (b *circuitBreaker) acquire(now time.Time) (allowed, probe bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < breakerCooldown {
+			return false, false
+		}
+		b.state = BreakerHalfOpen
+		log.Printf("[INFO] Backend %s circuit breaker half-open, probing", b.name)
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, false
+		}
+	default:
+		return true, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// release gives up the probe of a connection that ended without dialing, so
+// the next connection can probe
+func (b *circuitBreaker) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+}
+
+// recordSuccess closes the breaker after a successful dial
+func (b *circuitBreaker) recordSuccess() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state != BreakerClosed {
+		log.Printf("[INFO] Backend %s circuit breaker closed, backend recovered", b.name)
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// recordFailure counts a failed dial, opening the breaker at the threshold or
+// when the half-open probe failed
+func (b *circuitBreaker) recordFailure(now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	switch {
+	case b.state == BreakerHalfOpen:
+		log.Printf("[WARN] Backend %s circuit breaker reopened, probe failed", b.name)
+	case b.state == BreakerClosed && b.failures >= breakerFailureThreshold:
+		log.Printf("[WARN] Backend %s circuit breaker opened after %d consecutive failures", b.name, b.failures)
+	default:
+		return
+	}
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.probing = false
+}
+
+// BreakerStatus is the state of a backend's circuit breaker for the API
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+}
+
+// status returns the breaker's current state
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := BreakerStatus{State: b.state, ConsecutiveFailures: b.failures}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"mcproxy/config"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tripBreaker opens b with enough consecutive failures
+func tripBreaker(b *circuitBreaker) {
+	for i := 0; i < breakerFailureThreshold; i++ {
+		b.recordFailure(time.Now())
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	b := newCircuitBreaker("127.0.0.1:40100")
+	start := time.Now()
+
+	// failures below the threshold keep it closed, a success resets the count
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		b.recordFailure(start)
+	}
+	b.recordSuccess()
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		b.recordFailure(start)
+	}
+	if status := b.status(); status.State != BreakerClosed || status.ConsecutiveFailures != breakerFailureThreshold-1 {
+		t.Fatalf("status = %+v, want closed", status)
+	}
+
+	// the threshold opens it, connections are rejected until the cooldown
+	b.recordFailure(start)
+	if status := b.status(); status.State != BreakerOpen || status.OpenedAt == nil {
+		t.Fatalf("status = %+v, want open", status)
+	}
+	if allowed, _ := b.acquire(start.Add(breakerCooldown / 2)); allowed || b.ready(start.Add(breakerCooldown/2)) {
+		t.Fatal("open breaker let a connection through")
+	}
+
+	// after the cooldown a single probe goes through
+	probeAt := start.Add(breakerCooldown)
+	if !b.ready(probeAt) {
+		t.Fatal("breaker not ready after the cooldown")
+	}
+	if allowed, probe := b.acquire(probeAt); !allowed || !probe {
+		t.Fatalf("acquire after cooldown = %t, %t, want the probe", allowed, probe)
+	}
+	if b.status().State != BreakerHalfOpen {
+		t.Fatalf("state = %s, want half-open", b.status().State)
+	}
+	if allowed, _ := b.acquire(probeAt); allowed {
+		t.Fatal("second connection let through while probing")
+	}
+
+	// a failed probe reopens it for another cooldown
+	b.recordFailure(probeAt)
+	if b.status().State != BreakerOpen || b.ready(probeAt.Add(breakerCooldown/2)) {
+		t.Fatalf("state = %s after failed probe, want open", b.status().State)
+	}
+
+	// a probe that ends without dialing lets the next connection probe
+	reprobeAt := probeAt.Add(breakerCooldown)
+	b.acquire(reprobeAt)
+	b.release()
+	if allowed, probe := b.acquire(reprobeAt); !allowed || !probe {
+		t.Fatalf("acquire after release = %t, %t, want the probe", allowed, probe)
+	}
+
+	// a successful probe closes it
+	b.recordSuccess()
+	if status := b.status(); status.State != BreakerClosed || status.ConsecutiveFailures != 0 || status.OpenedAt != nil {
+		t.Fatalf("status = %+v, want closed", status)
+	}
+	if allowed, probe := b.acquire(reprobeAt); !allowed || probe {
+		t.Fatalf("acquire when closed = %t, %t", allowed, probe)
+	}
+}
+
+func TestBalancerBreakerOpensOnDialFailures(t *testing.T) {
+	stubConnectionCounts(t, map[string]int{})
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40101", LocalAddr: "10.0.2.1:0", Remote: "backend.example.com:25565", Auth: "none", MaxPlayer: 10}
+	registerProxyStats(t, cfg)
+	pb := NewProxyBalancer("127.0.0.1:0", []config.ProxyConfig{cfg})
+	pb.onAllUnhealthy = BalancerRejectUnhealthy
+
+	for i := 0; i < breakerFailureThreshold; i++ {
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pb.handleConnection(server)
+		}()
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+		writeLoginStart(t, client, "Steve")
+		io.Copy(io.Discard, client)
+		client.Close()
+		<-done
+	}
+	if state := pb.proxyStats[0].breaker.status().State; state != BreakerOpen {
+		t.Fatalf("state = %s after %d failed dials, want open", state, breakerFailureThreshold)
+	}
+
+	// an open breaker rejects without dialing
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		t.Error("dialed a backend with an open breaker")
+		return nil, errors.New("connection refused")
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	go pb.handleConnection(server)
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	if reason := readDisconnect(t, client); !strings.Contains(reason, noServersMessage) {
+		t.Errorf("disconnect reason = %s", reason)
+	}
+
+	statuses := pb.breakerStatuses()
+	if status := statuses[cfg.Listen]; status.State != BreakerOpen || status.ConsecutiveFailures != breakerFailureThreshold {
+		t.Errorf("breaker status = %+v", status)
+	}
+}
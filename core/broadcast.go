@@ -0,0 +1,362 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Layouts of the clientbound packets that show a system message in the chat
+const (
+	chatPosition       = iota // Chat Message: JSON text and a position byte
+	chatPositionSender        // Chat Message: JSON text, position byte and sender UUID
+	systemChatType            // 1.19 System Chat Message: JSON text and a VarInt type
+	systemChatOverlay         // 1.19.1+ System Chat Message: JSON text and an overlay flag
+)
+
+// chatPacketIDs maps protocol ranges to the play state packet used for system
+// messages. Keep this sorted by protocol number when adding new versions.
+//
+// Like keepAlivePacketIDs only versions that go straight from login to play are
+// listed, 1.20.2+ clients can be in the configuration phase at any time.
+var chatPacketIDs = []struct {
+	minProtocol, maxProtocol int
+	packetID                 int
+	layout                   int
+}{
+	{340, 340, 0x0F, chatPosition},       // 1.12.2
+	{393, 404, 0x0E, chatPosition},       // 1.13 - 1.13.2
+	{477, 498, 0x0E, chatPosition},       // 1.14 - 1.14.4
+	{573, 578, 0x0F, chatPosition},       // 1.15 - 1.15.2
+	{735, 754, 0x0E, chatPositionSender}, // 1.16 - 1.16.5
+	{755, 758, 0x0F, chatPositionSender}, // 1.17 - 1.18.2
+	{759, 759, 0x5F, systemChatType},     // 1.19
+	{760, 760, 0x62, systemChatOverlay},  // 1.19.1 - 1.19.2
+	{761, 761, 0x60, systemChatOverlay},  // 1.19.3
+	{762, 763, 0x64, systemChatOverlay},  // 1.19.4 - 1.20.1
+}
+
+// playDisconnectPacketIDs maps protocol ranges to the play state Disconnect
+// packet, covering the same versions as chatPacketIDs. Keep this sorted by
+// protocol number when adding new versions.
+var playDisconnectPacketIDs = []struct {
+	minProtocol, maxProtocol int
+	packetID                 int
+}{
+	{340, 340, 0x1A}, // 1.12.2
+	{393, 404, 0x1B}, // 1.13 - 1.13.2
+	{477, 498, 0x1A}, // 1.14 - 1.14.4
+	{573, 578, 0x1B}, // 1.15 - 1.15.2
+	{735, 736, 0x1A}, // 1.16 - 1.16.1
+	{751, 754, 0x19}, // 1.16.2 - 1.16.5
+	{755, 758, 0x1A}, // 1.17 - 1.18.2
+	{759, 759, 0x17}, // 1.19
+	{760, 760, 0x19}, // 1.19.1 - 1.19.2
+	{761, 761, 0x17}, // 1.19.3
+	{762, 763, 0x1A}, // 1.19.4 - 1.20.1
+}
+
+// chatPositionSystem is the position byte of a system message
+const chatPositionSystem = 1
+
+var (
+	errChatUnsupported       = errors.New("chat messages not supported for this protocol")
+	errDisconnectUnsupported = errors.New("play disconnect not supported for this protocol")
+	errNotInPlay             = errors.New("connection is not in the play phase")
+)
+
+// lookupChatPacket returns the system message packet of a protocol
+func lookupChatPacket(protocol int) (packetID, layout int, ok bool) {
+	for _, ids := range chatPacketIDs {
+		if protocol >= ids.minProtocol && protocol <= ids.maxProtocol {
+			return ids.packetID, ids.layout, true
+		}
+	}
+	return 0, 0, false
+}
+
+// packSystemChat builds a play state packet showing text in the client's chat
+func packSystemChat(protocol int, text string) (int, []byte, error) {
+	packetID, layout, ok := lookupChatPacket(protocol)
+	if !ok {
+		return 0, nil, errChatUnsupported
+	}
+
+	component, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{text})
+	if err != nil {
+		return 0, nil, err
+	}
+	payload, err := Pack(String(string(component)))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	switch layout {
+	case chatPosition, systemChatType:
+		payload = append(payload, chatPositionSystem)
+	case chatPositionSender:
+		// the nil UUID marks a message without a sender
+		payload = append(payload, chatPositionSystem)
+		payload = append(payload, make([]byte, 16)...)
+	case systemChatOverlay:
+		payload = append(payload, 0x00) // shown in the chat, not above the hotbar
+	}
+	return packetID, payload, nil
+}
+
+// lookupPlayDisconnectID returns the play state Disconnect packet ID of a
+// protocol
+func lookupPlayDisconnectID(protocol int) (int, bool) {
+	for _, ids := range playDisconnectPacketIDs {
+		if protocol >= ids.minProtocol && protocol <= ids.maxProtocol {
+			return ids.packetID, true
+		}
+	}
+	return 0, false
+}
+
+// packPlayDisconnect builds a play state packet disconnecting the client with
+// reason
+func packPlayDisconnect(protocol int, reason string) (int, []byte, error) {
+	packetID, ok := lookupPlayDisconnectID(protocol)
+	if !ok {
+		return 0, nil, errDisconnectUnsupported
+	}
+
+	component, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{reason})
+	if err != nil {
+		return 0, nil, err
+	}
+	payload, err := Pack(String(string(component)))
+	if err != nil {
+		return 0, nil, err
+	}
+	return packetID, payload, nil
+}
+
+// packetInjector sits between the server stream and the client and lets the
+// control panel write whole packets to the client once it is in the play
+// phase, or for 1.20.2+ in the configuration phase. Injected packets are only
+// written between two of the server's packets, packets injected in the middle
+// of one are queued until it ends and dropped if that packet changed the phase.
+//
+// Injection stops for good once the backend enables encryption, since the
+// stream can no longer be followed.
+type packetInjector struct {
+	client   io.Writer
+	username string
+	chat     bool // broadcasts may be injected, set with chat_injection
+
+	mutex    sync.Mutex // guards writes to the client and the stream state
+	frames   frameFilter
+	stream   *streamState
+	disabled bool
+	queued   []queuedPacket
+}
+
+// queuedPacket is an injected frame waiting for the end of a server packet
+type queuedPacket struct {
+	phase int32 // phase the packet was built for
+	frame []byte
+}
+
+// newPacketInjector returns an injector writing to client
+func newPacketInjector(client io.Writer, protocol int, username string) *packetInjector {
+	return &packetInjector{client: client, username: username, stream: newStreamState(protocol)}
+}
+
+// Write forwards data from the server to the client
+func (pi *packetInjector) Write(p []byte) (int, error) {
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+
+	if pi.disabled {
+		if held := pi.frames.flush(); len(held) > 0 {
+			if _, err := pi.client.Write(held); err != nil {
+				return 0, err
+			}
+		}
+		return pi.client.Write(p)
+	}
+
+	out, err := pi.frames.filter(p, pi.hold, pi.observe)
+	if err != nil {
+		pi.stop(fmt.Sprintf("unreadable server stream: %v", err))
+	}
+	if len(out) > 0 {
+		if _, err := pi.client.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	if err := pi.writeQueued(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// hold buffers every frame until the login has finished, and the frames that
+// may change the phase of a 1.20.2+ stream after it
+func (pi *packetInjector) hold(length int) bool {
+	return !pi.stream.loggedIn() || (pi.stream.configurable() && length <= maxPhaseFrame)
+}
+
+// observe follows the phase of the server stream
+func (pi *packetInjector) observe(body []byte) bool {
+	event, err := pi.stream.observe(body)
+	if err != nil {
+		pi.stop(err.Error())
+		return true
+	}
+	if event == streamEncrypted {
+		pi.stop("backend enabled encryption")
+	}
+	return true
+}
+
+// stop disables injection from within the server stream filter, leaving the
+// rest of the data unparsed
+func (pi *packetInjector) stop(reason string) {
+	pi.frames.stop = true
+	pi.disableLocked(reason)
+}
+
+// Inject writes a play state packet to the client, or queues it if the server
+// stream is in the middle of a packet
+func (pi *packetInjector) Inject(packetID int, payload []byte) error {
+	return pi.injectIn(phasePlay, packetID, payload)
+}
+
+// phase returns the phase of the server stream, phaseUnknown once injection
+// has stopped
+func (pi *packetInjector) phase() int32 {
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+	if pi.disabled {
+		return phaseUnknown
+	}
+	return pi.stream.phase()
+}
+
+// injectIn is Inject for a packet of phase
+func (pi *packetInjector) injectIn(phase int32, packetID int, payload []byte) error {
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+
+	if pi.disabled || pi.stream.phase() != phase {
+		return errNotInPlay
+	}
+
+	body, err := Pack(VarInt(packetID))
+	if err != nil {
+		return err
+	}
+	body = append(body, payload...)
+	if pi.stream.compressed() {
+		// a data length of 0 marks an uncompressed packet
+		body = append([]byte{0x00}, body...)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := VarInt(len(body)).WriteTo(buf); err != nil {
+		return err
+	}
+	buf.Write(body)
+
+	// a stalled client must not hold up the broadcast
+	if conn, ok := pi.client.(net.Conn); ok {
+		conn.SetWriteDeadline(time.Now().Add(1 * time.Second))
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+	pi.queued = append(pi.queued, queuedPacket{phase, buf.Bytes()})
+	return pi.writeQueued()
+}
+
+// writeQueued writes the queued packets if the server stream is between
+// packets, packets of a phase the stream has left are dropped
+func (pi *packetInjector) writeQueued() error {
+	if len(pi.queued) == 0 || !pi.frames.atBoundary() {
+		return nil
+	}
+	for _, packet := range pi.queued {
+		if packet.phase != pi.stream.phase() {
+			log.Printf("[DEBUG] Dropped a packet injected for %s, the server changed the phase", pi.username)
+			continue
+		}
+		if _, err := pi.client.Write(packet.frame); err != nil {
+			pi.queued = nil
+			return err
+		}
+	}
+	pi.queued = nil
+	return nil
+}
+
+// disable stops injecting for the rest of the connection
+func (pi *packetInjector) disable(reason string) {
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+	pi.disableLocked(reason)
+}
+
+// disableLocked is disable with the mutex held
+func (pi *packetInjector) disableLocked(reason string) {
+	if pi.disabled {
+		return
+	}
+	pi.disabled = true
+	pi.queued = nil
+	log.Printf("[DEBUG] Packet injection disabled for %s: %s", pi.username, reason)
+}
+
+// BroadcastMessage shows text in the chat of every connection in the play
+// phase and returns how many were sent the message. Connections still logging
+// in, encrypted ones, clients with unsupported protocols and connections of
+// proxies without chat_injection are skipped.
+func BroadcastMessage(text string) int {
+	sent, targets := injectSystemChat(func(*Connection) bool { return true }, text)
+	log.Printf("[INFO] Broadcast message to %d of %d connections: %s", sent, targets, text)
+	return sent
+}
+
+// injectSystemChat shows text in the chat of the connections match selects
+// and returns how many were sent the message of how many have an injector
+func injectSystemChat(match func(conn *Connection) bool, text string) (sent, targets int) {
+	type target struct {
+		username string
+		protocol int
+		injector *packetInjector
+	}
+
+	activeConnections.RLock()
+	var selected []target
+	for _, conn := range activeConnections.connections {
+		if conn.injector != nil && conn.injector.chat && match(conn) {
+			selected = append(selected, target{conn.Username, conn.Protocol, conn.injector})
+		}
+	}
+	activeConnections.RUnlock()
+
+	for _, t := range selected {
+		packetID, payload, err := packSystemChat(t.protocol, text)
+		if err != nil {
+			log.Printf("[DEBUG] Not sending a chat message to %s (%s): %v", t.username, ProtocolName(t.protocol), err)
+			continue
+		}
+		if err := t.injector.Inject(packetID, payload); err != nil {
+			log.Printf("[DEBUG] Not sending a chat message to %s: %v", t.username, err)
+			continue
+		}
+		sent++
+	}
+	return sent, len(selected)
+}
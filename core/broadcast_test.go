@@ -0,0 +1,152 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// registerInjectedConnection registers a connection whose server stream goes
+// through a packet injector and returns the injector and the client's end
+func registerInjectedConnection(t *testing.T, id string, proxyAddr string, protocol int) (*packetInjector, net.Conn) {
+	t.Helper()
+	var injector *packetInjector
+	client := registerTestConnection(t, id, proxyAddr, func(conn *Connection) {
+		injector = newPacketInjector(conn.ClientConn, protocol, id)
+		injector.chat = true
+		conn.Protocol = protocol
+		conn.injector = injector
+	})
+	return injector, client
+}
+
+func TestAPIBroadcast(t *testing.T) {
+	playing, client := registerInjectedConnection(t, "playing", "127.0.0.1:40060", 763)
+	registerInjectedConnection(t, "logging-in", "127.0.0.1:40060", 763)
+
+	pktCh := make(chan Packet, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			pkt, err := ReadPacket(client)
+			if err != nil {
+				return
+			}
+			pktCh <- pkt
+		}
+	}()
+
+	// the server finishes the login of the first connection only
+	payload, _ := Pack(String("playing"))
+	if err := WritePacket(loginSuccess, payload, playing); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := <-pktCh; pkt.ID != loginSuccess {
+		t.Fatalf("got packet 0x%02X, want the login success", pkt.ID)
+	}
+
+	rec := httptest.NewRecorder()
+	handleAPIBroadcast(rec, httptest.NewRequest(http.MethodPost, "/api/broadcast", strings.NewReader(`{"message":"Restart in 5 minutes"}`)))
+	var result map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result["sent"] != float64(1) {
+		t.Fatalf("broadcast: %d %s", rec.Code, rec.Body)
+	}
+
+	var pkt Packet
+	select {
+	case pkt = <-pktCh:
+	case <-time.After(time.Second):
+		t.Fatal("no chat packet written to the client")
+	}
+	var text String
+	if _, err := pkt.Scan(&text); err != nil || pkt.ID != 0x64 || !strings.Contains(string(text), "Restart in 5 minutes") {
+		t.Errorf("got packet 0x%02X %q, %v", pkt.ID, text, err)
+	}
+
+	rec = httptest.NewRecorder()
+	handleAPIBroadcast(rec, httptest.NewRequest(http.MethodPost, "/api/broadcast", strings.NewReader(`{"message":" "}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("empty message: %d, want 400", rec.Code)
+	}
+}
+
+func TestDrainProxyNotice(t *testing.T) {
+	proxyMutex.Lock()
+	activeProxies["127.0.0.1:40060"] = &proxyInstance{stopChan: make(chan struct{})}
+	proxyMutex.Unlock()
+	t.Cleanup(func() {
+		proxyMutex.Lock()
+		delete(activeProxies, "127.0.0.1:40060")
+		proxyMutex.Unlock()
+	})
+
+	playing, client := registerInjectedConnection(t, "drain-notice", "127.0.0.1:40060", 763)
+	pktCh := make(chan Packet, 3)
+	go func() {
+		for {
+			pkt, err := ReadPacket(client)
+			if err != nil {
+				return
+			}
+			pktCh <- pkt
+		}
+	}()
+	payload, _ := Pack(String("drain-notice"))
+	if err := WritePacket(loginSuccess, payload, playing); err != nil {
+		t.Fatal(err)
+	}
+	<-pktCh
+
+	rec := httptest.NewRecorder()
+	handleAPIDrainProxy(rec, httptest.NewRequest(http.MethodPost, "/api/proxy/drain?listen=127.0.0.1:40060&message=Moving&grace=200ms", nil))
+	var result map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || rec.Code != http.StatusAccepted || result["draining"] != float64(1) {
+		t.Fatalf("drain: %d %s", rec.Code, rec.Body)
+	}
+
+	// the chat notice comes right away, the disconnect after the grace
+	start := time.Now()
+	for _, want := range []int{0x64, 0x1A} {
+		select {
+		case pkt := <-pktCh:
+			var text String
+			if _, err := pkt.Scan(&text); err != nil || pkt.ID != want || !strings.Contains(string(text), "Moving") {
+				t.Errorf("got packet 0x%02X %q, want 0x%02X", pkt.ID, text, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no packet 0x%02X written to the client", want)
+		}
+		if want == 0x64 && time.Since(start) > 100*time.Millisecond {
+			t.Error("chat notice arrived late")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("disconnected after %v, before the grace passed", elapsed)
+	}
+}
+
+func TestPacketInjectorQueuesMidPacket(t *testing.T) {
+	var out bytes.Buffer
+	injector := newPacketInjector(&out, 763, "queued")
+	payload, _ := Pack(String("queued"))
+	if err := WritePacket(loginSuccess, payload, injector); err != nil {
+		t.Fatal(err)
+	}
+
+	// half of a server packet has been forwarded
+	injector.Write([]byte{0x03, 0x20})
+	if err := injector.Inject(0x64, []byte{0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasSuffix(out.Bytes(), []byte{0x02, 0x64, 0x00}) {
+		t.Fatal("packet injected in the middle of a server packet")
+	}
+	injector.Write([]byte{0x01, 0x02})
+	if !bytes.HasSuffix(out.Bytes(), []byte{0x03, 0x20, 0x01, 0x02, 0x02, 0x64, 0x00}) {
+		t.Errorf("stream = %x, want the queued packet after the server packet", out.Bytes())
+	}
+}
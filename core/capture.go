@@ -0,0 +1,281 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Directions of captured frames
+const (
+	captureClientbound = "S->C"
+	captureServerbound = "C->S"
+)
+
+// defaultCaptureDuration is how long a capture runs when no duration is given
+const defaultCaptureDuration = 30 * time.Second
+
+// maxCaptureDuration bounds how long a single capture runs
+const maxCaptureDuration = 10 * time.Minute
+
+// maxCaptureEntries bounds the trace of a capture, consecutive frames of the
+// same packet share one entry
+const maxCaptureEntries = 200
+
+var (
+	errCaptureUnsupported = errors.New("packet capture is not enabled for this connection")
+	errCaptureStopped     = errors.New("packets of this connection can no longer be followed")
+	errCaptureRunning     = errors.New("a capture is already running for this connection")
+)
+
+// captureEntry is a run of consecutive frames with the same direction and ID
+type captureEntry struct {
+	Direction string
+	PacketID  int
+	Frames    int
+	Bytes     int
+}
+
+// packetCapture follows the frames of both directions of a connection so a
+// trace of their packet IDs and lengths can be written to the logs for a
+// while. Payloads are never recorded.
+//
+// Following stops for good once the backend enables encryption, since the
+// stream can no longer be read.
+type packetCapture struct {
+	client   io.Writer
+	username string
+
+	toClient frameFilter // only used by the server to client goroutine
+	toServer frameFilter // only used by the client to server goroutine
+
+	mutex    sync.Mutex // guards the fields below
+	stream   streamState
+	stopped  bool
+	started  time.Time // zero while no capture is running
+	timer    *time.Timer
+	entries  []captureEntry
+	frames   int
+	bytes    int
+	untraced int // frames left out of entries once the trace was full
+}
+
+// newPacketCapture returns a capture writing the server stream to client
+func newPacketCapture(client io.Writer, username string) *packetCapture {
+	return &packetCapture{client: client, username: username}
+}
+
+// Write forwards data from the server to the client
+func (pc *packetCapture) Write(p []byte) (int, error) {
+	if pc.isStopped() {
+		if held := pc.toClient.flush(); len(held) > 0 {
+			if _, err := pc.client.Write(held); err != nil {
+				return 0, err
+			}
+		}
+		return pc.client.Write(p)
+	}
+
+	out, err := pc.toClient.filter(p, pc.holdClientbound, pc.observeClientbound)
+	if err != nil {
+		pc.stop(fmt.Sprintf("unreadable server stream: %v", err))
+	}
+	if len(out) > 0 {
+		if _, err := pc.client.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// FilterServerbound follows data from the client to the server and returns
+// the bytes to forward, frames are held back until complete while capturing
+func (pc *packetCapture) FilterServerbound(data []byte) []byte {
+	if pc.isStopped() {
+		if held := pc.toServer.flush(); len(held) > 0 {
+			return append(held, data...)
+		}
+		return data
+	}
+
+	out, err := pc.toServer.filter(data, pc.holdServerbound, pc.observeServerbound)
+	if err != nil {
+		pc.stop(fmt.Sprintf("unreadable client stream: %v", err))
+	}
+	return out
+}
+
+// holdClientbound buffers login frames, which are inspected, and every frame
+// while capturing
+func (pc *packetCapture) holdClientbound(length int) bool {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	return !pc.stream.loggedIn() || !pc.started.IsZero()
+}
+
+// holdServerbound buffers every frame while capturing
+func (pc *packetCapture) holdServerbound(length int) bool {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	return !pc.started.IsZero()
+}
+
+// observeClientbound follows the login of the server stream and records frames
+func (pc *packetCapture) observeClientbound(body []byte) bool {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	// the rest of the data is passed on unparsed once following stopped
+	defer func() { pc.toClient.stop = pc.stopped }()
+
+	id, _, err := readPacketID(body, pc.stream.compressed())
+	if err != nil {
+		pc.stopLocked(fmt.Sprintf("unreadable server packet: %v", err))
+		return true
+	}
+	pc.record(captureClientbound, id, len(body))
+
+	if pc.stream.loggedIn() {
+		return true
+	}
+	event, err := pc.stream.observe(body)
+	if err != nil {
+		pc.stopLocked(err.Error())
+		return true
+	}
+	if event == streamEncrypted {
+		pc.stopLocked("backend enabled encryption")
+	}
+	return true
+}
+
+// observeServerbound records frames of the client stream
+func (pc *packetCapture) observeServerbound(body []byte) bool {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	defer func() { pc.toServer.stop = pc.stopped }()
+
+	id, _, err := readPacketID(body, pc.stream.compressed())
+	if err != nil {
+		pc.stopLocked(fmt.Sprintf("unreadable client packet: %v", err))
+		return true
+	}
+	pc.record(captureServerbound, id, len(body))
+	return true
+}
+
+// record adds a frame to the trace of a running capture
+func (pc *packetCapture) record(direction string, packetID, length int) {
+	if pc.started.IsZero() {
+		return
+	}
+	pc.frames++
+	pc.bytes += length
+
+	if n := len(pc.entries); n > 0 && pc.entries[n-1].Direction == direction && pc.entries[n-1].PacketID == packetID {
+		pc.entries[n-1].Frames++
+		pc.entries[n-1].Bytes += length
+		return
+	}
+	if len(pc.entries) >= maxCaptureEntries {
+		pc.untraced++
+		return
+	}
+	pc.entries = append(pc.entries, captureEntry{direction, packetID, 1, length})
+}
+
+// start begins a capture that ends after d
+func (pc *packetCapture) start(d time.Duration) error {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if pc.stopped {
+		return errCaptureStopped
+	}
+	if !pc.started.IsZero() {
+		return errCaptureRunning
+	}
+
+	pc.started = time.Now()
+	pc.entries = nil
+	pc.frames, pc.bytes, pc.untraced = 0, 0, 0
+	pc.timer = time.AfterFunc(d, pc.finish)
+	log.Printf("[INFO] Capturing packets of %s for %v", pc.username, d)
+	return nil
+}
+
+// finish ends a running capture and writes its trace to the logs
+func (pc *packetCapture) finish() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.finishLocked()
+}
+
+// finishLocked is finish with the mutex held
+func (pc *packetCapture) finishLocked() {
+	if pc.started.IsZero() {
+		return
+	}
+	pc.timer.Stop()
+
+	log.Printf("[INFO] Packet capture of %s: %d frames, %d bytes in %v",
+		pc.username, pc.frames, pc.bytes, time.Since(pc.started).Round(time.Millisecond))
+	for _, entry := range pc.entries {
+		log.Printf("[INFO] Capture %s: %s 0x%02X x%d, %d bytes",
+			pc.username, entry.Direction, entry.PacketID, entry.Frames, entry.Bytes)
+	}
+	if pc.untraced > 0 {
+		log.Printf("[INFO] Capture %s: %d more frames not traced", pc.username, pc.untraced)
+	}
+
+	pc.started = time.Time{}
+	pc.timer = nil
+	pc.entries = nil
+}
+
+// isStopped reports whether the streams are no longer followed
+func (pc *packetCapture) isStopped() bool {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	return pc.stopped
+}
+
+// stop stops following the streams for the rest of the connection, a running
+// capture is finished
+func (pc *packetCapture) stop(reason string) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.stopLocked(reason)
+}
+
+// stopLocked is stop with the mutex held
+func (pc *packetCapture) stopLocked(reason string) {
+	if pc.stopped {
+		return
+	}
+	pc.stopped = true
+	log.Printf("[DEBUG] Packet capture stopped for %s: %s", pc.username, reason)
+	pc.finishLocked()
+}
+
+// StartCapture writes a trace of the packet IDs and lengths of a connection
+// to the logs after d. The connection's proxy needs packet_capture enabled.
+func StartCapture(id string, d time.Duration) error {
+	activeConnections.RLock()
+	conn := activeConnections.connections[id]
+	var capture *packetCapture
+	if conn != nil {
+		capture = conn.capture
+	}
+	activeConnections.RUnlock()
+
+	if conn == nil {
+		return errConnectionNotFound
+	}
+	if capture == nil {
+		return errCaptureUnsupported
+	}
+	return capture.start(d)
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// registerCapturedConnection registers a connection whose streams go through a
+// packet capture and returns the capture and the data written to the client
+func registerCapturedConnection(t *testing.T, id string, proxyAddr string) (*packetCapture, *bytes.Buffer) {
+	t.Helper()
+	client := new(bytes.Buffer)
+	capture := newPacketCapture(client, id)
+	registerTestConnection(t, id, proxyAddr, func(conn *Connection) {
+		conn.capture = capture
+	})
+	t.Cleanup(capture.finish)
+	return capture, client
+}
+
+func postCapture(query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handleAPICapture(rec, httptest.NewRequest(http.MethodPost, "/api/capture?"+query, nil))
+	return rec
+}
+
+func TestAPICaptureRecordsFrames(t *testing.T) {
+	capture, client := registerCapturedConnection(t, "captured", "127.0.0.1:40070")
+
+	// frames before the capture starts are forwarded but not recorded
+	payload, _ := Pack(String("captured"))
+	if err := WritePacket(loginSuccess, payload, capture); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := postCapture("id=captured&duration=1m"); rec.Code != http.StatusOK {
+		t.Fatalf("start capture: %d %s", rec.Code, rec.Body)
+	}
+	if rec := postCapture("id=captured"); rec.Code != http.StatusConflict {
+		t.Errorf("second capture: %d, want 409", rec.Code)
+	}
+
+	var serverStream, clientStream bytes.Buffer
+	WritePacket(0x26, []byte{0x01, 0x02, 0x03}, &serverStream)
+	WritePacket(0x26, []byte{0x04}, &serverStream)
+	WritePacket(0x12, []byte{0x05, 0x06}, &clientStream)
+	want := append(bytes.Clone(client.Bytes()), serverStream.Bytes()...)
+
+	// a frame split across reads is recorded once it is complete
+	data := clientStream.Bytes()
+	forwarded := append(capture.FilterServerbound(data[:2]), capture.FilterServerbound(data[2:])...)
+	if !bytes.Equal(forwarded, data) {
+		t.Errorf("forwarded %x to the server, want %x", forwarded, data)
+	}
+	if _, err := capture.Write(serverStream.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(client.Bytes(), want) {
+		t.Errorf("forwarded %x to the client, want %x", client.Bytes(), want)
+	}
+
+	capture.mutex.Lock()
+	entries := append([]captureEntry(nil), capture.entries...)
+	capture.mutex.Unlock()
+	wantEntries := []captureEntry{
+		{captureServerbound, 0x12, 1, 3},
+		{captureClientbound, 0x26, 2, 6},
+	}
+	if len(entries) != len(wantEntries) {
+		t.Fatalf("entries = %+v, want %+v", entries, wantEntries)
+	}
+	for i := range entries {
+		if entries[i] != wantEntries[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], wantEntries[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	origOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(origOutput)
+
+	capture.finish()
+	out := buf.String()
+	for _, line := range []string{
+		"Packet capture of captured: 3 frames, 9 bytes",
+		"Capture captured: C->S 0x12 x1, 3 bytes",
+		"Capture captured: S->C 0x26 x2, 6 bytes",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in the trace, got:\n%s", line, out)
+		}
+	}
+}
+
+func TestAPICaptureErrors(t *testing.T) {
+	registerPipeConnection(t, "not-captured", "127.0.0.1:40071")
+	registerCapturedConnection(t, "captured-errors", "127.0.0.1:40070")
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", http.StatusBadRequest},
+		{"id=missing", http.StatusNotFound},
+		{"id=not-captured", http.StatusBadRequest},
+		{"id=captured-errors&duration=soon", http.StatusBadRequest},
+		{"id=captured-errors&duration=1h", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := postCapture(tt.query); rec.Code != tt.want {
+			t.Errorf("%q: %d, want %d", tt.query, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// With client_compression_threshold the proxy enables compression towards the
+// client itself when the backend leaves it off: it sends its own Set
+// Compression right before the backend's login success and from then on
+// compresses every frame to the client and decompresses every frame from it.
+// Both directions are split into frames for this instead of copied as bytes.
+//
+// The backend has to be in offline mode. When it sends a Set Compression or an
+// encryption request itself, the stream is passed through unchanged.
+
+// maxDecompressedLength is the largest packet a client may send compressed,
+// the limit of vanilla servers
+const maxDecompressedLength = 1 << 23
+
+// clientCompression is the compression negotiated with one client, shared by
+// both directions of the session
+type clientCompression struct {
+	threshold   int         // Packets of at least this many bytes are compressed
+	enabled     atomic.Bool // Set Compression was sent to the client
+	passthrough atomic.Bool // the backend compresses or encrypts, streams are left alone
+}
+
+// clientCompressor turns the uncompressed stream to the client into a
+// compressed one once the login succeeds
+type clientCompressor struct {
+	client   io.Writer
+	state    *clientCompression
+	username string
+
+	mutex   sync.Mutex // guards everything below and writes to the client
+	stream  streamState
+	pending []byte // start of an incomplete frame
+	buf     bytes.Buffer
+	zw      *zlib.Writer
+}
+
+func newClientCompressor(client io.Writer, state *clientCompression, username string) *clientCompressor {
+	c := &clientCompressor{client: client, state: state, username: username}
+	c.zw = zlib.NewWriter(&c.buf)
+	return c
+}
+
+// Write forwards data from the server to the client
+func (c *clientCompressor) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.state.passthrough.Load() {
+		if _, err := c.client.Write(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	c.pending = append(c.pending, p...)
+	out, err := c.frames()
+	if err != nil && c.state.enabled.Load() {
+		// the client expects compressed frames now, the stream can not be
+		// passed through anymore
+		return 0, fmt.Errorf("compress stream: %w", err)
+	}
+	if err != nil {
+		// the rest of the stream is forwarded as it is
+		log.Printf("[WARN] Stopped compressing the stream to %s: %v", c.username, err)
+		c.state.passthrough.Store(true)
+		out = append(out, c.pending...)
+		c.pending = nil
+	}
+	if len(out) > 0 {
+		if _, err := c.client.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// frames returns the complete frames in pending as they are sent to the
+// client and keeps the rest
+func (c *clientCompressor) frames() ([]byte, error) {
+	var out []byte
+	for {
+		var length VarInt
+		n, err := length.ReadFrom(bytes.NewReader(c.pending))
+		if err != nil {
+			if len(c.pending) >= 3 {
+				return out, errBadFrame
+			}
+			return out, nil // the length is not complete yet
+		}
+		if length <= 0 || length > maxFrameLength {
+			return out, errBadFrame
+		}
+		end := int(n) + int(length)
+		if len(c.pending) < end {
+			return out, nil
+		}
+		frame, body := c.pending[:end], c.pending[n:end]
+
+		if c.state.enabled.Load() {
+			compressed, err := c.compress(body)
+			if err != nil {
+				return out, err
+			}
+			out = append(out, compressed...)
+		} else {
+			event, err := c.stream.observe(body)
+			if err != nil {
+				return out, err
+			}
+			switch {
+			case event == streamEncrypted || c.stream.compressed():
+				log.Printf("[DEBUG] Backend of %s compresses or encrypts, not compressing the stream to the client", c.username)
+				c.state.passthrough.Store(true)
+				out = append(out, c.pending...)
+				c.pending = c.pending[:0]
+				return out, nil
+			case event == streamLoggedIn:
+				setCompression, err := Pack(VarInt(loginSetCompression), VarInt(c.state.threshold))
+				if err != nil {
+					return out, err
+				}
+				out = appendFrame(out, setCompression)
+				// enabled before the client can answer in the new format
+				c.state.enabled.Store(true)
+				compressed, err := c.compress(body)
+				if err != nil {
+					return out, err
+				}
+				out = append(out, compressed...)
+			default:
+				out = append(out, frame...)
+			}
+		}
+		c.pending = c.pending[:copy(c.pending, c.pending[end:])]
+	}
+}
+
+// compress returns the frame of an uncompressed packet in the compressed
+// format, zlib compressed if it reaches the threshold
+func (c *clientCompressor) compress(packet []byte) ([]byte, error) {
+	var data []byte
+	if len(packet) < c.state.threshold {
+		// a data length of 0 marks an uncompressed packet
+		data = append([]byte{0x00}, packet...)
+	} else {
+		c.buf.Reset()
+		c.zw.Reset(&c.buf)
+		if _, err := c.zw.Write(packet); err != nil {
+			return nil, err
+		}
+		if err := c.zw.Close(); err != nil {
+			return nil, err
+		}
+		header := new(bytes.Buffer)
+		VarInt(len(packet)).WriteTo(header)
+		data = append(header.Bytes(), c.buf.Bytes()...)
+	}
+	return appendFrame(nil, data), nil
+}
+
+// appendFrame appends body with its length prefix to out
+func appendFrame(out, body []byte) []byte {
+	header := new(bytes.Buffer)
+	VarInt(len(body)).WriteTo(header)
+	out = append(out, header.Bytes()...)
+	return append(out, body...)
+}
+
+// clientDecompressor reads the stream from the client and returns it in the
+// uncompressed format the backend expects
+type clientDecompressor struct {
+	r     *bufio.Reader
+	state *clientCompression
+	out   []byte // decoded bytes not read yet
+	zr    io.ReadCloser
+}
+
+func newClientDecompressor(r *bufio.Reader, state *clientCompression) *clientDecompressor {
+	return &clientDecompressor{r: r, state: state}
+}
+
+func (d *clientDecompressor) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.state.passthrough.Load() {
+			return d.r.Read(p)
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// next decodes the next frame from the client
+func (d *clientDecompressor) next() error {
+	body, err := readFrameBody(d.r)
+	if err != nil {
+		return err
+	}
+	if !d.state.enabled.Load() || d.state.passthrough.Load() {
+		d.out = appendFrame(d.out[:0], body)
+		return nil
+	}
+
+	r := bytes.NewReader(body)
+	var dataLength VarInt
+	if _, err := dataLength.ReadFrom(r); err != nil {
+		return err
+	}
+	if dataLength == 0 {
+		d.out = appendFrame(d.out[:0], body[len(body)-r.Len():])
+		return nil
+	}
+	if dataLength < 0 || dataLength > maxDecompressedLength {
+		return fmt.Errorf("bad decompressed length %d", dataLength)
+	}
+
+	if d.zr == nil {
+		d.zr, err = zlib.NewReader(r)
+	} else {
+		err = d.zr.(zlib.Resetter).Reset(r, nil)
+	}
+	if err != nil {
+		return err
+	}
+	packet := make([]byte, dataLength)
+	if _, err := io.ReadFull(d.zr, packet); err != nil {
+		return fmt.Errorf("decompress: %w", err)
+	}
+	if n, _ := d.zr.Read(make([]byte, 1)); n > 0 {
+		return errors.New("decompressed packet longer than its data length")
+	}
+	d.out = appendFrame(d.out[:0], packet)
+	return nil
+}
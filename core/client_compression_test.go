@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestClientCompressorSetCompression(t *testing.T) {
+	var client bytes.Buffer
+	state := &clientCompression{threshold: 256}
+	c := newClientCompressor(&client, state, "Steve")
+
+	// the login success arrives in pieces
+	success := frame(t, false, loginSuccess, String("00000000-0000-0000-0000-000000000000"), String("Steve"))
+	for i := range success {
+		if _, err := c.Write(success[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !state.enabled.Load() {
+		t.Fatal("compression not enabled after the login success")
+	}
+
+	id, payload := readFrame(t, &client, false)
+	if id != loginSetCompression {
+		t.Fatalf("first packet = 0x%02X, want set compression", id)
+	}
+	var threshold VarInt
+	threshold.ReadFrom(bytes.NewReader(payload))
+	if threshold != 256 {
+		t.Errorf("threshold = %d, want 256", threshold)
+	}
+
+	id, payload = readFrame(t, &client, true)
+	if id != loginSuccess {
+		t.Fatalf("second packet = 0x%02X, want login success", id)
+	}
+	var uuid, name String
+	r := bytes.NewReader(payload)
+	uuid.ReadFrom(r)
+	name.ReadFrom(r)
+	if name != "Steve" {
+		t.Errorf("login success name = %q, want Steve", name)
+	}
+	if client.Len() != 0 {
+		t.Errorf("%d unexpected bytes after the login success", client.Len())
+	}
+}
+
+func TestClientCompressionRoundTrip(t *testing.T) {
+	largeFrame := frame(t, false, 0x25, String(bytes.Repeat([]byte("chunk data "), 1000)))
+	large, _ := readFrameBody(bytes.NewReader(largeFrame))
+	var stream []byte
+	stream = append(stream, frame(t, false, 0x24, VarInt(7))...)
+	stream = append(stream, largeFrame...)
+
+	var compressed bytes.Buffer
+	state := &clientCompression{threshold: 256}
+	state.enabled.Store(true)
+	if _, err := newClientCompressor(&compressed, state, "Steve").Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	if compressed.Len() >= len(stream) {
+		t.Errorf("compressed stream is %d bytes, uncompressed %d", compressed.Len(), len(stream))
+	}
+
+	// the small packet is sent as it is, the large one zlib compressed
+	r := bytes.NewReader(compressed.Bytes())
+	small, _ := readFrameBody(r)
+	if small[0] != 0x00 {
+		t.Errorf("small packet has data length %d, want 0", small[0])
+	}
+	body, _ := readFrameBody(r)
+	var dataLength VarInt
+	dataLength.ReadFrom(bytes.NewReader(body))
+	if int(dataLength) != len(large) {
+		t.Errorf("large packet has data length %d, want %d", dataLength, len(large))
+	}
+
+	got, err := io.ReadAll(newClientDecompressor(bufio.NewReader(&compressed), state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, stream) {
+		t.Error("decompressed stream differs from the original")
+	}
+}
+
+func TestClientCompressorBackendCompression(t *testing.T) {
+	var client bytes.Buffer
+	state := &clientCompression{threshold: 256}
+	c := newClientCompressor(&client, state, "Steve")
+
+	var stream []byte
+	stream = append(stream, frame(t, false, loginSetCompression, VarInt(64))...)
+	stream = append(stream, frame(t, true, loginSuccess, String("00000000-0000-0000-0000-000000000000"), String("Steve"))...)
+	if _, err := c.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	if !state.passthrough.Load() || state.enabled.Load() {
+		t.Fatal("backend compression not passed through")
+	}
+	if !bytes.Equal(client.Bytes(), stream) {
+		t.Error("stream of a compressing backend was changed")
+	}
+}
+
+func TestClientCompressorBadFrameAfterSetCompression(t *testing.T) {
+	var client bytes.Buffer
+	state := &clientCompression{threshold: 256}
+	state.enabled.Store(true)
+	c := newClientCompressor(&client, state, "Steve")
+
+	// a frame length the client can not be sent compressed
+	if _, err := c.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F}); err == nil {
+		t.Fatal("expected an error for a bad frame after set compression")
+	}
+	if state.passthrough.Load() {
+		t.Error("stream passed through after set compression was sent")
+	}
+	if client.Len() != 0 {
+		t.Errorf("%d bytes of the bad frame reached the client", client.Len())
+	}
+}
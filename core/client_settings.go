@@ -0,0 +1,331 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+)
+
+// clientSettingsPacketIDs maps protocol ranges to the serverbound Client
+// Settings packet ID. Keep this sorted by protocol number when adding new
+// versions.
+//
+// Up to 1.20.1 the client sends its settings in the play state, 1.20.2+ sends
+// them as Client Information in the configuration phase right after the login.
+var clientSettingsPacketIDs = []struct {
+	minProtocol, maxProtocol int
+	packetID                 int
+}{
+	{340, 340, 0x04}, // 1.12.2
+	{393, 404, 0x04}, // 1.13 - 1.13.2
+	{477, 498, 0x05}, // 1.14 - 1.14.4
+	{573, 578, 0x05}, // 1.15 - 1.15.2
+	{735, 736, 0x05}, // 1.16 - 1.16.1
+	{751, 754, 0x05}, // 1.16.2 - 1.16.5
+	{755, 758, 0x05}, // 1.17 - 1.18.2
+	{759, 759, 0x07}, // 1.19
+	{760, 760, 0x08}, // 1.19.1 - 1.19.2
+	{761, 761, 0x07}, // 1.19.3
+	{762, 763, 0x08}, // 1.19.4 - 1.20.1
+	{764, 769, 0x00}, // 1.20.2 - 1.21.4, configuration phase
+}
+
+// lookupClientSettingsID returns the Client Settings packet ID of a protocol
+func lookupClientSettingsID(protocol int) (int, bool) {
+	for _, ids := range clientSettingsPacketIDs {
+		if protocol >= ids.minProtocol && protocol <= ids.maxProtocol {
+			return ids.packetID, true
+		}
+	}
+	return 0, false
+}
+
+// maxClientSettingsFrame is the largest serverbound frame inspected for the
+// Client Settings, the locale is at most 16 characters
+const maxClientSettingsFrame = 256
+
+// maxLocaleFrames is how many serverbound play state frames are inspected
+// before giving up, clients send their settings right after joining
+const maxLocaleFrames = 32
+
+// maxLocaleLength is the longest locale accepted from a client
+const maxLocaleLength = 16
+
+// maxTrackedLocales bounds the distinct locales counted, clients choose the
+// string so later ones are counted as localeOther
+const maxTrackedLocales = 256
+
+var errBadLocale = errors.New("bad locale length")
+
+// localeOther counts locales that are malformed or beyond maxTrackedLocales
+const localeOther = "other"
+
+// localeWatcher follows a forwarded login until the client sends its first
+// Client Settings packet after it, and stores the locale from it on
+// the connection. Nothing is changed in either direction.
+//
+// Following stops for good once the locale is known or the backend enables
+// encryption, since the stream can no longer be read.
+type localeWatcher struct {
+	client   io.Writer
+	username string
+	packetID int
+	conn     *Connection // nil when the connection is not registered
+
+	toClient frameFilter // only used by the server to client goroutine
+	toServer frameFilter // only used by the client to server goroutine
+	inPlay   int         // play state frames inspected, only used by the client to server goroutine
+
+	mutex   sync.Mutex // guards the fields below
+	stream  streamState
+	stopped bool
+}
+
+// newLocaleWatcher returns a watcher writing the server stream to client, or
+// nil if the protocol's Client Settings packet is unknown
+func newLocaleWatcher(client io.Writer, protocol int, conn *Connection, username string) *localeWatcher {
+	packetID, ok := lookupClientSettingsID(protocol)
+	if !ok {
+		log.Printf("[DEBUG] Locale capture not supported for protocol %s, user %s", ProtocolName(protocol), username)
+		return nil
+	}
+	return &localeWatcher{client: client, username: username, packetID: packetID, conn: conn}
+}
+
+// Write forwards data from the server to the client
+func (lw *localeWatcher) Write(p []byte) (int, error) {
+	if lw.isStopped() {
+		if held := lw.toClient.flush(); len(held) > 0 {
+			if _, err := lw.client.Write(held); err != nil {
+				return 0, err
+			}
+		}
+		return lw.client.Write(p)
+	}
+
+	out, err := lw.toClient.filter(p, func(int) bool { return true }, lw.observeClientbound)
+	if err != nil {
+		lw.stop(fmt.Sprintf("unreadable server stream: %v", err))
+	}
+	if len(out) > 0 {
+		if _, err := lw.client.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// FilterServerbound follows data from the client to the server and returns
+// the bytes to forward, small frames are held back until complete while the
+// locale is not known
+func (lw *localeWatcher) FilterServerbound(data []byte) []byte {
+	if lw.isStopped() {
+		if held := lw.toServer.flush(); len(held) > 0 {
+			return append(held, data...)
+		}
+		return data
+	}
+
+	out, err := lw.toServer.filter(data, lw.holdServerbound, lw.observeServerbound)
+	if err != nil {
+		lw.stop(fmt.Sprintf("unreadable client stream: %v", err))
+	}
+	return out
+}
+
+// observeClientbound follows the login of the server stream, the rest of it
+// is passed on unparsed
+func (lw *localeWatcher) observeClientbound(body []byte) bool {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	event, err := lw.stream.observe(body)
+	if err != nil {
+		lw.stopLocked(err.Error())
+		return true
+	}
+
+	switch event {
+	case streamEncrypted:
+		lw.stopLocked("backend enabled encryption")
+	case streamLoggedIn:
+		lw.toClient.stop = true
+	}
+	return true
+}
+
+// holdServerbound buffers the frames small enough to be the Client Settings
+func (lw *localeWatcher) holdServerbound(length int) bool {
+	return length <= maxClientSettingsFrame
+}
+
+// observeServerbound reads the locale from the first Client Settings packet
+func (lw *localeWatcher) observeServerbound(body []byte) bool {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	if !lw.stream.loggedIn() {
+		return true
+	}
+	// the rest of the data is passed on unparsed once following stopped
+	defer func() { lw.toServer.stop = lw.stopped }()
+
+	id, payload, err := readPacketID(body, lw.stream.compressed())
+	if err != nil {
+		lw.stopLocked(fmt.Sprintf("unreadable client packet: %v", err))
+		return true
+	}
+	if id != lw.packetID {
+		if lw.inPlay++; lw.inPlay >= maxLocaleFrames {
+			lw.stopLocked("no client settings received")
+		}
+		return true
+	}
+
+	locale, err := readLocale(payload)
+	if err != nil {
+		lw.stopLocked(fmt.Sprintf("unreadable client settings: %v", err))
+		return true
+	}
+	lw.stopLocked("locale received")
+	recordLocale(lw.conn, locale)
+	return true
+}
+
+// readLocale returns the locale at the start of a Client Settings payload,
+// the length is checked first as the client chooses it
+func readLocale(payload []byte) (string, error) {
+	r := bytes.NewReader(payload)
+	var length VarInt
+	if _, err := length.ReadFrom(r); err != nil {
+		return "", err
+	}
+	if length < 0 || int(length) > r.Len() {
+		return "", errBadLocale
+	}
+	locale := make([]byte, length)
+	r.Read(locale)
+	return string(locale), nil
+}
+
+// isStopped reports whether following has stopped
+func (lw *localeWatcher) isStopped() bool {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	return lw.stopped
+}
+
+// stop ends following the stream, the held frames are forwarded with the
+// next data
+func (lw *localeWatcher) stop(reason string) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	lw.stopLocked(reason)
+}
+
+// stopLocked is stop with the mutex held
+func (lw *localeWatcher) stopLocked(reason string) {
+	if lw.stopped {
+		return
+	}
+	lw.stopped = true
+	log.Printf("[DEBUG] Locale capture stopped for %s: %s", lw.username, reason)
+}
+
+// localeCounts counts the connections since the start by the locale of their
+// Client Settings
+var localeCounts = struct {
+	sync.Mutex
+	counts map[string]int64
+}{counts: make(map[string]int64)}
+
+// recordLocale stores the locale on conn and counts it
+func recordLocale(conn *Connection, locale string) {
+	locale = normalizeLocale(locale)
+	if conn != nil {
+		activeConnections.Lock()
+		conn.Locale = locale
+		activeConnections.Unlock()
+	}
+
+	localeCounts.Lock()
+	if _, ok := localeCounts.counts[locale]; !ok && len(localeCounts.counts) >= maxTrackedLocales {
+		locale = localeOther
+	}
+	localeCounts.counts[locale]++
+	localeCounts.Unlock()
+}
+
+// normalizeLocale returns the locale in lower case, or localeOther if it is
+// not made of letters, digits, '_' and '-'
+func normalizeLocale(locale string) string {
+	if locale == "" || len(locale) > maxLocaleLength {
+		return localeOther
+	}
+	b := []byte(locale)
+	for i, c := range b {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			b[i] = c + 'a' - 'A'
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return localeOther
+		}
+	}
+	return string(b)
+}
+
+// LocaleStat is the number of connections with one client locale
+type LocaleStat struct {
+	Locale      string  `json:"locale"`
+	Connections int64   `json:"connections"` // Connections since the start that reported the locale
+	Share       float64 `json:"share"`       // Percentage of all connections that reported a locale
+	Online      int     `json:"online"`      // Connections open right now
+}
+
+// LocaleStats returns the connections by client locale, most used first. Only
+// connections through proxies with capture_locale are counted.
+func LocaleStats() []LocaleStat {
+	localeCounts.Lock()
+	counts := make(map[string]int64, len(localeCounts.counts))
+	var total int64
+	for locale, n := range localeCounts.counts {
+		counts[locale] = n
+		total += n
+	}
+	localeCounts.Unlock()
+
+	online := make(map[string]int)
+	activeConnections.RLock()
+	for _, conn := range activeConnections.connections {
+		if conn.Locale == "" {
+			continue
+		}
+		if _, ok := counts[conn.Locale]; ok {
+			online[conn.Locale]++
+		} else {
+			online[localeOther]++
+		}
+	}
+	activeConnections.RUnlock()
+
+	stats := make([]LocaleStat, 0, len(counts))
+	for locale, n := range counts {
+		stats = append(stats, LocaleStat{
+			Locale:      locale,
+			Connections: n,
+			Share:       float64(n) / float64(total) * 100,
+			Online:      online[locale],
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Connections != stats[j].Connections {
+			return stats[i].Connections > stats[j].Connections
+		}
+		return stats[i].Locale < stats[j].Locale
+	})
+	return stats
+}
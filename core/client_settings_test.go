@@ -0,0 +1,130 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// localeConnections returns the connections counted for the locale
+func localeConnections(locale string) int64 {
+	for _, stat := range LocaleStats() {
+		if stat.Locale == locale {
+			return stat.Connections
+		}
+	}
+	return 0
+}
+
+// clientSettings returns a 1.20.1 Client Settings frame with the locale
+func clientSettings(t *testing.T, compressed bool, locale string) []byte {
+	t.Helper()
+	// view distance, chat mode, chat colors, skin parts, main hand, text
+	// filtering and server listings
+	rest := []byte{12, 0x00, 0x01, 0x7F, 0x01, 0x00, 0x01}
+	return frame(t, compressed, 0x08, String(locale), bytes.NewReader(rest))
+}
+
+func TestLocaleWatcherStoresLocale(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		conn := &Connection{ID: "locale-watched", Username: "Steve", ClientAddr: "pipe", ConnectedAt: time.Now()}
+		RegisterConnection(conn)
+		before := localeConnections("zh_tw")
+
+		client := new(bytes.Buffer)
+		lw := newLocaleWatcher(client, 763, conn, "Steve")
+		if lw == nil {
+			t.Fatal("no watcher for 1.20.1")
+		}
+
+		var login []byte
+		if compressed {
+			login = append(login, frame(t, false, loginSetCompression, VarInt(256))...)
+		}
+		login = append(login, frame(t, compressed, loginSuccess, String("00000000-0000-0000-0000-000000000000"), String("Steve"))...)
+		if _, err := lw.Write(login); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(client.Bytes(), login) {
+			t.Errorf("forwarded %x to the client, want %x", client.Bytes(), login)
+		}
+
+		// a frame split across reads is read once it is complete
+		data := append(frame(t, compressed, 0x12, Long(1)), clientSettings(t, compressed, "zh_TW")...)
+		forwarded := append(lw.FilterServerbound(data[:5]), lw.FilterServerbound(data[5:])...)
+		if !bytes.Equal(forwarded, data) {
+			t.Errorf("forwarded %x to the server, want %x", forwarded, data)
+		}
+
+		activeConnections.RLock()
+		locale := conn.Locale
+		activeConnections.RUnlock()
+		if locale != "zh_tw" {
+			t.Errorf("compressed %v: locale = %q, want zh_tw", compressed, locale)
+		}
+		if n := localeConnections("zh_tw"); n != before+1 {
+			t.Errorf("compressed %v: connections with locale zh_tw = %d, want %d", compressed, n, before+1)
+		}
+		UnregisterConnection(conn.ID)
+
+		// later settings are passed through unread
+		later := clientSettings(t, compressed, "en_us")
+		if forwarded := lw.FilterServerbound(later); !bytes.Equal(forwarded, later) {
+			t.Errorf("forwarded %x to the server, want %x", forwarded, later)
+		}
+		if n := localeConnections("en_us"); n != 0 {
+			t.Errorf("later settings were counted: %d", n)
+		}
+	}
+}
+
+func TestLocaleWatcherConfigurationPhase(t *testing.T) {
+	conn := &Connection{ID: "locale-configuration", Username: "Alex", ClientAddr: "pipe", ConnectedAt: time.Now()}
+	RegisterConnection(conn)
+	defer UnregisterConnection(conn.ID)
+
+	lw := newLocaleWatcher(io.Discard, 767, conn, "Alex")
+	if lw == nil {
+		t.Fatal("no watcher for 1.21")
+	}
+	lw.Write(frame(t, false, loginSuccess, String("00000000-0000-0000-0000-000000000000"), String("Alex")))
+
+	// the Login Acknowledged is followed by the Client Information
+	rest := []byte{12, 0x00, 0x01, 0x7F, 0x01, 0x00, 0x01, 0x00}
+	data := append(frame(t, false, 0x03), frame(t, false, 0x00, String("ja_JP"), bytes.NewReader(rest))...)
+	if forwarded := lw.FilterServerbound(data); !bytes.Equal(forwarded, data) {
+		t.Errorf("forwarded %x to the server, want %x", forwarded, data)
+	}
+
+	activeConnections.RLock()
+	locale := conn.Locale
+	activeConnections.RUnlock()
+	if locale != "ja_jp" {
+		t.Errorf("locale = %q, want ja_jp", locale)
+	}
+}
+
+func TestLocaleWatcherStopsOnEncryption(t *testing.T) {
+	lw := newLocaleWatcher(io.Discard, 763, nil, "Steve")
+	lw.Write(frame(t, false, loginEncryptionRequest, String(""), VarInt(0), VarInt(0)))
+	if !lw.isStopped() {
+		t.Error("watcher still following an encrypted stream")
+	}
+}
+
+func TestNormalizeLocale(t *testing.T) {
+	tests := map[string]string{
+		"en_US":               "en_us",
+		"lol_us":              "lol_us",
+		"":                    localeOther,
+		"en us":               localeOther,
+		"<script>":            localeOther,
+		"aaaaaaaaaaaaaaaaaaa": localeOther,
+	}
+	for in, want := range tests {
+		if got := normalizeLocale(in); got != want {
+			t.Errorf("normalizeLocale(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
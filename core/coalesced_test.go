@@ -0,0 +1,166 @@
+package core
+
+import (
+	"mcproxy/config"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = listener.Accept()
+	if err != nil {
+		client.Close()
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+// serveCoalesced sends segment to serve in a single write and returns the
+// client end, closing it and waiting for serve at the end of the test
+func serveCoalesced(t *testing.T, serve func(net.Conn), segment []byte) net.Conn {
+	t.Helper()
+	client, server := tcpPair(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(server)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("connection was not closed")
+		}
+	})
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write(segment); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// checkCoalescedPing sends the handshake, status request and ping in one
+// write and checks both are answered
+func checkCoalescedPing(t *testing.T, serve func(net.Conn)) {
+	t.Helper()
+	var segment []byte
+	segment = append(segment, frame(t, false, 0x00, VarInt(VERSION_1_18_2), String("localhost"), UShort(25565), VarInt(1))...)
+	segment = append(segment, frame(t, false, 0x00)...)
+	segment = append(segment, frame(t, false, 0x01, Long(42))...)
+	client := serveCoalesced(t, serve, segment)
+
+	if pkt, err := ReadPacket(client); err != nil || pkt.ID != 0x00 {
+		t.Fatalf("expected the status response, got %v", err)
+	}
+	pong, err := ReadPacket(client)
+	if err != nil || pong.ID != 0x01 {
+		t.Fatalf("expected the pong, got %v", err)
+	}
+	var payload Long
+	if _, err := pong.Scan(&payload); err != nil || payload != 42 {
+		t.Errorf("pong = %d, %v", payload, err)
+	}
+}
+
+// checkCoalescedLogin sends the handshake, login start and a play packet in
+// one write and checks the backend receives the login start and the play
+// packet intact
+func checkCoalescedLogin(t *testing.T, serve func(net.Conn)) {
+	t.Helper()
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+
+	type received struct {
+		loginStart, play Packet
+		err              error
+	}
+	backendGot := make(chan received, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer backendSide.Close()
+			var got received
+			if _, got.err = ReadPacket(backendSide); got.err == nil {
+				if got.loginStart, got.err = ReadPacket(backendSide); got.err == nil {
+					got.play, got.err = ReadPacket(backendSide)
+				}
+			}
+			backendGot <- got
+		}()
+		return proxySide, nil
+	}
+
+	var segment []byte
+	segment = append(segment, frame(t, false, 0x00, VarInt(VERSION_1_18_2), String("localhost"), UShort(25565), VarInt(2))...)
+	segment = append(segment, frame(t, false, 0x00, String("Steve"))...)
+	segment = append(segment, frame(t, false, 0x12, Long(7))...)
+	client := serveCoalesced(t, serve, segment)
+
+	var got received
+	select {
+	case got = <-backendGot:
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend received nothing")
+	}
+	if got.err != nil {
+		t.Fatalf("backend: %v", got.err)
+	}
+
+	var username String
+	if _, err := got.loginStart.Scan(&username); err != nil || got.loginStart.ID != 0x00 || username != "Steve" {
+		t.Errorf("login start = %+v", got.loginStart)
+	}
+	var payload Long
+	if _, err := got.play.Scan(&payload); err != nil || got.play.ID != 0x12 || payload != 7 {
+		t.Errorf("play packet = %+v", got.play)
+	}
+	client.Close()
+}
+
+func TestHandlerCoalescedPackets(t *testing.T) {
+	cfg := config.ProxyConfig{
+		Listen:    "127.0.0.1:40044",
+		Remote:    "backend.example.com:25565",
+		MaxPlayer: 20,
+		PingMode:  "fake",
+		Auth:      "none",
+	}
+	registerProxyStats(t, cfg)
+	serve := func(conn net.Conn) { handler(conn, cfg, 0, nil) }
+
+	t.Run("ping", func(t *testing.T) { checkCoalescedPing(t, serve) })
+	t.Run("login", func(t *testing.T) { checkCoalescedLogin(t, serve) })
+}
+
+func TestBalancerCoalescedPackets(t *testing.T) {
+	stubConnectionCounts(t, map[string]int{})
+
+	cfg := config.ProxyConfig{
+		Listen:    "127.0.0.1:40045",
+		Remote:    "backend.example.com:25565",
+		LocalAddr: "10.0.3.1:0",
+		MaxPlayer: 20,
+		PingMode:  "fake",
+		Auth:      "none",
+	}
+	registerProxyStats(t, cfg)
+	pb := NewProxyBalancer("127.0.0.1:0", []config.ProxyConfig{cfg})
+
+	t.Run("ping", func(t *testing.T) { checkCoalescedPing(t, pb.handleConnection) })
+	t.Run("login", func(t *testing.T) { checkCoalescedLogin(t, pb.handleConnection) })
+}
@@ -1,13 +1,18 @@
 package core
 
 import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"mcproxy/config"
+	"mcproxy/logger"
 	"net"
 	"os/exec"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,10 +20,82 @@ import (
 )
 
 const VERSION_1_8_9 = 47
+const VERSION_1_16 = 735
 const VERSION_1_18_2 = 758
+const VERSION_1_19 = 759
+const VERSION_1_20_5 = 766
+const VERSION_1_21_2 = 768
 
 var onlineCount atomic.Int32
 
+// maxTotalConnections caps onlineCount across all proxies, 0 = unlimited. It is
+// set from the global configuration.
+var maxTotalConnections atomic.Int32
+
+// SetMaxTotalConnections sets the limit of players connected through all
+// proxies together, 0 = unlimited
+func SetMaxTotalConnections(limit int) {
+	maxTotalConnections.Store(int32(limit))
+}
+
+// proxyConnectionCount returns the number of players connected through the
+// proxy listening on listenAddr
+func proxyConnectionCount(listenAddr string) int {
+	cp := GetControlPanel()
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+
+	if stats, exists := cp.Stats[listenAddr]; exists {
+		return int(stats.ConnectionCount.Load())
+	}
+	return 0
+}
+
+// serverFull reports whether a login of username would exceed the proxy's
+// max_player, counted for that proxy alone, or max_total_connections. The
+// reserved slots of max_player are kept for players in the whitelist, players
+// forwarded to the overflow_remote do not count.
+func serverFull(cfg config.ProxyConfig, username string) bool {
+	if totalConnectionsReached() {
+		return true
+	}
+	limit := cfg.MaxPlayer + cfg.FullOverflow
+	if !whitelisted(username, cfg) {
+		limit -= cfg.ReservedSlots
+	}
+	overflowed := overflowConnections(cfg.Listen)
+	if proxyConnectionCount(cfg.Listen)-overflowed < limit {
+		return false
+	}
+
+	// The counter can drift from the open connections, with an overflow
+	// allowance a full counter is checked against the registry so a drifted
+	// counter does not reject logins
+	if cfg.FullOverflow > 0 {
+		if registered := countRegistry().proxies[cfg.Listen] - overflowed; registered < limit {
+			log.Printf("[WARN] Proxy %s counts %d players but has %d logged in, accepting the login", cfg.Listen, proxyConnectionCount(cfg.Listen)-overflowed, registered)
+			return false
+		}
+	}
+	return true
+}
+
+// totalConnectionsReached reports whether all proxies together are at
+// max_total_connections
+func totalConnectionsReached() bool {
+	limit := maxTotalConnections.Load()
+	return limit > 0 && onlineCount.Load() >= limit
+}
+
+// fullRejection returns the reason a login serverFull turned away is counted
+// under
+func fullRejection() RejectReason {
+	if totalConnectionsReached() {
+		return RejectTotalConnections
+	}
+	return RejectFull
+}
+
 // decrementOnlineCount safely decrements onlineCount without allowing negative values
 func decrementOnlineCount() {
 	for {
@@ -32,6 +109,21 @@ func decrementOnlineCount() {
 	}
 }
 
+// recoverConnection is deferred by the goroutines serving a connection. It
+// keeps a panic from taking down the proxy, logs it with the stack and closes
+// conns so the other goroutines of the connection exit. The deferred cleanup
+// of the panicking goroutine has run by then, releasing the counters.
+func recoverConnection(who string, conns ...io.Closer) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("[ERROR] Recovered from panic while handling %s: %v\n%s", who, r, debug.Stack())
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
 // Connection represents an active client connection
 type Connection struct {
 	ID          string    // Unique identifier for the connection
@@ -43,7 +135,98 @@ type Connection struct {
 	ClientConn  net.Conn  // The client connection
 	RemoteConn  net.Conn  // The connection to the remote server
 	ProxyIndex  int       // Index of the proxy in the configuration
+	ViaBalancer bool      // Accepted by the load balancer, which forwarded it through ProxyIndex
 	PublicIP    string    // Public IP address of the connection
+	ModLoader   string    // Forge marker sent by the client (FML, FML2, ...), empty for vanilla
+	Group       string    // Group of the proxy the connection came through
+	Protocol    int       // Protocol version from the client's handshake
+	Locale      string    // Language from the client's Client Settings, empty until known or without capture_locale
+	Flapping    bool      // The client's host:port reconnected flap_threshold times within the window
+
+	injector *packetInjector // writes proxy packets to the client, nil for BungeeCord switches
+	capture  *packetCapture  // traces the connection's packets, nil when not enabled
+
+	bytesIn     atomic.Int64           // forwarded from the client to the server
+	bytesOut    atomic.Int64           // forwarded from the server to the client
+	reason      atomic.Pointer[string] // why the session ended, for the access log
+	established atomic.Int64           // UnixNano of the backend's Login Success, 0 until then
+}
+
+// markEstablished records that the backend accepted the login at t
+func (c *Connection) markEstablished(t time.Time) {
+	c.established.CompareAndSwap(0, t.UnixNano())
+}
+
+// EstablishedAt returns when the backend accepted the login, zero while it
+// has not or when the login could not be followed
+func (c *Connection) EstablishedAt() time.Time {
+	if ns := c.established.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// setEndReason records why the session ends, the first reason is kept
+func (c *Connection) setEndReason(reason string) {
+	c.reason.CompareAndSwap(nil, &reason)
+}
+
+// endReason returns why the session ended, "closed" when nothing more
+// specific was recorded
+func (c *Connection) endReason() string {
+	if reason := c.reason.Load(); reason != nil {
+		return *reason
+	}
+	return "closed"
+}
+
+// connectionIDCounter numbers the connections for newConnectionID
+var connectionIDCounter atomic.Uint64
+
+// newConnectionID returns a unique ID for a connection from clientAddr. A
+// counter keeps IDs unique within the process and a random suffix keeps them
+// from repeating across restarts.
+func newConnectionID(clientAddr string) string {
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	return fmt.Sprintf("%s-%d-%s", clientAddr, connectionIDCounter.Add(1), hex.EncodeToString(suffix[:]))
+}
+
+// Per-connection log verbosities of ProxyConfig.LogVerbosity
+const (
+	LogQuiet   = "quiet"   // only warnings, errors and rejections
+	LogNormal  = "normal"  // also the connection lifecycle
+	LogVerbose = "verbose" // also debug details such as byte counts
+)
+
+// connInfof logs a per-connection lifecycle line unless the proxy is quiet or
+// the runtime log level is above INFO
+func connInfof(cfg config.ProxyConfig, format string, v ...any) {
+	if cfg.LogVerbosity != LogQuiet && logger.GetLogger().Enabled(logger.INFO) {
+		log.Printf("[INFO] "+format, v...)
+	}
+}
+
+// connDebugf logs a per-connection detail if the proxy is verbose and the
+// runtime log level is DEBUG
+func connDebugf(cfg config.ProxyConfig, format string, v ...any) {
+	if cfg.LogVerbosity == LogVerbose && logger.GetLogger().Enabled(logger.DEBUG) {
+		log.Printf("[DEBUG] "+format, v...)
+	}
+}
+
+// acceptLogCounters counts the accepted connections of each proxy for
+// ProxyConfig.AcceptLogSample, keyed by listen address
+var acceptLogCounters sync.Map
+
+// sampleAcceptLog reports whether the accept and close of a new connection are
+// logged, one in every accept_log_sample connections is
+func sampleAcceptLog(cfg config.ProxyConfig) bool {
+	if cfg.AcceptLogSample <= 1 {
+		return true
+	}
+	counter, _ := acceptLogCounters.LoadOrStore(cfg.Listen, new(atomic.Uint64))
+	return (counter.(*atomic.Uint64).Add(1)-1)%uint64(cfg.AcceptLogSample) == 0
 }
 
 // ActiveConnections tracks all active connections
@@ -65,11 +248,141 @@ var connectionsPerIP = struct {
 // MaxConnectionsPerIP is the maximum number of connections allowed per public IP
 const MaxConnectionsPerIP = 4
 
+// connectionsPerClientIP tracks the number of logins per client source IP
+var connectionsPerClientIP = struct {
+	sync.Mutex
+	counts map[string]int
+}{
+	counts: make(map[string]int),
+}
+
+// defaultLoginGrace is how long a client may take to send its login start
+// packet after the handshake when login_grace_ms is not set
+const defaultLoginGrace = 5 * time.Second
+
+// waitForLoginStart waits for the login start packet to arrive after a login
+// handshake and returns the username in it. Connections are only counted and
+// registered once it does. The packet is left in reader for handleForward.
+func waitForLoginStart(conn net.Conn, reader *bufio.Reader, cfg config.ProxyConfig) (string, error) {
+	grace := defaultLoginGrace
+	if cfg.LoginGraceMs > 0 {
+		grace = time.Duration(cfg.LoginGraceMs) * time.Millisecond
+	}
+
+	conn.SetReadDeadline(time.Now().Add(grace))
+	defer conn.SetReadDeadline(time.Time{})
+
+	// the username comes first, the rest of the packet may not fit the buffer
+	peek := &peekReader{r: reader}
+	var length, id, size VarInt
+	if _, err := length.ReadFrom(peek); err != nil {
+		return "", err
+	}
+	if _, err := id.ReadFrom(peek); err != nil {
+		return "", err
+	}
+	if id != 0x00 {
+		return "", fmt.Errorf("expect packet login start, got %d", id)
+	}
+	if _, err := size.ReadFrom(peek); err != nil {
+		return "", err
+	}
+	if size < 0 || size > maxUsernameBytes {
+		return "", fmt.Errorf("username too long: %d bytes", size)
+	}
+	username := make([]byte, size)
+	if _, err := io.ReadFull(peek, username); err != nil {
+		return "", err
+	}
+	return string(username), nil
+}
+
+// maxUsernameBytes is the longest username accepted in a login start, 16
+// characters of up to four bytes each
+const maxUsernameBytes = 16 * 4
+
+// peekReader reads from a bufio.Reader without consuming what it reads
+type peekReader struct {
+	r   *bufio.Reader
+	off int
+}
+
+func (p *peekReader) Read(b []byte) (int, error) {
+	buf, err := p.r.Peek(p.off + len(b))
+	n := copy(b, buf[min(p.off, len(buf)):])
+	p.off += n
+	if n == len(b) {
+		return n, nil
+	}
+	return n, err
+}
+
+// clientIPFromAddr returns the host part of a client address, IPv4-mapped
+// IPv6 addresses in their IPv4 form
+func clientIPFromAddr(addr string) string {
+	return normalizeIP(hostOnly(addr))
+}
+
+// acquireClientIPSlot counts a new connection from the client IP unless the
+// limit (0 = unlimited) is already reached. Returns the current count and
+// whether the slot was acquired.
+func acquireClientIPSlot(ip string, limit int) (int, bool) {
+	connectionsPerClientIP.Lock()
+	defer connectionsPerClientIP.Unlock()
+
+	count := connectionsPerClientIP.counts[ip]
+	if limit > 0 && count >= limit {
+		return count, false
+	}
+	connectionsPerClientIP.counts[ip] = count + 1
+	return count + 1, true
+}
+
+// releaseClientIPSlot releases a slot acquired with acquireClientIPSlot
+func releaseClientIPSlot(ip string) {
+	connectionsPerClientIP.Lock()
+	defer connectionsPerClientIP.Unlock()
+
+	if connectionsPerClientIP.counts[ip] <= 1 {
+		delete(connectionsPerClientIP.counts, ip)
+		return
+	}
+	connectionsPerClientIP.counts[ip]--
+}
+
+// GetConnectionCountForClientIP returns the number of logins from the given client IP
+func GetConnectionCountForClientIP(ip string) int {
+	connectionsPerClientIP.Lock()
+	defer connectionsPerClientIP.Unlock()
+	return connectionsPerClientIP.counts[normalizeIP(ip)]
+}
+
+// Dependencies of the connection handlers and the balancer. They default to
+// the real implementations so tests can stub the network lookups and the
+// connection registry.
+var (
+	publicIPFunc         = GetPublicIP
+	connectionCountForIP = GetConnectionCountForIP
+	registerConnection   = RegisterConnection
+	unregisterConnection = UnregisterConnection
+)
+
 // RegisterConnection adds a connection to the tracking system
 func RegisterConnection(conn *Connection) {
 	activeConnections.Lock()
 	defer activeConnections.Unlock()
+
+	// newConnectionID does not repeat, but a colliding ID would replace the
+	// other connection in the registry, so the new one is renamed instead
+	for existing := activeConnections.connections[conn.ID]; existing != nil && existing != conn; existing = activeConnections.connections[conn.ID] {
+		id := newConnectionID(conn.ClientAddr)
+		log.Printf("[INFO] Connection ID %s of %s already used by %s, registering it as %s",
+			conn.ID, conn.ClientAddr, existing.ClientAddr, id)
+		conn.ID = id
+	}
 	activeConnections.connections[conn.ID] = conn
+	recordNewConnection()
+	recordProtocol(conn.Protocol)
 
 	// Increment connection count for this IP
 	if conn.PublicIP != "" && conn.PublicIP != "N/A" && conn.PublicIP != "Error" && conn.PublicIP != "Unknown" {
@@ -95,6 +408,8 @@ func UnregisterConnection(id string) {
 		return
 	}
 
+	logAccess(conn, time.Now())
+
 	// Decrement connection count for this IP
 	if conn.PublicIP != "" && conn.PublicIP != "N/A" && conn.PublicIP != "Error" && conn.PublicIP != "Unknown" {
 		connectionsPerIP.Lock()
@@ -106,12 +421,37 @@ func UnregisterConnection(id string) {
 	}
 }
 
+// connectionLimitKey returns what MaxConnectionsPerIP counts a connection by:
+// its public IP, or with the public IP lookup disabled the public_ip_label, or
+// the client IP when no label is set
+func connectionLimitKey(publicIP, clientAddr string) string {
+	publicIPSettings.RLock()
+	disabled, label := publicIPSettings.disabled, publicIPSettings.label
+	publicIPSettings.RUnlock()
+
+	if disabled && label == "" {
+		return clientIPFromAddr(clientAddr)
+	}
+	return publicIP
+}
+
 // GetConnectionCountForIP returns the number of outbound connections from a network interface with the given IP
 func GetConnectionCountForIP(ip string) int {
 	if ip == "" || ip == "N/A" || ip == "Error" || ip == "Unknown" {
 		return 0
 	}
 
+	// Without the lookup ip is a label or a client IP, see connectionLimitKey
+	if publicIPLookupDisabled() {
+		count := 0
+		for _, conn := range GetAllConnections() {
+			if connectionLimitKey(conn.PublicIP, conn.ClientAddr) == ip {
+				count++
+			}
+		}
+		return count
+	}
+
 	// Get all active connections
 	connections := GetAllConnections()
 
@@ -121,11 +461,8 @@ func GetConnectionCountForIP(ip string) int {
 		// Check if this connection is using the specified network interface
 		// by comparing the local address of the remote connection
 		if conn.RemoteConn != nil {
-			localAddr := conn.RemoteConn.LocalAddr().String()
 			// Extract just the IP address from the localAddr (remove port if present)
-			if idx := strings.LastIndex(localAddr, ":"); idx != -1 {
-				localAddr = localAddr[:idx]
-			}
+			localAddr := hostOnly(conn.RemoteConn.LocalAddr().String())
 
 			// If the local address of the outbound connection matches the specified IP,
 			// increment the count
@@ -157,6 +494,61 @@ func GetAllConnections() []*Connection {
 	return connections
 }
 
+// Defaults of the disconnect timeouts
+const (
+	defaultDisconnectWriteTimeout = 1 * time.Second
+	defaultDisconnectGrace        = 200 * time.Millisecond
+)
+
+// disconnectTimeouts bound how long DisconnectClient spends on a client. It is
+// set from the global configuration.
+var disconnectTimeouts = struct {
+	sync.RWMutex
+	write time.Duration // deadline for writing the disconnect message
+	grace time.Duration // longest wait for the client to close after the message
+}{
+	write: defaultDisconnectWriteTimeout,
+	grace: defaultDisconnectGrace,
+}
+
+// SetDisconnectTimeouts configures DisconnectClient, zero uses the defaults of
+// one second to write the disconnect message and 200ms for the client to close
+func SetDisconnectTimeouts(write, grace time.Duration) {
+	if write <= 0 {
+		write = defaultDisconnectWriteTimeout
+	}
+	if grace <= 0 {
+		grace = defaultDisconnectGrace
+	}
+
+	disconnectTimeouts.Lock()
+	defer disconnectTimeouts.Unlock()
+	disconnectTimeouts.write = write
+	disconnectTimeouts.grace = grace
+}
+
+// getDisconnectTimeouts returns the write timeout and grace period
+func getDisconnectTimeouts() (write, grace time.Duration) {
+	disconnectTimeouts.RLock()
+	defer disconnectTimeouts.RUnlock()
+	return disconnectTimeouts.write, disconnectTimeouts.grace
+}
+
+// awaitClientClose waits up to grace for the client to close the connection
+// after the disconnect message was written. Closing the socket while the
+// client's packets are still unread sends a reset, which can make the client
+// drop the message, so the write side is shut down and the client's remaining
+// data is drained until it hangs up. Connections without a half-close return
+// right away, the message is already written.
+func awaitClientClose(conn net.Conn, grace time.Duration) {
+	closer, ok := conn.(interface{ CloseWrite() error })
+	if !ok || closer.CloseWrite() != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(grace))
+	io.Copy(io.Discard, conn)
+}
+
 // DisconnectClient forcibly disconnects a client by ID
 func DisconnectClient(id string, reason string) error {
 	// Get the connection with a read lock first to check if it exists
@@ -175,6 +567,7 @@ func DisconnectClient(id string, reason string) error {
 	proxyAddr := conn.ProxyAddr
 
 	log.Printf("[INFO] Disconnecting client %s (%s) with reason: %s", username, clientAddr, reason)
+	conn.setEndReason("disconnected: " + reason)
 
 	// Create local copies of the connections to avoid race conditions
 	var clientConn, remoteConn net.Conn
@@ -189,14 +582,13 @@ func DisconnectClient(id string, reason string) error {
 	}
 	activeConnections.RUnlock()
 
+	writeTimeout, grace := getDisconnectTimeouts()
+
 	// Send disconnect message if possible
 	if clientConn != nil {
 		// Set a write deadline to avoid blocking indefinitely
-		if setter, ok := clientConn.(interface{ SetWriteDeadline(time.Time) error }); ok {
-			err := setter.SetWriteDeadline(time.Now().Add(1 * time.Second))
-			if err != nil {
-				log.Printf("[WARN] Failed to set write deadline for %s: %v", username, err)
-			}
+		if err := clientConn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			log.Printf("[WARN] Failed to set write deadline for %s: %v", username, err)
 		}
 
 		err := sendDisconnect(clientConn, reason)
@@ -205,17 +597,7 @@ func DisconnectClient(id string, reason string) error {
 			log.Printf("[WARN] Failed to send disconnect message to %s: %v", username, err)
 		} else {
 			log.Printf("[DEBUG] Successfully sent disconnect message to %s", username)
-
-			// Ensure we flush any buffered data
-			if flusher, ok := clientConn.(interface{ SetWriteDeadline(time.Time) error }); ok {
-				err := flusher.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
-				if err != nil {
-					log.Printf("[WARN] Failed to set flush deadline for %s: %v", username, err)
-				}
-			}
-
-			// Close connections with a small delay to ensure disconnect message is sent
-			time.Sleep(200 * time.Millisecond)
+			awaitClientClose(clientConn, grace)
 		}
 	} else {
 		log.Printf("[WARN] No client connection available for %s, skipping disconnect message", username)
@@ -253,6 +635,27 @@ func DisconnectClient(id string, reason string) error {
 	return nil
 }
 
+// DisconnectClients disconnects the connections with the given IDs in
+// parallel, so each client's grace period overlaps the others'. It returns
+// how many were disconnected.
+func DisconnectClients(ids []string, reason string) int {
+	var wg sync.WaitGroup
+	var disconnected atomic.Int32
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := DisconnectClient(id, reason); err != nil {
+				log.Printf("[WARN] Failed to disconnect connection %s: %v", id, err)
+				return
+			}
+			disconnected.Add(1)
+		}(id)
+	}
+	wg.Wait()
+	return int(disconnected.Load())
+}
+
 // write disconnect packet
 func sendDisconnect(w io.Writer, reason string) error {
 	type chat struct {
@@ -297,30 +700,70 @@ type statusResponse struct {
 	Favicon     string        `json:"favicon"`
 }
 
+// publicIPSettings controls GetPublicIP and is set from the global configuration
+var publicIPSettings = struct {
+	sync.RWMutex
+	disabled bool
+	label    string
+}{}
+
+// SetPublicIPLookup enables or disables the public IP lookup. When disabled,
+// GetPublicIP returns label (or "N/A" if label is empty) without any network call.
+func SetPublicIPLookup(disabled bool, label string) {
+	publicIPSettings.Lock()
+	defer publicIPSettings.Unlock()
+	publicIPSettings.disabled = disabled
+	publicIPSettings.label = label
+}
+
+// publicIPLookupDisabled reports whether the public IP lookup is disabled
+func publicIPLookupDisabled() bool {
+	publicIPSettings.RLock()
+	defer publicIPSettings.RUnlock()
+	return publicIPSettings.disabled
+}
+
+// lookupPublicIP asks ipinfo.io for the public IP of the given interface address
+var lookupPublicIP = func(ip string) (string, error) {
+	// Create the curl command with the interface binding
+	cmd := exec.Command("curl", "-s", "--interface", ip, "ipinfo.io/ip")
+
+	// Execute the command
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
 // GetPublicIP gets the public IP address using curl to ipinfo.io through a specific network interface
 func GetPublicIP(localAddr string) string {
+	publicIPSettings.RLock()
+	disabled, label := publicIPSettings.disabled, publicIPSettings.label
+	publicIPSettings.RUnlock()
+
+	if disabled {
+		if label == "" {
+			return "N/A"
+		}
+		return label
+	}
+
 	if localAddr == "" {
 		return "N/A"
 	}
 
 	// Extract just the IP address from the localAddr (remove port if present)
-	ipOnly := localAddr
-	if idx := strings.LastIndex(localAddr, ":"); idx != -1 {
-		ipOnly = localAddr[:idx]
-	}
-
-	// Create the curl command with the interface binding
-	cmd := exec.Command("curl", "-s", "--interface", ipOnly, "ipinfo.io/ip")
+	ipOnly := hostOnly(localAddr)
 
-	// Execute the command
-	output, err := cmd.Output()
+	output, err := lookupPublicIP(ipOnly)
 	if err != nil {
 		log.Printf("[ERROR] Failed to get public IP for interface %s: %v", localAddr, err)
 		return "Error"
 	}
 
 	// Clean the output
-	ip := strings.TrimSpace(string(output))
+	ip := strings.TrimSpace(output)
 	if ip == "" {
 		return "Unknown"
 	}
@@ -328,15 +771,25 @@ func GetPublicIP(localAddr string) string {
 	return ip
 }
 
+// displayedPublicIP returns the address shown to players and operators for
+// the proxy, its public_hostname or else the looked up public IP. Connections
+// are still counted by the looked up IP.
+func displayedPublicIP(cfg config.ProxyConfig) string {
+	if cfg.PublicHostname != "" {
+		return cfg.PublicHostname
+	}
+	return publicIPFunc(cfg.LocalAddr)
+}
+
 // write ping response packet
-func sendResponse(w io.Writer, protocol int, cfg config.ProxyConfig) error {
+func sendResponse(w io.Writer, protocol int, cfg config.ProxyConfig, description string) error {
 	// Get all active connections to display online users
 	connections := GetAllConnections()
 
-	// Create player samples from active connections
+	// Create player samples from the proxy's active connections
 	samples := make([]statusPlayerSample, 0, len(connections))
 	for _, conn := range connections {
-		if conn.Username != "" {
+		if conn.Username != "" && conn.ProxyAddr == cfg.Listen {
 			// Use username as both name and ID for simplicity
 			// In a real Minecraft server, ID would be a UUID
 			samples = append(samples, statusPlayerSample{
@@ -346,17 +799,20 @@ func sendResponse(w io.Writer, protocol int, cfg config.ProxyConfig) error {
 		}
 	}
 
+	online := proxyConnectionCount(cfg.Listen)
+	maxPlayers, description := fullPingStatus(cfg, online, description)
+
 	resp, err := json.Marshal(statusResponse{
 		Version: statusVersion{
-			Name:     "gomcproxy",
+			Name:     versionName(cfg, protocol),
 			Protocol: protocol,
 		},
 		Players: statusPlayers{
-			Max:    cfg.MaxPlayer,
-			Online: int(onlineCount.Load()),
+			Max:    maxPlayers,
+			Online: online,
 			Sample: samples,
 		},
-		Description: cfg.Description,
+		Description: description,
 		Favicon:     cfg.Favicon,
 	})
 
@@ -0,0 +1,43 @@
+package core
+
+import "testing"
+
+func TestGetPublicIPLookupDisabled(t *testing.T) {
+	origLookup := lookupPublicIP
+	lookupPublicIP = func(ip string) (string, error) {
+		t.Errorf("unexpected public IP lookup for %s", ip)
+		return "", nil
+	}
+	defer func() {
+		lookupPublicIP = origLookup
+		SetPublicIPLookup(false, "")
+	}()
+
+	SetPublicIPLookup(true, "")
+	if ip := GetPublicIP("192.168.1.2:0"); ip != "N/A" {
+		t.Errorf("GetPublicIP = %s, want N/A", ip)
+	}
+
+	SetPublicIPLookup(true, "edge-1")
+	if ip := GetPublicIP("192.168.1.2:0"); ip != "edge-1" {
+		t.Errorf("GetPublicIP = %s, want edge-1", ip)
+	}
+}
+
+func TestGetPublicIPLookupEnabled(t *testing.T) {
+	origLookup := lookupPublicIP
+	defer func() { lookupPublicIP = origLookup }()
+
+	var looked string
+	lookupPublicIP = func(ip string) (string, error) {
+		looked = ip
+		return "203.0.113.7\n", nil
+	}
+
+	if ip := GetPublicIP("192.168.1.2:0"); ip != "203.0.113.7" {
+		t.Errorf("GetPublicIP = %s, want 203.0.113.7", ip)
+	}
+	if looked != "192.168.1.2" {
+		t.Errorf("looked up interface %s, want 192.168.1.2", looked)
+	}
+}
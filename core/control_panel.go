@@ -2,17 +2,23 @@ package core
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"mcproxy/config"
 	"mcproxy/logger"
+	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,6 +29,95 @@ type ProxyStats struct {
 	Config          config.ProxyConfig
 	ConnectionCount atomic.Int32
 	PublicIP        string
+	Rejections      RejectionStats
+}
+
+// RejectReason identifies why a login was rejected
+type RejectReason string
+
+const (
+	RejectFull               RejectReason = "full"
+	RejectIPLimit            RejectReason = "ip_limit"
+	RejectAuth               RejectReason = "auth"
+	RejectUnsupportedVersion RejectReason = "unsupported_version"
+	RejectBusy               RejectReason = "busy"
+	RejectPaused             RejectReason = "paused"
+	RejectTotalConnections   RejectReason = "total_connections"
+	RejectStatusOnly         RejectReason = "status_only"
+	RejectInvalidHostname    RejectReason = "invalid_hostname"
+	RejectHandshakeRate      RejectReason = "handshake_rate"
+	RejectMalformed          RejectReason = "malformed_handshake"
+	RejectInvalidUsername    RejectReason = "invalid_username"
+	RejectUnhealthy          RejectReason = "unhealthy"
+)
+
+// RejectionStats counts rejected logins by reason
+type RejectionStats struct {
+	Full               atomic.Int64
+	IPLimit            atomic.Int64
+	Auth               atomic.Int64
+	UnsupportedVersion atomic.Int64
+	Busy               atomic.Int64
+	Paused             atomic.Int64
+	TotalConnections   atomic.Int64
+	StatusOnly         atomic.Int64
+	InvalidHostname    atomic.Int64
+	HandshakeRate      atomic.Int64
+	Malformed          atomic.Int64
+	InvalidUsername    atomic.Int64
+	Unhealthy          atomic.Int64
+}
+
+// counter returns the counter for the given reason
+func (rs *RejectionStats) counter(reason RejectReason) *atomic.Int64 {
+	switch reason {
+	case RejectFull:
+		return &rs.Full
+	case RejectIPLimit:
+		return &rs.IPLimit
+	case RejectAuth:
+		return &rs.Auth
+	case RejectUnsupportedVersion:
+		return &rs.UnsupportedVersion
+	case RejectBusy:
+		return &rs.Busy
+	case RejectPaused:
+		return &rs.Paused
+	case RejectTotalConnections:
+		return &rs.TotalConnections
+	case RejectStatusOnly:
+		return &rs.StatusOnly
+	case RejectInvalidHostname:
+		return &rs.InvalidHostname
+	case RejectHandshakeRate:
+		return &rs.HandshakeRate
+	case RejectMalformed:
+		return &rs.Malformed
+	case RejectInvalidUsername:
+		return &rs.InvalidUsername
+	case RejectUnhealthy:
+		return &rs.Unhealthy
+	}
+	return nil
+}
+
+// Snapshot returns the current counts keyed by reason
+func (rs *RejectionStats) Snapshot() map[RejectReason]int64 {
+	return map[RejectReason]int64{
+		RejectFull:               rs.Full.Load(),
+		RejectIPLimit:            rs.IPLimit.Load(),
+		RejectAuth:               rs.Auth.Load(),
+		RejectUnsupportedVersion: rs.UnsupportedVersion.Load(),
+		RejectBusy:               rs.Busy.Load(),
+		RejectPaused:             rs.Paused.Load(),
+		RejectTotalConnections:   rs.TotalConnections.Load(),
+		RejectStatusOnly:         rs.StatusOnly.Load(),
+		RejectInvalidHostname:    rs.InvalidHostname.Load(),
+		RejectHandshakeRate:      rs.HandshakeRate.Load(),
+		RejectMalformed:          rs.Malformed.Load(),
+		RejectInvalidUsername:    rs.InvalidUsername.Load(),
+		RejectUnhealthy:          rs.Unhealthy.Load(),
+	}
 }
 
 // Session represents a user session
@@ -31,6 +126,14 @@ type Session struct {
 	Username  string
 	CreatedAt time.Time
 	ExpiresAt time.Time
+	SourceIP  string
+}
+
+// Handle returns a stable opaque identifier for the session that can be shown
+// to admins without revealing the session token
+func (s *Session) Handle() string {
+	sum := sha256.Sum256([]byte(s.ID))
+	return hex.EncodeToString(sum[:8])
 }
 
 // ControlPanel manages the web-based control panel
@@ -71,8 +174,8 @@ func generateSessionID() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-// CreateSession creates a new session for the given username
-func (cp *ControlPanel) CreateSession(username string) (*Session, error) {
+// CreateSession creates a new session for the given username logging in from sourceIP
+func (cp *ControlPanel) CreateSession(username, sourceIP string) (*Session, error) {
 	sessionID, err := generateSessionID()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate session ID: %w", err)
@@ -84,6 +187,7 @@ func (cp *ControlPanel) CreateSession(username string) (*Session, error) {
 		Username:  username,
 		CreatedAt: now,
 		ExpiresAt: now.Add(24 * time.Hour), // Sessions expire after 24 hours
+		SourceIP:  sourceIP,
 	}
 
 	cp.SessionMutex.Lock()
@@ -120,6 +224,40 @@ func (cp *ControlPanel) RemoveSession(sessionID string) {
 	delete(cp.Sessions, sessionID)
 }
 
+// ListSessions returns copies of the unexpired sessions, oldest first
+func (cp *ControlPanel) ListSessions() []Session {
+	cp.SessionMutex.RLock()
+	defer cp.SessionMutex.RUnlock()
+
+	now := time.Now()
+	sessions := make([]Session, 0, len(cp.Sessions))
+	for _, session := range cp.Sessions {
+		if now.After(session.ExpiresAt) {
+			continue
+		}
+		sessions = append(sessions, *session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
+// RemoveSessionByHandle removes the session with the given opaque handle and
+// reports whether one was found
+func (cp *ControlPanel) RemoveSessionByHandle(handle string) bool {
+	cp.SessionMutex.Lock()
+	defer cp.SessionMutex.Unlock()
+
+	for id, session := range cp.Sessions {
+		if subtle.ConstantTimeCompare([]byte(session.Handle()), []byte(handle)) == 1 {
+			delete(cp.Sessions, id)
+			return true
+		}
+	}
+	return false
+}
+
 // InitControlPanel initializes the control panel with the given configuration
 func InitControlPanel(cfg *config.Config, configPath string) {
 	cp := GetControlPanel()
@@ -128,6 +266,19 @@ func InitControlPanel(cfg *config.Config, configPath string) {
 
 	cp.ConfigPath = configPath
 	cp.CurrentConfig = cfg
+	SetPublicIPLookup(cfg.DisablePublicIPLookup, cfg.PublicIPLabel)
+	SetHostOverrides(cfg.HostOverrides)
+	SetDisconnectTimeouts(time.Duration(cfg.DisconnectWriteTimeoutMs)*time.Millisecond, time.Duration(cfg.DisconnectGraceMs)*time.Millisecond)
+	SetMaxTotalConnections(cfg.MaxTotalConnections)
+	SetPausedLoginMessage(cfg.PausedLoginMessage)
+	SetConnectionRateAlert(cfg.ConnectionRateAlert, time.Duration(cfg.ConnectionRateAlertCooldown)*time.Second, cfg.ConnectionRateWebhook)
+	SetHandshakeRateLimit(cfg.HandshakeRateLimit, time.Duration(cfg.HandshakeRateWindowSeconds)*time.Second, time.Duration(cfg.HandshakeBlockSeconds)*time.Second)
+	SetFlapDetection(cfg.FlapThreshold, time.Duration(cfg.FlapWindowSeconds)*time.Second)
+	if err := SetAccessLog(cfg.Logging); err != nil {
+		log.Printf("[ERROR] Failed to open the access log %s: %v", cfg.Logging.AccessLogPath, err)
+	}
+	startStatsSampler()
+	startCounterReconciler()
 	cp.ConnectionLimit = MaxConnectionsPerIP
 	cp.Username = cfg.ControlPanel.Username
 	cp.Password = cfg.ControlPanel.Password
@@ -135,7 +286,7 @@ func InitControlPanel(cfg *config.Config, configPath string) {
 	// Initialize stats for each proxy
 	for _, proxy := range cfg.Proxies {
 		listenAddr := proxy.Listen
-		publicIP := GetPublicIP(proxy.LocalAddr)
+		publicIP := displayedPublicIP(proxy)
 
 		if _, exists := cp.Stats[listenAddr]; !exists {
 			cp.Stats[listenAddr] = &ProxyStats{
@@ -180,67 +331,117 @@ func (cp *ControlPanel) DecrementConnectionCount(listenAddr string) {
 	}
 }
 
-// SaveConfig saves the current configuration to the config file
-func (cp *ControlPanel) SaveConfig() error {
+// RecordRejection counts a rejected login for a proxy
+func (cp *ControlPanel) RecordRejection(listenAddr string, reason RejectReason) {
 	cp.mutex.RLock()
 	defer cp.mutex.RUnlock()
 
-	// Pretty format the JSON
-	jsonData, err := json.MarshalIndent(cp.CurrentConfig, "", "    ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+	if stats, exists := cp.Stats[listenAddr]; exists {
+		if counter := stats.Rejections.counter(reason); counter != nil {
+			counter.Add(1)
+		}
 	}
+}
 
-	// Write to file
-	err = os.WriteFile(cp.ConfigPath, jsonData, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write config file: %w", err)
-	}
+// SaveConfig saves the current configuration to the config file
+func (cp *ControlPanel) SaveConfig() error {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
 
-	return nil
+	return config.Save(cp.CurrentConfig, cp.ConfigPath)
 }
 
+// restartProxies restarts the proxy servers with a new configuration, tests
+// replace it so no listeners are opened
+var restartProxies = Restart
+
 // ReloadConfig reloads the configuration and restarts the proxies
 func (cp *ControlPanel) ReloadConfig() error {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
 
 	// Save the current configuration first
-	jsonData, err := json.MarshalIndent(cp.CurrentConfig, "", "    ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
-	}
-
-	err = os.WriteFile(cp.ConfigPath, jsonData, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write config file: %w", err)
+	if err := config.Save(cp.CurrentConfig, cp.ConfigPath); err != nil {
+		return err
 	}
 
 	// Restart the proxies with the new configuration
 	log.Printf("[INFO] Reloading proxy configuration from control panel")
-	Restart(*cp.CurrentConfig)
+	SetPublicIPLookup(cp.CurrentConfig.DisablePublicIPLookup, cp.CurrentConfig.PublicIPLabel)
+	SetHostOverrides(cp.CurrentConfig.HostOverrides)
+	SetDisconnectTimeouts(time.Duration(cp.CurrentConfig.DisconnectWriteTimeoutMs)*time.Millisecond,
+		time.Duration(cp.CurrentConfig.DisconnectGraceMs)*time.Millisecond)
+	SetMaxTotalConnections(cp.CurrentConfig.MaxTotalConnections)
+	SetPausedLoginMessage(cp.CurrentConfig.PausedLoginMessage)
+	SetConnectionRateAlert(cp.CurrentConfig.ConnectionRateAlert,
+		time.Duration(cp.CurrentConfig.ConnectionRateAlertCooldown)*time.Second, cp.CurrentConfig.ConnectionRateWebhook)
+	SetHandshakeRateLimit(cp.CurrentConfig.HandshakeRateLimit,
+		time.Duration(cp.CurrentConfig.HandshakeRateWindowSeconds)*time.Second, time.Duration(cp.CurrentConfig.HandshakeBlockSeconds)*time.Second)
+	SetFlapDetection(cp.CurrentConfig.FlapThreshold, time.Duration(cp.CurrentConfig.FlapWindowSeconds)*time.Second)
+	err := logger.GetLogger().Reopen(cp.CurrentConfig.Logging.DBPath, logger.StorageOptions{
+		JournalMode: cp.CurrentConfig.Logging.JournalMode,
+		Synchronous: cp.CurrentConfig.Logging.Synchronous,
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to move the log database to %s: %v", cp.CurrentConfig.Logging.DBPath, err)
+	}
+	if cp.CurrentConfig.Logging.Level != "" {
+		level, err := logger.ParseLogLevel(cp.CurrentConfig.Logging.Level)
+		if err != nil {
+			log.Printf("[WARN] Ignoring logging level: %v", err)
+		} else {
+			logger.GetLogger().SetLevel(level)
+		}
+	}
+	if err := SetAccessLog(cp.CurrentConfig.Logging); err != nil {
+		log.Printf("[ERROR] Failed to open the access log %s: %v", cp.CurrentConfig.Logging.AccessLogPath, err)
+	}
+	restartProxies(*cp.CurrentConfig)
 
-	// Re-initialize the control panel stats for the new proxies
-	// Clear existing stats first
-	for k := range cp.Stats {
+	// Re-initialize the control panel stats for the new proxies. Players stay
+	// connected across a reload and decrement the new counters when they
+	// leave, so the connection counts of proxies that remain are carried over.
+	oldStats := make(map[string]*ProxyStats, len(cp.Stats))
+	for k, stats := range cp.Stats {
+		oldStats[k] = stats
 		delete(cp.Stats, k)
 	}
 
 	// Initialize stats for each proxy in the new configuration
 	for _, proxy := range cp.CurrentConfig.Proxies {
 		listenAddr := proxy.Listen
-		cp.Stats[listenAddr] = &ProxyStats{
+		stats := &ProxyStats{
 			Config:   proxy,
-			PublicIP: GetPublicIP(proxy.LocalAddr),
+			PublicIP: displayedPublicIP(proxy),
+		}
+		if old, exists := oldStats[listenAddr]; exists {
+			stats.ConnectionCount.Store(old.ConnectionCount.Load())
 		}
+		cp.Stats[listenAddr] = stats
 	}
 
 	return nil
 }
 
-// sessionAuth is a middleware that checks for session authentication
+// sessionAuth is a middleware that checks for session authentication. API
+// requests may authenticate with a configured API token instead.
 func sessionAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Responses carry client data such as usernames, browsers must not
+		// sniff them as HTML
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		// Scripts send a bearer token and get an error instead of the login page
+		if token, ok := bearerToken(r); ok && strings.HasPrefix(r.URL.Path, "/api/") {
+			if !GetControlPanel().validAPIToken(token) {
+				logger.GetLogger().Warn("Rejected API request to %s from %s with an invalid token", r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Invalid API token", http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+			return
+		}
+
 		// Check for session cookie
 		cookie, err := r.Cookie("session")
 		if err != nil {
@@ -263,6 +464,33 @@ func sessionAuth(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// bearerToken returns the token of a "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// validAPIToken reports whether token is one of the configured API tokens
+func (cp *ControlPanel) validAPIToken(token string) bool {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+
+	if token == "" || cp.CurrentConfig == nil {
+		return false
+	}
+	valid := false
+	for _, configured := range cp.CurrentConfig.ControlPanel.APITokens {
+		// compare every token in constant time so timing reveals nothing
+		if configured != "" && subtle.ConstantTimeCompare([]byte(configured), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
 // handleLogin displays the login page
 func handleLogin(w http.ResponseWriter, r *http.Request) {
 	// Check if already logged in
@@ -476,7 +704,7 @@ func handleAuth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create a new session
-	session, err := cp.CreateSession(username)
+	session, err := cp.CreateSession(username, clientIPFromAddr(r.RemoteAddr))
 	if err != nil {
 		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -527,45 +755,135 @@ func handleFavicon(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "favicon.png")
 }
 
-// StartControlPanel starts the HTTP server for the control panel
-func StartControlPanel(addr string) {
+// Control panel server limits used when the configuration leaves them unset
+const (
+	defaultPanelReadHeaderTimeout = 10 * time.Second
+	defaultPanelReadTimeout       = 30 * time.Second
+	defaultPanelWriteTimeout      = 60 * time.Second
+	defaultPanelIdleTimeout       = 120 * time.Second
+	defaultPanelMaxHeaderBytes    = 64 << 10
+)
+
+// newControlPanelServer returns the HTTP server for the control panel with
+// timeouts, so slow or abandoned clients can not hold connections open.
+// Streaming endpoints have to lift the write deadline themselves with
+// http.ResponseController.
+func newControlPanelServer(cfg config.ControlPanelConfig, handler http.Handler) *http.Server {
+	seconds := func(value int, def time.Duration) time.Duration {
+		if value <= 0 {
+			return def
+		}
+		return time.Duration(value) * time.Second
+	}
+
+	maxHeaderBytes := cfg.MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = defaultPanelMaxHeaderBytes
+	}
+
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: seconds(cfg.ReadHeaderTimeout, defaultPanelReadHeaderTimeout),
+		ReadTimeout:       seconds(cfg.ReadTimeout, defaultPanelReadTimeout),
+		WriteTimeout:      seconds(cfg.WriteTimeout, defaultPanelWriteTimeout),
+		IdleTimeout:       seconds(cfg.IdleTimeout, defaultPanelIdleTimeout),
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+}
+
+// newControlPanelMux returns a mux with the control panel's routes. Each
+// server gets its own mux so the control panel can be started more than once.
+func newControlPanelMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
 	// Serve favicon
-	http.HandleFunc("/favicon.png", handleFavicon)
+	mux.HandleFunc("/favicon.png", handleFavicon)
+
+	// Dashboard stylesheet and script
+	mux.HandleFunc("/static/", handleStatic)
 
 	// Login routes (no authentication required)
-	http.HandleFunc("/login", handleLogin)
-	http.HandleFunc("/auth", handleAuth)
-	http.HandleFunc("/logout", handleLogout)
+	mux.HandleFunc("/login", handleLogin)
+	mux.HandleFunc("/auth", handleAuth)
+	mux.HandleFunc("/logout", handleLogout)
 
 	// Detect if a separated Vite build exists
 	distPath := "web\\dist"
 	if _, err := os.Stat(distPath); err == nil {
 		viteFS := http.FileServer(http.Dir(distPath))
 		// Serve static assets behind session auth
-		http.HandleFunc("/", sessionAuth(func(w http.ResponseWriter, r *http.Request) {
+		mux.HandleFunc("/", sessionAuth(func(w http.ResponseWriter, r *http.Request) {
 			// Always serve the Vite app; FileServer will handle index.html and assets
 			viteFS.ServeHTTP(w, r)
 		}))
 	} else {
 		// Fallback to legacy server-side rendered UI
-		http.HandleFunc("/", sessionAuth(handleIndex))
+		mux.HandleFunc("/", sessionAuth(handleIndex))
 	}
 
 	// Config update and reload (still require auth)
-	http.HandleFunc("/update", sessionAuth(handleUpdate))
-	http.HandleFunc("/reload", sessionAuth(handleReload))
+	mux.HandleFunc("/update", sessionAuth(handleUpdate))
+	mux.HandleFunc("/reload", sessionAuth(handleReload))
+	mux.HandleFunc("/api/config", sessionAuth(handleAPIConfig))
 
 	// API routes for connection management with authentication
-	http.HandleFunc("/api/connections", sessionAuth(handleAPIConnections))
-	http.HandleFunc("/api/disconnect", sessionAuth(handleAPIDisconnect))
+	mux.HandleFunc("/api/connections", sessionAuth(handleAPIConnections))
+	mux.HandleFunc("/api/connections/export", sessionAuth(handleAPIConnectionsExport))
+	mux.HandleFunc("/api/disconnect", sessionAuth(handleAPIDisconnect))
+	mux.HandleFunc("/api/transfer", sessionAuth(handleAPITransfer))
+	mux.HandleFunc("/api/broadcast", sessionAuth(handleAPIBroadcast))
+	mux.HandleFunc("/api/capture", sessionAuth(handleAPICapture))
+	mux.HandleFunc("/api/proxy/drain", sessionAuth(handleAPIDrainProxy))
+	mux.HandleFunc("/api/pause-logins", sessionAuth(handleAPIPauseLogins))
+	mux.HandleFunc("/api/resume-logins", sessionAuth(handleAPIResumeLogins))
+	mux.HandleFunc("/api/ping-test", sessionAuth(handleAPIPingTest))
 
 	// API routes for logs with authentication
-	http.HandleFunc("/api/logs", sessionAuth(handleAPILogs))
-	http.HandleFunc("/api/recent-logs", sessionAuth(handleAPIRecentLogs))
-	http.HandleFunc("/api/delete-logs", sessionAuth(handleAPIDeleteLogs))
+	mux.HandleFunc("/api/logs", sessionAuth(handleAPILogs))
+	mux.HandleFunc("/api/recent-logs", sessionAuth(handleAPIRecentLogs))
+	mux.HandleFunc("/api/delete-logs", sessionAuth(handleAPIDeleteLogs))
+	mux.HandleFunc("/api/log-level", sessionAuth(handleAPILogLevel))
+
+	// Session management endpoints
+	mux.HandleFunc("/api/sessions", sessionAuth(handleAPISessions))
+	mux.HandleFunc("/api/sessions/revoke", sessionAuth(handleAPIRevokeSession))
 
 	// API route for stats (including real-time Public IP)
-	http.HandleFunc("/api/stats", sessionAuth(handleAPIStats))
+	mux.HandleFunc("/api/stats", sessionAuth(handleAPIStats))
+	mux.HandleFunc("/api/stats/history", sessionAuth(handleAPIStatsHistory))
+	mux.HandleFunc("/api/summary", sessionAuth(handleAPISummary))
+	mux.HandleFunc("/api/balancer/stats", sessionAuth(handleAPIBalancerStats))
+	mux.HandleFunc("/api/handshake-blocks", sessionAuth(handleAPIHandshakeBlocks))
+
+	return mux
+}
+
+// StartControlPanel starts the HTTP server for the control panel. /metrics and
+// /healthz are served on metricsAddr instead when it is not empty. The
+// listeners are created before returning so that bind failures are reported
+// to the caller.
+func StartControlPanel(addr, metricsAddr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("control panel failed to listen on %s: %w", addr, err)
+	}
+	var metricsListener net.Listener
+	if metricsAddr != "" {
+		metricsListener, err = net.Listen("tcp", metricsAddr)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("metrics failed to listen on %s: %w", metricsAddr, err)
+		}
+	}
+
+	mux := newControlPanelMux()
+
+	// Monitoring endpoints (no authentication required)
+	metricsMux := mux
+	if metricsListener != nil {
+		metricsMux = http.NewServeMux()
+	}
+	registerMetricsRoutes(metricsMux)
 
 	// Start background refresher for Public IPs
 	go func() {
@@ -573,14 +891,17 @@ func StartControlPanel(addr string) {
 			cp := GetControlPanel()
 			// Snapshot proxies to avoid holding lock during network calls
 			cp.mutex.RLock()
-			proxies := make([]config.ProxyConfig, len(cp.CurrentConfig.Proxies))
-			copy(proxies, cp.CurrentConfig.Proxies)
+			var proxies []config.ProxyConfig
+			if cp.CurrentConfig != nil {
+				proxies = make([]config.ProxyConfig, len(cp.CurrentConfig.Proxies))
+				copy(proxies, cp.CurrentConfig.Proxies)
+			}
 			cp.mutex.RUnlock()
 
 			for _, proxy := range proxies {
 				listenAddr := proxy.Listen
 				// Compute without holding lock
-				pub := GetPublicIP(proxy.LocalAddr)
+				pub := displayedPublicIP(proxy)
 				// Update stats safely
 				cp.mutex.Lock()
 				stats, ok := cp.Stats[listenAddr]
@@ -596,13 +917,50 @@ func StartControlPanel(addr string) {
 		}
 	}()
 
+	cp := GetControlPanel()
+	var panelConfig config.ControlPanelConfig
+	cp.mutex.RLock()
+	if cp.CurrentConfig != nil {
+		panelConfig = cp.CurrentConfig.ControlPanel
+	}
+	cp.mutex.RUnlock()
+	server := newControlPanelServer(panelConfig, mux)
+
 	log.Printf("[INFO] Control panel listening on %s", addr)
 	go func() {
-		err := http.ListenAndServe(addr, nil)
+		err := server.Serve(listener)
 		if err != nil {
-			log.Fatalf("[ERROR] Control panel server failed: %v", err)
+			log.Printf("[ERROR] Control panel server on %s stopped: %v", addr, err)
 		}
 	}()
+
+	if metricsListener != nil {
+		metricsServer := newControlPanelServer(panelConfig, metricsMux)
+		log.Printf("[INFO] Metrics listening on %s", metricsAddr)
+		go func() {
+			err := metricsServer.Serve(metricsListener)
+			if err != nil {
+				log.Printf("[ERROR] Metrics server on %s stopped: %v", metricsAddr, err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// logStorageWarning explains why the logs tab may be incomplete, or returns
+// an empty string while logs are stored on disk
+func logStorageWarning() string {
+	mode, err := logger.GetLogger().Status()
+	switch {
+	case mode == logger.ModeMemory:
+		return fmt.Sprintf("Logs are kept in memory and will be lost on restart: %v", err)
+	case mode == logger.ModeFailed:
+		return fmt.Sprintf("Logs are not being stored, they are only written to the console: %v", err)
+	case err != nil:
+		return fmt.Sprintf("Writing logs to the database is failing: %v", err)
+	}
+	return ""
 }
 
 // handleIndex handles the main control panel page
@@ -625,336 +983,139 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 <html>
 <head>
     <title>Minecraft Proxy Control Panel</title>
-    <style>
-        :root {
-            --primary-color: #3498db;
-            --primary-dark: #2980b9;
-            --secondary-color: #2ecc71;
-            --secondary-dark: #27ae60;
-            --danger-color: #e74c3c;
-            --danger-dark: #c0392b;
-            --text-color: #333;
-            --light-bg: #f8f9fa;
-            --border-color: #e0e0e0;
-            --shadow: 0 4px 6px rgba(0,0,0,0.1);
-        }
-
-        body {
-            font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif;
-            margin: 0;
-            padding: 0;
-            background-color: var(--light-bg);
-            color: var(--text-color);
-            line-height: 1.6;
-        }
+    <link rel="stylesheet" href="{{StaticURL "app.css"}}">
+</head>
+<body>
+    <div class="container">
+        <h1>Minecraft Proxy Control Panel</h1>
+        {{with LogStorageWarning}}<div class="log-warning">{{.}}</div>{{end}}
+        {{if LoginsPaused}}<div class="logins-paused">New logins are paused on all proxies, players already online are not affected. <button class="refresh-btn" onclick="setLoginsPaused(false)">Resume logins</button></div>{{end}}
 
-        .container {
-            max-width: 1200px;
-            margin: 20px auto;
-            background-color: white;
-            padding: 25px;
-            border-radius: 8px;
-            box-shadow: var(--shadow);
-        }
+        <div class="tab">
+            <button class="tablinks active" onclick="openTab(event, 'status')">Status</button>
+            <button class="tablinks" onclick="openTab(event, 'connections')">Active Connections</button>
+            <button class="tablinks" onclick="openTab(event, 'logs')">Logs</button>
+            <button class="tablinks" onclick="openTab(event, 'config')">Configuration</button>
+            <div style="margin-left: auto;">
+                <a href="/logout" style="display: inline-block; padding: 12px 20px; color: var(--danger-color); text-decoration: none; font-weight: 500;">Logout</a>
+            </div>
+        </div>
 
-        h1, h2, h3 {
-            color: var(--primary-color);
-            margin-top: 0;
-        }
+        <div id="status" class="tabcontent active-tabcontent">
+            <h2>Proxy Status</h2>
+            <div class="card">
+                <h3>System Overview</h3>
+                <p>Total active connections: <strong>{{.TotalConnections}}</strong></p>
+                <p>Connection limit per IP: <strong>{{.ConnectionLimit}}</strong></p>
+                <p>New logins: <strong>{{if LoginsPaused}}Paused{{else}}Accepted{{end}}</strong>
+                    {{if not LoginsPaused}}<button class="danger-btn" onclick="setLoginsPaused(true)">Pause logins</button>{{end}}</p>
+            </div>
 
-        h1 {
-            border-bottom: 2px solid var(--primary-color);
-            padding-bottom: 10px;
-            margin-bottom: 20px;
-        }
+            <div class="card">
+                <h3>Proxy Servers</h3>
+                <table>
+                    <tr>
+                        <th>Listen Address</th>
+                        <th>Name</th>
+                        <th>Group</th>
+                        <th>Remote Server</th>
+                        {{if ShowPublicIP}}<th>{{PublicIPHeader}}</th>{{end}}
+                        <th>Status</th>
+                        <th>Connections</th>
+                        <th>Capacity</th>
+                    </tr>
+                    {{range $addr, $stats := .Stats}}
+                    <tr>
+                        <td>{{$addr}}</td>
+                        <td>{{$stats.Config.Label}}</td>
+                        <td>{{$stats.Config.Group}}</td>
+                      		<td>{{$stats.Config.Remote}}</td>
+						{{if ShowPublicIP}}<td class="public-ip" data-listen="{{$addr}}">{{$stats.PublicIP}}</td>{{end}}
+                        <td>
+                            {{if lt $stats.ConnectionCount.Load 1}}
+                                <span class="status-indicator status-good" title="Idle"></span>Idle
+                            {{else if lt $stats.ConnectionCount.Load (MaxConnectionsPerIP)}}
+                                <span class="status-indicator status-good" title="Active"></span>Active
+                            {{else if eq $stats.ConnectionCount.Load (MaxConnectionsPerIP)}}
+                                <span class="status-indicator status-warning" title="Full"></span>Full
+                            {{else}}
+                                <span class="status-indicator status-error" title="Overloaded"></span>Overloaded
+                            {{end}}
+                        </td>
+                        <td>{{$stats.ConnectionCount.Load}}</td>
+                        <td>{{$stats.Config.MaxPlayer}} ({{MaxConnectionsPerIP}} per IP)</td>
+                    </tr>
+                    {{end}}
+                </table>
+            </div>
 
-        table {
-            width: 100%;
-            border-collapse: collapse;
-            margin-bottom: 20px;
-            box-shadow: 0 2px 3px rgba(0,0,0,0.05);
-        }
+            <div class="card">
+                <h3>Rejections</h3>
+                <table>
+                    <tr>
+                        <th>Listen Address</th>
+                        <th>Server Full</th>
+                        <th>IP Limit</th>
+                        <th>Whitelist / Blacklist</th>
+                        <th>Unsupported Version</th>
+                    </tr>
+                    {{range $addr, $stats := .Stats}}
+                    <tr>
+                        <td>{{$addr}}</td>
+                        <td>{{$stats.Rejections.Full.Load}}</td>
+                        <td>{{$stats.Rejections.IPLimit.Load}}</td>
+                        <td>{{$stats.Rejections.Auth.Load}}</td>
+                        <td>{{$stats.Rejections.UnsupportedVersion.Load}}</td>
+                    </tr>
+                    {{end}}
+                </table>
+            </div>
 
-        th, td {
-            padding: 12px 15px;
-            border: 1px solid var(--border-color);
-            text-align: left;
-        }
+            <div class="card">
+                <h3>New Connections per Minute</h3>
+                <canvas id="rate-graph" width="800" height="160" style="width: 100%;"></canvas>
+            </div>
 
-        th {
-            background-color: var(--primary-color);
-            color: white;
-            font-weight: 500;
-        }
+            <div class="action-buttons">
+                <form action="/reload" method="post">
+                    <button type="submit" class="refresh-btn">Reload Configuration</button>
+                </form>
+            </div>
+        </div>
 
-        tr:nth-child(even) {
-            background-color: rgba(0,0,0,0.02);
-        }
+        <div id="connections" class="tabcontent">
+            <h2>Active Connections</h2>
 
-        .tab {
-            display: flex;
-            border-bottom: 2px solid var(--border-color);
-            margin-bottom: 20px;
-            overflow: hidden;
-        }
+            <div class="card">
+                <h3>Connection Management</h3>
+                <p>Manage active client connections to the proxy servers. You can disconnect clients if needed.</p>
+                <p>
+                    <label for="group-filter">Group:</label>
+                    <select id="group-filter" onchange="refreshConnections()">
+                        <option value="">All groups</option>
+                    </select>
+                </p>
 
-        .tab button {
-            background-color: transparent;
-            border: none;
-            outline: none;
-            cursor: pointer;
-            padding: 12px 20px;
-            font-size: 16px;
-            color: var(--text-color);
-            transition: all 0.3s ease;
-            position: relative;
-            margin-right: 5px;
-        }
-
-        .tab button:hover {
-            color: var(--primary-color);
-        }
-
-        .tab button.active {
-            color: var(--primary-color);
-            font-weight: bold;
-        }
-
-        .tab button.active::after {
-            content: '';
-            position: absolute;
-            bottom: 0;
-            left: 0;
-            width: 100%;
-            height: 3px;
-            background-color: var(--primary-color);
-        }
-
-        .tabcontent {
-            display: none;
-            padding: 20px 0;
-            animation: fadeIn 0.5s;
-        }
-
-        @keyframes fadeIn {
-            from { opacity: 0; }
-            to { opacity: 1; }
-        }
-
-        .active-tabcontent {
-            display: block;
-        }
-
-        .connection-row:hover {
-            background-color: rgba(52, 152, 219, 0.05) !important;
-        }
-
-        .disconnect-btn {
-            background-color: var(--danger-color);
-            color: white;
-            padding: 6px 12px;
-            border: none;
-            border-radius: 4px;
-            cursor: pointer;
-            transition: background-color 0.3s;
-        }
-
-        .disconnect-btn:hover {
-            background-color: var(--danger-dark);
-        }
-
-        .form-group {
-            margin-bottom: 20px;
-        }
-
-        label {
-            display: block;
-            margin-bottom: 8px;
-            font-weight: 500;
-            color: var(--text-color);
-        }
-
-        input[type="text"], input[type="number"], select {
-            width: 100%;
-            padding: 10px;
-            border: 1px solid var(--border-color);
-            border-radius: 4px;
-            font-size: 14px;
-            transition: border-color 0.3s;
-        }
-
-        input[type="text"]:focus, input[type="number"]:focus, select:focus {
-            border-color: var(--primary-color);
-            outline: none;
-            box-shadow: 0 0 0 3px rgba(52, 152, 219, 0.1);
-        }
-
-        button {
-            background-color: var(--primary-color);
-            color: white;
-            padding: 10px 16px;
-            border: none;
-            border-radius: 4px;
-            cursor: pointer;
-            font-size: 14px;
-            transition: background-color 0.3s;
-        }
-
-        button:hover {
-            background-color: var(--primary-dark);
-        }
-
-        .danger-btn {
-            background-color: var(--danger-color);
-            color: white;
-            border: none;
-            padding: 8px 16px;
-            border-radius: 4px;
-            cursor: pointer;
-            font-size: 14px;
-            transition: background-color 0.3s;
-            margin-left: 10px;
-        }
-
-        .danger-btn:hover {
-            background-color: var(--danger-dark);
-        }
-
-        .card {
-            background-color: white;
-            border-radius: 8px;
-            box-shadow: var(--shadow);
-            padding: 20px;
-            margin-bottom: 20px;
-        }
-
-        .status-indicator {
-            display: inline-block;
-            width: 12px;
-            height: 12px;
-            border-radius: 50%;
-            margin-right: 8px;
-        }
-
-        .status-good {
-            background-color: var(--secondary-color);
-        }
-
-        .status-warning {
-            background-color: #f39c12;
-        }
-
-        .status-error {
-            background-color: var(--danger-color);
-        }
-
-        .refresh-btn {
-            background-color: var(--secondary-color);
-            margin-right: 10px;
-        }
-
-        .refresh-btn:hover {
-            background-color: var(--secondary-dark);
-        }
-
-        .action-buttons {
-            margin-top: 20px;
-            display: flex;
-            gap: 10px;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <h1>Minecraft Proxy Control Panel</h1>
-
-        <div class="tab">
-            <button class="tablinks active" onclick="openTab(event, 'status')">Status</button>
-            <button class="tablinks" onclick="openTab(event, 'connections')">Active Connections</button>
-            <button class="tablinks" onclick="openTab(event, 'logs')">Logs</button>
-            <button class="tablinks" onclick="openTab(event, 'config')">Configuration</button>
-            <div style="margin-left: auto;">
-                <a href="/logout" style="display: inline-block; padding: 12px 20px; color: var(--danger-color); text-decoration: none; font-weight: 500;">Logout</a>
-            </div>
-        </div>
-
-        <div id="status" class="tabcontent active-tabcontent">
-            <h2>Proxy Status</h2>
-            <div class="card">
-                <h3>System Overview</h3>
-                <p>Total active connections: <strong>{{.TotalConnections}}</strong></p>
-                <p>Connection limit per IP: <strong>{{.ConnectionLimit}}</strong></p>
-            </div>
-
-            <div class="card">
-                <h3>Proxy Servers</h3>
-                <table>
-                    <tr>
-                        <th>Listen Address</th>
-                        <th>Description</th>
-                        <th>Remote Server</th>
-                        <th>Public IP</th>
-                        <th>Status</th>
-                        <th>Connections</th>
-                        <th>Capacity</th>
-                    </tr>
-                    {{range $addr, $stats := .Stats}}
-                    <tr>
-                        <td>{{$addr}}</td>
-                        <td>{{$stats.Config.Description}}</td>
-                      		<td>{{$stats.Config.Remote}}</td>
-						<td class="public-ip" data-listen="{{$addr}}">{{$stats.PublicIP}}</td>
-                        <td>
-                            {{if lt $stats.ConnectionCount.Load 1}}
-                                <span class="status-indicator status-good" title="Idle"></span>Idle
-                            {{else if lt $stats.ConnectionCount.Load (MaxConnectionsPerIP)}}
-                                <span class="status-indicator status-good" title="Active"></span>Active
-                            {{else if eq $stats.ConnectionCount.Load (MaxConnectionsPerIP)}}
-                                <span class="status-indicator status-warning" title="Full"></span>Full
-                            {{else}}
-                                <span class="status-indicator status-error" title="Overloaded"></span>Overloaded
-                            {{end}}
-                        </td>
-                        <td>{{$stats.ConnectionCount.Load}}</td>
-                        <td>{{$stats.Config.MaxPlayer}} ({{MaxConnectionsPerIP}} per IP)</td>
-                    </tr>
-                    {{end}}
-                </table>
-            </div>
-
-            <div class="action-buttons">
-                <form action="/reload" method="post">
-                    <button type="submit" class="refresh-btn">Reload Configuration</button>
-                </form>
-            </div>
-        </div>
-
-        <div id="connections" class="tabcontent">
-            <h2>Active Connections</h2>
-
-            <div class="card">
-                <h3>Connection Management</h3>
-                <p>Manage active client connections to the proxy servers. You can disconnect clients if needed.</p>
-
-                <table id="connections-table">
-                    <thead>
-                        <tr>
-                            <th>Username</th>
-                            <th>Client Address</th>
-                            <th>Proxy Address</th>
-                            <th>Remote Server</th>
-                            <th>Public IP</th>
-                            <th>Connected At</th>
-                            <th>Actions</th>
-                        </tr>
-                    </thead>
-                    <tbody id="connections-tbody">
-                        <!-- Connection rows will be populated by JavaScript -->
-                        <tr>
-                            <td colspan="7" style="text-align: center;">Loading connections...</td>
-                        </tr>
-                    </tbody>
-                </table>
+                <table id="connections-table">
+                    <thead>
+                        <tr>
+                            <th>Username</th>
+                            <th>Group</th>
+                            <th>Client Address</th>
+                            <th>Proxy Address</th>
+                            <th>Remote Server</th>
+                            {{if ShowPublicIP}}<th>{{PublicIPHeader}}</th>{{end}}
+                            <th>Connected At</th>
+                            <th>Actions</th>
+                        </tr>
+                    </thead>
+                    <tbody id="connections-tbody">
+                        <!-- Connection rows will be populated by JavaScript -->
+                        <tr>
+                            <td colspan="8" style="text-align: center;">Loading connections...</td>
+                        </tr>
+                    </tbody>
+                </table>
 
                 <div class="action-buttons">
                     <button onclick="refreshConnections()" class="refresh-btn">Refresh Connections</button>
@@ -1040,7 +1201,7 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
                 <form action="/update" method="post">
                     {{range $index, $proxy := .CurrentConfig.Proxies}}
                     <div class="card" style="margin-bottom: 30px;">
-                        <h3>Proxy {{$index}}: {{$proxy.Description}}</h3>
+                        <h3>Proxy {{$index}}: {{$proxy.Label}}</h3>
 
                         <div class="form-group">
                             <label for="listen{{$index}}">Listen Address:</label>
@@ -1058,7 +1219,12 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
                         </div>
 
                         <div class="form-group">
-                            <label for="description{{$index}}">Description:</label>
+                            <label for="display_name{{$index}}">Display Name (control panel only):</label>
+                            <input type="text" id="display_name{{$index}}" name="proxies[{{$index}}].display_name" value="{{$proxy.DisplayName}}">
+                        </div>
+
+                        <div class="form-group">
+                            <label for="description{{$index}}">Description (MOTD):</label>
                             <input type="text" id="description{{$index}}" name="proxies[{{$index}}].description" value="{{$proxy.Description}}">
                         </div>
 
@@ -1107,480 +1273,10 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
         </div>
 
     <script>
-        // Tab switching functionality
-        function openTab(evt, tabName) {
-            var i, tabcontent, tablinks;
-
-            // Hide all tab content
-            tabcontent = document.getElementsByClassName("tabcontent");
-            for (i = 0; i < tabcontent.length; i++) {
-                tabcontent[i].className = tabcontent[i].className.replace(" active-tabcontent", "");
-            }
-
-            // Remove active class from all tab buttons
-            tablinks = document.getElementsByClassName("tablinks");
-            for (i = 0; i < tablinks.length; i++) {
-                tablinks[i].className = tablinks[i].className.replace(" active", "");
-            }
-
-            // Show the current tab and add active class to the button
-            document.getElementById(tabName).className += " active-tabcontent";
-            evt.currentTarget.className += " active";
-
-            // If connections tab is opened, refresh the connections list
-            if (tabName === 'connections') {
-                refreshConnections();
-            }
-
-            // If logs tab is opened, refresh the logs list
-            if (tabName === 'logs') {
-                refreshLogs();
-            }
-        }
-
-        // Function to refresh the connections list
-        function refreshConnections() {
-            fetch('/api/connections')
-                .then(response => response.json())
-                .then(connections => {
-                    const tbody = document.getElementById('connections-tbody');
-                    tbody.innerHTML = '';
-
-                    if (connections.length === 0) {
-                        const row = document.createElement('tr');
-                        row.innerHTML = '<td colspan="7" style="text-align: center;">No active connections</td>';
-                        tbody.appendChild(row);
-                        return;
-                    }
-
-                    connections.forEach(conn => {
-                        const row = document.createElement('tr');
-                        row.className = 'connection-row';
-
-                        // Format the connected at time
-                        const connectedAt = new Date(conn.connected_at);
-                        const formattedTime = connectedAt.toLocaleString();
-
-                        row.innerHTML = 
-                            '<td>' + (conn.username || '&lt;unknown&gt;') + '</td>' +
-                            '<td>' + conn.client_addr + '</td>' +
-                            '<td>' + conn.proxy_addr + '</td>' +
-                            '<td>' + conn.remote_addr + '</td>' +
-                            '<td>' + conn.public_ip + '</td>' +
-                            '<td>' + formattedTime + '</td>' +
-                            '<td>' +
-                                '<button class="disconnect-btn" onclick="disconnectClient(\'' + conn.id + '\')">Disconnect</button>' +
-                            '</td>';
-                        tbody.appendChild(row);
-                    });
-                })
-                .catch(error => {
-                    console.error('Error fetching connections:', error);
-                    const tbody = document.getElementById('connections-tbody');
-                    tbody.innerHTML = '<tr><td colspan="7" style="text-align: center; color: red;">Error loading connections</td></tr>';
-                });
-        }
-
-        // Function to disconnect a client
-        function disconnectClient(id) {
-            if (!id) {
-                console.error('Attempted to disconnect client with empty ID');
-                alert('Error: Connection ID is missing');
-                return;
-            }
-
-            if (!confirm('Are you sure you want to disconnect this client?')) {
-                return;
-            }
-
-            const requestData = {
-                id: id,
-                reason: 'Disconnected by administrator'
-            };
-
-            fetch('/api/disconnect', {
-                method: 'POST',
-                headers: {
-                    'Content-Type': 'application/json'
-                },
-                body: JSON.stringify(requestData)
-            })
-            .then(async response => {
-                if (!response.ok) {
-                    // Try to get the error message from the response body
-                    let errorMessage = '';
-                    try {
-                        // Clone the response to avoid consuming it
-                        const clonedResponse = response.clone();
-                        // Try to read the response as text
-                        const text = await clonedResponse.text();
-                        if (text) {
-                            errorMessage = ': ' + text;
-                        } else if (response.statusText) {
-                            errorMessage = ': ' + response.statusText;
-                        }
-                    } catch (e) {
-                        // If we can't read the response, just use the status text if available
-                        if (response.statusText) {
-                            errorMessage = ': ' + response.statusText;
-                        }
-                    }
-                    throw new Error('HTTP error ' + response.status + errorMessage);
-                }
-                return response.json();
-            })
-            .then(result => {
-                if (result.success) {
-                    if (result.message) {
-                        console.log(result.message);
-                    }
-                    refreshConnections();
-                } else {
-                    alert('Failed to disconnect client');
-                }
-            })
-            .catch(error => {
-                console.error('Error disconnecting client:', error);
-                alert('Error disconnecting client: ' + error.message);
-            });
-        }
-
-        // Auto-refresh connections every 10 seconds when the tab is active
-        setInterval(() => {
-            const connectionsTab = document.getElementById('connections');
-            if (connectionsTab.className.includes('active-tabcontent')) {
-                refreshConnections();
-            }
-        }, 10000);
-
-        // Logs pagination variables
-        let logsCurrentPage = 0;
-        let logsPageSize = 100;
-        let logsTotalCount = 0;
-
-        // Function to refresh the logs list
-        function refreshLogs() {
-            // Get filter values
-            const level = document.getElementById('log-level').value;
-            const startTime = document.getElementById('log-start-time').value ? 
-                new Date(document.getElementById('log-start-time').value).toISOString() : '';
-            const endTime = document.getElementById('log-end-time').value ? 
-                new Date(document.getElementById('log-end-time').value).toISOString() : '';
-
-            // Build the query URL
-            let url = '/api/logs?limit=' + logsPageSize + '&offset=' + (logsCurrentPage * logsPageSize);
-            if (level) url += '&level=' + encodeURIComponent(level);
-            if (startTime) url += '&start_time=' + encodeURIComponent(startTime);
-            if (endTime) url += '&end_time=' + encodeURIComponent(endTime);
-
-            fetch(url)
-                .then(response => response.json())
-                .then(data => {
-                    const tbody = document.getElementById('logs-tbody');
-                    tbody.innerHTML = '';
-
-                    logsTotalCount = data.total_count;
-
-                    if (data.logs.length === 0) {
-                        const row = document.createElement('tr');
-                        row.innerHTML = '<td colspan="4" style="text-align: center;">No logs found</td>';
-                        tbody.appendChild(row);
-                    } else {
-                        data.logs.forEach(log => {
-                            const row = document.createElement('tr');
-
-                            // Format the timestamp
-                            const timestamp = new Date(log.timestamp);
-                            const formattedTime = timestamp.toLocaleString();
-
-                            // Set row color based on log level
-                            let rowClass = '';
-                            if (log.level === 'ERROR' || log.level === 'FATAL') {
-                                rowClass = 'style="background-color: rgba(231, 76, 60, 0.1);"';
-                            } else if (log.level === 'WARN') {
-                                rowClass = 'style="background-color: rgba(243, 156, 18, 0.1);"';
-                            }
-
-                            row.innerHTML = 
-                                '<tr ' + rowClass + '>' +
-                                '<td>' + formattedTime + '</td>' +
-                                '<td>' + log.level + '</td>' +
-                                '<td>' + log.source + '</td>' +
-                                '<td>' + log.message + '</td>' +
-                                '</tr>';
-                            tbody.appendChild(row);
-                        });
-                    }
-
-                    // Calculate total pages
-                    const totalPages = Math.ceil(logsTotalCount / logsPageSize) || 1;
-
-                    // Update pagination info
-                    document.getElementById('logs-showing').textContent = 
-                        data.logs.length > 0 ? 
-                        ((logsCurrentPage * logsPageSize) + 1) + '-' + 
-                        Math.min((logsCurrentPage + 1) * logsPageSize, logsTotalCount) : 0;
-                    document.getElementById('logs-total').textContent = logsTotalCount;
-
-                    // Update page numbers
-                    document.getElementById('current-page').textContent = logsCurrentPage + 1;
-                    document.getElementById('total-pages').textContent = totalPages;
-
-                    // Update pagination buttons
-                    document.getElementById('logs-first-btn').disabled = logsCurrentPage === 0;
-                    document.getElementById('logs-prev-btn').disabled = logsCurrentPage === 0;
-                    document.getElementById('logs-next-btn').disabled = 
-                        (logsCurrentPage + 1) * logsPageSize >= logsTotalCount;
-                    document.getElementById('logs-last-btn').disabled = 
-                        (logsCurrentPage + 1) * logsPageSize >= logsTotalCount;
-
-                    // Update the lastLogTimestamp for real-time updates
-                    if (data.logs.length > 0 && logsCurrentPage === 0) {
-                        lastLogTimestamp = data.logs[0].timestamp;
-                    }
-                })
-                .catch(error => {
-                    console.error('Error fetching logs:', error);
-                    const tbody = document.getElementById('logs-tbody');
-                    tbody.innerHTML = '<tr><td colspan="4" style="text-align: center; color: red;">Error loading logs</td></tr>';
-                });
-        }
-
-        // Function to go to the first page of logs
-        function goToFirstPage() {
-            logsCurrentPage = 0;
-            refreshLogs();
-        }
-
-        // Function to go to the previous page of logs
-        function previousLogsPage() {
-            if (logsCurrentPage > 0) {
-                logsCurrentPage--;
-                refreshLogs();
-            }
-        }
-
-        // Function to go to the next page of logs
-        function nextLogsPage() {
-            if ((logsCurrentPage + 1) * logsPageSize < logsTotalCount) {
-                logsCurrentPage++;
-                refreshLogs();
-            }
-        }
-
-        // Function to go to the last page of logs
-        function goToLastPage() {
-            logsCurrentPage = Math.ceil(logsTotalCount / logsPageSize) - 1;
-            if (logsCurrentPage < 0) logsCurrentPage = 0;
-            refreshLogs();
-        }
-
-        // Function to clear log filters
-        function clearLogFilters() {
-            document.getElementById('log-level').value = '';
-            document.getElementById('log-start-time').value = '';
-            document.getElementById('log-end-time').value = '';
-            logsCurrentPage = 0;
-            refreshLogs();
-        }
-
-        // Function to delete logs based on current filters
-        function deleteFilteredLogs() {
-            if (!confirm('Are you sure you want to delete all logs matching the current filters? This action cannot be undone.')) {
-                return;
-            }
-
-            // Get filter values
-            const level = document.getElementById('log-level').value;
-            const startTime = document.getElementById('log-start-time').value ? 
-                new Date(document.getElementById('log-start-time').value).toISOString() : '';
-            const endTime = document.getElementById('log-end-time').value ? 
-                new Date(document.getElementById('log-end-time').value).toISOString() : '';
-
-            // Prepare request data
-            const requestData = {
-                level: level,
-                start_time: startTime,
-                end_time: endTime
-            };
-
-            // Send delete request
-            fetch('/api/delete-logs', {
-                method: 'POST',
-                headers: {
-                    'Content-Type': 'application/json'
-                },
-                body: JSON.stringify(requestData)
-            })
-            .then(response => response.json())
-            .then(data => {
-                if (data.success) {
-                    alert('Successfully deleted ' + data.rows_affected + ' log entries.');
-                    refreshLogs(); // Refresh the logs display
-                } else {
-                    alert('Failed to delete logs: ' + (data.error || 'Unknown error'));
-                }
-            })
-            .catch(error => {
-                console.error('Error deleting logs:', error);
-                alert('Error deleting logs: ' + error.message);
-            });
-        }
-
-        // Function to delete all logs
-        function deleteAllLogs() {
-            if (!confirm('Are you sure you want to delete ALL logs? This action cannot be undone.')) {
-                return;
-            }
-
-            // Send delete request with no filters to delete all logs
-            fetch('/api/delete-logs', {
-                method: 'POST',
-                headers: {
-                    'Content-Type': 'application/json'
-                },
-                body: JSON.stringify({})
-            })
-            .then(response => response.json())
-            .then(data => {
-                if (data.success) {
-                    alert('Successfully deleted ' + data.rows_affected + ' log entries.');
-                    refreshLogs(); // Refresh the logs display
-                } else {
-                    alert('Failed to delete logs: ' + (data.error || 'Unknown error'));
-                }
-            })
-            .catch(error => {
-                console.error('Error deleting logs:', error);
-                alert('Error deleting logs: ' + error.message);
-            });
-        }
-
-        // Variable to track the timestamp of the most recent log
-        let lastLogTimestamp = '';
-
-        // Function to fetch only new logs since the last fetch
-        function fetchRecentLogs() {
-            // Only fetch if we have a timestamp to start from
-            if (!lastLogTimestamp) {
-                refreshLogs(); // Do a full refresh the first time
-                return;
-            }
-
-            // Get filter values
-            const level = document.getElementById('log-level').value;
-
-            // Build the query URL
-            let url = '/api/recent-logs?limit=100';
-            if (level) url += '&level=' + encodeURIComponent(level);
-            if (lastLogTimestamp) url += '&since=' + encodeURIComponent(lastLogTimestamp);
-
-            fetch(url)
-                .then(response => response.json())
-                .then(data => {
-                    if (data.logs.length === 0) {
-                        return; // No new logs
-                    }
-
-                    // Update the last timestamp for the next fetch
-                    if (data.logs.length > 0) {
-                        lastLogTimestamp = data.logs[0].timestamp;
-                    }
-
-                    // Get the current tbody
-                    const tbody = document.getElementById('logs-tbody');
-
-                    // If this is the first load or we're showing "No logs found", clear the tbody
-                    if (tbody.children.length === 1 && 
-                        tbody.children[0].innerHTML.includes('No logs found')) {
-                        tbody.innerHTML = '';
-                    }
-
-                    // Add new logs to the top of the table
-                    data.logs.reverse().forEach(log => {
-                        const row = document.createElement('tr');
-
-                        // Format the timestamp
-                        const timestamp = new Date(log.timestamp);
-                        const formattedTime = timestamp.toLocaleString();
-
-                        // Set row color based on log level
-                        let rowClass = '';
-                        if (log.level === 'ERROR' || log.level === 'FATAL') {
-                            rowClass = 'style="background-color: rgba(231, 76, 60, 0.1);"';
-                        } else if (log.level === 'WARN') {
-                            rowClass = 'style="background-color: rgba(243, 156, 18, 0.1);"';
-                        }
-
-                        row.innerHTML = 
-                            '<tr ' + rowClass + '>' +
-                            '<td>' + formattedTime + '</td>' +
-                            '<td>' + log.level + '</td>' +
-                            '<td>' + log.source + '</td>' +
-                            '<td>' + log.message + '</td>' +
-                            '</tr>';
-
-                        // Insert at the beginning of the table
-                        if (tbody.firstChild) {
-                            tbody.insertBefore(row, tbody.firstChild);
-                        } else {
-                            tbody.appendChild(row);
-                        }
-                    });
-
-                    // Limit the number of rows to 100 to prevent the table from growing too large
-                    while (tbody.children.length > 100) {
-                        tbody.removeChild(tbody.lastChild);
-                    }
-                })
-                .catch(error => {
-                    console.error('Error fetching recent logs:', error);
-                });
-        }
-
-        // Auto-refresh logs every 5 seconds when the tab is active
-        setInterval(() => {
-            const logsTab = document.getElementById('logs');
-            if (logsTab.className.includes('active-tabcontent')) {
-                fetchRecentLogs();
-            }
-        }, 5000);
-
-        // Also do a full refresh every 30 seconds to ensure we have the latest data
-        setInterval(() => {
-            const logsTab = document.getElementById('logs');
-            if (logsTab.className.includes('active-tabcontent')) {
-                refreshLogs();
-            }
-        }, 30000);
-
-        // Real-time update for Public IPs in the Status tab
-        function refreshStats() {
-            fetch('/api/stats')
-                .then(resp => resp.json())
-                .then(items => {
-                    items.forEach(item => {
-                        const selector = 'td.public-ip[data-listen="' + item.listen.replace(/[-[\]{}()*+?.,\\^$|#\s]/g, '\\$&') + '"]';
-                        const cell = document.querySelector(selector);
-                        if (cell && cell.textContent !== item.public_ip) {
-                            cell.textContent = item.public_ip;
-                        }
-                    });
-                })
-                .catch(err => console.error('Failed to refresh stats:', err));
-        }
-
-        // Auto-refresh Public IPs every 10 seconds when Status tab is active
-        setInterval(() => {
-            const statusTab = document.getElementById('status');
-            if (statusTab.className.includes('active-tabcontent')) {
-                refreshStats();
-            }
-        }, 10000);
-
-        // Initial fetch shortly after load
-        setTimeout(refreshStats, 2000);
+        // Whether the Public IP column is shown (hidden when the lookup is disabled)
+        const showPublicIP = {{if ShowPublicIP}}true{{else}}false{{end}};
     </script>
+    <script src="{{StaticURL "app.js"}}"></script>
 </body>
 </html>
 `
@@ -1590,6 +1286,22 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 		"MaxConnectionsPerIP": func() int {
 			return MaxConnectionsPerIP
 		},
+		// The Public IP column is hidden when the lookup is disabled, or relabeled
+		// when a static label is configured instead
+		"ShowPublicIP": func() bool {
+			publicIPSettings.RLock()
+			defer publicIPSettings.RUnlock()
+			return !publicIPSettings.disabled || publicIPSettings.label != ""
+		},
+		"PublicIPHeader": func() string {
+			if publicIPLookupDisabled() {
+				return "Label"
+			}
+			return "Public IP"
+		},
+		"StaticURL":         staticURL,
+		"LogStorageWarning": logStorageWarning,
+		"LoginsPaused":      LoginsPaused,
 	}
 
 	t, err := template.New("index").Funcs(funcMap).Parse(tmpl)
@@ -1645,6 +1357,11 @@ func handleUpdate(w http.ResponseWriter, r *http.Request) {
 			newConfig.Proxies[i].Description = description
 		}
 
+		// An empty display name falls back to the description
+		if displayName, ok := r.Form[fmt.Sprintf("proxies[%d].display_name", i)]; ok && len(displayName) > 0 {
+			newConfig.Proxies[i].DisplayName = displayName[0]
+		}
+
 		if localAddr := r.FormValue(fmt.Sprintf("proxies[%d].local_addr", i)); localAddr != "" {
 			newConfig.Proxies[i].LocalAddr = localAddr
 		}
@@ -1722,35 +1439,50 @@ func handleReload(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-// handleAPIConnections returns a JSON list of all active connections
-func handleAPIConnections(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// connectionInfo is the API view of an active connection
+type connectionInfo struct {
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	ClientAddr    string `json:"client_addr"`
+	ProxyAddr     string `json:"proxy_addr"`
+	RemoteAddr    string `json:"remote_addr"`
+	PublicIP      string `json:"public_ip"`
+	ConnectedAt   string `json:"connected_at"`
+	ProxyIndex    int    `json:"proxy_index"`
+	ViaBalancer   bool   `json:"via_balancer"`
+	Group         string `json:"group"`
+	Protocol      int    `json:"protocol"`
+	ModLoader     string `json:"mod_loader"`
+	Locale        string `json:"locale"`
+	EstablishedAt string `json:"established_at"` // When the backend accepted the login, empty until then and for logins the backend encrypts
+	Flapping      bool   `json:"flapping"`       // The client's host:port reconnected flap_threshold times within the window
+}
 
-	// Set content type
-	w.Header().Set("Content-Type", "application/json")
+// connectionCSVHeader names the columns of connectionInfo.csvRecord
+var connectionCSVHeader = []string{
+	"id", "username", "client_addr", "proxy_addr", "remote_addr", "public_ip",
+	"connected_at", "proxy_index", "via_balancer", "group", "protocol", "mod_loader", "locale",
+	"established_at", "flapping",
+}
+
+// csvRecord returns the connection as a CSV row
+func (c connectionInfo) csvRecord() []string {
+	return []string{
+		c.ID, c.Username, c.ClientAddr, c.ProxyAddr, c.RemoteAddr, c.PublicIP,
+		c.ConnectedAt, strconv.Itoa(c.ProxyIndex), strconv.FormatBool(c.ViaBalancer), c.Group,
+		strconv.Itoa(c.Protocol), c.ModLoader, c.Locale, c.EstablishedAt,
+		strconv.FormatBool(c.Flapping),
+	}
+}
 
-	// Get all active connections
-	connections := GetAllConnections()
-
-	// Create a simplified version for the API response
-	type ConnectionInfo struct {
-		ID          string `json:"id"`
-		Username    string `json:"username"`
-		ClientAddr  string `json:"client_addr"`
-		ProxyAddr   string `json:"proxy_addr"`
-		RemoteAddr  string `json:"remote_addr"`
-		PublicIP    string `json:"public_ip"`
-		ConnectedAt string `json:"connected_at"`
-		ProxyIndex  int    `json:"proxy_index"`
-	}
-
-	// Convert to the simplified format
-	connectionInfos := make([]ConnectionInfo, 0, len(connections))
-	for _, conn := range connections {
-		connectionInfos = append(connectionInfos, ConnectionInfo{
+// connectionSnapshot returns all active connections, oldest first. The list
+// and the fields are read under one lock, as the login updates the username
+// while the connection is registered, so the snapshot is consistent.
+func connectionSnapshot() []connectionInfo {
+	activeConnections.RLock()
+	infos := make([]connectionInfo, 0, len(activeConnections.connections))
+	for _, conn := range activeConnections.connections {
+		infos = append(infos, connectionInfo{
 			ID:          conn.ID,
 			Username:    conn.Username,
 			ClientAddr:  conn.ClientAddr,
@@ -1759,7 +1491,47 @@ func handleAPIConnections(w http.ResponseWriter, r *http.Request) {
 			PublicIP:    conn.PublicIP,
 			ConnectedAt: conn.ConnectedAt.Format(time.RFC3339),
 			ProxyIndex:  conn.ProxyIndex,
+			ViaBalancer: conn.ViaBalancer,
+			Group:       conn.Group,
+			Protocol:    conn.Protocol,
+			ModLoader:   conn.ModLoader,
+			Locale:      conn.Locale,
+			Flapping:    conn.Flapping,
 		})
+		if established := conn.EstablishedAt(); !established.IsZero() {
+			infos[len(infos)-1].EstablishedAt = established.Format(time.RFC3339)
+		}
+	}
+	activeConnections.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].ConnectedAt != infos[j].ConnectedAt {
+			return infos[i].ConnectedAt < infos[j].ConnectedAt
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// handleAPIConnections returns a JSON list of all active connections
+func handleAPIConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Optionally only return the connections of one group
+	group, filterGroup := r.URL.Query()["group"]
+
+	connectionInfos := make([]connectionInfo, 0)
+	for _, info := range connectionSnapshot() {
+		if filterGroup && info.Group != group[0] {
+			continue
+		}
+		connectionInfos = append(connectionInfos, info)
 	}
 
 	// Marshal to JSON
@@ -1773,11 +1545,49 @@ func handleAPIConnections(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonData)
 }
 
-// handleAPIDisconnect disconnects a specific client
-func handleAPIDisconnect(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		// Set content type for error response
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+// handleAPIConnectionsExport downloads a snapshot of all active connections
+// as CSV or JSON, for keeping a record during an incident
+func handleAPIConnectionsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "Invalid format, use csv or json", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	infos := connectionSnapshot()
+	filename := fmt.Sprintf("connections-%s.%s", now.Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	logger.GetLogger().Info("Connections exported as %s by %s from %s (%d connections)", format, sessionUsername(r), r.RemoteAddr, len(infos))
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+	writer.Write(connectionCSVHeader)
+	for _, info := range infos {
+		writer.Write(info.csvRecord())
+	}
+	writer.Flush()
+}
+
+// handleAPIDisconnect disconnects a specific client
+func handleAPIDisconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// Set content type for error response
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		// Write a clear error message
 		fmt.Fprint(w, "Method not allowed. Only POST requests are accepted.")
@@ -1846,6 +1656,490 @@ func handleAPIDisconnect(w http.ResponseWriter, r *http.Request) {
  w.Write([]byte(`{"success": true}`))
 }
 
+// handleAPITransfer moves a connection to another address with a Transfer
+// packet, clients that do not support it are disconnected instead
+func handleAPITransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData struct {
+		ID     string `json:"id"`
+		Target string `json:"target"` // host[:port]
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Failed to parse request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestData.ID == "" {
+		http.Error(w, "Connection ID is required", http.StatusBadRequest)
+		return
+	}
+
+	host, port, err := parseTransferTarget(requestData.Target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transferred, err := TransferClient(requestData.ID, host, port)
+	if errors.Is(err, errConnectionNotFound) {
+		http.Error(w, "Connection not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to transfer client: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.GetLogger().Info("Connection %s moved to %s by %s (transferred: %t)",
+		requestData.ID, formatAddr(host, port), sessionUsername(r), transferred)
+
+	jsonData, err := json.Marshal(map[string]bool{"success": true, "transferred": transferred})
+	if err != nil {
+		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// handleAPIBroadcast shows a message in the chat of every player in the play phase
+func handleAPIBroadcast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Failed to parse request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(requestData.Message) == "" {
+		http.Error(w, "Message is required", http.StatusBadRequest)
+		return
+	}
+
+	sent := BroadcastMessage(requestData.Message)
+	logger.GetLogger().Info("Broadcast to %d connections by %s: %s", sent, sessionUsername(r), requestData.Message)
+
+	jsonData, err := json.Marshal(map[string]any{"success": true, "sent": sent})
+	if err != nil {
+		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// handleAPICapture traces the packet IDs and lengths of a connection to the
+// logs for the given duration
+func handleAPICapture(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Connection ID is required", http.StatusBadRequest)
+		return
+	}
+
+	duration := defaultCaptureDuration
+	if value := r.URL.Query().Get("duration"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > maxCaptureDuration {
+			http.Error(w, fmt.Sprintf("Invalid duration, must be positive and at most %v", maxCaptureDuration), http.StatusBadRequest)
+			return
+		}
+		duration = d
+	}
+
+	err := StartCapture(id, duration)
+	switch {
+	case errors.Is(err, errConnectionNotFound):
+		http.Error(w, "Connection not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errCaptureRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.GetLogger().Info("Packet capture of connection %s for %v started by %s", id, duration, sessionUsername(r))
+
+	jsonData, err := json.Marshal(map[string]any{"success": true, "until": time.Now().Add(duration)})
+	if err != nil {
+		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// redactedPassword replaces the control panel password in exported configurations
+const redactedPassword = "********"
+
+// sessionUsername returns the user of the request's session, for audit logs
+func sessionUsername(r *http.Request) string {
+	if token, ok := bearerToken(r); ok && GetControlPanel().validAPIToken(token) {
+		return "api token"
+	}
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return "unknown"
+	}
+	session := GetControlPanel().GetSession(cookie.Value)
+	if session == nil {
+		return "unknown"
+	}
+	return session.Username
+}
+
+// handleAPIConfig exports the configuration on GET and replaces it on POST.
+// An imported configuration is validated as a whole before anything changes,
+// then saved and applied with a reload.
+func handleAPIConfig(w http.ResponseWriter, r *http.Request) {
+	cp := GetControlPanel()
+
+	switch r.Method {
+	case http.MethodGet:
+		cp.mutex.RLock()
+		exported := cp.CurrentConfig.WithSecretRefs()
+		cp.mutex.RUnlock()
+		exported.ControlPanel.Password = redactedPassword
+		exported.ControlPanel.APITokens = nil
+
+		jsonData, err := json.MarshalIndent(exported, "", "    ")
+		if err != nil {
+			http.Error(w, "Failed to marshal config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+
+	case http.MethodPost:
+		var newConfig config.Config
+		err := json.NewDecoder(r.Body).Decode(&newConfig)
+		if err != nil {
+			http.Error(w, "Failed to parse request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := newConfig.ResolveSecrets(); err != nil {
+			http.Error(w, "Invalid configuration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := newConfig.Validate(); err != nil {
+			logger.GetLogger().Warn("Rejected configuration import by %s from %s: %v", sessionUsername(r), r.RemoteAddr, err)
+			http.Error(w, "Invalid configuration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Keep the current values for anything left out, including the redacted password
+		cp.mutex.Lock()
+		if newConfig.Logging.DBPath == "" {
+			newConfig.Logging.DBPath = cp.CurrentConfig.Logging.DBPath
+		}
+		if newConfig.ControlPanel.Username == "" {
+			newConfig.ControlPanel.Username = cp.CurrentConfig.ControlPanel.Username
+		}
+		if newConfig.ControlPanel.Password == "" || newConfig.ControlPanel.Password == redactedPassword {
+			newConfig.ControlPanel.Password = cp.CurrentConfig.ControlPanel.Password
+		}
+		if newConfig.ControlPanel.APITokens == nil {
+			newConfig.ControlPanel.APITokens = cp.CurrentConfig.ControlPanel.APITokens
+		}
+		cp.CurrentConfig = &newConfig
+		cp.Username = newConfig.ControlPanel.Username
+		cp.Password = newConfig.ControlPanel.Password
+		cp.mutex.Unlock()
+
+		logger.GetLogger().Info("Configuration imported by %s from %s with %d proxies", sessionUsername(r), r.RemoteAddr, len(newConfig.Proxies))
+
+		if err := cp.ReloadConfig(); err != nil {
+			http.Error(w, "Failed to reload configuration: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success": true}`))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAPIPauseLogins turns away new logins on all proxies
+func handleAPIPauseLogins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	PauseLogins()
+	writeLoginsPaused(w)
+}
+
+// handleAPIResumeLogins accepts new logins again
+func handleAPIResumeLogins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ResumeLogins()
+	writeLoginsPaused(w)
+}
+
+// writeLoginsPaused answers a pause or resume request with the new state
+func writeLoginsPaused(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Success bool `json:"success"`
+		Paused  bool `json:"paused"`
+	}{
+		Success: true,
+		Paused:  LoginsPaused(),
+	}
+
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+// handleAPIDrainProxy stops a single proxy from accepting connections and
+// starts draining its players
+func handleAPIDrainProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	listen := query.Get("listen")
+	if listen == "" {
+		http.Error(w, "listen is required", http.StatusBadRequest)
+		return
+	}
+
+	message := query.Get("message")
+	if message == "" {
+		message = "Moving you off this server, please reconnect"
+	}
+
+	grace := 5 * time.Second
+	if graceStr := query.Get("grace"); graceStr != "" {
+		parsed, err := time.ParseDuration(graceStr)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid grace duration: "+graceStr, http.StatusBadRequest)
+			return
+		}
+		grace = parsed
+	}
+
+	draining, err := DrainProxy(listen, message, grace)
+	if err != nil {
+		http.Error(w, "Failed to drain proxy: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// the players are disconnected in the background once the grace passed
+	response := struct {
+		Success  bool `json:"success"`
+		Draining int  `json:"draining"`
+	}{
+		Success:  true,
+		Draining: draining,
+	}
+
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(jsonData)
+}
+
+// handleAPILogLevel returns the logger's minimum level on GET and changes it on
+// POST, optionally saving the new level to the configuration file
+func handleAPILogLevel(w http.ResponseWriter, r *http.Request) {
+	l := logger.GetLogger()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var requestData struct {
+			Level   string `json:"level"`
+			Persist bool   `json:"persist"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&requestData)
+		if err != nil {
+			http.Error(w, "Failed to parse request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		level, err := logger.ParseLogLevel(requestData.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		l.SetLevel(level)
+		log.Printf("[INFO] Log level set to %s", level)
+
+		if requestData.Persist {
+			cp := GetControlPanel()
+			cp.mutex.Lock()
+			if cp.CurrentConfig != nil {
+				cp.CurrentConfig.Logging.Level = level.String()
+			}
+			cp.mutex.Unlock()
+
+			if err := cp.SaveConfig(); err != nil {
+				http.Error(w, "Failed to save configuration: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jsonData, err := json.Marshal(map[string]string{"level": l.Level().String()})
+	if err != nil {
+		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// sessionInfo describes a control panel session without its token
+type sessionInfo struct {
+	Handle    string    `json:"handle"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	SourceIP  string    `json:"source_ip"`
+	Current   bool      `json:"current"`
+}
+
+// handleAPISessions lists the active control panel sessions
+func handleAPISessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current := ""
+	if cookie, err := r.Cookie("session"); err == nil {
+		current = cookie.Value
+	}
+
+	sessions := GetControlPanel().ListSessions()
+	infos := make([]sessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, sessionInfo{
+			Handle:    session.Handle(),
+			Username:  session.Username,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+			SourceIP:  session.SourceIP,
+			Current:   current != "" && session.ID == current,
+		})
+	}
+
+	jsonData, err := json.Marshal(infos)
+	if err != nil {
+		http.Error(w, "Failed to marshal sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// handleAPIRevokeSession deletes a session by its opaque handle
+func handleAPIRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData struct {
+		Handle string `json:"handle"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Failed to parse request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestData.Handle == "" {
+		http.Error(w, "Session handle is required", http.StatusBadRequest)
+		return
+	}
+
+	// Resolve the caller first, admins may revoke their own session
+	admin := sessionUsername(r)
+	if !GetControlPanel().RemoveSessionByHandle(requestData.Handle) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	logger.GetLogger().Info("Session %s revoked by %s from %s", requestData.Handle, admin, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"success": true}`))
+}
+
+// handleAPIPingTest pings a remote server so it can be checked before it is added to the configuration
+func handleAPIPingTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData struct {
+		Remote    string `json:"remote"`
+		LocalAddr string `json:"local_addr"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		http.Error(w, "Failed to parse request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if requestData.Remote == "" {
+		http.Error(w, "remote is required", http.StatusBadRequest)
+		return
+	}
+
+	result := pingTest(requestData.Remote, requestData.LocalAddr, 10*time.Second)
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
 // handleAPIStats returns current stats including Public IP for each listen address
 func handleAPIStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -1856,13 +2150,19 @@ func handleAPIStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	type StatItem struct {
-		Listen       string `json:"listen"`
-		PublicIP     string `json:"public_ip"`
-		Connections  int32  `json:"connections"`
-		Description  string `json:"description"`
-		Remote       string `json:"remote"`
+		Listen       string                 `json:"listen"`
+		PublicIP     string                 `json:"public_ip"`
+		Connections  int32                  `json:"connections"`
+		DisplayName  string                 `json:"display_name"`
+		Group        string                 `json:"group"`
+		Description  string                 `json:"description"`
+		Remote       string                 `json:"remote"`
+		Rejections   map[RejectReason]int64 `json:"rejections"`
+		Breaker      *BreakerStatus         `json:"breaker,omitempty"` // circuit breaker, when the balancer uses the proxy
 	}
 
+	breakers := balancerBreakerStatuses()
+
 	cp := GetControlPanel()
 	cp.mutex.RLock()
 	items := make([]StatItem, 0, len(cp.Stats))
@@ -1873,8 +2173,14 @@ func handleAPIStats(w http.ResponseWriter, r *http.Request) {
 			Listen:      listen,
 			PublicIP:    st.PublicIP,
 			Connections: c,
+			DisplayName: st.Config.Label(),
+			Group:       st.Config.Group,
 			Description: st.Config.Description,
 			Remote:      st.Config.Remote,
+			Rejections:  st.Rejections.Snapshot(),
+		}
+		if breaker, ok := breakers[listen]; ok {
+			item.Breaker = &breaker
 		}
 		total += c
 		items = append(items, item)
@@ -1883,13 +2189,17 @@ func handleAPIStats(w http.ResponseWriter, r *http.Request) {
 	cp.mutex.RUnlock()
 
 	response := struct {
-		TotalConnections int32      `json:"total_connections"`
-		ConnectionLimit  int        `json:"connection_limit"`
-		Proxies          []StatItem `json:"proxies"`
+		TotalConnections int32          `json:"total_connections"`
+		ConnectionLimit  int            `json:"connection_limit"`
+		Proxies          []StatItem     `json:"proxies"`
+		Protocols        []ProtocolStat `json:"protocols"` // logins by client version
+		Locales          []LocaleStat   `json:"locales"`   // connections by client locale, with capture_locale
 	}{
 		TotalConnections: total,
 		ConnectionLimit:  limit,
 		Proxies:          items,
+		Protocols:        ProtocolStats(),
+		Locales:          LocaleStats(),
 	}
 
 	data, err := json.Marshal(response)
@@ -1900,6 +2210,136 @@ func handleAPIStats(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// handleAPIStatsHistory returns the sampled online and new connection counts
+func handleAPIStatsHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := struct {
+		IntervalSeconds int           `json:"interval_seconds"`
+		Samples         []StatsSample `json:"samples"`
+	}{
+		IntervalSeconds: int(statsSampleInterval / time.Second),
+		Samples:         StatsHistory(),
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to marshal stats history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleAPISummary returns the totals of the stats tab in one call, for
+// external dashboards. There are no byte counters, so no traffic totals.
+func handleAPISummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type ProxySummary struct {
+		Listen      string `json:"listen"`
+		DisplayName string `json:"display_name"`
+		Online      int32  `json:"online"`
+		Health      string `json:"health,omitempty"` // circuit breaker state, when the balancer uses the proxy
+	}
+
+	breakers := balancerBreakerStatuses()
+
+	rejections := make(map[RejectReason]int64)
+	cp := GetControlPanel()
+	cp.mutex.RLock()
+	proxies := make([]ProxySummary, 0, len(cp.Stats))
+	for listen, st := range cp.Stats {
+		proxies = append(proxies, ProxySummary{
+			Listen:      listen,
+			DisplayName: st.Config.Label(),
+			Online:      st.ConnectionCount.Load(),
+			Health:      breakers[listen].State,
+		})
+		for reason, n := range st.Rejections.Snapshot() {
+			rejections[reason] += n
+		}
+	}
+	cp.mutex.RUnlock()
+	sort.Slice(proxies, func(i, j int) bool { return proxies[i].Listen < proxies[j].Listen })
+
+	// the last full minute, or the current one before the first sample
+	perMinute := statsHistory.newConnections.Load()
+	if history := StatsHistory(); len(history) > 0 {
+		perMinute = history[len(history)-1].NewConnections
+	}
+
+	response := struct {
+		Online               int32                  `json:"online"`
+		ConnectionsPerMinute int64                  `json:"connections_per_minute"`
+		LoginsPaused         bool                   `json:"logins_paused"`
+		Rejections           map[RejectReason]int64 `json:"rejections"`
+		Proxies              []ProxySummary         `json:"proxies"`
+	}{
+		Online:               onlineCount.Load(),
+		ConnectionsPerMinute: perMinute,
+		LoginsPaused:         LoginsPaused(),
+		Rejections:           rejections,
+		Proxies:              proxies,
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to marshal summary: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleAPIBalancerStats returns the raw statistics of each proxy of every
+// running balancer, an empty list when no balancer is running
+func handleAPIBalancerStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := []BalancerProxyStat{}
+	for _, balancer := range runningBalancers() {
+		stats = append(stats, balancer.Stats()...)
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, "Failed to marshal balancer stats: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleAPIHandshakeBlocks returns the client IPs blocked by the handshake rate limit
+func handleAPIHandshakeBlocks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(HandshakeBlocks())
+	if err != nil {
+		http.Error(w, "Failed to marshal handshake blocks: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
 // handleAPILogs returns a JSON list of logs with optional filtering
 func handleAPILogs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -0,0 +1,720 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mcproxy/config"
+	"mcproxy/logger"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartControlPanelPortInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().String()
+	err = StartControlPanel(addr, "")
+	if err == nil {
+		t.Fatal("expected an error binding a port that is in use")
+	}
+	if !strings.Contains(err.Error(), addr) {
+		t.Errorf("error %q does not mention %s", err, addr)
+	}
+}
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// getStatus requests path from addr and returns the status code, without
+// following redirects
+func getStatus(t *testing.T, addr, path string) int {
+	t.Helper()
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("http://" + addr + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestStartControlPanelTwice(t *testing.T) {
+	first, second := freeAddr(t), freeAddr(t)
+	if err := StartControlPanel(first, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := StartControlPanel(second, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{first, second} {
+		if code := getStatus(t, addr, "/login"); code != http.StatusOK {
+			t.Errorf("%s /login: %d", addr, code)
+		}
+	}
+
+	// the routes live on the servers' own muxes, not the global one
+	for _, path := range []string{"/login", "/api/summary", "/metrics"} {
+		if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest(http.MethodGet, path, nil)); pattern != "" {
+			t.Errorf("%s registered on the default mux as %q", path, pattern)
+		}
+	}
+}
+
+func TestStartControlPanelMetricsAddr(t *testing.T) {
+	panelAddr, metricsAddr := freeAddr(t), freeAddr(t)
+	if err := StartControlPanel(panelAddr, metricsAddr); err != nil {
+		t.Fatal(err)
+	}
+	get := func(addr, path string) int { return getStatus(t, addr, path) }
+
+	if code := get(metricsAddr, "/metrics"); code != http.StatusOK {
+		t.Errorf("metrics address /metrics: %d", code)
+	}
+	if code := get(metricsAddr, "/healthz"); code != http.StatusOK {
+		t.Errorf("metrics address /healthz: %d", code)
+	}
+	for _, path := range []string{"/", "/login", "/api/stats"} {
+		if code := get(metricsAddr, path); code != http.StatusNotFound {
+			t.Errorf("metrics address %s: %d, want 404", path, code)
+		}
+	}
+	// the control panel's catch-all route sends it to the login instead
+	if code := get(panelAddr, "/metrics"); code == http.StatusOK {
+		t.Errorf("control panel address served /metrics")
+	}
+	if code := get(panelAddr, "/login"); code != http.StatusOK {
+		t.Errorf("control panel address /login: %d", code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40033", Remote: "127.0.0.1:1"}
+	stats := registerProxyStats(t, cfg)
+	stats.ConnectionCount.Store(3)
+	GetControlPanel().RecordRejection(cfg.Listen, RejectFull)
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE mcproxy_online_players gauge\n",
+		`mcproxy_proxy_online_players{listen="127.0.0.1:40033"} 3`,
+		`mcproxy_rejections_total{listen="127.0.0.1:40033",reason="full"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestAPILogLevel(t *testing.T) {
+	l := logger.GetLogger()
+	orig := l.Level()
+	defer l.SetLevel(orig)
+	l.SetLevel(logger.INFO)
+
+	cp := GetControlPanel()
+	origConfig, origPath := cp.CurrentConfig, cp.ConfigPath
+	defer func() { cp.CurrentConfig, cp.ConfigPath = origConfig, origPath }()
+	cp.CurrentConfig = &config.Config{}
+	cp.ConfigPath = filepath.Join(t.TempDir(), "config.json")
+
+	do := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleAPILogLevel(rec, httptest.NewRequest(method, "/api/log-level", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodGet, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"level":"INFO"`) {
+		t.Fatalf("get: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(http.MethodPost, `{"level":"debug"}`)
+	if rec.Code != http.StatusOK || l.Level() != logger.DEBUG {
+		t.Fatalf("set: %d %s, level %s", rec.Code, rec.Body, l.Level())
+	}
+	if _, err := os.Stat(cp.ConfigPath); !os.IsNotExist(err) {
+		t.Error("config saved without persist")
+	}
+
+	rec = do(http.MethodPost, `{"level":"loud"}`)
+	if rec.Code != http.StatusBadRequest || l.Level() != logger.DEBUG {
+		t.Fatalf("invalid: %d %s, level %s", rec.Code, rec.Body, l.Level())
+	}
+
+	rec = do(http.MethodPost, `{"level":"warn","persist":true}`)
+	if rec.Code != http.StatusOK || l.Level() != logger.WARN {
+		t.Fatalf("persist: %d %s, level %s", rec.Code, rec.Body, l.Level())
+	}
+	data, err := os.ReadFile(cp.ConfigPath)
+	if err != nil || !strings.Contains(string(data), `"level": "WARN"`) {
+		t.Errorf("persisted config = %s, %v", data, err)
+	}
+}
+
+func TestAPIConfigExportImport(t *testing.T) {
+	cp := GetControlPanel()
+	cp.mutex.Lock()
+	origConfig, origPath := cp.CurrentConfig, cp.ConfigPath
+	origStats := cp.Stats
+	cp.Stats = make(map[string]*ProxyStats)
+	cp.CurrentConfig = &config.Config{
+		Proxies:      []config.ProxyConfig{{Listen: "127.0.0.1:40050", Remote: "old.example.com", PingMode: "fake", Auth: "none"}},
+		Logging:      config.LogConfig{DBPath: "logs/test.db"},
+		ControlPanel: config.ControlPanelConfig{Username: "admin", Password: "secret"},
+	}
+	cp.ConfigPath = filepath.Join(t.TempDir(), "config.json")
+	cp.mutex.Unlock()
+	defer func() {
+		cp.mutex.Lock()
+		cp.CurrentConfig, cp.ConfigPath, cp.Stats = origConfig, origPath, origStats
+		cp.mutex.Unlock()
+	}()
+
+	var restarted []config.Config
+	origRestart := restartProxies
+	restartProxies = func(c config.Config) { restarted = append(restarted, c) }
+	defer func() { restartProxies = origRestart }()
+
+	rec := httptest.NewRecorder()
+	handleAPIConfig(rec, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: %d %s", rec.Code, rec.Body)
+	}
+	exported := rec.Body.String()
+	if strings.Contains(exported, "secret") || !strings.Contains(exported, redactedPassword) {
+		t.Fatalf("password not redacted: %s", exported)
+	}
+
+	// Importing an invalid proxy leaves the running configuration untouched
+	invalid := strings.Replace(exported, `"proxies": [`, `"proxies": [{"listen": "127.0.0.1:40051", "remote": "x", "ping_mode": "bogus", "auth": "none"},`, 1)
+	rec = httptest.NewRecorder()
+	handleAPIConfig(rec, httptest.NewRequest(http.MethodPost, "/api/config", strings.NewReader(invalid)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid import: %d %s", rec.Code, rec.Body)
+	}
+	if len(cp.CurrentConfig.Proxies) != 1 || len(restarted) != 0 {
+		t.Fatalf("invalid import changed the configuration")
+	}
+
+	// A valid import keeps the redacted password, is saved and reloaded
+	valid := strings.Replace(exported, "old.example.com", "new.example.com", 1)
+	rec = httptest.NewRecorder()
+	handleAPIConfig(rec, httptest.NewRequest(http.MethodPost, "/api/config", strings.NewReader(valid)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("valid import: %d %s", rec.Code, rec.Body)
+	}
+	if len(restarted) != 1 || restarted[0].Proxies[0].Remote != "new.example.com" {
+		t.Fatalf("proxies not restarted with the new config: %+v", restarted)
+	}
+	if cp.CurrentConfig.ControlPanel.Password != "secret" {
+		t.Errorf("password = %q, want the previous password", cp.CurrentConfig.ControlPanel.Password)
+	}
+	if data, err := os.ReadFile(cp.ConfigPath); err != nil || !strings.Contains(string(data), "new.example.com") {
+		t.Errorf("saved config = %s, %v", data, err)
+	}
+}
+
+func TestReloadConfigMovesLogDatabase(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "old.db"), filepath.Join(dir, "new.db")
+
+	cp := GetControlPanel()
+	cp.mutex.Lock()
+	origConfig, origPath := cp.CurrentConfig, cp.ConfigPath
+	cp.CurrentConfig = &config.Config{Logging: config.LogConfig{DBPath: oldPath}}
+	cp.ConfigPath = filepath.Join(dir, "config.json")
+	cp.mutex.Unlock()
+	defer func() {
+		cp.mutex.Lock()
+		cp.CurrentConfig, cp.ConfigPath = origConfig, origPath
+		cp.mutex.Unlock()
+	}()
+
+	origRestart := restartProxies
+	restartProxies = func(config.Config) {}
+	defer func() { restartProxies = origRestart }()
+
+	l := logger.GetLogger()
+	if err := l.Initialize(oldPath); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Info("written before the reload")
+
+	cp.CurrentConfig.Logging.DBPath = newPath
+	if err := cp.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("written after the reload")
+
+	// messages reports which of the two messages the current database holds
+	messages := func() (before, after bool) {
+		logs, err := l.GetLogs(1000, 0, "", time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range logs {
+			before = before || entry.Message == "written before the reload"
+			after = after || entry.Message == "written after the reload"
+		}
+		return before, after
+	}
+
+	if before, after := messages(); before || !after {
+		t.Errorf("new database: before reload %t, after reload %t", before, after)
+	}
+	if err := l.Reopen(oldPath, logger.StorageOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if before, after := messages(); !before || after {
+		t.Errorf("old database: before reload %t, after reload %t", before, after)
+	}
+}
+
+func TestReloadConfigKeepsConnectionCounts(t *testing.T) {
+	dir := t.TempDir()
+	proxy := config.ProxyConfig{Listen: "127.0.0.1:40164", Remote: "backend.example.com:25565", MaxPlayer: 2, Auth: "none"}
+	stats := registerProxyStats(t, proxy)
+	stats.ConnectionCount.Store(2)
+
+	cp := GetControlPanel()
+	cp.mutex.Lock()
+	origConfig, origPath := cp.CurrentConfig, cp.ConfigPath
+	cp.CurrentConfig = &config.Config{Proxies: []config.ProxyConfig{proxy}, Logging: config.LogConfig{DBPath: filepath.Join(dir, "logs.db")}}
+	cp.ConfigPath = filepath.Join(dir, "config.json")
+	cp.mutex.Unlock()
+	defer func() {
+		cp.mutex.Lock()
+		cp.CurrentConfig, cp.ConfigPath = origConfig, origPath
+		cp.mutex.Unlock()
+	}()
+
+	origRestart := restartProxies
+	restartProxies = func(config.Config) {}
+	defer func() { restartProxies = origRestart }()
+	defer logger.GetLogger().Close()
+
+	if err := cp.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the players connected before the reload still fill the proxy
+	if !serverFull(proxy, "Alex") {
+		t.Error("proxy with max_player players connected is not full after the reload")
+	}
+	cp.DecrementConnectionCount(proxy.Listen)
+	cp.mutex.RLock()
+	n := cp.Stats[proxy.Listen].ConnectionCount.Load()
+	cp.mutex.RUnlock()
+	if n != 1 {
+		t.Errorf("connection count after one player left = %d, want 1", n)
+	}
+}
+
+func TestReloadConfigAppliesLogLevel(t *testing.T) {
+	dir := t.TempDir()
+	cp := GetControlPanel()
+	cp.mutex.Lock()
+	origConfig, origPath := cp.CurrentConfig, cp.ConfigPath
+	cp.CurrentConfig = &config.Config{Logging: config.LogConfig{DBPath: filepath.Join(dir, "logs.db"), Level: "warn"}}
+	cp.ConfigPath = filepath.Join(dir, "config.json")
+	cp.mutex.Unlock()
+	defer func() {
+		cp.mutex.Lock()
+		cp.CurrentConfig, cp.ConfigPath = origConfig, origPath
+		cp.mutex.Unlock()
+	}()
+
+	origRestart := restartProxies
+	restartProxies = func(config.Config) {}
+	defer func() { restartProxies = origRestart }()
+
+	l := logger.GetLogger()
+	origLevel := l.Level()
+	defer l.SetLevel(origLevel)
+	defer l.Close()
+	l.SetLevel(logger.DEBUG)
+
+	if err := cp.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if level := l.Level(); level != logger.WARN {
+		t.Errorf("level after the reload = %s, want WARN", level)
+	}
+}
+
+func TestAPISessionsListAndRevoke(t *testing.T) {
+	cp := GetControlPanel()
+	admin, err := cp.CreateSession("admin", "198.51.100.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := cp.CreateSession("admin", "203.0.113.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.RemoveSession(admin.ID)
+	defer cp.RemoveSession(other.ID)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: admin.ID})
+	rec := httptest.NewRecorder()
+	handleAPISessions(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), admin.ID) || strings.Contains(rec.Body.String(), other.ID) {
+		t.Fatalf("session token exposed: %s", rec.Body)
+	}
+
+	var infos []sessionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	byHandle := map[string]sessionInfo{}
+	for _, info := range infos {
+		byHandle[info.Handle] = info
+	}
+	if info := byHandle[admin.Handle()]; !info.Current || info.SourceIP != "198.51.100.4" {
+		t.Errorf("admin session = %+v", info)
+	}
+	if info := byHandle[other.Handle()]; info.Current || info.SourceIP != "203.0.113.9" || info.Username != "admin" {
+		t.Errorf("other session = %+v", info)
+	}
+
+	revoke := func(handle string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/sessions/revoke", strings.NewReader(`{"handle":"`+handle+`"}`))
+		req.AddCookie(&http.Cookie{Name: "session", Value: admin.ID})
+		handleAPIRevokeSession(rec, req)
+		return rec.Code
+	}
+
+	if code := revoke(other.Handle()); code != http.StatusOK {
+		t.Fatalf("revoke: %d", code)
+	}
+	if cp.GetSession(other.ID) != nil {
+		t.Error("revoked session still valid")
+	}
+	if cp.GetSession(admin.ID) == nil {
+		t.Error("unrelated session revoked")
+	}
+	if code := revoke(other.Handle()); code != http.StatusNotFound {
+		t.Errorf("second revoke: %d, want 404", code)
+	}
+}
+
+func TestAPITokenAuth(t *testing.T) {
+	cp := GetControlPanel()
+	origConfig := cp.CurrentConfig
+	defer func() { cp.CurrentConfig = origConfig }()
+	cp.CurrentConfig = &config.Config{
+		ControlPanel: config.ControlPanelConfig{APITokens: []string{"ci-token", "bot-token"}},
+	}
+
+	handler := sessionAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(sessionUsername(r)))
+	})
+	call := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := call("/api/stats", "Bearer bot-token"); rec.Code != http.StatusOK || rec.Body.String() != "api token" {
+		t.Errorf("valid token: %d %s", rec.Code, rec.Body)
+	}
+	for _, authorization := range []string{"Bearer wrong-token", "Bearer ", "Bearer ci-token-2"} {
+		if rec := call("/api/stats", authorization); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%q: %d, want 401", authorization, rec.Code)
+		}
+	}
+
+	// without a token, and outside the API, the session flow applies
+	if rec := call("/api/stats", ""); rec.Code != http.StatusSeeOther {
+		t.Errorf("no token: %d, want a redirect to the login page", rec.Code)
+	}
+	if rec := call("/", "Bearer ci-token"); rec.Code != http.StatusSeeOther {
+		t.Errorf("token for the UI: %d, want a redirect to the login page", rec.Code)
+	}
+}
+
+func TestControlPanelServerTimesOutSlowHeaders(t *testing.T) {
+	server := newControlPanelServer(config.ControlPanelConfig{ReadHeaderTimeout: 1}, http.NotFoundHandler())
+	if server.WriteTimeout != defaultPanelWriteTimeout || server.MaxHeaderBytes != defaultPanelMaxHeaderBytes {
+		t.Errorf("defaults not applied: write %v, max header %d", server.WriteTimeout, server.MaxHeaderBytes)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// start a request but never finish the headers
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: panel\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("connection was not closed by the server: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("server closed the connection after %v", elapsed)
+	}
+}
+
+func TestConnectionsExportCSV(t *testing.T) {
+	registerPipeConnection(t, "export-a", "127.0.0.1:40011")
+	registerPipeConnection(t, "export-b", "127.0.0.1:40012")
+
+	rec := httptest.NewRecorder()
+	handleAPIConnectionsExport(rec, httptest.NewRequest(http.MethodGet, "/api/connections/export?format=csv", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: %d %s", rec.Code, rec.Body)
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment;") || !strings.HasSuffix(disposition, `.csv"`) {
+		t.Errorf("Content-Disposition = %q", disposition)
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d rows, want a header and 2 connections", len(records))
+	}
+	if strings.Join(records[0], ",") != strings.Join(connectionCSVHeader, ",") {
+		t.Errorf("header = %v", records[0])
+	}
+	ids := map[string]bool{records[1][0]: true, records[2][0]: true}
+	if !ids["export-a"] || !ids["export-b"] {
+		t.Errorf("rows = %v", records[1:])
+	}
+
+	rec = httptest.NewRecorder()
+	handleAPIConnectionsExport(rec, httptest.NewRequest(http.MethodGet, "/api/connections/export?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: %d, want 400", rec.Code)
+	}
+}
+
+func TestDashboardShowsClientDataAsText(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleStatic(rec, httptest.NewRequest(http.MethodGet, "/static/app.js", nil))
+	js := rec.Body.String()
+
+	// JSON escaping does not help once the dashboard puts a value into
+	// innerHTML, so client data may only reach the page as text
+	assignments := regexp.MustCompile(`innerHTML\s*=[^=]`).FindAllStringIndex(js, -1)
+	if len(assignments) == 0 {
+		t.Fatal("no innerHTML assignments found in app.js")
+	}
+	constant := regexp.MustCompile(`^innerHTML\s*=\s*'[^'\n]*'\s*;`)
+	for _, m := range assignments {
+		if !constant.MatchString(js[m[0]:]) {
+			line, _, _ := strings.Cut(js[m[0]:], "\n")
+			t.Errorf("innerHTML set from a non-constant value: %s", line)
+		}
+	}
+
+	cp := GetControlPanel()
+	origConfig := cp.CurrentConfig
+	defer func() { cp.CurrentConfig = origConfig }()
+	cp.CurrentConfig = &config.Config{
+		ControlPanel: config.ControlPanelConfig{APITokens: []string{"ci-token"}},
+	}
+
+	tests := []struct {
+		field string
+		value string
+		conn  *Connection // serves the value through /api/connections, nil for log fields
+	}{
+		{"conn.username", "<img src=x onerror=alert(1)>", &Connection{Username: "<img src=x onerror=alert(1)>"}},
+		{"conn.username", "<script>alert(document.cookie)</script>", &Connection{Username: "<script>alert(document.cookie)</script>"}},
+		{"conn.group", "<b>lobby</b>", &Connection{Group: "<b>lobby</b>"}},
+		{"conn.client_addr", "<i>127.0.0.1</i>", &Connection{ClientAddr: "<i>127.0.0.1</i>"}},
+		{"log.source", "<img src=x>", nil},
+		{"log.message", "User rejected: <img src=x onerror=alert(1)>", nil},
+	}
+	for i, tt := range tests {
+		if !strings.Contains(js, "appendCell(row, "+tt.field) {
+			t.Errorf("%s is not shown with appendCell", tt.field)
+		}
+		for _, line := range strings.Split(js, "\n") {
+			if strings.Contains(line, tt.field) && strings.Contains(line, "innerHTML") {
+				t.Errorf("%s used with innerHTML: %s", tt.field, strings.TrimSpace(line))
+			}
+		}
+		if tt.conn == nil {
+			continue
+		}
+
+		// the API hands the value to the dashboard as the client sent it,
+		// as JSON that is never sniffed as HTML
+		tt.conn.ID = fmt.Sprintf("conn-html-%d", i)
+		tt.conn.ConnectedAt = time.Now()
+		RegisterConnection(tt.conn)
+		req := httptest.NewRequest(http.MethodGet, "/api/connections", nil)
+		req.Header.Set("Authorization", "Bearer ci-token")
+		rec := httptest.NewRecorder()
+		sessionAuth(handleAPIConnections)(rec, req)
+		UnregisterConnection(tt.conn.ID)
+		if sniff := rec.Header().Get("X-Content-Type-Options"); sniff != "nosniff" {
+			t.Errorf("X-Content-Type-Options = %q", sniff)
+		}
+		var conns []connectionInfo
+		if err := json.NewDecoder(rec.Body).Decode(&conns); err != nil {
+			t.Fatal(err)
+		}
+		for _, conn := range conns {
+			if conn.ID == tt.conn.ID && (conn.Username != tt.conn.Username || conn.Group != tt.conn.Group || conn.ClientAddr != tt.conn.ClientAddr) {
+				t.Errorf("%s: connection = %+v", tt.field, conn)
+			}
+		}
+	}
+}
+
+func TestAPISummary(t *testing.T) {
+	a := registerProxyStats(t, config.ProxyConfig{Listen: "127.0.0.1:40121", Remote: "a.example.com:25565"})
+	b := registerProxyStats(t, config.ProxyConfig{Listen: "127.0.0.1:40122", Remote: "b.example.com:25565"})
+	a.ConnectionCount.Store(2)
+	b.ConnectionCount.Store(1)
+	a.Rejections.Full.Add(3)
+	b.Rejections.Full.Add(1)
+	b.Rejections.Auth.Add(2)
+	onlineCount.Add(3)
+	defer onlineCount.Add(-3)
+
+	statsHistory.newConnections.Store(7)
+	takeStatsSample(time.Now())
+
+	pb := NewProxyBalancer("127.0.0.1:0", []config.ProxyConfig{a.Config, b.Config})
+	tripBreaker(pb.proxyStats[1].breaker)
+	registerTestBalancer(t, pb)
+
+	rec := httptest.NewRecorder()
+	handleAPISummary(rec, httptest.NewRequest(http.MethodGet, "/api/summary", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("summary: %d %s", rec.Code, rec.Body)
+	}
+
+	var summary struct {
+		Online               int32                  `json:"online"`
+		ConnectionsPerMinute int64                  `json:"connections_per_minute"`
+		Rejections           map[RejectReason]int64 `json:"rejections"`
+		Proxies              []struct {
+			Listen string `json:"listen"`
+			Online int32  `json:"online"`
+			Health string `json:"health"`
+		} `json:"proxies"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	if summary.Online < 3 {
+		t.Errorf("online = %d, want at least 3", summary.Online)
+	}
+	if summary.ConnectionsPerMinute != 7 {
+		t.Errorf("connections per minute = %d, want 7", summary.ConnectionsPerMinute)
+	}
+	if summary.Rejections[RejectFull] != 4 || summary.Rejections[RejectAuth] != 2 {
+		t.Errorf("rejections = %v, want full 4 and auth 2", summary.Rejections)
+	}
+
+	proxies := make(map[string]int)
+	for i, p := range summary.Proxies {
+		proxies[p.Listen] = i
+	}
+	if i, ok := proxies[a.Config.Listen]; !ok || summary.Proxies[i].Online != 2 || summary.Proxies[i].Health != BreakerClosed {
+		t.Errorf("proxy a = %+v", summary.Proxies)
+	}
+	if i, ok := proxies[b.Config.Listen]; !ok || summary.Proxies[i].Online != 1 || summary.Proxies[i].Health != BreakerOpen {
+		t.Errorf("proxy b = %+v", summary.Proxies)
+	}
+}
+
+func TestAPIBalancerStats(t *testing.T) {
+	pb := NewProxyBalancer("127.0.0.1:0", []config.ProxyConfig{
+		{Listen: "127.0.0.1:40131", Remote: "a.example.com:25565"},
+		{Listen: "127.0.0.1:40132", Remote: "b.example.com:25565"},
+	})
+	pb.proxyStats[0].successfulConnections.Add(5)
+	pb.proxyStats[0].failedConnections.Add(1)
+	pb.proxyStats[0].lastSelected.Store(time.Now().UnixNano())
+	tripBreaker(pb.proxyStats[1].breaker)
+	registerTestBalancer(t, pb)
+
+	rec := httptest.NewRecorder()
+	handleAPIBalancerStats(rec, httptest.NewRequest(http.MethodGet, "/api/balancer/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("balancer stats: %d %s", rec.Code, rec.Body)
+	}
+
+	var stats []BalancerProxyStat
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("stats = %+v, want 2 proxies", stats)
+	}
+	a, b := stats[0], stats[1]
+	if a.Listen != "127.0.0.1:40131" || a.SuccessfulConnections != 5 || a.FailedConnections != 1 || a.LastSelected == nil || !a.Healthy {
+		t.Errorf("proxy a = %+v", a)
+	}
+	if b.LastSelected != nil || b.Healthy || b.Breaker.State != BreakerOpen {
+		t.Errorf("proxy b = %+v", b)
+	}
+
+	StopBalancer(pb.listenAddr)
+	rec = httptest.NewRecorder()
+	handleAPIBalancerStats(rec, httptest.NewRequest(http.MethodGet, "/api/balancer/stats", nil))
+	if body := strings.TrimSpace(rec.Body.String()); body != "[]" {
+		t.Errorf("without a balancer: %s, want []", body)
+	}
+}
@@ -2,11 +2,11 @@ package core
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"mcproxy/config"
 	"net"
-	"strings"
 	"sync"
 	"time"
 )
@@ -25,6 +25,8 @@ var proxyMutex sync.RWMutex
 
 // Start starts all proxy servers defined in the configuration
 func Start(c config.Config) {
+	checkFileLimit(c)
+
 	// Start a separate proxy server for each configuration
 	var wg sync.WaitGroup
 
@@ -32,7 +34,7 @@ func Start(c config.Config) {
 		wg.Add(1)
 		go func(idx int, cfg config.ProxyConfig) {
 			defer wg.Done()
-			startProxy(idx, cfg)
+			startProxy(idx, cfg, c.ReusePort)
 		}(i, proxyConfig)
 	}
 
@@ -65,10 +67,13 @@ func StopAll() {
 	log.Printf("[INFO] All proxy servers stopped")
 }
 
-// Restart stops all running proxy servers and starts new ones with the given configuration
+// Restart stops all running proxy servers and starts new ones with the given
+// configuration, running balancers are restarted with its proxies
 func Restart(c config.Config) {
 	// Stop all running proxies
 	StopAll()
+	closeStatusPools()
+	restartBalancers(&c)
 
 	// Start new proxies with the updated configuration
 	log.Printf("[INFO] Restarting proxy servers with new configuration...")
@@ -77,8 +82,38 @@ func Restart(c config.Config) {
 	go Start(c)
 }
 
-func startProxy(idx int, cfg config.ProxyConfig) {
-	listener, err := net.Listen("tcp", cfg.Listen)
+func startProxy(idx int, cfg config.ProxyConfig, reusePort bool) {
+	listenAddr, err := resolveInterfaceAddr(cfg.Listen)
+	if err != nil {
+		log.Fatalf("[ERROR] Proxy %d: Failed to resolve listen address %s: %v", idx+1, cfg.Listen, err)
+		return
+	}
+	if listenAddr != cfg.Listen {
+		log.Printf("[INFO] Proxy %d: Resolved interface %s to %s", idx+1, cfg.Listen, listenAddr)
+	}
+
+	prefixes, err := config.ParseAllowlist(cfg.ListenAllowlist)
+	if err != nil {
+		log.Fatalf("[ERROR] Proxy %d: Invalid listen_allowlist: %v", idx+1, err)
+		return
+	}
+	allowlist := listenAllowlist(prefixes)
+
+	// A trusted load balancer in front of the proxy names the client in a
+	// PROXY protocol header
+	trusted, err := config.ParseAllowlist(cfg.ProxyProtocolTrustedProxies)
+	if err != nil {
+		log.Fatalf("[ERROR] Proxy %d: Invalid proxy_protocol_trusted_proxies: %v", idx+1, err)
+		return
+	}
+
+	tlsConfig, err := listenerTLSConfig(cfg)
+	if err != nil {
+		log.Fatalf("[ERROR] Proxy %d: Invalid tls_cert_file: %v", idx+1, err)
+		return
+	}
+
+	listener, err := listenTCP(listenAddr, reusePort)
 	if err != nil {
 		log.Fatalf("[ERROR] Proxy %d: Failed to listen on %s: %v", idx+1, cfg.Listen, err)
 		return
@@ -109,7 +144,9 @@ func startProxy(idx int, cfg config.ProxyConfig) {
 
 				// Unregister this proxy instance
 				proxyMutex.Lock()
-				delete(activeProxies, cfg.Listen)
+				if activeProxies[cfg.Listen] == proxy {
+					delete(activeProxies, cfg.Listen)
+				}
 				proxyMutex.Unlock()
 
 				return
@@ -135,24 +172,75 @@ func startProxy(idx int, cfg config.ProxyConfig) {
 					return
 				}
 
-				go handler(conn, cfg, idx)
+				// Connections from outside the allowlist are closed before
+				// anything is read from them
+				if !allowlist.allows(conn.RemoteAddr()) {
+					connDebugf(cfg, "Proxy %d: Closing %s, not in the listen allowlist", idx+1, conn.RemoteAddr())
+					conn.Close()
+					continue
+				}
+
+				// TLS listeners hand the handler the decrypted stream, which
+				// may start with a PROXY header
+				if tlsConfig != nil {
+					conn = tls.Server(conn, tlsConfig)
+				}
+
+				go handler(conn, cfg, idx, trusted)
 			}
 		}
 	}()
 }
 
-func handler(conn net.Conn, cfg config.ProxyConfig, idx int) {
-	clientAddr := conn.RemoteAddr().String()
-	defer conn.Close()
-	defer log.Printf("[INFO] Proxy %d: Connection ended: %s", idx+1, clientAddr)
-	log.Printf("[INFO] Proxy %d: New connection from: %s", idx+1, clientAddr)
+// handler serves one accepted connection, trusted holds the peers whose PROXY
+// protocol header is read
+func handler(conn net.Conn, cfg config.ProxyConfig, idx int, trusted listenAllowlist) {
+	ctx, cancel := loginContext(cfg, time.Now())
+	defer cancel()
 
+	// Clients may send the handshake and the packets after it in one write, so
+	// everything after the handshake is read through reader, which can already
+	// hold them, and never from conn directly
 	reader := bufio.NewReader(conn)
 	defer reader.Reset(nil)
 
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsHandshake(tlsConn); err != nil {
+			connDebugf(cfg, "Proxy %d: Closing %s, TLS handshake failed: %v", idx+1, conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+	}
+
+	conn, err := acceptProxyHeader(conn, reader, trusted)
+	if err != nil {
+		log.Printf("[WARN] Proxy %d: Closing %s: %v", idx+1, conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	clientAddr := normalizeClientAddr(conn.RemoteAddr().String())
+	defer recoverConnection(clientAddr, conn)
+	defer conn.Close()
+	flapping := clientFlaps.record(clientAddr, time.Now())
+
+	// Only a sample of the connections that never log in is logged, logins
+	// always are
+	logLifecycle := sampleAcceptLog(cfg)
+	defer func() {
+		if logLifecycle {
+			connInfof(cfg, "Proxy %d: Connection ended: %s", idx+1, clientAddr)
+		}
+	}()
+	if logLifecycle {
+		connInfof(cfg, "Proxy %d: New connection from: %s", idx+1, clientAddr)
+	}
+
 	pkt, err := ReadPacket(reader)
 	if err != nil {
-		log.Printf("[ERROR] Proxy %d: Failed to read packet from %s: %v", idx+1, clientAddr, err)
+		if logLifecycle {
+			log.Printf("[ERROR] Proxy %d: Failed to read packet from %s: %v", idx+1, clientAddr, err)
+		}
 		return
 	}
 
@@ -167,20 +255,70 @@ func handler(conn net.Conn, cfg config.ProxyConfig, idx int) {
 		return
 	}
 
-	log.Printf("[INFO] Proxy %d: Client %s connecting to %s:%d, protocol=%d, state=%d", 
-		idx+1, clientAddr, address, port, protocol, nextState)
+	// IPs sending handshakes faster than handshake_rate_limit are dropped
+	if !handshakeRateLimit.allow(clientIPFromAddr(clientAddr), time.Now()) {
+		connDebugf(cfg, "Proxy %d: Dropping %s, handshake rate limit exceeded", idx+1, clientAddr)
+		GetControlPanel().RecordRejection(cfg.Listen, RejectHandshakeRate)
+		return
+	}
+
+	if cfg.StrictHandshake {
+		if err := checkStrictHandshake(int(protocol), string(address), int(port), int(nextState)); err != nil {
+			connDebugf(cfg, "Proxy %d: Closing %s, malformed handshake: %v", idx+1, clientAddr, err)
+			GetControlPanel().RecordRejection(cfg.Listen, RejectMalformed)
+			return
+		}
+	}
+
+	checked, truncated, err := checkHandshakeAddress(string(address), cfg)
+	if err != nil {
+		log.Printf("[WARN] Proxy %d: Closing %s, oversized handshake hostname: %v", idx+1, clientAddr, err)
+		GetControlPanel().RecordRejection(cfg.Listen, RejectInvalidHostname)
+		if nextState == 2 || nextState == 3 {
+			sendDisconnect(conn, invalidHostnameMessage)
+		}
+		return
+	}
+	if truncated {
+		log.Printf("[WARN] Proxy %d: Truncated oversized handshake hostname from %s (%d bytes)", idx+1, clientAddr, len(address))
+		address = String(checked)
+	}
+
+	connInfof(cfg, "Proxy %d: Client %s connecting to %s, protocol=%d (%s), state=%d",
+		idx+1, clientAddr, formatAddr(string(address), int(port)), protocol, ProtocolName(int(protocol)), nextState)
 
 	switch nextState {
 	case 1: // status
-		log.Printf("[DEBUG] Proxy %d: Handling ping request from %s", idx+1, clientAddr)
+		connDebugf(cfg, "Proxy %d: Handling ping request from %s", idx+1, clientAddr)
 		err := handlePing(reader, conn, int(protocol), cfg)
 		if err != nil {
 			log.Printf("[ERROR] Proxy %d: Failed to handle ping from %s: %v", idx+1, clientAddr, err)
 		}
 
-	case 2: // login
+	case 2, 3: // login, transfer (1.20.5+) continues with a regular login
+		// Status-only proxies have no backend, every login is turned away
+		if cfg.Mode == ModeStatusOnly {
+			connInfof(cfg, "Proxy %d: Rejecting login from %s, status-only proxy", idx+1, clientAddr)
+			GetControlPanel().RecordRejection(cfg.Listen, RejectStatusOnly)
+			err := sendDisconnect(conn, statusOnlyMessage(cfg))
+			if err != nil {
+				log.Printf("[ERROR] Proxy %d: Failed to disconnect %s: %v", idx+1, clientAddr, err)
+			}
+			return
+		}
+
+		if nextState == 3 && cfg.RejectTransfers {
+			log.Printf("[INFO] Proxy %d: Rejecting transfer from %s", idx+1, clientAddr)
+			err := sendDisconnect(conn, transferRejectedMessage)
+			if err != nil {
+				log.Printf("[ERROR] Proxy %d: Failed to disconnect %s: %v", idx+1, clientAddr, err)
+			}
+			return
+		}
+
 		if protocol < VERSION_1_8_9 {
 			log.Printf("[WARN] Proxy %d: Client %s using unsupported protocol version: %d", idx+1, clientAddr, protocol)
+			GetControlPanel().RecordRejection(cfg.Listen, RejectUnsupportedVersion)
 			err := sendDisconnect(conn, "unsupported client version")
 			if err != nil {
 				log.Printf("[ERROR] Proxy %d: Failed to disconnect %s: %v", idx+1, clientAddr, err)
@@ -188,25 +326,55 @@ func handler(conn net.Conn, cfg config.ProxyConfig, idx int) {
 			return
 		}
 
-		// disconnect if server is full
-		if onlineCount.Load() >= int32(cfg.MaxPlayer) {
-			log.Printf("[WARN] Proxy %d: Server full, rejecting client %s", idx+1, clientAddr)
-			err := sendDisconnect(conn, "The server is full")
+		// Scanners and health checks that only send a handshake never reach the limits
+		username, err := waitForLoginStart(conn, reader, cfg)
+		if err != nil {
+			connDebugf(cfg, "Proxy %d: No login start from %s: %v", idx+1, clientAddr, err)
+			return
+		}
+		if !logLifecycle {
+			logLifecycle = true
+			connInfof(cfg, "Proxy %d: New connection from: %s", idx+1, clientAddr)
+		}
+
+		if LoginsPaused() {
+			log.Printf("[INFO] Proxy %d: Logins paused, rejecting client %s", idx+1, clientAddr)
+			GetControlPanel().RecordRejection(cfg.Listen, RejectPaused)
+			err := sendDisconnect(conn, pausedMessage())
 			if err != nil {
 				log.Printf("[ERROR] Proxy %d: Failed to disconnect %s: %v", idx+1, clientAddr, err)
 			}
 			return
 		}
 
+		// Logins beyond max_player go to the overflow backend while it has
+		// room, the rest are disconnected
+		if serverFull(cfg, username) {
+			if !acquireOverflowSlot(cfg) {
+				log.Printf("[WARN] Proxy %d: Server full, rejecting client %s", idx+1, clientAddr)
+				GetControlPanel().RecordRejection(cfg.Listen, fullRejection())
+				err := sendDisconnect(conn, "The server is full")
+				if err != nil {
+					log.Printf("[ERROR] Proxy %d: Failed to disconnect %s: %v", idx+1, clientAddr, err)
+				}
+				return
+			}
+			defer releaseOverflowSlot(cfg.Listen)
+			log.Printf("[INFO] Proxy %d: Server full, forwarding client %s to overflow server %s", idx+1, clientAddr, cfg.OverflowRemote)
+			cfg.Remote = cfg.OverflowRemote
+		}
+
 		// Create a connection ID and get the public IP
-		connID := fmt.Sprintf("%s-%d", clientAddr, time.Now().UnixNano())
-		publicIP := GetPublicIP(cfg.LocalAddr)
+		connID := newConnectionID(clientAddr)
+		publicIP := publicIPFunc(cfg.LocalAddr)
 
 		// Check if we've reached the connection limit for this IP
-		currentCount := GetConnectionCountForIP(publicIP)
+		limitKey := connectionLimitKey(publicIP, clientAddr)
+		currentCount := connectionCountForIP(limitKey)
 		if currentCount >= MaxConnectionsPerIP {
 			log.Printf("[WARN] Proxy %d: Connection limit reached for IP %s (%d connections), rejecting client %s", 
-				idx+1, publicIP, currentCount, clientAddr)
+				idx+1, limitKey, currentCount, clientAddr)
+			GetControlPanel().RecordRejection(cfg.Listen, RejectIPLimit)
 			err := sendDisconnect(conn, "Connection limit reached for your IP")
 			if err != nil {
 				log.Printf("[ERROR] Proxy %d: Failed to disconnect %s: %v", idx+1, clientAddr, err)
@@ -214,6 +382,27 @@ func handler(conn net.Conn, cfg config.ProxyConfig, idx int) {
 			return
 		}
 
+		// Check the connection limit for the client's own IP
+		clientIP := clientIPFromAddr(clientAddr)
+		clientCount, ok := acquireClientIPSlot(clientIP, cfg.MaxConnectionsPerClientIP)
+		if !ok {
+			log.Printf("[WARN] Proxy %d: Connection limit reached for client IP %s (%d connections), rejecting client %s",
+				idx+1, clientIP, clientCount, clientAddr)
+			GetControlPanel().RecordRejection(cfg.Listen, RejectIPLimit)
+			err := sendDisconnect(conn, "Too many connections from your IP")
+			if err != nil {
+				log.Printf("[ERROR] Proxy %d: Failed to disconnect %s: %v", idx+1, clientAddr, err)
+			}
+			return
+		}
+		defer releaseClientIPSlot(clientIP)
+
+		// Check if the client is using a Forge style mod loader
+		_, addressSuffix, modLoader := splitHandshakeAddress(string(address))
+		if modLoader != "" {
+			connInfof(cfg, "Proxy %d: Forge client detected (%s): %s", idx+1, modLoader, clientAddr)
+		}
+
 		// Create and register the connection
 		connection := &Connection{
 			ID:          connID,
@@ -224,18 +413,89 @@ func handler(conn net.Conn, cfg config.ProxyConfig, idx int) {
 			ClientConn:  conn,
 			ProxyIndex:  idx,
 			PublicIP:    publicIP,
+			ModLoader:   modLoader,
+			Group:       cfg.Group,
+			Protocol:    int(protocol),
+			Flapping:    flapping,
 		}
-		RegisterConnection(connection)
-		defer UnregisterConnection(connID)
-
-		isFML := strings.HasSuffix(string(address), "\x00FML\x00")
-		if isFML {
-			log.Printf("[INFO] Proxy %d: FML client detected: %s", idx+1, clientAddr)
-		}
+		registerConnection(connection)
+		// the registry may have renamed the connection
+		defer func() { unregisterConnection(connection.ID) }()
 
-		err := handleForward(reader, conn, isFML, int(protocol), cfg)
+		err = handleForward(ctx, reader, conn, addressSuffix, int(protocol), cfg, nil)
 		if err != nil {
 			log.Printf("[ERROR] Proxy %d: Failed to handle forward for %s: %v", idx+1, clientAddr, err)
 		}
+
+	default:
+		connDebugf(cfg, "Proxy %d: Closing %s, unexpected next state %d", idx+1, clientAddr, nextState)
+	}
+}
+
+// ModeStatusOnly is the ProxyConfig.Mode of proxies that answer pings but
+// reject every login without a backend
+const ModeStatusOnly = "status_only"
+
+// defaultStatusOnlyMessage is sent to logins to a status-only proxy without a
+// status_only_message
+const defaultStatusOnlyMessage = "This server is not open yet"
+
+// statusOnlyMessage returns the disconnect message of a status-only proxy
+func statusOnlyMessage(cfg config.ProxyConfig) string {
+	if cfg.StatusOnlyMessage != "" {
+		return cfg.StatusOnlyMessage
 	}
+	return defaultStatusOnlyMessage
+}
+
+// transferRejectedMessage is sent to clients transferred to a proxy that
+// does not accept transfers
+const transferRejectedMessage = "This server does not accept transfers"
+
+// invalidHostnameMessage is sent to clients whose handshake hostname is longer
+// than the proxy accepts
+const invalidHostnameMessage = "Invalid server address"
+
+// DrainProxy stops a single proxy from accepting new connections and, after the
+// grace period, disconnects its remaining players with the given message in
+// the background. Players are told the message in the chat first when the
+// proxy has chat_injection. Other proxies and the balancer are left untouched.
+// Returns the number of connections being drained.
+func DrainProxy(listen string, message string, grace time.Duration) (int, error) {
+	proxyMutex.Lock()
+	proxy, exists := activeProxies[listen]
+	if exists {
+		delete(activeProxies, listen)
+		close(proxy.stopChan)
+	}
+	proxyMutex.Unlock()
+
+	if !exists {
+		return 0, fmt.Errorf("no active proxy listening on %s", listen)
+	}
+
+	onProxy := func(conn *Connection) bool { return conn.ProxyAddr == listen }
+	draining := 0
+	for _, conn := range GetAllConnections() {
+		if onProxy(conn) {
+			draining++
+		}
+	}
+	if grace > 0 {
+		notified, _ := injectSystemChat(onProxy, fmt.Sprintf("%s (in %v)", message, grace))
+		log.Printf("[INFO] Draining proxy on %s, told %d of %d players, closing sessions in %v", listen, notified, draining, grace)
+	}
+
+	// sessions that finished their login during the grace are closed as well
+	time.AfterFunc(grace, func() {
+		var ids []string
+		for _, conn := range GetAllConnections() {
+			if onProxy(conn) {
+				ids = append(ids, conn.ID)
+			}
+		}
+		drained := DisconnectClients(ids, message)
+		log.Printf("[INFO] Drained %d connections from proxy on %s", drained, listen)
+	})
+	return draining, nil
 }
@@ -0,0 +1,1009 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mcproxy/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// registerPipeConnection registers a fake connection whose client side is
+// drained in the background so disconnect packets never block
+func registerPipeConnection(t *testing.T, id string, proxyAddr string) {
+	t.Helper()
+	client := registerTestConnection(t, id, proxyAddr, nil)
+	go io.Copy(io.Discard, client)
+}
+
+// registerTestConnection registers a fake connection on a pipe and returns the
+// client's end. setup, if not nil, sets the fields a test needs before the
+// connection is registered, its ClientConn is the server's end of the pipe.
+func registerTestConnection(t *testing.T, id string, proxyAddr string, setup func(conn *Connection)) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+		UnregisterConnection(id)
+	})
+
+	conn := &Connection{
+		ID:          id,
+		Username:    id,
+		ClientAddr:  id,
+		ProxyAddr:   proxyAddr,
+		ConnectedAt: time.Now(),
+		ClientConn:  server,
+	}
+	if setup != nil {
+		setup(conn)
+	}
+	RegisterConnection(conn)
+	return client
+}
+
+func TestDrainProxy(t *testing.T) {
+	proxyMutex.Lock()
+	activeProxies["127.0.0.1:40001"] = &proxyInstance{stopChan: make(chan struct{})}
+	activeProxies["127.0.0.1:40002"] = &proxyInstance{stopChan: make(chan struct{})}
+	proxyMutex.Unlock()
+	t.Cleanup(func() {
+		proxyMutex.Lock()
+		delete(activeProxies, "127.0.0.1:40002")
+		proxyMutex.Unlock()
+	})
+
+	registerPipeConnection(t, "drain-a1", "127.0.0.1:40001")
+	registerPipeConnection(t, "drain-a2", "127.0.0.1:40001")
+	registerPipeConnection(t, "drain-b1", "127.0.0.1:40002")
+
+	draining, err := DrainProxy("127.0.0.1:40001", "bye", 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draining != 2 {
+		t.Errorf("draining %d connections, want 2", draining)
+	}
+
+	// the players are disconnected in the background after the grace
+	if GetConnection("drain-a1") == nil || GetConnection("drain-a2") == nil {
+		t.Error("connections drained before the grace passed")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for GetConnection("drain-a1") != nil || GetConnection("drain-a2") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("drained proxy still has registered connections")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if GetConnection("drain-b1") == nil {
+		t.Error("connection on other proxy was drained")
+	}
+
+	proxyMutex.RLock()
+	_, stillActive := activeProxies["127.0.0.1:40001"]
+	_, otherActive := activeProxies["127.0.0.1:40002"]
+	proxyMutex.RUnlock()
+	if stillActive || !otherActive {
+		t.Errorf("active proxies after drain: drained=%v other=%v", stillActive, otherActive)
+	}
+
+	if _, err := DrainProxy("127.0.0.1:40001", "bye", 0); err == nil {
+		t.Error("draining an inactive proxy should fail")
+	}
+}
+
+// registerTCPConnection registers a connection over loopback TCP and returns
+// its client side, which the test has to read from
+func registerTCPConnection(t *testing.T, id string, proxyAddr string) net.Conn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+		UnregisterConnection(id)
+	})
+
+	RegisterConnection(&Connection{
+		ID:          id,
+		Username:    id,
+		ClientAddr:  id,
+		ProxyAddr:   proxyAddr,
+		ConnectedAt: time.Now(),
+		ClientConn:  server,
+	})
+	return client
+}
+
+func TestDisconnectClientsInParallel(t *testing.T) {
+	const grace = 300 * time.Millisecond
+	SetDisconnectTimeouts(time.Second, grace)
+	t.Cleanup(func() { SetDisconnectTimeouts(0, 0) })
+
+	proxyMutex.Lock()
+	activeProxies["127.0.0.1:40003"] = &proxyInstance{stopChan: make(chan struct{})}
+	proxyMutex.Unlock()
+
+	// the clients never hang up, so every disconnect waits the whole grace period
+	const n = 5
+	clients := make([]net.Conn, n)
+	for i := range clients {
+		clients[i] = registerTCPConnection(t, fmt.Sprintf("parallel-%d", i), "127.0.0.1:40003")
+		// unread data from the client must not keep the message from arriving
+		clients[i].Write([]byte{0x01, 0x00})
+	}
+
+	start := time.Now()
+	draining, err := DrainProxy("127.0.0.1:40003", "bye", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draining != n {
+		t.Errorf("draining %d connections, want %d", draining, n)
+	}
+
+	// one at a time the last message would only arrive after n-1 graces
+	for i, client := range clients {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		if reason := readDisconnect(t, client); reason != `{"text":"bye"}` {
+			t.Errorf("client %d got disconnect reason %q", i, reason)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*grace {
+		t.Errorf("draining %d clients took %v, want about %v", n, elapsed, grace)
+	}
+}
+
+// writeHandshake writes a handshake packet as a client would
+func writeHandshake(t *testing.T, w io.Writer, protocol int, address string, port int, nextState int) {
+	t.Helper()
+	pkt, err := Pack(VarInt(protocol), String(address), UShort(port), VarInt(nextState))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePacket(0x00, pkt, w); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readDisconnect reads a login disconnect packet and returns its JSON reason
+func readDisconnect(t *testing.T, r io.Reader) string {
+	t.Helper()
+	pkt, err := ReadPacket(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.ID != 0x1A {
+		t.Fatalf("expected disconnect packet, got 0x%02X", pkt.ID)
+	}
+	var reason String
+	if _, err := pkt.Scan(&reason); err != nil {
+		t.Fatal(err)
+	}
+	return string(reason)
+}
+
+func TestClientIPSlots(t *testing.T) {
+	ip := "198.51.100.10"
+	for i := 1; i <= 2; i++ {
+		if count, ok := acquireClientIPSlot(ip, 2); !ok || count != i {
+			t.Fatalf("acquire %d: count=%d ok=%v", i, count, ok)
+		}
+	}
+	if _, ok := acquireClientIPSlot(ip, 2); ok {
+		t.Error("acquired a slot above the limit")
+	}
+	if _, ok := acquireClientIPSlot("198.51.100.11", 2); !ok {
+		t.Error("limit of one client IP affected another")
+	}
+
+	releaseClientIPSlot(ip)
+	if _, ok := acquireClientIPSlot(ip, 2); !ok {
+		t.Error("slot was not released")
+	}
+
+	releaseClientIPSlot(ip)
+	releaseClientIPSlot(ip)
+	releaseClientIPSlot("198.51.100.11")
+	if n := GetConnectionCountForClientIP(ip); n != 0 {
+		t.Errorf("count after release = %d", n)
+	}
+}
+
+func TestHandlerClientIPLimit(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	// net.Pipe reports "pipe" as the remote address
+	acquireClientIPSlot("pipe", 0)
+	defer releaseClientIPSlot("pipe")
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40010", MaxPlayer: 10, MaxConnectionsPerClientIP: 1}
+	go handler(server, cfg, 0, nil)
+
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	writeLoginStart(t, client, "Steve")
+	if reason := readDisconnect(t, client); !strings.Contains(reason, "Too many connections") {
+		t.Errorf("unexpected disconnect reason: %s", reason)
+	}
+}
+
+func TestHandlerIPLimitLookupDisabled(t *testing.T) {
+	t.Cleanup(func() { SetPublicIPLookup(false, "") })
+
+	tests := []struct {
+		name       string
+		label      string
+		publicIP   string
+		clientAddr func(i int) string
+	}{
+		// net.Pipe reports "pipe" as the remote address
+		{"client IP", "", "N/A", func(int) string { return "pipe" }},
+		{"label", "edge-1", "edge-1", func(i int) string { return fmt.Sprintf("198.51.100.%d:25565", i) }},
+	}
+	for _, tt := range tests {
+		SetPublicIPLookup(true, tt.label)
+		for i := range MaxConnectionsPerIP {
+			id := fmt.Sprintf("ip-limit-%s-%d", tt.name, i)
+			RegisterConnection(&Connection{ID: id, ClientAddr: tt.clientAddr(i), PublicIP: tt.publicIP, ConnectedAt: time.Now()})
+			defer UnregisterConnection(id)
+		}
+
+		client, server := net.Pipe()
+		cfg := config.ProxyConfig{Listen: "127.0.0.1:40011", MaxPlayer: 10, Auth: "none"}
+		go handler(server, cfg, 0, nil)
+
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+		writeLoginStart(t, client, "Steve")
+		if reason := readDisconnect(t, client); !strings.Contains(reason, "Connection limit reached") {
+			t.Errorf("%s: unexpected disconnect reason: %s", tt.name, reason)
+		}
+		client.Close()
+	}
+}
+
+// writeLoginStart writes a login start packet with the given username
+func writeLoginStart(t *testing.T, w io.Writer, username string) {
+	t.Helper()
+	pkt, err := Pack(String(username))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePacket(0x00, pkt, w); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// registerProxyStats adds control panel stats for a test proxy
+func registerProxyStats(t *testing.T, cfg config.ProxyConfig) *ProxyStats {
+	t.Helper()
+	cp := GetControlPanel()
+	stats := &ProxyStats{Config: cfg}
+	cp.mutex.Lock()
+	cp.Stats[cfg.Listen] = stats
+	cp.mutex.Unlock()
+	t.Cleanup(func() {
+		cp.mutex.Lock()
+		delete(cp.Stats, cfg.Listen)
+		cp.mutex.Unlock()
+	})
+	return stats
+}
+
+func TestHandlerRecordsRejections(t *testing.T) {
+	base := config.ProxyConfig{Listen: "127.0.0.1:40020", MaxPlayer: 10, Auth: "none"}
+	stats := registerProxyStats(t, base)
+
+	tests := []struct {
+		name     string
+		protocol int
+		mutate   func(cfg *config.ProxyConfig)
+		username string
+		counter  *atomic.Int64
+	}{
+		{"unsupported version", 5, func(cfg *config.ProxyConfig) {}, "", &stats.Rejections.UnsupportedVersion},
+		{"full", VERSION_1_18_2, func(cfg *config.ProxyConfig) { cfg.MaxPlayer = 0 }, "Steve", &stats.Rejections.Full},
+		{"auth", VERSION_1_18_2, func(cfg *config.ProxyConfig) { cfg.Auth = "whitelist" }, "Steve", &stats.Rejections.Auth},
+		{"status only", VERSION_1_18_2, func(cfg *config.ProxyConfig) { cfg.Mode = ModeStatusOnly }, "", &stats.Rejections.StatusOnly},
+		{"hostname", VERSION_1_18_2, func(cfg *config.ProxyConfig) { cfg.MaxHostnameLength = 4 }, "", &stats.Rejections.InvalidHostname},
+	}
+
+	for _, tt := range tests {
+		cfg := base
+		tt.mutate(&cfg)
+
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			handler(server, cfg, 0, nil)
+			close(done)
+		}()
+
+		writeHandshake(t, client, tt.protocol, "localhost", 25565, 2)
+		if tt.username != "" {
+			writeLoginStart(t, client, tt.username)
+		}
+		readDisconnect(t, client)
+		client.Close()
+		<-done
+
+		if n := tt.counter.Load(); n != 1 {
+			t.Errorf("%s: counter = %d, want 1", tt.name, n)
+		}
+	}
+
+	// client IP limit
+	acquireClientIPSlot("pipe", 0)
+	defer releaseClientIPSlot("pipe")
+	cfg := base
+	cfg.MaxConnectionsPerClientIP = 1
+	client, server := net.Pipe()
+	go handler(server, cfg, 0, nil)
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	writeLoginStart(t, client, "Steve")
+	readDisconnect(t, client)
+	client.Close()
+	if n := stats.Rejections.IPLimit.Load(); n != 1 {
+		t.Errorf("ip limit: counter = %d, want 1", n)
+	}
+
+	// max_total_connections is told apart from the proxy's max_player
+	onlineCount.Add(1)
+	defer onlineCount.Add(-1)
+	SetMaxTotalConnections(int(onlineCount.Load()))
+	defer SetMaxTotalConnections(0)
+	client, server = net.Pipe()
+	go handler(server, base, 0, nil)
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	writeLoginStart(t, client, "Steve")
+	readDisconnect(t, client)
+	client.Close()
+	if n := stats.Rejections.TotalConnections.Load(); n != 1 {
+		t.Errorf("total connections: counter = %d, want 1", n)
+	}
+	if n := stats.Rejections.Full.Load(); n != 1 {
+		t.Errorf("total connections counted as full: %d", n)
+	}
+}
+
+func TestHandlerMaxPlayerPerProxy(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dialed := make(chan struct{}, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		dialed <- struct{}{}
+		return nil, io.EOF
+	}
+
+	full := config.ProxyConfig{Listen: "127.0.0.1:40046", Remote: "backend.example.com:25565", MaxPlayer: 2, Auth: "none"}
+	idle := full
+	idle.Listen = "127.0.0.1:40047"
+	registerProxyStats(t, full).ConnectionCount.Store(2)
+	registerProxyStats(t, idle)
+
+	// login reports whether the login was passed on to the backend
+	login := func(cfg config.ProxyConfig) bool {
+		client, server := net.Pipe()
+		defer client.Close()
+		go handler(server, cfg, 0, nil)
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+		writeLoginStart(t, client, "Steve")
+
+		pkt := make(chan Packet, 1)
+		go func() {
+			if p, err := ReadPacket(client); err == nil {
+				pkt <- p
+			}
+		}()
+		select {
+		case <-dialed:
+			return true
+		case p := <-pkt:
+			if p.ID != 0x1A {
+				t.Errorf("expected disconnect packet, got 0x%02X", p.ID)
+			}
+			return false
+		case <-time.After(5 * time.Second):
+			t.Fatal("login neither forwarded nor rejected")
+			return false
+		}
+	}
+
+	if login(full) {
+		t.Error("full proxy accepted a login")
+	}
+	if !login(idle) {
+		t.Error("idle proxy rejected a login while another proxy is full")
+	}
+
+	// max_total_connections caps all proxies together
+	onlineCount.Add(1)
+	defer onlineCount.Add(-1)
+	SetMaxTotalConnections(int(onlineCount.Load()))
+	defer SetMaxTotalConnections(0)
+	if login(idle) {
+		t.Error("login accepted over max_total_connections")
+	}
+}
+
+func TestHandlerReservedSlots(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dialed := make(chan struct{}, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		dialed <- struct{}{}
+		return nil, io.EOF
+	}
+
+	// 8 of 10 slots taken, the last 2 are reserved
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40048", Remote: "backend.example.com:25565", MaxPlayer: 10, ReservedSlots: 2, Auth: "none", Whitelist: []string{"VIP"}}
+	stats := registerProxyStats(t, cfg)
+	stats.ConnectionCount.Store(8)
+
+	// login reports whether the login of username was passed on to the backend
+	login := func(username string) bool {
+		client, server := net.Pipe()
+		defer client.Close()
+		go handler(server, cfg, 0, nil)
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+		writeLoginStart(t, client, username)
+
+		pkt := make(chan Packet, 1)
+		go func() {
+			if p, err := ReadPacket(client); err == nil {
+				pkt <- p
+			}
+		}()
+		select {
+		case <-dialed:
+			return true
+		case p := <-pkt:
+			if p.ID != 0x1A {
+				t.Errorf("expected disconnect packet, got 0x%02X", p.ID)
+			}
+			return false
+		case <-time.After(5 * time.Second):
+			t.Fatal("login neither forwarded nor rejected")
+			return false
+		}
+	}
+
+	if login("Steve") {
+		t.Error("ordinary player took a reserved slot")
+	}
+	if !login("VIP") {
+		t.Error("whitelisted player rejected while reserved slots are free")
+	}
+
+	// max_player still caps whitelisted players
+	stats.ConnectionCount.Store(10)
+	if login("VIP") {
+		t.Error("whitelisted player accepted over max_player")
+	}
+}
+
+func TestServerFullOverflow(t *testing.T) {
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40049", Remote: "backend.example.com:25565", MaxPlayer: 2, FullOverflow: 1, Auth: "none"}
+	stats := registerProxyStats(t, cfg)
+
+	// login registers a logged in player on the proxy
+	login := func(id string) {
+		RegisterConnection(&Connection{ID: id, Username: id, ClientAddr: id, ProxyAddr: cfg.Listen, ConnectedAt: time.Now()})
+		t.Cleanup(func() { UnregisterConnection(id) })
+	}
+
+	login("a")
+	login("b")
+	stats.ConnectionCount.Store(2)
+	if serverFull(cfg, "Steve") {
+		t.Error("full at max_player with an overflow of 1")
+	}
+
+	login("c")
+	stats.ConnectionCount.Store(3)
+	if !serverFull(cfg, "Steve") {
+		t.Error("not full at max_player + full_overflow")
+	}
+
+	// a counter that drifted above the logged in players does not reject
+	UnregisterConnection("c")
+	if serverFull(cfg, "Steve") {
+		t.Error("full by a drifted counter")
+	}
+
+	// without an overflow the counter alone decides, as before
+	cfg.FullOverflow = 0
+	stats.ConnectionCount.Store(2)
+	if !serverFull(cfg, "Steve") {
+		t.Error("not full at max_player without an overflow")
+	}
+}
+
+func TestHandlerRoutesFullToOverflow(t *testing.T) {
+	type dial struct {
+		remote     string
+		registered string
+		overflowed int
+	}
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dials := make(chan dial, 1)
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40152", Remote: "backend.example.com:25565", MaxPlayer: 1, Auth: "none",
+		OverflowRemote: "lobby.example.com:25565", OverflowMaxPlayer: 1}
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		d := dial{remote: remote, overflowed: overflowConnections(cfg.Listen)}
+		activeConnections.RLock()
+		for _, conn := range activeConnections.connections {
+			if conn.Username == "Steve" {
+				d.registered = conn.RemoteAddr
+			}
+		}
+		activeConnections.RUnlock()
+		dials <- d
+		return nil, io.EOF
+	}
+
+	stats := registerProxyStats(t, cfg)
+	stats.ConnectionCount.Store(1)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		handler(server, cfg, 0, nil)
+		close(done)
+	}()
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	writeLoginStart(t, client, "Steve")
+
+	select {
+	case d := <-dials:
+		if d.remote != cfg.OverflowRemote || d.registered != cfg.OverflowRemote {
+			t.Errorf("dialed %s, registered %s, want %s", d.remote, d.registered, cfg.OverflowRemote)
+		}
+		if d.overflowed != 1 {
+			t.Errorf("overflow connections = %d, want 1", d.overflowed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the login did not reach a backend")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return")
+	}
+	if n := overflowConnections(cfg.Listen); n != 0 {
+		t.Errorf("overflow connections after the session = %d, want 0", n)
+	}
+
+	// a full overflow backend rejects like a full proxy, its players count
+	// towards the proxy's connections
+	if !acquireOverflowSlot(cfg) {
+		t.Fatal("no overflow slot")
+	}
+	defer releaseOverflowSlot(cfg.Listen)
+	stats.ConnectionCount.Store(2)
+	client, server = net.Pipe()
+	defer client.Close()
+	go handler(server, cfg, 0, nil)
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	writeLoginStart(t, client, "Alex")
+	if reason := readDisconnect(t, client); !strings.Contains(reason, "The server is full") {
+		t.Errorf("reason = %s, want the server is full", reason)
+	}
+}
+
+func TestHandlerPausedLogins(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dials := make(chan string, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		dials <- remote
+		return nil, io.EOF
+	}
+	t.Cleanup(ResumeLogins)
+	SetPausedLoginMessage("Back in five minutes")
+	t.Cleanup(func() { SetPausedLoginMessage("") })
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40155", Remote: "backend.example.com:25565", MaxPlayer: 10, Auth: "none"}
+	stats := registerProxyStats(t, cfg)
+
+	setPaused := func(path string, handle http.HandlerFunc, want bool) {
+		rec := httptest.NewRecorder()
+		handle(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusOK || LoginsPaused() != want {
+			t.Fatalf("%s: status %d, paused = %v", path, rec.Code, LoginsPaused())
+		}
+	}
+	login := func() net.Conn {
+		client, server := net.Pipe()
+		go handler(server, cfg, 0, nil)
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+		writeLoginStart(t, client, "Steve")
+		return client
+	}
+
+	setPaused("/api/pause-logins", handleAPIPauseLogins, true)
+	client := login()
+	if reason := readDisconnect(t, client); !strings.Contains(reason, "Back in five minutes") {
+		t.Errorf("paused: reason = %s", reason)
+	}
+	client.Close()
+	if n := stats.Rejections.Paused.Load(); n != 1 {
+		t.Errorf("paused rejections = %d, want 1", n)
+	}
+	select {
+	case remote := <-dials:
+		t.Fatalf("paused login dialed %s", remote)
+	default:
+	}
+
+	setPaused("/api/resume-logins", handleAPIResumeLogins, false)
+	client = login()
+	defer client.Close()
+	select {
+	case <-dials:
+	case <-time.After(5 * time.Second):
+		t.Fatal("login after resume did not reach the backend")
+	}
+}
+
+func TestHandlerNextStates(t *testing.T) {
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40040", MaxPlayer: 0, Auth: "none"}
+
+	run := func(cfg config.ProxyConfig, nextState int) net.Conn {
+		client, server := net.Pipe()
+		go handler(server, cfg, 0, nil)
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, nextState)
+		return client
+	}
+
+	// Transfers continue like a login, here rejected because the server is full
+	client := run(cfg, 3)
+	writeLoginStart(t, client, "Steve")
+	if reason := readDisconnect(t, client); !strings.Contains(reason, "The server is full") {
+		t.Errorf("transfer: reason = %s", reason)
+	}
+	client.Close()
+
+	cfg.RejectTransfers = true
+	client = run(cfg, 3)
+	if reason := readDisconnect(t, client); !strings.Contains(reason, transferRejectedMessage) {
+		t.Errorf("rejected transfer: reason = %s", reason)
+	}
+	client.Close()
+
+	// Oversized hostnames are refused before the login
+	client, server := net.Pipe()
+	go handler(server, cfg, 0, nil)
+	writeHandshake(t, client, VERSION_1_18_2, strings.Repeat("a", 1000)+"\x00FML2\x00", 25565, 2)
+	if reason := readDisconnect(t, client); !strings.Contains(reason, invalidHostnameMessage) {
+		t.Errorf("oversized hostname: reason = %s", reason)
+	}
+	client.Close()
+
+	// Unknown states close the connection without a response
+	client = run(cfg, 7)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ReadPacket(client); err != io.EOF {
+		t.Errorf("invalid state: got %v, want EOF", err)
+	}
+	client.Close()
+}
+
+func TestHandlerStatusOnly(t *testing.T) {
+	origDial := dialRemote
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		t.Error("status-only proxy dialed a backend")
+		return nil, io.EOF
+	}
+	defer func() { dialRemote = origDial }()
+
+	cfg := config.ProxyConfig{
+		Listen:            "127.0.0.1:40041",
+		Description:       "Coming soon",
+		MaxPlayer:         20,
+		PingMode:          "fake",
+		Auth:              "none",
+		Mode:              ModeStatusOnly,
+		StatusOnlyMessage: "Opening on Friday",
+	}
+	registerProxyStats(t, cfg)
+
+	run := func(nextState int) net.Conn {
+		client, server := net.Pipe()
+		go handler(server, cfg, 0, nil)
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, nextState)
+		return client
+	}
+
+	client := run(1)
+	if status := readStatus(t, client); status.Description != "Coming soon" || status.Players.Max != 20 {
+		t.Errorf("status = %+v", status)
+	}
+	client.Close()
+
+	client = run(2)
+	if reason := readDisconnect(t, client); !strings.Contains(reason, "Opening on Friday") {
+		t.Errorf("login: reason = %s", reason)
+	}
+	client.Close()
+
+	cfg.StatusOnlyMessage = ""
+	client = run(2)
+	if reason := readDisconnect(t, client); !strings.Contains(reason, defaultStatusOnlyMessage) {
+		t.Errorf("login without a message: reason = %s", reason)
+	}
+	client.Close()
+}
+
+func TestHandlerAcceptLogSample(t *testing.T) {
+	var buf syncBuffer
+	origOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(origOutput)
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40042", MaxPlayer: 0, Auth: "none", AcceptLogSample: 4}
+	registerProxyStats(t, cfg)
+
+	run := func(send func(client net.Conn)) {
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			handler(server, cfg, 0, nil)
+			close(done)
+		}()
+		send(client)
+		client.Close()
+		<-done
+	}
+
+	// scanners that close without a handshake
+	for i := 0; i < 8; i++ {
+		run(func(net.Conn) {})
+	}
+	out := buf.String()
+	if n := strings.Count(out, "New connection from"); n != 2 {
+		t.Errorf("logged %d of 8 accepts, want 2", n)
+	}
+	if n := strings.Count(out, "Connection ended"); n != 2 {
+		t.Errorf("logged %d of 8 closes, want 2", n)
+	}
+
+	// logins are always logged, here rejected because the server is full
+	for i := 0; i < 3; i++ {
+		run(func(client net.Conn) {
+			writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+			writeLoginStart(t, client, "Steve")
+			readDisconnect(t, client)
+		})
+	}
+	out = buf.String()
+	if n := strings.Count(out, "New connection from"); n != 5 {
+		t.Errorf("logged %d accepts after 3 logins, want 5", n)
+	}
+	if n := strings.Count(out, "Connection ended"); n != 5 {
+		t.Errorf("logged %d closes after 3 logins, want 5", n)
+	}
+}
+
+func TestHandlerHandshakeOnlyNotCounted(t *testing.T) {
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40070", MaxPlayer: 10, Auth: "none", PingMode: "fake", LoginGraceMs: 50}
+	stats := registerProxyStats(t, cfg)
+
+	origLookup := lookupPublicIP
+	lookupPublicIP = func(ip string) (string, error) { return "", nil }
+	defer func() { lookupPublicIP = origLookup }()
+
+	counters := func() (int32, int32, int64, int) {
+		return onlineCount.Load(), stats.ConnectionCount.Load(), statsHistory.newConnections.Load(), GetConnectionCountForClientIP("pipe")
+	}
+	online, proxyCount, newConns, clientIP := counters()
+
+	// Status ping
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handler(server, cfg, 0, nil)
+		close(done)
+	}()
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 1)
+	readStatus(t, client)
+	client.Close()
+	<-done
+
+	// Login handshake that never sends the login start, closed or timed out
+	for _, hangUp := range []bool{true, false} {
+		client, server = net.Pipe()
+		done = make(chan struct{})
+		go func() {
+			handler(server, cfg, 0, nil)
+			close(done)
+		}()
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+		if hangUp {
+			client.Close()
+		}
+		<-done
+		client.Close()
+	}
+
+	if o, p, n, c := counters(); o != online || p != proxyCount || n != newConns || c != clientIP {
+		t.Errorf("counters changed: online %d->%d, proxy %d->%d, new %d->%d, client IP %d->%d",
+			online, o, proxyCount, p, newConns, n, clientIP, c)
+	}
+}
+
+// panicConn is a backend connection whose reads panic
+type panicConn struct {
+	net.Conn
+}
+
+func (c panicConn) Read(p []byte) (int, error) {
+	panic("injected panic")
+}
+
+func TestHandlerRecoversFromForwardPanic(t *testing.T) {
+	stubConnectionCounts(t, map[string]int{})
+
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		proxySide, backendSide := net.Pipe()
+		go io.Copy(io.Discard, backendSide)
+		t.Cleanup(func() { backendSide.Close() })
+		return panicConn{proxySide}, nil
+	}
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40110", Remote: "backend.example.com:25565", MaxPlayer: 10, Auth: "none"}
+	stats := registerProxyStats(t, cfg)
+
+	counters := func() (int32, int32, int, int) {
+		return onlineCount.Load(), stats.ConnectionCount.Load(), GetConnectionCountForClientIP("pipe"), len(GetAllConnections())
+	}
+	online, proxyCount, clientIP, conns := counters()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		handler(server, cfg, 0, nil)
+		close(done)
+	}()
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	writeLoginStart(t, client, "Steve")
+
+	// the panic closes the client connection and the handler returns
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("client connection not closed after the panic: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return after the panic")
+	}
+
+	if o, p, c, n := counters(); o != online || p != proxyCount || c != clientIP || n != conns {
+		t.Errorf("counters changed: online %d->%d, proxy %d->%d, client IP %d->%d, connections %d->%d",
+			online, o, proxyCount, p, clientIP, c, conns, n)
+	}
+}
+
+func TestHandlerConnectionGroup(t *testing.T) {
+	stubBackend(t, 0)
+	stubConnectionCounts(t, map[string]int{})
+
+	registered := make(chan *Connection, 1)
+	origRegister := registerConnection
+	registerConnection = func(conn *Connection) {
+		origRegister(conn)
+		registered <- conn
+	}
+	defer func() { registerConnection = origRegister }()
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40100", Remote: "backend.example.com:25565", MaxPlayer: 10, Auth: "none", Group: "survival"}
+	registerProxyStats(t, cfg)
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handler(server, cfg, 0, nil)
+		close(done)
+	}()
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	writeLoginStart(t, client, "Steve")
+
+	conn := <-registered
+	if conn.Group != "survival" {
+		t.Errorf("connection group = %q, want survival", conn.Group)
+	}
+
+	// The API reports the group and can filter by it
+	for query, want := range map[string]bool{"": true, "?group=survival": true, "?group=creative": false} {
+		rec := httptest.NewRecorder()
+		handleAPIConnections(rec, httptest.NewRequest(http.MethodGet, "/api/connections"+query, nil))
+		if got := strings.Contains(rec.Body.String(), `"group":"survival"`); got != want {
+			t.Errorf("%q: group listed = %v, want %v: %s", query, got, want, rec.Body)
+		}
+	}
+
+	client.Close()
+	<-done
+}
+
+func TestNewConnectionIDUnique(t *testing.T) {
+	const workers, perWorker = 16, 1000
+
+	ids := make(chan string, workers*perWorker)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				// every connection comes from the same address
+				ids <- newConnectionID("203.0.113.7:50000")
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool, workers*perWorker)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate connection ID %s", id)
+		}
+		seen[id] = true
+		if !strings.HasPrefix(id, "203.0.113.7:50000-") {
+			t.Fatalf("ID %s does not start with the client address", id)
+		}
+	}
+}
+
+func TestRegisterConnectionIDCollision(t *testing.T) {
+	var buf bytes.Buffer
+	origOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(origOutput)
+
+	first := &Connection{ID: "collision", ClientAddr: "203.0.113.7:50000", ConnectedAt: time.Now()}
+	second := &Connection{ID: "collision", ClientAddr: "203.0.113.8:50000", ConnectedAt: time.Now()}
+	RegisterConnection(first)
+	t.Cleanup(func() { UnregisterConnection(first.ID) })
+	RegisterConnection(second)
+	t.Cleanup(func() { UnregisterConnection(second.ID) })
+
+	if first.ID != "collision" || second.ID == "collision" {
+		t.Errorf("IDs = %s and %s, want the second connection renamed", first.ID, second.ID)
+	}
+	if GetConnection(first.ID) != first || GetConnection(second.ID) != second {
+		t.Error("a colliding connection replaced the other")
+	}
+	if line := buf.String(); !strings.Contains(line, "[INFO]") || !strings.Contains(line, first.ClientAddr) || !strings.Contains(line, second.ID) {
+		t.Errorf("collision log = %q", line)
+	}
+}
@@ -0,0 +1,218 @@
+package core
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// FakeBackendDescription is the MOTD of the status served by StartFakeBackend
+const FakeBackendDescription = "go-mcproxy fake backend"
+
+// fakeBackendVersionName is the version name of the fake backend's status
+const fakeBackendVersionName = "FakeBackend"
+
+// fakeBackend is a minimal Minecraft server started by StartFakeBackend
+type fakeBackend struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mutex  sync.Mutex // guards the fields below
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// StartFakeBackend starts a minimal Minecraft server on addr for exercising
+// the proxy without a real server. It answers status pings with a canned
+// status and ping requests with a pong, and finishes logins with a Login
+// Success for the offline UUID of the player, after which the connection is
+// held open and everything the client sends is discarded.
+//
+// Closing the returned value stops the server and closes its connections.
+func StartFakeBackend(addr string) (io.Closer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	fb := &fakeBackend{listener: ln, conns: make(map[net.Conn]struct{})}
+	fb.wg.Add(1)
+	go fb.acceptLoop()
+	return fb, nil
+}
+
+// Close stops accepting connections, closes the open ones and waits for them
+// to be done
+func (fb *fakeBackend) Close() error {
+	fb.mutex.Lock()
+	fb.closed = true
+	err := fb.listener.Close()
+	for conn := range fb.conns {
+		conn.Close()
+	}
+	fb.mutex.Unlock()
+
+	fb.wg.Wait()
+	return err
+}
+
+// acceptLoop serves connections until the listener is closed
+func (fb *fakeBackend) acceptLoop() {
+	defer fb.wg.Done()
+	for {
+		conn, err := fb.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		fb.mutex.Lock()
+		if fb.closed {
+			fb.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		fb.conns[conn] = struct{}{}
+		fb.wg.Add(1)
+		fb.mutex.Unlock()
+
+		go func() {
+			defer fb.wg.Done()
+			defer func() {
+				fb.mutex.Lock()
+				delete(fb.conns, conn)
+				fb.mutex.Unlock()
+				conn.Close()
+			}()
+			if err := serveFakeBackend(conn); err != nil && err != io.EOF {
+				log.Printf("[DEBUG] Fake backend: connection from %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// serveFakeBackend answers the status or login of one connection
+func serveFakeBackend(conn net.Conn) error {
+	pkt, err := ReadPacket(conn)
+	if err != nil {
+		return err
+	}
+	var protocol, nextState VarInt
+	var address String
+	var port UShort
+	if _, err := pkt.Scan(&protocol, &address, &port, &nextState); err != nil {
+		return err
+	}
+
+	switch nextState {
+	case 1:
+		return serveFakeStatus(conn, int(protocol))
+	case 2, 3:
+		return serveFakeLogin(conn, int(protocol))
+	}
+	return nil
+}
+
+// serveFakeStatus answers the status request and the ping that follows it
+func serveFakeStatus(conn net.Conn, protocol int) error {
+	if _, err := ReadPacket(conn); err != nil {
+		return err
+	}
+	status, err := json.Marshal(statusResponse{
+		Version:     statusVersion{Name: fakeBackendVersionName, Protocol: protocol},
+		Players:     statusPlayers{Max: 20, Sample: []statusPlayerSample{}},
+		Description: FakeBackendDescription,
+	})
+	if err != nil {
+		return err
+	}
+	payload, err := Pack(String(status))
+	if err != nil {
+		return err
+	}
+	if err := WritePacket(0x00, payload, conn); err != nil {
+		return err
+	}
+
+	ping, err := ReadPacket(conn)
+	if err != nil {
+		return err
+	}
+	return WritePacket(0x01, ping.Payload, conn)
+}
+
+// serveFakeLogin finishes the login and holds the connection open
+func serveFakeLogin(conn net.Conn, protocol int) error {
+	pkt, err := ReadPacket(conn)
+	if err != nil {
+		return err
+	}
+	var username String
+	if _, err := pkt.Scan(&username); err != nil {
+		return err
+	}
+
+	payload, err := packFakeLoginSuccess(protocol, string(username))
+	if err != nil {
+		return err
+	}
+	if err := WritePacket(loginSuccess, payload, conn); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(io.Discard, conn)
+	return err
+}
+
+// packFakeLoginSuccess builds the login success payload for the protocol
+func packFakeLoginSuccess(protocol int, username string) ([]byte, error) {
+	uuid := offlineUUID(username)
+
+	var buf bytes.Buffer
+	// 1.16 sends the UUID as 16 bytes instead of a string
+	if protocol < VERSION_1_16 {
+		if _, err := String(formatUUID(uuid)).WriteTo(&buf); err != nil {
+			return nil, err
+		}
+	} else {
+		buf.Write(uuid[:])
+	}
+	if _, err := String(username).WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	if protocol >= VERSION_1_19 {
+		// no properties
+		if _, err := VarInt(0).WriteTo(&buf); err != nil {
+			return nil, err
+		}
+	}
+	if protocol >= VERSION_1_20_5 && protocol < VERSION_1_21_2 {
+		// strict error handling, only sent by 1.20.5 to 1.21.1
+		buf.WriteByte(1)
+	}
+	return buf.Bytes(), nil
+}
+
+// offlineUUID returns the UUID offline mode servers give username
+func offlineUUID(username string) [16]byte {
+	uuid := md5.Sum([]byte("OfflinePlayer:" + username))
+	uuid[6] = uuid[6]&0x0f | 0x30 // version 3
+	uuid[8] = uuid[8]&0x3f | 0x80 // RFC 4122 variant
+	return uuid
+}
+
+// formatUUID formats a UUID with hyphens
+func formatUUID(uuid [16]byte) string {
+	const hex = "0123456789abcdef"
+	var out []byte
+	for i, b := range uuid {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			out = append(out, '-')
+		}
+		out = append(out, hex[b>>4], hex[b&0x0f])
+	}
+	return string(out)
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"mcproxy/config"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakeBackendThroughHandler(t *testing.T) {
+	backend, err := StartFakeBackend("127.0.0.1:40158")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	cfg := config.ProxyConfig{
+		Listen:    "127.0.0.1:40043",
+		Remote:    "127.0.0.1:40158",
+		MaxPlayer: 20,
+		PingMode:  "real",
+		Auth:      "none",
+	}
+	registerProxyStats(t, cfg)
+
+	// later tests look connections up by the pipe's address, so each run
+	// waits for its handler to finish
+	run := func(nextState int) (net.Conn, chan struct{}) {
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler(server, cfg, 0, nil)
+		}()
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, nextState)
+		return client, done
+	}
+	wait := func(client net.Conn, done chan struct{}) {
+		client.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler did not return")
+		}
+	}
+
+	// readStatus also checks the pong
+	client, done := run(1)
+	if status := readStatus(t, client); status.Description != FakeBackendDescription || status.Version.Protocol != VERSION_1_18_2 {
+		t.Errorf("status = %+v", status)
+	}
+	wait(client, done)
+
+	client, done = run(2)
+	writeLoginStart(t, client, "Notch")
+	pkt, err := ReadPacket(client)
+	if err != nil || pkt.ID != loginSuccess {
+		t.Fatalf("expected login success, got %v", err)
+	}
+	if want, _ := packFakeLoginSuccess(VERSION_1_18_2, "Notch"); string(pkt.Payload) != string(want) {
+		t.Errorf("login success payload = %x", pkt.Payload)
+	}
+	// the forward only ends once the backend hangs up, like a real server
+	// timing the player out
+	backend.Close()
+	wait(client, done)
+}
+
+func TestFakeLoginSuccess(t *testing.T) {
+	// offline mode servers give Notch this UUID
+	const notch = "b50ad385-829d-3141-a216-7e7d7539ba7f"
+	if uuid := formatUUID(offlineUUID("Notch")); uuid != notch {
+		t.Fatalf("offline UUID = %s", uuid)
+	}
+
+	payload, err := packFakeLoginSuccess(VERSION_1_8_9, "Notch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt := Packet{ID: loginSuccess, Payload: payload}
+	var uuid, username String
+	if _, err := pkt.Scan(&uuid, &username); err != nil || uuid != notch || username != "Notch" {
+		t.Errorf("1.8.9 login success: %q %q %v", uuid, username, err)
+	}
+
+	// 16 byte UUID, name, no properties and the strict error handling flag
+	payload, _ = packFakeLoginSuccess(VERSION_1_20_5, "Notch")
+	if len(payload) != 16+6+1+1 || !strings.HasSuffix(string(payload), "Notch\x00\x01") {
+		t.Errorf("1.20.5 login success: %x", payload)
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultFlapWindow = 10 * time.Second
+
+// flapDetector flags client addresses, host and source port, that connect
+// again and again within a sliding window. Clients pick a new source port for
+// every connection, so the same host:port coming back quickly points to a NAT
+// rebinding ports or a broken client.
+type flapDetector struct {
+	sync.Mutex
+	threshold int // Connections per window that flag an address, 0 = disabled
+	window    time.Duration
+	recent    map[string][]time.Time // Connections within the window by host:port, oldest first
+	lastSweep time.Time
+}
+
+var clientFlaps = &flapDetector{}
+
+// SetFlapDetection configures the flapping detection. A window of zero uses
+// the default of ten seconds.
+func SetFlapDetection(threshold int, window time.Duration) {
+	if window <= 0 {
+		window = defaultFlapWindow
+	}
+
+	clientFlaps.Lock()
+	defer clientFlaps.Unlock()
+	clientFlaps.threshold = threshold
+	clientFlaps.window = window
+	if threshold <= 0 {
+		clientFlaps.recent = nil
+	}
+}
+
+// record counts a connection accepted from addr at now and reports whether
+// the address is flapping, that is it connected at least threshold times
+// within the window
+func (f *flapDetector) record(addr string, now time.Time) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.threshold <= 0 {
+		return false
+	}
+	if f.recent == nil {
+		f.recent = make(map[string][]time.Time)
+	}
+	if now.Sub(f.lastSweep) >= f.window {
+		f.sweep(now)
+	}
+
+	times := f.recent[addr]
+	start := 0
+	for start < len(times) && now.Sub(times[start]) >= f.window {
+		start++
+	}
+	times = append(times[start:], now)
+	// only whether the threshold was reached matters, an address flapping
+	// for long does not grow its list
+	if len(times) > f.threshold+1 {
+		times = times[len(times)-f.threshold-1:]
+	}
+	f.recent[addr] = times
+	if len(times) < f.threshold {
+		return false
+	}
+	// logged once when the address starts flapping
+	if len(times) == f.threshold {
+		log.Printf("[WARN] Client %s reconnected %d times within %v from the same source port, possible NAT rebinding", addr, len(times), f.window)
+	}
+	return true
+}
+
+// sweep forgets the addresses without connections in the window
+func (f *flapDetector) sweep(now time.Time) {
+	f.lastSweep = now
+	for addr, times := range f.recent {
+		if now.Sub(times[len(times)-1]) >= f.window {
+			delete(f.recent, addr)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"errors"
+	"mcproxy/config"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFlapDetectorWindow(t *testing.T) {
+	f := &flapDetector{threshold: 3, window: 10 * time.Second}
+	start := time.Now()
+
+	// reconnects spread over more than the window are not flapping
+	for i := 0; i < 6; i++ {
+		if f.record("203.0.113.1:40000", start.Add(time.Duration(i)*6*time.Second)) {
+			t.Fatalf("connection %d flagged below the rate", i+1)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if f.record("203.0.113.2:40000", start) {
+			t.Fatalf("connection %d flagged below the threshold", i+1)
+		}
+	}
+	if !f.record("203.0.113.2:40000", start.Add(time.Second)) {
+		t.Fatal("third rapid reconnect not flagged")
+	}
+	if f.record("203.0.113.2:40001", start.Add(time.Second)) {
+		t.Error("another source port of the host was flagged")
+	}
+	if f.record("203.0.113.2:40000", start.Add(time.Minute)) {
+		t.Error("still flagged after the window")
+	}
+}
+
+func TestHandlerFlagsFlappingClients(t *testing.T) {
+	SetFlapDetection(3, time.Minute)
+	defer SetFlapDetection(0, 0)
+
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	flags := make(chan bool, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		for _, conn := range connectionSnapshot() {
+			if conn.ClientAddr == "198.51.100.7:40000" {
+				flags <- conn.Flapping
+			}
+		}
+		return nil, errors.New("connection refused")
+	}
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40157", Remote: "backend.example.com:25565", MaxPlayer: 10, Auth: "none",
+		ProxyProtocolTrustedProxies: []string{"127.0.0.1"}}
+	registerProxyStats(t, cfg)
+	trusted, err := config.ParseAllowlist(cfg.ProxyProtocolTrustedProxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// login reconnects from the same host:port, named by a PROXY header, and
+	// reports whether its connection was flagged
+	login := func() bool {
+		client, server := tcpPair(t)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler(server, cfg, 0, trusted)
+		}()
+		defer func() {
+			client.Close()
+			<-done
+		}()
+
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := client.Write([]byte("PROXY TCP4 198.51.100.7 10.0.0.1 40000 25565\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+		writeLoginStart(t, client, "Steve")
+		select {
+		case flapping := <-flags:
+			return flapping
+		case <-time.After(5 * time.Second):
+			t.Fatal("login not forwarded")
+			return false
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if login() {
+			t.Fatalf("connection %d flagged below the threshold", i+1)
+		}
+	}
+	if !login() {
+		t.Error("rapid reconnect from the same host:port not flagged")
+	}
+}
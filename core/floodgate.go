@@ -0,0 +1,32 @@
+package core
+
+import "strings"
+
+// floodgateIdentifier starts the data Geyser adds to the handshake address of
+// Bedrock players for the Floodgate plugin on the backend. The data follows
+// the host after a NUL and is encrypted with Floodgate's key, so the proxy
+// only passes it on.
+const floodgateIdentifier = "^Floodgate^"
+
+// splitFloodgateData splits the Floodgate data, including its NUL separator,
+// off a handshake address. The data is empty for Java clients.
+func splitFloodgateData(address string) (rest string, data string) {
+	i := strings.Index(address, "\x00"+floodgateIdentifier)
+	if i < 0 {
+		return address, ""
+	}
+	data = address[i:]
+	if j := strings.IndexByte(data[1:], 0); j >= 0 {
+		data = data[:j+1]
+	}
+	return address[:i] + address[i+len(data):], data
+}
+
+// splitHandshakeAddress splits a handshake address into the host and the
+// suffix that is kept when the host is rewritten: the Floodgate data followed
+// by the mod loader marker. loader names the mod loader, empty for vanilla.
+func splitHandshakeAddress(address string) (host string, suffix string, loader string) {
+	rest, floodgate := splitFloodgateData(address)
+	host, marker, loader := splitForgeMarker(rest)
+	return host, floodgate + marker, loader
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"fmt"
+	"mcproxy/config"
+	"strings"
+	"unicode/utf8"
+)
+
+// forgeMarkers are the handshake address suffixes appended by modded clients,
+// mapped to the loader they identify. Longer markers come first so that
+// "\x00FML2\x00" is not mistaken for "\x00FML\x00".
+var forgeMarkers = []struct {
+	suffix string
+	loader string
+}{
+	{"\x00FML3\x00", "FML3"},
+	{"\x00FML2\x00", "FML2"},
+	{"\x00FML\x00", "FML"},
+	{"\x00FORGE\x00", "FORGE"},
+	{"\x00FORGE", "FORGE"},
+}
+
+// splitForgeMarker splits a handshake address into the host and the mod
+// loader marker suffix. The marker is empty for vanilla clients.
+func splitForgeMarker(address string) (host string, marker string, loader string) {
+	for _, m := range forgeMarkers {
+		if strings.HasSuffix(address, m.suffix) {
+			return strings.TrimSuffix(address, m.suffix), m.suffix, m.loader
+		}
+	}
+	return address, "", ""
+}
+
+// defaultMaxHostnameLength is the longest handshake hostname vanilla clients send
+const defaultMaxHostnameLength = 255
+
+// checkHandshakeAddress limits the host part of a handshake address to the
+// proxy's max_hostname_length. The Floodgate data and mod loader marker do not
+// count towards the limit and are kept when the host is truncated. Oversized
+// hosts are an error unless truncate_long_hostnames is set, truncated reports
+// whether the returned address was shortened.
+func checkHandshakeAddress(address string, cfg config.ProxyConfig) (checked string, truncated bool, err error) {
+	maxLength := defaultMaxHostnameLength
+	if cfg.MaxHostnameLength > 0 {
+		maxLength = cfg.MaxHostnameLength
+	}
+
+	host, suffix, _ := splitHandshakeAddress(address)
+	if len(host) <= maxLength {
+		return address, false, nil
+	}
+	if !cfg.TruncateLongHostnames {
+		return "", false, fmt.Errorf("hostname is %d bytes, the limit is %d", len(host), maxLength)
+	}
+
+	// cut on a rune boundary so the host stays valid UTF-8
+	n := maxLength
+	for n > 0 && !utf8.RuneStart(host[n]) {
+		n--
+	}
+	return host[:n] + suffix, true, nil
+}
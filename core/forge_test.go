@@ -0,0 +1,121 @@
+package core
+
+import (
+	"mcproxy/config"
+	"strings"
+	"testing"
+)
+
+func TestForgeMarkerRewrite(t *testing.T) {
+	cfg := config.ProxyConfig{RewirteHost: "backend.example.com", RewirtePort: 25565}
+
+	tests := map[string]string{
+		"play.example.com":              "",
+		"play.example.com\x00FML\x00":   "FML",
+		"play.example.com\x00FML2\x00":  "FML2",
+		"play.example.com\x00FML3\x00":  "FML3",
+		"play.example.com\x00FORGE":     "FORGE",
+		"play.example.com\x00FORGE\x00": "FORGE",
+	}
+
+	for address, wantLoader := range tests {
+		host, marker, loader := splitForgeMarker(address)
+		if host != "play.example.com" || loader != wantLoader {
+			t.Errorf("%q: host=%q loader=%q, want loader %q", address, host, loader, wantLoader)
+			continue
+		}
+
+		payload, err := packLoginHandshake(VERSION_1_18_2, cfg, marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pkt := Packet{ID: 0x00, Payload: payload}
+		var protocol, nextState VarInt
+		var rewritten String
+		var port UShort
+		if _, err := pkt.Scan(&protocol, &rewritten, &port, &nextState); err != nil {
+			t.Fatal(err)
+		}
+
+		rewrittenHost, rewrittenMarker, rewrittenLoader := splitForgeMarker(string(rewritten))
+		if rewrittenHost != cfg.RewirteHost || rewrittenMarker != marker || rewrittenLoader != wantLoader {
+			t.Errorf("%q: rewritten to %q", address, rewritten)
+		}
+	}
+}
+
+func TestCheckHandshakeAddress(t *testing.T) {
+	long := strings.Repeat("a", 300) + ".example.com"
+
+	// hosts within the limit are kept as sent, markers do not count
+	address := strings.Repeat("a", defaultMaxHostnameLength) + "\x00FML2\x00"
+	if checked, truncated, err := checkHandshakeAddress(address, config.ProxyConfig{}); err != nil || truncated || checked != address {
+		t.Errorf("255 byte host: %q %v %v", checked, truncated, err)
+	}
+
+	if _, _, err := checkHandshakeAddress(long+"\x00FML2\x00", config.ProxyConfig{}); err == nil {
+		t.Error("over-long hostname was accepted")
+	}
+
+	cfg := config.ProxyConfig{MaxHostnameLength: 16, TruncateLongHostnames: true}
+	checked, truncated, err := checkHandshakeAddress(long+"\x00FML2\x00", cfg)
+	if err != nil || !truncated {
+		t.Fatalf("truncate: %v %v", truncated, err)
+	}
+	host, marker, loader := splitForgeMarker(checked)
+	if host != strings.Repeat("a", 16) || marker != "\x00FML2\x00" || loader != "FML2" {
+		t.Errorf("truncated to host=%q marker=%q loader=%q", host, marker, loader)
+	}
+
+	// truncation does not split a multi-byte character
+	checked, _, _ = checkHandshakeAddress(strings.Repeat("é", 20), cfg)
+	if checked != strings.Repeat("é", 8) {
+		t.Errorf("truncated to %q", checked)
+	}
+}
+
+// floodgateSample is a handshake address in the format Geyser sends for a
+// Bedrock player: the host, then the Floodgate data and optionally a Forge
+// marker, each after a NUL.
+var floodgateSample = "play.example.com\x00^Floodgate^" + strings.Repeat("QUJDREVGR0hJSktMTU5PUA==", 24)
+
+func TestFloodgateDataPassthrough(t *testing.T) {
+	_, data := splitFloodgateData(floodgateSample)
+	if data != floodgateSample[len("play.example.com"):] {
+		t.Fatalf("floodgate data %q", data)
+	}
+
+	// the data does not count towards the hostname limit
+	if checked, truncated, err := checkHandshakeAddress(floodgateSample, config.ProxyConfig{}); err != nil || truncated || checked != floodgateSample {
+		t.Errorf("floodgate address: %q %v %v", checked, truncated, err)
+	}
+
+	// truncation keeps the data and the marker after it
+	cfg := config.ProxyConfig{MaxHostnameLength: 4, TruncateLongHostnames: true}
+	checked, truncated, err := checkHandshakeAddress(floodgateSample+"\x00FML2\x00", cfg)
+	if err != nil || !truncated || checked != "play"+data+"\x00FML2\x00" {
+		t.Errorf("truncated to %q %v %v", checked, truncated, err)
+	}
+
+	// the rewritten handshake carries the data after the new host
+	host, suffix, loader := splitHandshakeAddress(floodgateSample + "\x00FML2\x00")
+	if host != "play.example.com" || suffix != data+"\x00FML2\x00" || loader != "FML2" {
+		t.Fatalf("host=%q suffix=%q loader=%q", host, suffix, loader)
+	}
+	rewriteCfg := config.ProxyConfig{RewirteHost: "backend.example.com", RewirtePort: 25565}
+	payload, err := packLoginHandshake(VERSION_1_18_2, rewriteCfg, suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt := Packet{ID: 0x00, Payload: payload}
+	var protocol, nextState VarInt
+	var rewritten String
+	var port UShort
+	if _, err := pkt.Scan(&protocol, &rewritten, &port, &nextState); err != nil {
+		t.Fatal(err)
+	}
+	if string(rewritten) != rewriteCfg.RewirteHost+data+"\x00FML2\x00" {
+		t.Errorf("rewritten to %q", rewritten)
+	}
+}
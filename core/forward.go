@@ -2,23 +2,31 @@ package core
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mcproxy/config"
+	"mcproxy/logger"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, cfg config.ProxyConfig) error {
-	// Increment global connection count
-	onlineCount.Add(1)
-	defer decrementOnlineCount()
+// dialRemote opens the connection to the backend for a new login. It is a
+// variable so tests can inject slow or failing dials.
+var dialRemote = dialMC
 
-	// Increment per-proxy connection count
+// handleForward logs the client in to the remote server and forwards the
+// connection. Connections that have not finished the login when ctx's deadline
+// passes are closed. reader must be the reader the handshake was read from, it
+// may already hold the login start and the packets after it. observeDial, if
+// not nil, is called with the result of dialing the remote server.
+func handleForward(ctx context.Context, reader io.Reader, writer io.Writer, addressSuffix string, protocol int, cfg config.ProxyConfig, observeDial func(error)) error {
 	cp := GetControlPanel()
-	cp.IncrementConnectionCount(cfg.Listen)
-	defer cp.DecrementConnectionCount(cfg.Listen)
 
 	// Get the client connection from the writer
 	clientConn, ok := writer.(net.Conn)
@@ -27,7 +35,7 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 	}
 
 	// Get the connection ID from the client address
-	clientAddr := clientConn.RemoteAddr().String()
+	clientAddr := normalizeClientAddr(clientConn.RemoteAddr().String())
 
 	// Find the connection in the active connections map
 	var connection *Connection
@@ -40,7 +48,7 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 			// If this connection already exists and has a username, it might be a BungeeCord server switch
 			if conn.Username != "" {
 				isBungeeServerSwitch = true
-				log.Printf("[DEBUG] Detected potential BungeeCord server switch for user: %s", conn.Username)
+				connDebugf(cfg, "Detected potential BungeeCord server switch for user: %s", conn.Username)
 			}
 			break
 		}
@@ -66,16 +74,50 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 		return fmt.Errorf("scan login start: %w", err)
 	}
 
-	log.Printf("[INFO] User login attempt: %s", username)
+	// Usernames are logged and shown in the control panel, names outside the
+	// Minecraft charset may carry newlines, escape sequences or markup. A
+	// sanitized name is also the one sent to the backend, so it is checked
+	// against the whitelist under the name it plays with.
+	if !validUsername(string(username)) {
+		switch cfg.UsernamePolicy {
+		case UsernamePolicyAllow:
+		case UsernamePolicyReject:
+			connInfof(cfg, "User rejected: %q, reason: invalid username", string(username))
+			cp.RecordRejection(cfg.Listen, RejectInvalidUsername)
+			if err := sendDisconnect(writer, invalidUsernameMessage); err != nil {
+				return fmt.Errorf("write disconnect: %w", err)
+			}
+			return nil
+		default:
+			sanitized := sanitizeUsername(string(username))
+			payload, err := replaceLoginStartName(pkt.Payload, sanitized)
+			if err != nil {
+				return fmt.Errorf("sanitize login start: %w", err)
+			}
+			log.Printf("[WARN] Sanitized invalid username %q to %s", string(username), sanitized)
+			username = String(sanitized)
+			pkt.Payload = payload
+		}
+	}
+
+	// The connection only counts as a player once the login has started
+	onlineCount.Add(1)
+	defer decrementOnlineCount()
+	cp.IncrementConnectionCount(cfg.Listen)
+	defer cp.DecrementConnectionCount(cfg.Listen)
+
+	connInfof(cfg, "User login attempt: %s", username)
 
 	// Update the connection with the username if we found it
 	if connection != nil {
 		// If the username matches the existing connection, it's likely a BungeeCord server switch
 		if connection.Username == string(username) {
 			isBungeeServerSwitch = true
-			log.Printf("[DEBUG] Confirmed BungeeCord server switch for user: %s", username)
+			connDebugf(cfg, "Confirmed BungeeCord server switch for user: %s", username)
 		}
+		activeConnections.Lock()
 		connection.Username = string(username)
+		activeConnections.Unlock()
 	}
 
 	// whitelist / blacklist
@@ -87,6 +129,7 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 
 	if !allow {
 		log.Printf("[WARN] User rejected: %s, reason: %s", username, msg)
+		cp.RecordRejection(cfg.Listen, RejectAuth)
 
 		err = sendDisconnect(writer, msg)
 		if err != nil {
@@ -95,50 +138,79 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 		return nil
 	}
 
-	log.Printf("[INFO] User authenticated: %s", username)
+	connInfof(cfg, "User authenticated: %s", username)
+
+	// A lingering session with the same username would count against the
+	// player's own limits, BungeeCord switches reuse the session and are skipped
+	if cfg.KickDuplicateLogin && !isBungeeServerSwitch {
+		for _, id := range findDuplicateLogins(string(username), clientAddr) {
+			log.Printf("[INFO] Disconnecting previous session %s of user %s", id, username)
+			if err := DisconnectClient(id, duplicateLoginMessage); err != nil {
+				log.Printf("[WARN] Failed to disconnect previous session %s of user %s: %v", id, username, err)
+			}
+		}
+	}
+
+	// Bursts of logins wait for a free slot instead of all dialing the backend
+	// at once, the slot is released once the backend answered the login
+	releaseLoginSlot, ok := acquireLoginSlot(ctx, cfg)
+	if !ok {
+		log.Printf("[WARN] User rejected: %s, reason: %d logins to %s already in progress", username, cfg.MaxConcurrentLogins, cfg.Remote)
+		cp.RecordRejection(cfg.Listen, RejectBusy)
+
+		err = sendDisconnect(writer, busyMessage)
+		if err != nil {
+			return fmt.Errorf("write disconnect: %w", err)
+		}
+		return nil
+	}
+	defer releaseLoginSlot()
 
 	// connect to remote
-	log.Printf("[DEBUG] Connecting to remote server: %s", cfg.Remote)
+	connDebugf(cfg, "Connecting to remote server: %s", cfg.Remote)
 	if cfg.LocalAddr != "" {
-		log.Printf("[DEBUG] Using local address for outgoing connection: %s", cfg.LocalAddr)
+		connDebugf(cfg, "Using local address for outgoing connection: %s", cfg.LocalAddr)
+	}
+	connectStart := time.Now()
+	remote, err := dialRemote(cfg.Remote, cfg.LocalAddr, cfg.ResolveViaLocalAddr)
+	if observeDial != nil {
+		observeDial(err)
 	}
-	remote, err := DialMC(cfg.Remote, cfg.LocalAddr)
 	if err != nil {
-		log.Printf("[ERROR] Failed to connect to remote server %s: %v", cfg.Remote, err)
+		logger.GetLogger().Error("Failed to connect to remote server %s: %v", cfg.Remote, err)
 		return err
 	}
 	defer remote.Close()
 
 	// Store the remote connection in the connection object
 	if connection != nil {
+		activeConnections.Lock()
 		connection.RemoteConn = remote
+		activeConnections.Unlock()
+	}
+
+	// The backend can log the connection ID to correlate its logs with ours,
+	// reconnects send the same ID
+	if cfg.HandshakeConnectionID != "" && connection != nil {
+		addressSuffix += strings.ReplaceAll(cfg.HandshakeConnectionID, "{id}", connection.ID)
+		connDebugf(cfg, "Sending connection ID %s to the remote server for %s", connection.ID, username)
 	}
 
 	// If this is a BungeeCord server switch, we need to handle it differently
 	// to avoid sending duplicate login packets
 	if isBungeeServerSwitch {
-		log.Printf("[INFO] Handling BungeeCord server switch for user: %s", username)
+		connInfof(cfg, "Handling BungeeCord server switch for user: %s", username)
 
 		// For BungeeCord server switches, we don't need to send the handshake and login start packets
 		// as they are already handled by BungeeCord. Sending them again causes issues.
-		log.Printf("[DEBUG] Skipping handshake and login start packets for BungeeCord server switch")
+		connDebugf(cfg, "Skipping handshake and login start packets for BungeeCord server switch")
 
 		// Instead, we'll just forward the packets between the client and server
 		// The BungeeCord server will handle the server switch properly
 	} else {
 		// Normal connection (not a BungeeCord server switch)
 		// handshake packet
-		rewriteHost := cfg.RewirteHost
-		if fml {
-			rewriteHost += "\x00FML\x00"
-		}
-
-		pktHandshake, err := Pack(
-			VarInt(protocol),
-			String(rewriteHost),
-			UShort(cfg.RewirtePort),
-			VarInt(2), // next state login
-		)
+		pktHandshake, err := packLoginHandshake(protocol, cfg, addressSuffix)
 		if err != nil {
 			return err
 		}
@@ -157,8 +229,126 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 		}
 	}
 
+	warnSlowConnect(cfg, string(username), time.Since(connectStart))
+
+	// A backend that accepts the connection but never answers the login is
+	// given up on instead of leaving the client waiting without feedback
+	var firstByte *firstByteTimer
+	if !isBungeeServerSwitch {
+		firstByte = startFirstByteTimer(cfg, string(username), connection, clientConn, remote)
+		defer firstByte.stop()
+	}
+
+	// The login ends with the server's answer to the login start, BungeeCord
+	// switches skip the login and are already past it
+	stopLoginTimeout := closeOnLoginTimeout(ctx, string(username), clientConn, remote)
+	defer stopLoginTimeout()
+	if isBungeeServerSwitch {
+		stopLoginTimeout()
+		releaseLoginSlot()
+	}
+
+	// BungeeCord switches join the stream mid-session, so packets can not be
+	// followed from the start
+	var injector *packetInjector
+	clientStream := writer
+
+	// Compression towards the client is added by the proxy below every other
+	// layer, so injected packets are compressed like the backend's
+	var compression *clientCompression
+	if cfg.ClientCompressionThreshold > 0 && !isBungeeServerSwitch {
+		compression = &clientCompression{threshold: cfg.ClientCompressionThreshold}
+		clientStream = newClientCompressor(writer, compression, string(username))
+	}
+
+	// The injector also writes the lost server message, so every session has
+	// one and chat_injection only decides whether broadcasts may use it
+	if !isBungeeServerSwitch {
+		injector = newPacketInjector(clientStream, protocol, string(username))
+		injector.chat = cfg.ChatInjection
+		clientStream = injector
+		if connection != nil {
+			activeConnections.Lock()
+			connection.injector = injector
+			activeConnections.Unlock()
+			defer func() {
+				activeConnections.Lock()
+				connection.injector = nil
+				activeConnections.Unlock()
+			}()
+		}
+	}
+
+	// Proxies with packet capture follow the frames of both directions, so a
+	// capture can start at any time
+	var capture *packetCapture
+	if cfg.PacketCapture && !isBungeeServerSwitch {
+		capture = newPacketCapture(clientStream, string(username))
+		clientStream = capture
+		defer capture.finish()
+		if connection != nil {
+			activeConnections.Lock()
+			connection.capture = capture
+			activeConnections.Unlock()
+			defer func() {
+				activeConnections.Lock()
+				connection.capture = nil
+				activeConnections.Unlock()
+			}()
+		}
+	}
+
+	// The locale is read from the client's settings right after the login
+	var locale *localeWatcher
+	if cfg.CaptureLocale && !isBungeeServerSwitch {
+		locale = newLocaleWatcher(clientStream, protocol, connection, string(username))
+		if locale != nil {
+			clientStream = locale
+		}
+	}
+
+	var keepAlive *keepAliveInjector
+	if cfg.KeepAliveIntervalMs > 0 && !isBungeeServerSwitch {
+		keepAlive = newKeepAliveInjector(clientStream, protocol, time.Duration(cfg.KeepAliveIntervalMs)*time.Millisecond, string(username))
+	}
+	if keepAlive != nil {
+		defer keepAlive.Close()
+	}
+
+	// Play state sessions whose backend is lost can be held while the backend
+	// is logged in to again, the client's data then goes to held
+	var held heldBackend
+	held.set(remote)
+	clientDone := make(chan struct{})
+	var reconnect *playReconnect
+	if cfg.PlayReconnectTimeoutMs > 0 && keepAlive != nil {
+		reconnect = &playReconnect{
+			cfg:           cfg,
+			protocol:      protocol,
+			addressSuffix: addressSuffix,
+			loginStart:    pkt.Payload,
+			username:      string(username),
+			keepAlive:     keepAlive,
+			clientDone:    clientDone,
+		}
+		keepAlive.watchKicks(protocol)
+	}
+
+	// Replaying the login to a new backend only works while the client is still
+	// waiting for its answer, BungeeCord switches join after the login
+	var pastLogin atomic.Bool
+	pastLogin.Store(isBungeeServerSwitch)
+	endSession := func(reason error) {
+		if connection != nil {
+			connection.setEndReason("lost server connection")
+		}
+		log.Printf("[WARN] Lost the server connection of %s after the login, disconnecting instead of reconnecting: %v", username, reason)
+		disconnectInPlay(injector, protocol, string(username))
+		clientConn.Close()
+	}
+
 	// start forward
-	log.Printf("[INFO] Starting data forwarding for user: %s", username)
+	connInfof(cfg, "Starting data forwarding for user: %s", username)
 	var wg sync.WaitGroup
 	wg.Add(2)
 
@@ -167,6 +357,9 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 
 	// Forward data from remote server to client with buffering
 	go func() {
+		defer wg.Done()
+		defer recoverConnection(string(username), clientConn, remote)
+
 		// Use a buffer for copying
 		buffer := make([]byte, bufferSize)
 
@@ -174,20 +367,91 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 		var bytesWritten int64
 		var remoteConn net.Conn = remote
 		var bufferedRemote *bufio.Reader = bufio.NewReaderSize(remote, bufferSize)
+		// the reconnected connection is closed even after a panic
+		defer func() {
+			if remoteConn != remote {
+				remoteConn.Close()
+			}
+		}()
+		var clientWriter io.Writer = clientStream
+		if keepAlive != nil {
+			clientWriter = keepAlive
+		}
+		if !isBungeeServerSwitch {
+			clientWriter = &loginWatcher{client: clientWriter, done: func(success bool) {
+				stopLoginTimeout()
+				releaseLoginSlot()
+				held.set(remoteConn)
+				pastLogin.Store(true)
+				// only a Login Success confirms the backend let the player in,
+				// logins the server encrypts can not be confirmed
+				if success {
+					if connection != nil {
+						connection.markEstablished(time.Now())
+					}
+					connInfof(cfg, "Session established for user %s on %s", username, cfg.Remote)
+				}
+			}}
+		}
+
+		// holdClient reports whether the lost backend could be logged in to
+		// again while the client was held
+		holdClient := func(reason error) bool {
+			// a backend that kicked the player is not reconnected to
+			if reconnect == nil || keepAlive.kicked.Load() || !keepAlive.canInject() {
+				return false
+			}
+			log.Printf("[WARN] Lost the server connection of %s, holding the client while reconnecting: %v", username, reason)
+			held.set(nil)
+			remoteConn.Close()
+
+			newConn, newReader, err := reconnect.hold()
+			if err != nil {
+				log.Printf("[WARN] Failed to reconnect %s to remote server %s: %v", username, cfg.Remote, err)
+				return false
+			}
+			log.Printf("[INFO] Reconnected %s to remote server %s", username, cfg.Remote)
+			remoteConn = newConn
+			bufferedRemote = newReader
+			held.set(newConn)
+			if connection != nil {
+				activeConnections.Lock()
+				connection.RemoteConn = newConn
+				activeConnections.Unlock()
+			}
+			return true
+		}
 
 		for {
 			// Read from the remote server
 			nr, er := bufferedRemote.Read(buffer)
 
-			// If read failed with an error other than EOF, try to reconnect
-			if er != nil && er != io.EOF {
+			// If read failed with an error other than EOF, try to reconnect if
+			// enabled. A connection closed on our side, after a panic or a
+			// login timeout, is not a server failure.
+			if er != nil && er != io.EOF && !errors.Is(er, net.ErrClosed) {
+				if pastLogin.Load() {
+					// the client to server side failed to write to the backend
+					if writeErr := held.writeError(); writeErr != nil {
+						er = writeErr
+					}
+					if holdClient(er) {
+						continue
+					}
+					endSession(er)
+					break
+				}
+				if !cfg.AutoReconnect {
+					log.Printf("[WARN] Read error from server for %s, ending the session: %v", username, er)
+					break
+				}
 				log.Printf("[WARN] Read error from server for %s, attempting to reconnect: %v", username, er)
 
 				// Close the old connection
 				remoteConn.Close()
 
 				// Reconnect using DialMC to re-resolve DNS
-				newConn, dialErr := DialMC(cfg.Remote, cfg.LocalAddr)
+				newConn, dialErr := dialMC(cfg.Remote, cfg.LocalAddr, cfg.ResolveViaLocalAddr)
 				if dialErr != nil {
 					log.Printf("[ERROR] Failed to reconnect to remote server %s: %v", cfg.Remote, dialErr)
 					break
@@ -196,6 +460,18 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 				log.Printf("[INFO] Successfully reconnected to remote server %s for user %s", cfg.Remote, username)
 				remoteConn = newConn
 				bufferedRemote = bufio.NewReaderSize(newConn, bufferSize)
+				if keepAlive != nil {
+					keepAlive.disable("reconnected to remote server")
+				}
+				if injector != nil {
+					injector.disable("reconnected to remote server")
+				}
+				if capture != nil {
+					capture.stop("reconnected to remote server")
+				}
+				if locale != nil {
+					locale.stop("reconnected to remote server")
+				}
 
 				// Update the connection in the connection object with proper synchronization
 				if connection != nil {
@@ -205,7 +481,7 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 					updatedConn := activeConnections.connections[connection.ID]
 					if updatedConn != nil {
 						updatedConn.RemoteConn = newConn
-						log.Printf("[DEBUG] Updated remote connection for user %s", username)
+						connDebugf(cfg, "Updated remote connection for user %s", username)
 					} else {
 						log.Printf("[WARN] Connection %s no longer exists in active connections map", connection.ID)
 					}
@@ -215,17 +491,7 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 				// Need to resend handshake and login packets after reconnection
 				if !isBungeeServerSwitch {
 					// Resend handshake packet
-					rewriteHost := cfg.RewirteHost
-					if fml {
-						rewriteHost += "\x00FML\x00"
-					}
-
-					pktHandshake, err := Pack(
-						VarInt(protocol),
-						String(rewriteHost),
-						UShort(cfg.RewirtePort),
-						VarInt(2), // next state login
-					)
+					pktHandshake, err := packLoginHandshake(protocol, cfg, addressSuffix)
 					if err != nil {
 						log.Printf("[ERROR] Failed to create handshake packet for reconnection: %v", err)
 						break
@@ -237,14 +503,9 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 						break
 					}
 
-					// Resend login start packet
-					pktLoginStart, err := Pack(String(username))
-					if err != nil {
-						log.Printf("[ERROR] Failed to create login start packet for reconnection: %v", err)
-						break
-					}
-
-					err = WritePacket(0x00, pktLoginStart, newConn)
+					// Resend the client's login start, the payload carries the
+					// fields of newer versions and the sanitized username
+					err = WritePacket(0x00, pkt.Payload, newConn)
 					if err != nil {
 						log.Printf("[ERROR] Failed to send login start packet for reconnection: %v", err)
 						break
@@ -255,8 +516,11 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 				continue
 			}
 
+			if nr > 0 && !firstByte.receive() {
+				break
+			}
 			if nr > 0 {
-				nw, ew := writer.Write(buffer[0:nr])
+				nw, ew := clientWriter.Write(buffer[0:nr])
 				if nw < 0 || nr < nw {
 					nw = 0
 					if ew == nil {
@@ -264,6 +528,9 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 					}
 				}
 				bytesWritten += int64(nw)
+				if connection != nil {
+					connection.bytesOut.Add(int64(nw))
+				}
 				if ew != nil {
 					log.Printf("[ERROR] Write error forwarding data from server to client for %s: %v", username, ew)
 					break
@@ -276,28 +543,48 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 
 			if er != nil {
 				// We already handled non-EOF errors above
+				if er == io.EOF && pastLogin.Load() && reconnect != nil && !keepAlive.kicked.Load() {
+					if holdClient(er) {
+						continue
+					}
+					// the client was held or the backend dropped it without
+					// a reason, either way it is told what happened
+					endSession(er)
+				}
 				break
 			}
 		}
 
-		// Make sure to close the current remote connection if it's different from the original
-		if remoteConn != remote {
-			remoteConn.Close()
+		// The session ends with the server's side, the client is not left
+		// waiting on a connection nothing is forwarded to
+		if connection != nil {
+			if keepAlive != nil && keepAlive.kicked.Load() {
+				connection.setEndReason("kicked by server")
+			} else {
+				connection.setEndReason("server closed")
+			}
 		}
-
-		log.Printf("[DEBUG] Forwarded %d bytes from server to client for %s", bytesWritten, username)
-		wg.Done()
+		clientConn.Close()
+		connDebugf(cfg, "Forwarded %d bytes from server to client for %s", bytesWritten, username)
 	}()
 
 	// Forward data from client to remote server with buffering
 	go func() {
-		// Create a buffered reader if needed
+		defer wg.Done()
+		defer close(clientDone)
+		defer recoverConnection(string(username), clientConn, remote)
+
+		// Create a buffered reader if needed, the reader the handshake was read
+		// from is reused so the bytes it buffered are forwarded too
 		var bufferedReader *bufio.Reader
 		if br, ok := reader.(*bufio.Reader); ok {
 			bufferedReader = br
 		} else {
 			bufferedReader = bufio.NewReaderSize(reader, bufferSize)
 		}
+		if compression != nil {
+			bufferedReader = bufio.NewReaderSize(newClientDecompressor(bufferedReader, compression), bufferSize)
+		}
 
 		// Use a buffer for copying
 		buffer := make([]byte, bufferSize)
@@ -305,18 +592,55 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 		// Manual copy loop with buffering for better performance
 		var bytesWritten int64
 		var remoteConn net.Conn = remote
+		// the reconnected connection is closed even after a panic
+		defer func() {
+			if remoteConn != remote {
+				remoteConn.Close()
+			}
+		}()
 
 		for {
 			nr, er := bufferedReader.Read(buffer)
 			if nr > 0 {
+				data := buffer[0:nr]
+				if keepAlive != nil {
+					// answers to injected keep-alives never reach the server
+					data = keepAlive.FilterServerbound(data)
+				}
+				if capture != nil {
+					data = capture.FilterServerbound(data)
+				}
+				if locale != nil {
+					data = locale.FilterServerbound(data)
+				}
+
 				// Try to write to the remote server
 				var writeErr error
 				var nw int
 
 				// Attempt to write to the current connection
-				nw, writeErr = remoteConn.Write(buffer[0:nr])
+				if reconnect != nil && pastLogin.Load() {
+					nw, writeErr = held.Write(data)
+				} else {
+					nw, writeErr = remoteConn.Write(data)
+				}
 
-				// If write failed, try to reconnect using DialMC to re-resolve DNS
+				// The server to client side holds the client or ends the
+				// session, the data not written is dropped like while the
+				// client is held
+				if writeErr != nil && reconnect != nil && pastLogin.Load() {
+					data = data[:max(nw, 0)]
+					writeErr = nil
+				}
+				// Past the login a failed write ends the session
+				if writeErr != nil && pastLogin.Load() {
+					endSession(writeErr)
+					break
+				}
+				if writeErr != nil && !cfg.AutoReconnect {
+					log.Printf("[WARN] Write error to server for %s, ending the session: %v", username, writeErr)
+					break
+				}
 				if writeErr != nil {
 					log.Printf("[WARN] Write error to server for %s, attempting to reconnect: %v", username, writeErr)
 
@@ -324,7 +648,7 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 					remoteConn.Close()
 
 					// Reconnect using DialMC to re-resolve DNS
-					newConn, dialErr := DialMC(cfg.Remote, cfg.LocalAddr)
+					newConn, dialErr := dialMC(cfg.Remote, cfg.LocalAddr, cfg.ResolveViaLocalAddr)
 					if dialErr != nil {
 						log.Printf("[ERROR] Failed to reconnect to remote server %s: %v", cfg.Remote, dialErr)
 						break
@@ -332,6 +656,15 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 
 					log.Printf("[INFO] Successfully reconnected to remote server %s for user %s", cfg.Remote, username)
 					remoteConn = newConn
+					if keepAlive != nil {
+						keepAlive.disable("reconnected to remote server")
+					}
+					if injector != nil {
+						injector.disable("reconnected to remote server")
+					}
+					if capture != nil {
+						capture.stop("reconnected to remote server")
+					}
 
 					// Update the connection in the connection object with proper synchronization
 					if connection != nil {
@@ -341,7 +674,7 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 						updatedConn := activeConnections.connections[connection.ID]
 						if updatedConn != nil {
 							updatedConn.RemoteConn = newConn
-							log.Printf("[DEBUG] Updated remote connection for user %s", username)
+							connDebugf(cfg, "Updated remote connection for user %s", username)
 						} else {
 							log.Printf("[WARN] Connection %s no longer exists in active connections map", connection.ID)
 						}
@@ -349,10 +682,10 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 					}
 
 					// Try writing again with the new connection
-					nw, writeErr = remoteConn.Write(buffer[0:nr])
+					nw, writeErr = remoteConn.Write(data)
 				}
 
-				if nw < 0 || nr < nw {
+				if nw < 0 || len(data) < nw {
 					nw = 0
 					if writeErr == nil {
 						writeErr = fmt.Errorf("invalid write result")
@@ -360,34 +693,244 @@ func handleForward(reader io.Reader, writer io.Writer, fml bool, protocol int, c
 				}
 
 				bytesWritten += int64(nw)
+				if connection != nil {
+					connection.bytesIn.Add(int64(nw))
+				}
 				if writeErr != nil {
 					log.Printf("[ERROR] Write error forwarding data from client to server for %s: %v", username, writeErr)
 					break
 				}
-				if nr != nw {
+				if len(data) != nw {
 					log.Printf("[ERROR] Short write forwarding data from client to server for %s", username)
 					break
 				}
 			}
 
 			if er != nil {
-				if er != io.EOF {
+				if er == io.EOF && connection != nil {
+					connection.setEndReason("client closed")
+				}
+				// the client is closed on our side once the server's side ended
+				if er != io.EOF && !errors.Is(er, net.ErrClosed) {
 					log.Printf("[ERROR] Read error forwarding data from client to server for %s: %v", username, er)
 				}
 				break
 			}
 		}
 
-		// Make sure to close the current remote connection
-		if remoteConn != remote {
-			remoteConn.Close()
-		}
-
-		log.Printf("[DEBUG] Forwarded %d bytes from client to server for %s", bytesWritten, username)
-		wg.Done()
+		connDebugf(cfg, "Forwarded %d bytes from client to server for %s", bytesWritten, username)
 	}()
 
 	wg.Wait()
-	log.Printf("[INFO] Data forwarding completed for user: %s", username)
+	connInfof(cfg, "Data forwarding completed for user: %s", username)
 	return nil
 }
+
+// duplicateLoginMessage is shown to a session replaced by a newer login
+const duplicateLoginMessage = "You logged in from another location"
+
+// findDuplicateLogins returns the IDs of other connections logged in with the
+// same username, on any proxy. A player's lingering session counts against
+// the limits whichever proxy it came through.
+func findDuplicateLogins(username, clientAddr string) []string {
+	activeConnections.RLock()
+	defer activeConnections.RUnlock()
+
+	var ids []string
+	for id, conn := range activeConnections.connections {
+		if conn.ClientAddr != clientAddr && strings.EqualFold(conn.Username, username) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// warnSlowConnect logs when dialing the backend and sending the login
+// handshake took longer than the proxy's slow connect threshold
+func warnSlowConnect(cfg config.ProxyConfig, username string, elapsed time.Duration) {
+	if cfg.SlowConnectThresholdMs <= 0 {
+		return
+	}
+	if elapsed < time.Duration(cfg.SlowConnectThresholdMs)*time.Millisecond {
+		return
+	}
+	log.Printf("[WARN] Slow connection for user %s to %s: dial and handshake took %v (threshold %dms)",
+		username, cfg.Remote, elapsed.Round(time.Millisecond), cfg.SlowConnectThresholdMs)
+}
+
+// packLoginHandshake builds the handshake sent to the remote server, using the
+// rewritten host and port and re-appending the client's Floodgate data and
+// Forge marker, followed by the connection ID with handshake_connection_id
+func packLoginHandshake(protocol int, cfg config.ProxyConfig, addressSuffix string) ([]byte, error) {
+	return Pack(
+		VarInt(protocol),
+		String(cfg.RewirteHost+addressSuffix),
+		UShort(cfg.RewirtePort),
+		VarInt(2), // next state login
+	)
+}
+
+// loginContext returns the context that bounds a login accepted at acceptedAt,
+// it has no deadline when the proxy sets no login timeout
+func loginContext(cfg config.ProxyConfig, acceptedAt time.Time) (context.Context, context.CancelFunc) {
+	if cfg.LoginTimeoutMs <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), acceptedAt.Add(time.Duration(cfg.LoginTimeoutMs)*time.Millisecond))
+}
+
+// closeOnLoginTimeout closes conns when ctx's deadline passes before the
+// returned stop function is called to mark the login as finished
+func closeOnLoginTimeout(ctx context.Context, username string, conns ...net.Conn) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		if ctx.Err() != context.DeadlineExceeded {
+			return
+		}
+		log.Printf("[WARN] Login of %s did not finish in time, closing the connection", username)
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+}
+
+// backendSilentMessage is shown to players whose backend accepted the
+// connection but sent nothing back within first_byte_timeout_ms
+const backendSilentMessage = "Backend not responding, please try again later"
+
+// firstByteTimer disconnects a client whose backend has not sent anything
+// within the proxy's first byte timeout after the login was forwarded
+type firstByteTimer struct {
+	timer *time.Timer
+	state atomic.Int32 // firstBytePending, firstByteReceived or firstByteExpired
+}
+
+const (
+	firstBytePending int32 = iota
+	firstByteReceived
+	firstByteExpired
+)
+
+// startFirstByteTimer starts the first byte timeout of cfg, on expiry the
+// client is sent backendSilentMessage and both connections are closed. It
+// returns nil when the proxy sets no first byte timeout.
+func startFirstByteTimer(cfg config.ProxyConfig, username string, connection *Connection, clientConn, remote net.Conn) *firstByteTimer {
+	if cfg.FirstByteTimeoutMs <= 0 {
+		return nil
+	}
+	ft := &firstByteTimer{}
+	ft.timer = time.AfterFunc(time.Duration(cfg.FirstByteTimeoutMs)*time.Millisecond, func() {
+		if !ft.state.CompareAndSwap(firstBytePending, firstByteExpired) {
+			return
+		}
+		log.Printf("[WARN] Remote server %s sent nothing for %s within %dms, disconnecting", cfg.Remote, username, cfg.FirstByteTimeoutMs)
+		if connection != nil {
+			connection.setEndReason("backend not responding")
+		}
+		// nothing was forwarded to the client yet, so the disconnect does
+		// not race with the server's data
+		writeTimeout, _ := getDisconnectTimeouts()
+		clientConn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := sendDisconnect(clientConn, backendSilentMessage); err != nil {
+			log.Printf("[DEBUG] Not sending a disconnect message to %s: %v", username, err)
+		}
+		remote.Close()
+		clientConn.Close()
+	})
+	return ft
+}
+
+// receive marks the first bytes from the server as arrived and reports
+// whether they may still be forwarded, which they may not once the timeout
+// disconnected the client
+func (ft *firstByteTimer) receive() bool {
+	if ft == nil {
+		return true
+	}
+	if ft.state.CompareAndSwap(firstBytePending, firstByteReceived) {
+		ft.timer.Stop()
+	}
+	return ft.state.Load() == firstByteReceived
+}
+
+// stop cancels the timeout
+func (ft *firstByteTimer) stop() {
+	if ft != nil {
+		ft.timer.Stop()
+	}
+}
+
+// backendLostMessage is shown to players whose backend dropped after the login
+const backendLostMessage = "Lost connection to the server, please reconnect"
+
+// disconnectInPlay shows backendLostMessage to a client past the login. Without
+// an injector, for BungeeCord switches, or when it can not write, the client
+// only sees the connection close.
+func disconnectInPlay(injector *packetInjector, protocol int, username string) {
+	if injector == nil {
+		return
+	}
+	packetID, payload, err := packPlayDisconnect(protocol, backendLostMessage)
+	if err == nil {
+		err = injector.Inject(packetID, payload)
+	}
+	if err != nil {
+		log.Printf("[DEBUG] Not sending a disconnect message to %s: %v", username, err)
+	}
+}
+
+// loginWatcher follows the server's login packets on their way to the client
+// and calls done once the login has finished, was refused, or can no longer be
+// followed because the server enabled encryption. success is only true when
+// the server's Login Success was seen.
+type loginWatcher struct {
+	client   io.Writer
+	frames   frameFilter
+	stream   streamState
+	finished bool
+	done     func(success bool)
+}
+
+// Write forwards data from the server to the client
+func (lw *loginWatcher) Write(p []byte) (int, error) {
+	if lw.finished {
+		return lw.client.Write(p)
+	}
+
+	out, err := lw.frames.filter(p, func(int) bool { return true }, lw.observe)
+	if err != nil {
+		lw.finish(false)
+	}
+	if len(out) > 0 {
+		if _, err := lw.client.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// observe checks a login packet from the server
+func (lw *loginWatcher) observe(body []byte) bool {
+	event, err := lw.stream.observe(body)
+	if err != nil {
+		lw.finish(false)
+		return true
+	}
+
+	switch event {
+	case streamRefused, streamEncrypted:
+		lw.finish(false)
+	case streamLoggedIn:
+		lw.finish(true)
+	}
+	return true
+}
+
+// finish stops following the stream and reports the end of the login
+func (lw *loginWatcher) finish(success bool) {
+	if lw.finished {
+		return
+	}
+	lw.finished = true
+	lw.frames.stop = true
+	lw.done(success)
+}
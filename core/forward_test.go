@@ -0,0 +1,740 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mcproxy/config"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubBackend replaces the backend dial with one that waits for delay and
+// returns a backend that accepts the handshake and login start, then hangs up
+func stubBackend(t *testing.T, delay time.Duration) {
+	t.Helper()
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		time.Sleep(delay)
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer backendSide.Close()
+			ReadPacket(backendSide)
+			ReadPacket(backendSide)
+		}()
+		return proxySide, nil
+	}
+}
+
+// runForward logs username in through handleForward and waits for it to return
+func runForward(t *testing.T, cfg config.ProxyConfig, username string) {
+	t.Helper()
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+
+	writeLoginStart(t, client, username)
+	client.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("handleForward: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleForward did not return")
+	}
+}
+
+func TestHandleForwardSlowConnectWarning(t *testing.T) {
+	var buf bytes.Buffer
+	origOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(origOutput)
+
+	stubBackend(t, 30*time.Millisecond)
+
+	cfg := config.ProxyConfig{
+		Listen:                 "127.0.0.1:40030",
+		Remote:                 "backend.example.com:25565",
+		Auth:                   "none",
+		SlowConnectThresholdMs: 10,
+	}
+	registerProxyStats(t, cfg)
+
+	runForward(t, cfg, "Steve")
+
+	out := buf.String()
+	if !strings.Contains(out, "[WARN] Slow connection for user Steve to backend.example.com:25565") {
+		t.Errorf("expected slow connection warning, got:\n%s", out)
+	}
+}
+
+func TestHandleForwardLogVerbosity(t *testing.T) {
+	var buf bytes.Buffer
+	origOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(origOutput)
+
+	stubBackend(t, 0)
+
+	cfg := config.ProxyConfig{
+		Listen:       "127.0.0.1:40033",
+		Remote:       "backend.example.com:25565",
+		Auth:         "none",
+		LogVerbosity: LogQuiet,
+	}
+	registerProxyStats(t, cfg)
+
+	runForward(t, cfg, "Steve")
+	if out := buf.String(); strings.Contains(out, "[INFO]") || strings.Contains(out, "[DEBUG]") {
+		t.Errorf("quiet proxy logged connection lines:\n%s", out)
+	}
+
+	for verbosity, want := range map[string][]string{
+		LogNormal:  {"[INFO] User login attempt: Steve", "[INFO] Starting data forwarding for user: Steve"},
+		LogVerbose: {"[INFO] User login attempt: Steve", "[DEBUG] Forwarded"},
+	} {
+		buf.Reset()
+		cfg.LogVerbosity = verbosity
+		runForward(t, cfg, "Steve")
+		out := buf.String()
+		for _, line := range want {
+			if !strings.Contains(out, line) {
+				t.Errorf("%s: missing %q in:\n%s", verbosity, line, out)
+			}
+		}
+		if verbosity == LogNormal && strings.Contains(out, "[DEBUG] Forwarded") {
+			t.Errorf("normal proxy logged byte counts:\n%s", out)
+		}
+	}
+}
+
+func TestHandleForwardKickDuplicateLogin(t *testing.T) {
+	stubBackend(t, 0)
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40031", Remote: "backend.example.com:25565", Auth: "none"}
+	registerProxyStats(t, cfg)
+
+	// Without the option the old session is kept
+	registerPipeConnection(t, "Alex", cfg.Listen)
+	runForward(t, cfg, "Alex")
+	if GetConnection("Alex") == nil {
+		t.Fatal("old session was disconnected without kick_duplicate_login")
+	}
+
+	cfg.KickDuplicateLogin = true
+	runForward(t, cfg, "alex")
+	if GetConnection("Alex") != nil {
+		t.Error("old session with the same username was not disconnected")
+	}
+
+	// Sessions on other proxies count against the player's limits too
+	registerPipeConnection(t, "Steve", "127.0.0.1:40032")
+	runForward(t, cfg, "steve")
+	if GetConnection("Steve") != nil {
+		t.Error("old session on another proxy was not disconnected")
+	}
+}
+
+func TestHandleForwardLoginTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	origOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(origOutput)
+
+	// the backend takes the handshake and login start but never answers
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer backendSide.Close()
+			ReadPacket(backendSide)
+			ReadPacket(backendSide)
+			io.Copy(io.Discard, backendSide)
+		}()
+		return proxySide, nil
+	}
+
+	cfg := config.ProxyConfig{
+		Listen:         "127.0.0.1:40070",
+		Remote:         "backend.example.com:25565",
+		Auth:           "none",
+		LoginTimeoutMs: 100,
+	}
+	registerProxyStats(t, cfg)
+	ctx, cancel := loginContext(cfg, time.Now())
+	defer cancel()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() { done <- handleForward(ctx, server, server, "", VERSION_1_18_2, cfg, nil) }()
+	writeLoginStart(t, client, "Steve")
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handleForward did not return after the login timeout")
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("client connection still open: %v", err)
+	}
+	if !strings.Contains(buf.String(), "[WARN] Login of Steve did not finish in time") {
+		t.Errorf("no login timeout warning in %q", buf.String())
+	}
+}
+
+func TestHandleForwardLoginSuccessStopsTimeout(t *testing.T) {
+	// the backend finishes the login and then stays quiet
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer backendSide.Close()
+			ReadPacket(backendSide)
+			ReadPacket(backendSide)
+			payload, _ := Pack(String("00000000-0000-0000-0000-000000000000"), String("Steve"), VarInt(0))
+			WritePacket(loginSuccess, payload, backendSide)
+			io.Copy(io.Discard, backendSide)
+		}()
+		return proxySide, nil
+	}
+
+	cfg := config.ProxyConfig{
+		Listen:         "127.0.0.1:40071",
+		Remote:         "backend.example.com:25565",
+		Auth:           "none",
+		LoginTimeoutMs: 100,
+	}
+	registerProxyStats(t, cfg)
+	ctx, cancel := loginContext(cfg, time.Now())
+	defer cancel()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() { done <- handleForward(ctx, server, server, "", VERSION_1_18_2, cfg, nil) }()
+	writeLoginStart(t, client, "Steve")
+
+	if pkt, err := ReadPacket(client); err != nil || pkt.ID != loginSuccess {
+		t.Fatalf("got packet 0x%02X, %v", pkt.ID, err)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("connection closed after the login finished")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestHandleForwardEstablishedAfterLoginSuccess(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	origOutput := log.Writer()
+	t.Cleanup(func() { log.SetOutput(origOutput) })
+
+	for _, success := range []bool{true, false} {
+		// the backend answers the login once released, then hangs up
+		loggedIn := make(chan struct{})
+		release := make(chan struct{})
+		dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+			proxySide, backendSide := net.Pipe()
+			go func() {
+				defer backendSide.Close()
+				ReadPacket(backendSide)
+				ReadPacket(backendSide)
+				close(loggedIn)
+				<-release
+				if success {
+					payload, _ := Pack(String("00000000-0000-0000-0000-000000000000"), String("Steve"), VarInt(0))
+					WritePacket(loginSuccess, payload, backendSide)
+				} else {
+					payload, _ := Pack(String(`{"text":"banned"}`))
+					WritePacket(loginDisconnect, payload, backendSide)
+				}
+			}()
+			return proxySide, nil
+		}
+
+		cfg := config.ProxyConfig{Listen: "127.0.0.1:40153", Remote: "backend.example.com:25565", Auth: "none"}
+		registerProxyStats(t, cfg)
+		conn := &Connection{ID: "conn-established", ClientAddr: "pipe", ProxyAddr: cfg.Listen, ConnectedAt: time.Now()}
+		RegisterConnection(conn)
+
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+
+		client, server := net.Pipe()
+		done := make(chan error, 1)
+		go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+		writeLoginStart(t, client, "Steve")
+
+		select {
+		case <-loggedIn:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the login did not reach the backend")
+		}
+		if !conn.EstablishedAt().IsZero() {
+			t.Error("established before the backend answered the login")
+		}
+
+		close(release)
+		if pkt, err := ReadPacket(client); err != nil {
+			t.Fatal(err)
+		} else if success && pkt.ID != loginSuccess || !success && pkt.ID != loginDisconnect {
+			t.Fatalf("got packet 0x%02X", pkt.ID)
+		}
+		established := !conn.EstablishedAt().IsZero()
+		client.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handleForward did not return")
+		}
+		log.SetOutput(origOutput)
+		UnregisterConnection(conn.ID)
+
+		if established != success {
+			t.Errorf("login success %v: established = %v", success, established)
+		}
+		if logged := strings.Contains(buf.String(), "Session established for user Steve"); logged != success {
+			t.Errorf("login success %v: established logged = %v:\n%s", success, logged, buf.String())
+		}
+	}
+}
+
+func TestHandleForwardFirstByteTimeout(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+
+	// the backend accepts the login but never answers it
+	backendClosed := make(chan struct{})
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer close(backendClosed)
+			io.Copy(io.Discard, backendSide)
+		}()
+		return proxySide, nil
+	}
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40154", Remote: "backend.example.com:25565", Auth: "none", FirstByteTimeoutMs: 50}
+	registerProxyStats(t, cfg)
+	conn := &Connection{ID: "conn-first-byte", ClientAddr: "pipe", ProxyAddr: cfg.Listen, ConnectedAt: time.Now()}
+	RegisterConnection(conn)
+	t.Cleanup(func() { UnregisterConnection(conn.ID) })
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+	writeLoginStart(t, client, "Steve")
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if reason := readDisconnect(t, client); !strings.Contains(reason, backendSilentMessage) {
+		t.Errorf("disconnect reason = %s", reason)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleForward did not return")
+	}
+	select {
+	case <-backendClosed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the backend connection was not closed")
+	}
+	if reason := conn.endReason(); reason != "backend not responding" {
+		t.Errorf("end reason = %q", reason)
+	}
+}
+
+func TestHandleForwardUsernamePolicy(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	loginStarts := make(chan string, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer backendSide.Close()
+			ReadPacket(backendSide)
+			pkt, _ := ReadPacket(backendSide)
+			var name String
+			pkt.Scan(&name)
+			loginStarts <- string(name)
+		}()
+		return proxySide, nil
+	}
+
+	const evil = "Steve\n<b>x</b>"
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40156", Remote: "backend.example.com:25565", Auth: "none"}
+	stats := registerProxyStats(t, cfg)
+	conn := &Connection{ID: "conn-username", ClientAddr: "pipe", ProxyAddr: cfg.Listen, ConnectedAt: time.Now()}
+	RegisterConnection(conn)
+	t.Cleanup(func() { UnregisterConnection(conn.ID) })
+
+	// the backend gets the name the proxy checked and shows, sanitize is the
+	// default
+	for _, tt := range []struct {
+		policy string
+		want   string
+	}{
+		{"", "Steve??b?x??b?"},
+		{UsernamePolicySanitize, "Steve??b?x??b?"},
+		{UsernamePolicyAllow, evil},
+	} {
+		// a known username would make the login look like a BungeeCord switch
+		activeConnections.Lock()
+		conn.Username = ""
+		activeConnections.Unlock()
+		cfg.UsernamePolicy = tt.policy
+		client, server := net.Pipe()
+		done := make(chan error, 1)
+		go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+		writeLoginStart(t, client, evil)
+		select {
+		case name := <-loginStarts:
+			if name != tt.want {
+				t.Errorf("policy %q: backend got username %q, want %q", tt.policy, name, tt.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("policy %q: the login did not reach the backend", tt.policy)
+		}
+		activeConnections.RLock()
+		shown := conn.Username
+		activeConnections.RUnlock()
+		if shown != tt.want {
+			t.Errorf("policy %q: username = %q, want %q", tt.policy, shown, tt.want)
+		}
+		client.Close()
+		<-done
+	}
+
+	// rejected names never reach the backend
+	cfg.UsernamePolicy = UsernamePolicyReject
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+	writeLoginStart(t, client, evil)
+	if reason := readDisconnect(t, client); !strings.Contains(reason, invalidUsernameMessage) {
+		t.Errorf("reason = %s", reason)
+	}
+	select {
+	case name := <-loginStarts:
+		t.Errorf("rejected username %q reached the backend", name)
+	case <-done:
+	}
+	if n := stats.Rejections.InvalidUsername.Load(); n != 1 {
+		t.Errorf("invalid username rejections = %d, want 1", n)
+	}
+}
+
+// resetConn turns the end of the backend's stream into a connection reset
+type resetConn struct {
+	net.Conn
+}
+
+func (c resetConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err == io.EOF {
+		err = errors.New("connection reset by peer")
+	}
+	return n, err
+}
+
+func TestHandleForwardNoLoginReplayAfterLogin(t *testing.T) {
+	// a reconnect would dial the remote and replay the login there
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var redialed atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			redialed.Add(1)
+			conn.Close()
+		}
+	}()
+
+	// the backend finishes the login and drops the connection
+	origDial := dialRemote
+	defer func() { dialRemote = origDial }()
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer backendSide.Close()
+			ReadPacket(backendSide)
+			ReadPacket(backendSide)
+			payload, _ := Pack(String("Steve"))
+			WritePacket(loginSuccess, payload, backendSide)
+		}()
+		return resetConn{proxySide}, nil
+	}
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40031", Remote: ln.Addr().String(), Auth: "none", AutoReconnect: true}
+	registerProxyStats(t, cfg)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+
+	writeLoginStart(t, client, "Steve")
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if pkt, err := ReadPacket(client); err != nil || pkt.ID != loginSuccess {
+		t.Fatalf("expected the login success, got %v", err)
+	}
+	if reason := readDisconnect(t, client); !strings.Contains(reason, backendLostMessage) {
+		t.Errorf("disconnect reason = %s", reason)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleForward did not return")
+	}
+	if n := redialed.Load(); n != 0 {
+		t.Errorf("backend redialed %d times after the login", n)
+	}
+}
+
+func TestHandleForwardMaxConcurrentLogins(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+
+	var inFlight, maxInFlight, dials atomic.Int32
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		dials.Add(1)
+		n := inFlight.Add(1)
+		for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+		}
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer backendSide.Close()
+			ReadPacket(backendSide)
+			ReadPacket(backendSide)
+			time.Sleep(20 * time.Millisecond)
+			inFlight.Add(-1)
+		}()
+		return proxySide, nil
+	}
+
+	cfg := config.ProxyConfig{
+		Listen:              "127.0.0.1:40036",
+		Remote:              "backend.example.com:25565",
+		Auth:                "none",
+		MaxConcurrentLogins: 2,
+		LoginQueueMs:        5000,
+	}
+	registerProxyStats(t, cfg)
+
+	const logins = 8
+	done := make(chan error, logins)
+	for i := 0; i < logins; i++ {
+		client, server := net.Pipe()
+		go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+		writeLoginStart(t, client, "Steve")
+		client.Close()
+	}
+	for i := 0; i < logins; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("handleForward: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("handleForward did not return")
+		}
+	}
+
+	if n := dials.Load(); n != logins {
+		t.Errorf("backend dialed %d times, want %d", n, logins)
+	}
+	if n := maxInFlight.Load(); n > 2 {
+		t.Errorf("%d logins in flight at once, limit is 2", n)
+	}
+
+	// without a queue, logins beyond the limit are turned away
+	cfg.MaxConcurrentLogins = 1
+	cfg.LoginQueueMs = 0
+	release, ok := acquireLoginSlot(context.Background(), cfg)
+	if !ok {
+		t.Fatal("no free login slot")
+	}
+	defer release()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil)
+	writeLoginStart(t, client, "Alex")
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if reason := readDisconnect(t, client); !strings.Contains(reason, busyMessage) {
+		t.Errorf("disconnect reason = %s", reason)
+	}
+	if n := dials.Load(); n != logins {
+		t.Errorf("busy login dialed the backend")
+	}
+}
+
+func TestHandleForwardAutoReconnect(t *testing.T) {
+	// redials land on this backend, which reports the replayed login start
+	// and hangs up
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var redialed atomic.Int32
+	replayed := make(chan []byte, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			redialed.Add(1)
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			ReadPacket(conn)
+			if pkt, err := ReadPacket(conn); err == nil {
+				replayed <- pkt.Payload
+			}
+			conn.Close()
+		}
+	}()
+
+	// 1.19 and newer send the player UUID after the name, the replay must keep it
+	loginStart, err := Pack(String("Steve"), Long(0x0123456789abcdef), Long(0x7edcba9876543210))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first backend drops the connection during the login
+	origDial := dialRemote
+	defer func() { dialRemote = origDial }()
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer backendSide.Close()
+			ReadPacket(backendSide)
+			ReadPacket(backendSide)
+		}()
+		return resetConn{proxySide}, nil
+	}
+
+	for _, autoReconnect := range []bool{false, true} {
+		redialed.Store(0)
+		cfg := config.ProxyConfig{Listen: "127.0.0.1:40037", Remote: ln.Addr().String(), Auth: "none", AutoReconnect: autoReconnect}
+		registerProxyStats(t, cfg)
+
+		// the session ends without the client hanging up
+		client, server := net.Pipe()
+		done := make(chan error, 1)
+		go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+		if err := WritePacket(0x00, loginStart, client); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("auto_reconnect %v: handleForward did not return", autoReconnect)
+		}
+		client.Close()
+
+		if autoReconnect {
+			waitForCount(t, &redialed, 1)
+			select {
+			case payload := <-replayed:
+				if !bytes.Equal(payload, loginStart) {
+					t.Errorf("replayed login start = %x, want %x", payload, loginStart)
+				}
+			case <-time.After(5 * time.Second):
+				t.Error("the login start was not replayed")
+			}
+		} else if n := redialed.Load(); n != 0 {
+			t.Errorf("backend redialed %d times with auto_reconnect off", n)
+		}
+	}
+}
+
+func TestHandleForwardHandshakeConnectionID(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	addresses := make(chan string, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		proxySide, backendSide := net.Pipe()
+		go func() {
+			defer backendSide.Close()
+			pkt, err := ReadPacket(backendSide)
+			if err != nil {
+				return
+			}
+			var (
+				protocol VarInt
+				address  String
+			)
+			pkt.Scan(&protocol, &address)
+			addresses <- string(address)
+			ReadPacket(backendSide)
+		}()
+		return proxySide, nil
+	}
+
+	for _, tc := range []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"enabled", "\x00mcproxy-id={id}", "backend.example\x00FML2\x00\x00mcproxy-id=conn-42"},
+		{"disabled", "", "backend.example\x00FML2\x00"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.ProxyConfig{Listen: "127.0.0.1:40034", Remote: "127.0.0.1:1", Auth: "none",
+				RewirteHost: "backend.example", RewirtePort: 25565, HandshakeConnectionID: tc.template}
+			registerProxyStats(t, cfg)
+
+			// handleForward finds the connection by the client's address
+			RegisterConnection(&Connection{ID: "conn-42", ClientAddr: "pipe", ConnectedAt: time.Now()})
+			t.Cleanup(func() { UnregisterConnection("conn-42") })
+
+			client, server := net.Pipe()
+			done := make(chan error, 1)
+			go func() {
+				done <- handleForward(context.Background(), server, server, "\x00FML2\x00", VERSION_1_18_2, cfg, nil)
+			}()
+			writeLoginStart(t, client, "Steve")
+
+			select {
+			case address := <-addresses:
+				if address != tc.want {
+					t.Errorf("handshake address = %q, want %q", address, tc.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no handshake reached the backend")
+			}
+			client.Close()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handleForward did not return")
+			}
+		})
+	}
+}
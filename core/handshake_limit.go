@@ -0,0 +1,128 @@
+package core
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHandshakeRateWindow = 10 * time.Second
+	defaultHandshakeBlock      = time.Minute
+)
+
+// handshakeLimiter blocks client IPs that send more handshakes within a
+// sliding window than the limit, dropping their connections before the login
+type handshakeLimiter struct {
+	sync.Mutex
+	limit     int // Handshakes per window, 0 = disabled
+	window    time.Duration
+	block     time.Duration
+	recent    map[string][]time.Time // Handshakes within the window by IP, oldest first
+	blocked   map[string]time.Time   // Blocked IPs and when the block ends
+	lastSweep time.Time
+}
+
+var handshakeRateLimit = &handshakeLimiter{}
+
+// SetHandshakeRateLimit configures the handshake rate limit. A window or
+// block of zero uses the defaults of ten seconds and one minute.
+func SetHandshakeRateLimit(limit int, window, block time.Duration) {
+	if window <= 0 {
+		window = defaultHandshakeRateWindow
+	}
+	if block <= 0 {
+		block = defaultHandshakeBlock
+	}
+
+	handshakeRateLimit.Lock()
+	defer handshakeRateLimit.Unlock()
+	handshakeRateLimit.limit = limit
+	handshakeRateLimit.window = window
+	handshakeRateLimit.block = block
+	if limit <= 0 {
+		handshakeRateLimit.recent = nil
+		handshakeRateLimit.blocked = nil
+	}
+}
+
+// allow counts a handshake from ip at now and reports whether its connection
+// may continue. The IP is blocked once it exceeds the limit.
+func (h *handshakeLimiter) allow(ip string, now time.Time) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.limit <= 0 {
+		return true
+	}
+	if h.recent == nil {
+		h.recent = make(map[string][]time.Time)
+		h.blocked = make(map[string]time.Time)
+	}
+	if now.Sub(h.lastSweep) >= h.window {
+		h.sweep(now)
+	}
+
+	if until, ok := h.blocked[ip]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(h.blocked, ip)
+	}
+
+	times := h.recent[ip]
+	start := 0
+	for start < len(times) && now.Sub(times[start]) >= h.window {
+		start++
+	}
+	times = append(times[start:], now)
+	if len(times) <= h.limit {
+		h.recent[ip] = times
+		return true
+	}
+
+	delete(h.recent, ip)
+	h.blocked[ip] = now.Add(h.block)
+	log.Printf("[WARN] Blocking %s for %v: %d handshakes within %v (limit %d)", ip, h.block, len(times), h.window, h.limit)
+	return false
+}
+
+// sweep forgets the IPs without handshakes in the window and expired blocks
+func (h *handshakeLimiter) sweep(now time.Time) {
+	h.lastSweep = now
+	for ip, times := range h.recent {
+		if now.Sub(times[len(times)-1]) >= h.window {
+			delete(h.recent, ip)
+		}
+	}
+	for ip, until := range h.blocked {
+		if !now.Before(until) {
+			delete(h.blocked, ip)
+		}
+	}
+}
+
+// HandshakeBlock is a client IP blocked for exceeding the handshake rate limit
+type HandshakeBlock struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// HandshakeBlocks returns the IPs currently blocked by the handshake rate
+// limit, sorted by IP
+func HandshakeBlocks() []HandshakeBlock {
+	h := handshakeRateLimit
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	blocks := make([]HandshakeBlock, 0, len(h.blocked))
+	for ip, until := range h.blocked {
+		if now.Before(until) {
+			blocks = append(blocks, HandshakeBlock{IP: ip, Until: until})
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].IP < blocks[j].IP })
+	return blocks
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"mcproxy/config"
+	"testing"
+	"time"
+)
+
+func TestHandshakeLimiterWindow(t *testing.T) {
+	h := &handshakeLimiter{limit: 3, window: 10 * time.Second, block: time.Minute}
+	start := time.Now()
+
+	// handshakes spread over more than the window never add up to a block
+	for i := 0; i < 6; i++ {
+		if !h.allow("203.0.113.1", start.Add(time.Duration(i)*4*time.Second)) {
+			t.Fatalf("handshake %d blocked below the rate", i+1)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if !h.allow("203.0.113.2", start) {
+			t.Fatalf("handshake %d of the burst blocked", i+1)
+		}
+	}
+	if h.allow("203.0.113.2", start) {
+		t.Fatal("handshake over the limit allowed")
+	}
+	if h.allow("203.0.113.2", start.Add(30*time.Second)) {
+		t.Error("blocked IP allowed before the block ended")
+	}
+	if !h.allow("203.0.113.3", start) {
+		t.Error("another IP was blocked")
+	}
+	if !h.allow("203.0.113.2", start.Add(time.Minute)) {
+		t.Error("IP still blocked after the block ended")
+	}
+}
+
+func TestHandlerHandshakeRateLimit(t *testing.T) {
+	SetHandshakeRateLimit(3, time.Minute, time.Minute)
+	defer SetHandshakeRateLimit(0, 0, 0)
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40140", PingMode: "fake", MaxPlayer: 20, Auth: "none"}
+	stats := registerProxyStats(t, cfg)
+
+	// ping reports whether a status request over a new connection was answered
+	ping := func() bool {
+		client, server := tcpPair(t)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler(server, cfg, 0, nil)
+		}()
+		defer func() {
+			client.Close()
+			<-done
+		}()
+
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 1)
+		if err := WritePacket(0x00, []byte{}, client); err != nil {
+			t.Fatal(err)
+		}
+		pkt, err := ReadPacket(client)
+		return err == nil && pkt.ID == 0x00
+	}
+
+	for i := 0; i < 3; i++ {
+		if !ping() {
+			t.Fatalf("ping %d of the burst not answered", i+1)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if ping() {
+			t.Errorf("ping %d over the limit answered", i+1)
+		}
+	}
+	if n := stats.Rejections.HandshakeRate.Load(); n != 2 {
+		t.Errorf("handshake rate rejections = %d, want 2", n)
+	}
+
+	blocks := HandshakeBlocks()
+	if len(blocks) != 1 || blocks[0].IP != "127.0.0.1" || !blocks[0].Until.After(time.Now()) {
+		t.Errorf("blocks = %+v, want 127.0.0.1", blocks)
+	}
+}
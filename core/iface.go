@@ -0,0 +1,63 @@
+package core
+
+import (
+	"fmt"
+	"net"
+)
+
+// interfaceAddrs returns the addresses of the named network interface. It is a
+// variable so tests can stub the lookup.
+var interfaceAddrs = func(name string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return iface.Addrs()
+}
+
+// resolveInterfaceAddr turns "name:port" into "ip:port" when name is a network
+// interface, using the interface's current address. Literal IPs, empty hosts
+// and host names that are not interfaces are returned unchanged.
+func resolveInterfaceAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	addrs, err := interfaceAddrs(host)
+	if err != nil {
+		// Not an interface, let the caller resolve it as a host name
+		return addr, nil
+	}
+
+	ip := pickInterfaceIP(addrs)
+	if ip == nil {
+		return "", fmt.Errorf("interface %s has no usable address", host)
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// pickInterfaceIP picks the first IPv4 address, falling back to the first
+// IPv6 address that is not link-local
+func pickInterfaceIP(addrs []net.Addr) net.IP {
+	var v6 net.IP
+	for _, a := range addrs {
+		var ip net.IP
+		switch v := a.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
+		}
+		if ip == nil || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			continue
+		}
+		if ip.To4() != nil {
+			return ip
+		}
+		if v6 == nil {
+			v6 = ip
+		}
+	}
+	return v6
+}
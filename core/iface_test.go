@@ -0,0 +1,124 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+func stubInterfaceAddrs(t *testing.T, ifaces map[string][]net.Addr) {
+	t.Helper()
+	orig := interfaceAddrs
+	interfaceAddrs = func(name string) ([]net.Addr, error) {
+		addrs, ok := ifaces[name]
+		if !ok {
+			return nil, errors.New("no such network interface")
+		}
+		return addrs, nil
+	}
+	t.Cleanup(func() { interfaceAddrs = orig })
+}
+
+func ipNet(s string) *net.IPNet {
+	return &net.IPNet{IP: net.ParseIP(s), Mask: net.CIDRMask(24, 32)}
+}
+
+func TestResolveInterfaceAddr(t *testing.T) {
+	stubInterfaceAddrs(t, map[string][]net.Addr{
+		"eth1":  {ipNet("fe80::1"), ipNet("10.0.0.5"), ipNet("10.0.0.6")},
+		"v6":    {ipNet("fe80::1"), ipNet("2001:db8::2")},
+		"empty": {ipNet("fe80::1")},
+	})
+
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "eth1:25565", want: "10.0.0.5:25565"},
+		{in: "v6:0", want: "[2001:db8::2]:0"},
+		{in: "127.0.0.1:25565", want: "127.0.0.1:25565"},
+		{in: ":25565", want: ":25565"},
+		{in: "localhost:25565", want: "localhost:25565"},
+		{in: "empty:25565", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveInterfaceAddr(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveInterfaceAddr(%q) = %q, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolveInterfaceAddr(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestDialMCLocalInterface(t *testing.T) {
+	stubInterfaceAddrs(t, map[string][]net.Addr{"lo-test": {ipNet("127.0.0.1")}})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := DialMC(ln.Addr().String(), "lo-test:0")
+	if err != nil {
+		t.Fatalf("DialMC: %v", err)
+	}
+	defer conn.Close()
+
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("local IP = %v, want 127.0.0.1", ip)
+	}
+}
+
+func TestDialMCResolveViaLocalAddr(t *testing.T) {
+	stubInterfaceAddrs(t, map[string][]net.Addr{"lo-test": {ipNet("127.0.0.1")}})
+
+	var mu sync.Mutex
+	var sources []net.Addr
+	orig := resolverDial
+	resolverDial = func(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+		mu.Lock()
+		sources = append(sources, dialer.LocalAddr)
+		mu.Unlock()
+		return nil, errors.New("stub resolver")
+	}
+	defer func() { resolverDial = orig }()
+
+	// without the option the default resolver is used
+	if _, err := dialMC("backend.invalid:25565", "lo-test:0", false); err == nil {
+		t.Fatal("expected the dial to fail")
+	}
+	if len(sources) != 0 {
+		t.Fatalf("bound resolver used without the option: %v", sources)
+	}
+
+	// both the SRV and the A/AAAA lookups go through the bound resolver
+	if _, err := dialMC("backend.invalid", "lo-test:0", true); err == nil {
+		t.Fatal("expected the dial to fail")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sources) < 2 {
+		t.Fatalf("got %d DNS dials, want SRV and address lookups", len(sources))
+	}
+	for _, addr := range sources {
+		var ip net.IP
+		switch a := addr.(type) {
+		case *net.UDPAddr:
+			ip = a.IP
+		case *net.TCPAddr:
+			ip = a.IP
+		}
+		if !ip.Equal(net.ParseIP("127.0.0.1")) {
+			t.Errorf("DNS query sent from %v, want 127.0.0.1", addr)
+		}
+	}
+}
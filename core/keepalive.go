@@ -0,0 +1,464 @@
+package core
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// keepAlivePacketIDs maps protocol ranges to the play state keep-alive packet
+// IDs. Keep this sorted by protocol number when adding new versions.
+//
+// Only versions that go straight from login to play are listed, 1.20.2+ adds a
+// configuration phase that the proxy can re-enter at any time, so injecting
+// there is not safe without tracking every phase change.
+var keepAlivePacketIDs = []struct {
+	minProtocol, maxProtocol int
+	clientbound, serverbound int
+}{
+	{340, 340, 0x1F, 0x0B}, // 1.12.2
+	{393, 404, 0x21, 0x0E}, // 1.13 - 1.13.2
+	{477, 498, 0x20, 0x0F}, // 1.14 - 1.14.4
+	{573, 578, 0x21, 0x0F}, // 1.15 - 1.15.2
+	{735, 736, 0x20, 0x10}, // 1.16 - 1.16.1
+	{751, 754, 0x1F, 0x10}, // 1.16.2 - 1.16.5
+	{755, 758, 0x21, 0x0F}, // 1.17 - 1.18.2
+	{759, 759, 0x1E, 0x11}, // 1.19
+	{760, 760, 0x20, 0x12}, // 1.19.1 - 1.19.2
+	{761, 761, 0x1F, 0x11}, // 1.19.3
+	{762, 763, 0x23, 0x12}, // 1.19.4 - 1.20.1
+}
+
+// lookupKeepAliveIDs returns the play state keep-alive packet IDs of a protocol
+func lookupKeepAliveIDs(protocol int) (clientbound, serverbound int, ok bool) {
+	for _, ids := range keepAlivePacketIDs {
+		if protocol >= ids.minProtocol && protocol <= ids.maxProtocol {
+			return ids.clientbound, ids.serverbound, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Login state packets sent by the server
+const (
+	loginDisconnect        = 0x00
+	loginEncryptionRequest = 0x01
+	loginSuccess           = 0x02
+	loginSetCompression    = 0x03
+)
+
+// maxFrameLength is the largest frame allowed by the 3 byte length prefix
+const maxFrameLength = 1<<21 - 1
+
+// maxKeepAliveFrame is the largest frame that can hold a serverbound
+// keep-alive, smaller frames are held back until complete so they can be
+// inspected
+const maxKeepAliveFrame = 16
+
+// maxInspectedLength is how much of a compressed packet is decompressed to read its ID
+const maxInspectedLength = 64
+
+// maxWatchedFrame is the largest play state frame inspected for a kick, kick
+// reasons are far shorter
+const maxWatchedFrame = 8 * 1024
+
+// maxPendingKeepAlives stops injecting when the client does not answer
+const maxPendingKeepAlives = 16
+
+// injectedKeepAliveBase is the first ID of injected keep-alives. Vanilla
+// servers use the current time in milliseconds, so the ranges never overlap.
+const injectedKeepAliveBase = 0x4D435058 << 32 // "MCPX"
+
+var errBadFrame = errors.New("bad frame length")
+
+// frameFilter splits a packet stream into frames as it passes through. Frames
+// selected by hold are buffered until complete and only written if keep
+// accepts their body, all other frames are passed through unchanged.
+type frameFilter struct {
+	header    []byte
+	remaining int
+	held      []byte
+	bodyStart int // offset of the body in held
+	holding   bool
+	stop      bool // set by keep when the rest of the stream must not be parsed
+}
+
+// atBoundary reports whether everything written so far ends on a frame boundary
+func (f *frameFilter) atBoundary() bool {
+	return len(f.header) == 0 && f.remaining == 0
+}
+
+// filter returns the bytes of p that should be forwarded. On error the
+// remaining bytes are returned unparsed.
+func (f *frameFilter) filter(p []byte, hold func(length int) bool, keep func(body []byte) bool) ([]byte, error) {
+	out := make([]byte, 0, len(p))
+	for len(p) > 0 {
+		if f.stop {
+			return append(out, p...), nil
+		}
+
+		if f.remaining == 0 {
+			b := p[0]
+			p = p[1:]
+			f.header = append(f.header, b)
+			if b&0x80 != 0 {
+				if len(f.header) >= 3 {
+					return append(append(out, f.flush()...), p...), errBadFrame
+				}
+				continue
+			}
+
+			var length VarInt
+			length.ReadFrom(bytes.NewReader(f.header))
+			if length <= 0 || length > maxFrameLength {
+				return append(append(out, f.flush()...), p...), errBadFrame
+			}
+
+			f.remaining = int(length)
+			f.holding = hold(int(length))
+			if f.holding {
+				f.held = append(f.held[:0], f.header...)
+				f.bodyStart = len(f.header)
+			} else {
+				out = append(out, f.header...)
+			}
+			f.header = f.header[:0]
+			continue
+		}
+
+		n := min(f.remaining, len(p))
+		f.remaining -= n
+		if !f.holding {
+			out = append(out, p[:n]...)
+			p = p[n:]
+			continue
+		}
+
+		f.held = append(f.held, p[:n]...)
+		p = p[n:]
+		if f.remaining == 0 {
+			if keep(f.held[f.bodyStart:]) {
+				out = append(out, f.held...)
+			}
+			f.held = f.held[:0]
+		}
+	}
+	return out, nil
+}
+
+// flush returns bytes held for an incomplete frame
+func (f *frameFilter) flush() []byte {
+	out := append(f.header, f.held...)
+	f.header, f.held = nil, nil
+	f.remaining = 0
+	return out
+}
+
+// readPacketID returns the ID and payload of a frame body, the payload of a
+// compressed packet is truncated to maxInspectedLength
+func readPacketID(body []byte, compressed bool) (int, []byte, error) {
+	r := bytes.NewReader(body)
+	if compressed {
+		var dataLength VarInt
+		if _, err := dataLength.ReadFrom(r); err != nil {
+			return 0, nil, err
+		}
+		if dataLength > 0 {
+			zr, err := zlib.NewReader(r)
+			if err != nil {
+				return 0, nil, err
+			}
+			// only the start of the packet is inspected, so large packets are
+			// not decompressed in full
+			data, err := io.ReadAll(io.LimitReader(zr, int64(min(dataLength, maxInspectedLength))))
+			if err != nil {
+				return 0, nil, err
+			}
+			r = bytes.NewReader(data)
+		}
+	}
+
+	var id VarInt
+	if _, err := id.ReadFrom(r); err != nil {
+		return 0, nil, err
+	}
+	payload, _ := io.ReadAll(r)
+	return int(id), payload, nil
+}
+
+// keepAliveInjector watches both directions of a forwarded login and writes a
+// clientbound keep-alive to the client when nothing has been sent either way
+// for the interval. The client's answers to injected keep-alives are dropped so
+// the backend never sees an ID it did not ask for.
+//
+// Injection stops for good once the backend enables encryption, since the
+// stream can no longer be read.
+type keepAliveInjector struct {
+	client      io.Writer
+	username    string
+	interval    time.Duration
+	clientbound int
+	serverbound int
+
+	mutex    sync.Mutex // guards writes to the client and the clientbound state
+	toClient frameFilter
+	stream   streamState
+	disabled atomic.Bool
+	kickID   int // play state Disconnect packet ID watched for, -1 if not watched
+	kicked   atomic.Bool
+
+	toServer frameFilter // only used by the client to server goroutine
+
+	pendingMutex sync.Mutex
+	pending      map[int64]struct{}
+	nextID       int64
+
+	lastActivity atomic.Int64
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// newKeepAliveInjector returns an injector for the protocol, or nil if the
+// protocol's keep-alive packets are unknown
+func newKeepAliveInjector(client io.Writer, protocol int, interval time.Duration, username string) *keepAliveInjector {
+	clientbound, serverbound, ok := lookupKeepAliveIDs(protocol)
+	if !ok {
+		log.Printf("[DEBUG] Keep-alive injection not supported for protocol %s, user %s", ProtocolName(protocol), username)
+		return nil
+	}
+
+	k := &keepAliveInjector{
+		client:      client,
+		username:    username,
+		interval:    interval,
+		clientbound: clientbound,
+		serverbound: serverbound,
+		pending:     make(map[int64]struct{}),
+		nextID:      injectedKeepAliveBase,
+		kickID:      -1,
+		done:        make(chan struct{}),
+	}
+	k.lastActivity.Store(time.Now().UnixNano())
+	go k.run()
+	return k
+}
+
+// Write forwards data from the server to the client
+func (k *keepAliveInjector) Write(p []byte) (int, error) {
+	k.lastActivity.Store(time.Now().UnixNano())
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if !k.disabled.Load() {
+		out, err := k.toClient.filter(p, k.holdClientbound, k.observeClientbound)
+		if err != nil {
+			k.disable(fmt.Sprintf("unreadable server stream: %v", err))
+		}
+		if len(out) > 0 {
+			if _, err := k.client.Write(out); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+
+	if held := k.toClient.flush(); len(held) > 0 {
+		if _, err := k.client.Write(held); err != nil {
+			return 0, err
+		}
+	}
+	return k.client.Write(p)
+}
+
+// watchKicks makes the injector note when the backend disconnects the client
+// in the play state, see kicked
+func (k *keepAliveInjector) watchKicks(protocol int) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if id, ok := lookupPlayDisconnectID(protocol); ok {
+		k.kickID = id
+	}
+}
+
+// holdClientbound buffers every frame until the login has finished, and the
+// frames that may be a kick after it
+func (k *keepAliveInjector) holdClientbound(length int) bool {
+	return !k.stream.loggedIn() || (k.kickID >= 0 && length <= maxWatchedFrame)
+}
+
+// observeClientbound follows the login state of the server stream
+func (k *keepAliveInjector) observeClientbound(body []byte) bool {
+	if k.stream.loggedIn() {
+		if id, _, err := readPacketID(body, k.stream.compressed()); err == nil && id == k.kickID {
+			k.kicked.Store(true)
+		}
+		return true
+	}
+
+	event, err := k.stream.observe(body)
+	if err != nil {
+		k.stopClientbound(err.Error())
+		return true
+	}
+	if event == streamEncrypted {
+		k.stopClientbound("backend enabled encryption")
+	}
+	return true
+}
+
+// stopClientbound disables injection from within the server stream filter,
+// leaving the rest of the data unparsed
+func (k *keepAliveInjector) stopClientbound(reason string) {
+	k.toClient.stop = true
+	k.disable(reason)
+}
+
+// FilterServerbound returns the client's data with answers to injected
+// keep-alives removed
+func (k *keepAliveInjector) FilterServerbound(p []byte) []byte {
+	k.lastActivity.Store(time.Now().UnixNano())
+
+	if k.disabled.Load() {
+		if held := k.toServer.flush(); len(held) > 0 {
+			return append(held, p...)
+		}
+		return p
+	}
+
+	out, err := k.toServer.filter(p, k.holdServerbound, k.keepServerbound)
+	if err != nil {
+		k.disable(fmt.Sprintf("unreadable client stream: %v", err))
+	}
+	return out
+}
+
+// holdServerbound buffers frames small enough to be a keep-alive
+func (k *keepAliveInjector) holdServerbound(length int) bool {
+	return length <= maxKeepAliveFrame
+}
+
+// keepServerbound drops the client's answers to injected keep-alives
+func (k *keepAliveInjector) keepServerbound(body []byte) bool {
+	id, payload, err := readPacketID(body, k.stream.compressed())
+	if err != nil || id != k.serverbound || len(payload) != 8 {
+		return true
+	}
+
+	var keepAliveID Long
+	if _, err := keepAliveID.ReadFrom(bytes.NewReader(payload)); err != nil {
+		return true
+	}
+
+	k.pendingMutex.Lock()
+	defer k.pendingMutex.Unlock()
+	if _, ok := k.pending[int64(keepAliveID)]; !ok {
+		return true
+	}
+	delete(k.pending, int64(keepAliveID))
+	return false
+}
+
+// run checks for idle connections until the injector is closed
+func (k *keepAliveInjector) run() {
+	ticker := time.NewTicker(k.interval / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.done:
+			return
+		case now := <-ticker.C:
+			k.injectIfIdle(now)
+		}
+	}
+}
+
+// injectIfIdle writes a keep-alive to the client if the connection has been
+// idle for the interval and the server stream is between packets
+func (k *keepAliveInjector) injectIfIdle(now time.Time) {
+	if now.Sub(time.Unix(0, k.lastActivity.Load())) < k.interval {
+		return
+	}
+
+	if err := k.inject(now); err == nil {
+		log.Printf("[DEBUG] Injected keep-alive for idle user %s", k.username)
+	}
+}
+
+// canInject reports whether keep-alives can be written to the client, which
+// needs the play state and the server stream to be between packets
+func (k *keepAliveInjector) canInject() bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return !k.disabled.Load() && k.stream.loggedIn() && k.toClient.atBoundary()
+}
+
+// inject writes a keep-alive to the client whatever the activity
+func (k *keepAliveInjector) inject(now time.Time) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.disabled.Load() || !k.stream.loggedIn() || !k.toClient.atBoundary() {
+		return errors.New("keep-alive injection not possible")
+	}
+
+	k.pendingMutex.Lock()
+	if len(k.pending) >= maxPendingKeepAlives {
+		k.pendingMutex.Unlock()
+		return errors.New("client does not answer keep-alives")
+	}
+	id := k.nextID
+	k.nextID++
+	k.pending[id] = struct{}{}
+	k.pendingMutex.Unlock()
+
+	frame, err := k.packKeepAlive(id)
+	if err != nil {
+		log.Printf("[ERROR] Failed to pack keep-alive for %s: %v", k.username, err)
+		return err
+	}
+	if _, err := k.client.Write(frame); err != nil {
+		k.disable(fmt.Sprintf("write keep-alive: %v", err))
+		return err
+	}
+	k.lastActivity.Store(now.UnixNano())
+	return nil
+}
+
+// packKeepAlive builds a clientbound keep-alive frame
+func (k *keepAliveInjector) packKeepAlive(id int64) ([]byte, error) {
+	body, err := Pack(VarInt(k.clientbound), Long(id))
+	if err != nil {
+		return nil, err
+	}
+	if k.stream.compressed() {
+		// a data length of 0 marks an uncompressed packet
+		body = append([]byte{0x00}, body...)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := VarInt(len(body)).WriteTo(buf); err != nil {
+		return nil, err
+	}
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// disable stops injecting for the rest of the connection
+func (k *keepAliveInjector) disable(reason string) {
+	if k.disabled.CompareAndSwap(false, true) {
+		log.Printf("[DEBUG] Keep-alive injection disabled for %s: %s", k.username, reason)
+	}
+}
+
+// Close stops the idle checks
+func (k *keepAliveInjector) Close() {
+	k.closeOnce.Do(func() { close(k.done) })
+}
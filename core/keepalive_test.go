@@ -0,0 +1,132 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// frame wraps a packet in its length prefix, with the uncompressed data length
+// marker when compressed is set
+func frame(t *testing.T, compressed bool, id int, payload ...io.WriterTo) []byte {
+	t.Helper()
+	body, err := Pack(append([]io.WriterTo{VarInt(id)}, payload...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressed {
+		body = append([]byte{0x00}, body...)
+	}
+	buf := new(bytes.Buffer)
+	VarInt(len(body)).WriteTo(buf)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// readFrame reads one frame and returns its packet ID and payload
+func readFrame(t *testing.T, r io.Reader, compressed bool) (int, []byte) {
+	t.Helper()
+	var length VarInt
+	if _, err := length.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Fatal(err)
+	}
+	id, payload, err := readPacketID(body, compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, payload
+}
+
+func TestKeepAliveInjectedWhenIdle(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	k := newKeepAliveInjector(server, 763, 40*time.Millisecond, "Steve")
+	if k == nil {
+		t.Fatal("no injector for 1.20.1")
+	}
+	defer k.Close()
+
+	// the login is split across writes to exercise partial frames
+	login := append(frame(t, false, loginSetCompression, VarInt(256)),
+		frame(t, true, loginSuccess, String("0123456789abcdef"), String("Steve"), VarInt(0))...)
+	go func() {
+		k.Write(login[:3])
+		k.Write(login[3:])
+	}()
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if id, _ := readFrame(t, client, false); id != loginSetCompression {
+		t.Fatalf("got packet 0x%02X, want set compression", id)
+	}
+	if id, _ := readFrame(t, client, true); id != loginSuccess {
+		t.Fatalf("got packet 0x%02X, want login success", id)
+	}
+
+	// nothing else is sent, so a keep-alive must follow
+	start := time.Now()
+	id, payload := readFrame(t, client, true)
+	if id != 0x23 {
+		t.Fatalf("got packet 0x%02X, want keep-alive", id)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("keep-alive injected after %v, before the idle interval", elapsed)
+	}
+	var keepAliveID Long
+	keepAliveID.ReadFrom(bytes.NewReader(payload))
+	if keepAliveID != injectedKeepAliveBase {
+		t.Errorf("keep-alive ID = %d", keepAliveID)
+	}
+
+	// the client's answer is dropped, other packets still reach the server
+	chat := frame(t, true, 0x05, String("hello"))
+	answer := frame(t, true, 0x12, keepAliveID)
+	ownAnswer := frame(t, true, 0x12, Long(1700000000000))
+	data := append(append(append([]byte{}, chat...), answer...), ownAnswer...)
+	out := append(k.FilterServerbound(data[:len(chat)+2]), k.FilterServerbound(data[len(chat)+2:])...)
+	if want := append(append([]byte{}, chat...), ownAnswer...); !bytes.Equal(out, want) {
+		t.Errorf("forwarded %x, want %x", out, want)
+	}
+
+	// a repeated answer is not ours anymore and is forwarded
+	if out := k.FilterServerbound(answer); !bytes.Equal(out, answer) {
+		t.Errorf("second answer forwarded as %x", out)
+	}
+}
+
+func TestKeepAliveStopsOnEncryption(t *testing.T) {
+	var client bytes.Buffer
+	k := newKeepAliveInjector(&client, 758, time.Hour, "Steve")
+	defer k.Close()
+
+	encrypted := []byte{0xff, 0xff, 0xff, 0xff, 0x01, 0x02}
+	data := append(frame(t, false, loginEncryptionRequest, String(""), VarInt(0), VarInt(0)), encrypted...)
+	if n, err := k.Write(data); err != nil || n != len(data) {
+		t.Fatalf("write: %d, %v", n, err)
+	}
+	if !bytes.Equal(client.Bytes(), data) {
+		t.Errorf("client got %x, want %x", client.Bytes(), data)
+	}
+	if !k.disabled.Load() {
+		t.Error("injection still enabled after the encryption request")
+	}
+
+	// once disabled everything passes through unparsed
+	if out := k.FilterServerbound(encrypted); !bytes.Equal(out, encrypted) {
+		t.Errorf("forwarded %x", out)
+	}
+}
+
+func TestKeepAliveUnsupportedProtocol(t *testing.T) {
+	if k := newKeepAliveInjector(io.Discard, 765, time.Second, "Steve"); k != nil {
+		k.Close()
+		t.Error("injector created for 1.20.4, which has a configuration phase")
+	}
+}
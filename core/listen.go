@@ -0,0 +1,23 @@
+package core
+
+import (
+	"context"
+	"log"
+	"net"
+)
+
+// listenTCP opens a TCP listener on addr, optionally setting SO_REUSEPORT so
+// several mcproxy processes can share the same port
+func listenTCP(addr string, reusePort bool) (net.Listener, error) {
+	if !reusePort {
+		return net.Listen("tcp", addr)
+	}
+
+	if !reusePortSupported {
+		log.Printf("[WARN] reuse_port is not supported on this platform, listening on %s without it", addr)
+		return net.Listen("tcp", addr)
+	}
+
+	lc := net.ListenConfig{Control: reusePortControl}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"mcproxy/config"
+	"sync"
+	"time"
+)
+
+// busyMessage is sent to logins that find every login slot of their proxy taken
+const busyMessage = "Server busy, please try again"
+
+// loginLimits holds the slots of the proxies with max_concurrent_logins set,
+// keyed by listen address. A slot is held from dialing the backend until it
+// has answered the login.
+var loginLimits = struct {
+	sync.Mutex
+	slots map[string]chan struct{}
+}{slots: make(map[string]chan struct{})}
+
+// loginSlots returns the slots of a proxy, replacing them when the limit was
+// changed by a reload. Logins holding a replaced slot release it to the old
+// channel.
+func loginSlots(cfg config.ProxyConfig) chan struct{} {
+	loginLimits.Lock()
+	defer loginLimits.Unlock()
+
+	slots := loginLimits.slots[cfg.Listen]
+	if slots == nil || cap(slots) != cfg.MaxConcurrentLogins {
+		slots = make(chan struct{}, cfg.MaxConcurrentLogins)
+		loginLimits.slots[cfg.Listen] = slots
+	}
+	return slots
+}
+
+// acquireLoginSlot takes one of the proxy's login slots, waiting up to
+// login_queue_ms for one to free up. It reports false when no slot was free in
+// time or ctx ended first. release may be called more than once.
+func acquireLoginSlot(ctx context.Context, cfg config.ProxyConfig) (release func(), ok bool) {
+	if cfg.MaxConcurrentLogins <= 0 {
+		return func() {}, true
+	}
+
+	slots := loginSlots(cfg)
+	var once sync.Once
+	release = func() { once.Do(func() { <-slots }) }
+
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	default:
+	}
+	if cfg.LoginQueueMs <= 0 {
+		return nil, false
+	}
+
+	timer := time.NewTimer(time.Duration(cfg.LoginQueueMs) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// defaultPausedLoginMessage is shown to logins turned away while logins are
+// paused, unless paused_login_message sets another one
+const defaultPausedLoginMessage = "Logins are paused for maintenance, please try again later"
+
+// loginsPaused turns away new logins on every proxy and the load balancer
+// while set. Sessions already logged in and pings are not affected.
+var loginsPaused atomic.Bool
+
+// pausedLoginMessage is the disconnect message of paused logins, set from the
+// global configuration
+var pausedLoginMessage atomic.Pointer[string]
+
+// SetPausedLoginMessage sets the message shown to logins turned away while
+// logins are paused, empty = defaultPausedLoginMessage
+func SetPausedLoginMessage(message string) {
+	pausedLoginMessage.Store(&message)
+}
+
+// pausedMessage returns the disconnect message of paused logins
+func pausedMessage() string {
+	if message := pausedLoginMessage.Load(); message != nil && *message != "" {
+		return *message
+	}
+	return defaultPausedLoginMessage
+}
+
+// PauseLogins turns away new logins on all proxies until ResumeLogins
+func PauseLogins() {
+	if !loginsPaused.Swap(true) {
+		log.Printf("[INFO] New logins paused on all proxies")
+	}
+}
+
+// ResumeLogins accepts new logins again after PauseLogins
+func ResumeLogins() {
+	if loginsPaused.Swap(false) {
+		log.Printf("[INFO] New logins resumed on all proxies")
+	}
+}
+
+// LoginsPaused reports whether new logins are paused
+func LoginsPaused() bool {
+	return loginsPaused.Load()
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// /metrics and /healthz are meant for monitoring systems and need no login.
+// They are served on the control panel address, or on their own address
+// given with -metrics so they can be kept on an internal network.
+
+// registerMetricsRoutes adds the unauthenticated monitoring endpoints to mux
+func registerMetricsRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/healthz", handleHealthz)
+}
+
+// handleHealthz reports that the proxy is running
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// metricsLabelEscaper escapes label values for the Prometheus text format
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// handleMetrics writes player counts, rejections and logins by protocol
+// version in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type proxyMetrics struct {
+		listen     string
+		online     int32
+		rejections map[RejectReason]int64
+	}
+
+	cp := GetControlPanel()
+	cp.mutex.RLock()
+	proxies := make([]proxyMetrics, 0, len(cp.Stats))
+	for listen, st := range cp.Stats {
+		proxies = append(proxies, proxyMetrics{
+			listen:     listen,
+			online:     st.ConnectionCount.Load(),
+			rejections: st.Rejections.Snapshot(),
+		})
+	}
+	cp.mutex.RUnlock()
+	sort.Slice(proxies, func(i, j int) bool { return proxies[i].listen < proxies[j].listen })
+
+	var b strings.Builder
+	b.WriteString("# HELP mcproxy_online_players Players connected through all proxies.\n")
+	b.WriteString("# TYPE mcproxy_online_players gauge\n")
+	fmt.Fprintf(&b, "mcproxy_online_players %d\n", onlineCount.Load())
+
+	b.WriteString("# HELP mcproxy_proxy_online_players Players connected through the proxy.\n")
+	b.WriteString("# TYPE mcproxy_proxy_online_players gauge\n")
+	for _, p := range proxies {
+		fmt.Fprintf(&b, "mcproxy_proxy_online_players{listen=\"%s\"} %d\n", metricsLabelEscaper.Replace(p.listen), p.online)
+	}
+
+	b.WriteString("# HELP mcproxy_rejections_total Connections the proxy rejected, by reason.\n")
+	b.WriteString("# TYPE mcproxy_rejections_total counter\n")
+	for _, p := range proxies {
+		reasons := make([]RejectReason, 0, len(p.rejections))
+		for reason := range p.rejections {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool { return reasons[i] < reasons[j] })
+		for _, reason := range reasons {
+			fmt.Fprintf(&b, "mcproxy_rejections_total{listen=\"%s\",reason=\"%s\"} %d\n",
+				metricsLabelEscaper.Replace(p.listen), metricsLabelEscaper.Replace(string(reason)), p.rejections[reason])
+		}
+	}
+
+	b.WriteString("# HELP mcproxy_logins_by_protocol_total Logins by the client's protocol version.\n")
+	b.WriteString("# TYPE mcproxy_logins_by_protocol_total counter\n")
+	for _, p := range ProtocolStats() {
+		fmt.Fprintf(&b, "mcproxy_logins_by_protocol_total{protocol=\"%d\",version=\"%s\"} %d\n",
+			p.Protocol, metricsLabelEscaper.Replace(p.Version), p.Connections)
+	}
+
+	b.WriteString("# HELP mcproxy_handshake_blocked_ips Client IPs blocked by the handshake rate limit.\n")
+	b.WriteString("# TYPE mcproxy_handshake_blocked_ips gauge\n")
+	fmt.Fprintf(&b, "mcproxy_handshake_blocked_ips %d\n", len(HandshakeBlocks()))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
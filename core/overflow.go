@@ -0,0 +1,55 @@
+package core
+
+import (
+	"mcproxy/config"
+	"sync"
+)
+
+// overflowCounts counts the players each proxy, by listen address, forwarded
+// to its overflow_remote. They count towards the proxy's connection count
+// like any other player but do not take up its max_player.
+var overflowCounts = struct {
+	sync.Mutex
+	counts map[string]int
+}{counts: make(map[string]int)}
+
+// overflowConnections returns the players the proxy forwarded to its
+// overflow_remote
+func overflowConnections(listen string) int {
+	overflowCounts.Lock()
+	defer overflowCounts.Unlock()
+	return overflowCounts.counts[listen]
+}
+
+// acquireOverflowSlot counts a login forwarded to the overflow_remote of cfg
+// unless it has none, overflow_max_player (0 = unlimited) is already reached
+// or so is max_total_connections. Returns whether the slot was acquired.
+func acquireOverflowSlot(cfg config.ProxyConfig) bool {
+	if cfg.OverflowRemote == "" {
+		return false
+	}
+	if limit := maxTotalConnections.Load(); limit > 0 && onlineCount.Load() >= limit {
+		return false
+	}
+
+	overflowCounts.Lock()
+	defer overflowCounts.Unlock()
+	count := overflowCounts.counts[cfg.Listen]
+	if cfg.OverflowMaxPlayer > 0 && count >= cfg.OverflowMaxPlayer {
+		return false
+	}
+	overflowCounts.counts[cfg.Listen] = count + 1
+	return true
+}
+
+// releaseOverflowSlot releases a slot acquired with acquireOverflowSlot
+func releaseOverflowSlot(listen string) {
+	overflowCounts.Lock()
+	defer overflowCounts.Unlock()
+
+	if overflowCounts.counts[listen] <= 1 {
+		delete(overflowCounts.counts, listen)
+		return
+	}
+	overflowCounts.counts[listen]--
+}
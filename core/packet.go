@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sync"
 )
 
 type Packet struct {
@@ -13,7 +14,43 @@ type Packet struct {
 
 const maxPacketLength = 4096
 
+// maxStatusPacketLength is the limit for status responses read from remote
+// servers, which include the base64 encoded favicon
+const maxStatusPacketLength = 1 << 20
+
+// packetBuffers holds the buffers packets are assembled in. A buffer is only
+// put back once its packet was written out or copied, never while the bytes
+// are still handed to a caller.
+var packetBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// maxPooledBufferSize is the largest buffer kept in packetBuffers, buffers
+// grown by a rare large packet such as a status response with a favicon are
+// left to the GC instead of being held by the pool
+const maxPooledBufferSize = 64 * 1024
+
+// getPacketBuffer returns an empty buffer from packetBuffers
+func getPacketBuffer() *bytes.Buffer {
+	return packetBuffers.Get().(*bytes.Buffer)
+}
+
+// putPacketBuffer returns a buffer to packetBuffers, the caller may no longer
+// use it or any slice of its bytes
+func putPacketBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	packetBuffers.Put(buf)
+}
+
 func ReadPacket(r io.Reader) (Packet, error) {
+	return readPacket(r, maxPacketLength)
+}
+
+// readPacket reads a packet whose length may not exceed maxLength
+func readPacket(r io.Reader, maxLength int) (Packet, error) {
 	var pktLength, pktID VarInt
 	var err error
 
@@ -33,7 +70,7 @@ func ReadPacket(r io.Reader) (Packet, error) {
 		return Packet{}, fmt.Errorf("read packet: negateive packet id: %d", pktID)
 	}
 
-	if pktLength < 0 || pktLength > maxPacketLength {
+	if pktLength < 0 || int(pktLength) > maxLength {
 		return Packet{}, fmt.Errorf("read packet: invalid packet length: %d", pktLength)
 	}
 
@@ -43,6 +80,8 @@ func ReadPacket(r io.Reader) (Packet, error) {
 		return Packet{}, fmt.Errorf("read packet: invalid payload length: %d", payloadLen)
 	}
 
+	// the payload is kept by the caller, so it gets its own allocation
+	// rather than a pooled buffer
 	packet := Packet{
 		ID:      int(pktID),
 		Payload: make([]byte, payloadLen),
@@ -59,7 +98,8 @@ func ReadPacket(r io.Reader) (Packet, error) {
 
 // write a packet
 func WritePacket(pktID int, pkt []byte, w io.Writer) error {
-	buf := new(bytes.Buffer)
+	buf := getPacketBuffer()
+	defer putPacketBuffer(buf)
 
 	// length = packet id length + packet length
 	pktLength := VarInt(pktID).Len() + len(pkt)
@@ -85,15 +125,20 @@ func WritePacket(pktID int, pkt []byte, w io.Writer) error {
 	// Get the complete packet data
 	packetData := buf.Bytes()
 
-	// write to the connection
-	n, err := w.Write(packetData)
-	if err != nil {
-		return fmt.Errorf("write packet to connection: %w", err)
-	}
-
-	// Check if we wrote the complete packet
-	if n < len(packetData) {
-		return fmt.Errorf("short write: wrote %d of %d bytes", n, len(packetData))
+	// write to the connection, a slow socket may take a packet in several
+	// writes. A write deadline set on the connection ends the loop with its
+	// timeout error.
+	written := 0
+	for written < len(packetData) {
+		n, err := w.Write(packetData[written:])
+		written += n
+		if err != nil {
+			return fmt.Errorf("write packet to connection: wrote %d of %d bytes: %w", written, len(packetData), err)
+		}
+		// a writer that takes nothing without an error would never finish
+		if n == 0 {
+			return fmt.Errorf("short write: wrote %d of %d bytes", written, len(packetData))
+		}
 	}
 
 	return nil
@@ -114,7 +159,8 @@ func (p *Packet) Scan(r ...io.ReaderFrom) (int64, error) {
 }
 
 func Pack(w ...io.WriterTo) ([]byte, error) {
-	buf := new(bytes.Buffer)
+	buf := getPacketBuffer()
+	defer putPacketBuffer(buf)
 
 	// packet payload
 	for _, v := range w {
@@ -124,5 +170,6 @@ func Pack(w ...io.WriterTo) ([]byte, error) {
 		}
 	}
 
-	return buf.Bytes(), nil
+	// the payload outlives the pooled buffer
+	return bytes.Clone(buf.Bytes()), nil
 }
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// shortWriter takes at most limit bytes per write, failing with err once it
+// has taken failAfter bytes when failAfter > 0
+type shortWriter struct {
+	buf       bytes.Buffer
+	limit     int
+	failAfter int
+	err       error
+}
+
+func (sw *shortWriter) Write(p []byte) (int, error) {
+	if sw.failAfter > 0 && sw.buf.Len() >= sw.failAfter {
+		return 0, sw.err
+	}
+	if len(p) > sw.limit {
+		p = p[:sw.limit]
+	}
+	return sw.buf.Write(p)
+}
+
+func TestWritePacketShortWrites(t *testing.T) {
+	payload, err := Pack(String(strings.Repeat("a", 300)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	if err := WritePacket(0x1A, payload, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	sw := &shortWriter{limit: 7}
+	if err := WritePacket(0x1A, payload, sw); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if !bytes.Equal(sw.buf.Bytes(), want.Bytes()) {
+		t.Errorf("wrote %d bytes, want the full %d byte packet", sw.buf.Len(), want.Len())
+	}
+
+	pkt, err := ReadPacket(&sw.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.ID != 0x1A || !bytes.Equal(pkt.Payload, payload) {
+		t.Errorf("read packet 0x%02X with %d byte payload", pkt.ID, len(pkt.Payload))
+	}
+
+	// a real error still ends the write
+	errClosed := errors.New("connection closed")
+	sw = &shortWriter{limit: 7, failAfter: 14, err: errClosed}
+	if err := WritePacket(0x1A, payload, sw); !errors.Is(err, errClosed) {
+		t.Errorf("err = %v, want %v", err, errClosed)
+	}
+
+	// so does a writer that stops taking bytes
+	sw = &shortWriter{limit: 0}
+	if err := WritePacket(0x1A, payload, sw); err == nil || !strings.Contains(err.Error(), "short write") {
+		t.Errorf("err = %v, want a short write", err)
+	}
+}
+
+func TestPacketBufferReuse(t *testing.T) {
+	// payloads packed earlier are not overwritten by later packets
+	var packed [][]byte
+	for i := range 50 {
+		payload, err := Pack(String(strings.Repeat(string(rune('a'+i%26)), i*10)), VarInt(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		packed = append(packed, payload)
+	}
+	for i, payload := range packed {
+		var s String
+		var v VarInt
+		if _, err := (&Packet{Payload: payload}).Scan(&s, &v); err != nil {
+			t.Fatal(err)
+		}
+		if string(s) != strings.Repeat(string(rune('a'+i%26)), i*10) || int(v) != i {
+			t.Fatalf("payload %d changed after later packets", i)
+		}
+	}
+
+	// packets written at once from many goroutines each arrive intact
+	var wg sync.WaitGroup
+	for i := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			want := strings.Repeat(string(rune('A'+i%26)), 100+i)
+			for range 100 {
+				payload, err := Pack(String(want))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var buf bytes.Buffer
+				if err := WritePacket(i, payload, &buf); err != nil {
+					t.Error(err)
+					return
+				}
+				pkt, err := ReadPacket(&buf)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var got String
+				if _, err := pkt.Scan(&got); err != nil || pkt.ID != i || string(got) != want {
+					t.Errorf("goroutine %d read packet 0x%02X %q, %v", i, pkt.ID, got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkWritePacket(b *testing.B) {
+	payload := bytes.Repeat([]byte{0x42}, 200)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		WritePacket(0x00, payload, io.Discard)
+	}
+}
+
+func BenchmarkPack(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		Pack(VarInt(VERSION_1_18_2), String("play.example.com"), UShort(25565), VarInt(2))
+	}
+}
+
+func BenchmarkReadPacket(b *testing.B) {
+	var raw bytes.Buffer
+	WritePacket(0x00, bytes.Repeat([]byte{0x42}, 200), &raw)
+	r := bytes.NewReader(raw.Bytes())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		r.Reset(raw.Bytes())
+		ReadPacket(r)
+	}
+}
@@ -1,10 +1,14 @@
 package core
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"mcproxy/config"
+	"net"
+	"strconv"
 	"time"
 )
 
@@ -21,11 +25,7 @@ func handlePing(reader io.Reader, writer io.Writer, protocol int, cfg config.Pro
 	// fake ping mode
 	if cfg.PingMode == "fake" {
 		// response
-		if publicIP := GetPublicIP(cfg.LocalAddr); publicIP != "" {
-			cfg.Description += " (從: " + publicIP + " 連線)"
-		}
-
-		err = sendResponse(writer, protocol, cfg)
+		err = sendResponse(writer, protocol, cfg, pingDescription(cfg))
 		if err != nil {
 			return err
 		}
@@ -62,74 +62,66 @@ func handlePing(reader io.Reader, writer io.Writer, protocol int, cfg config.Pro
 		if cfg.LocalAddr != "" {
 			log.Printf("[DEBUG] Using local address for outgoing connection: %s", cfg.LocalAddr)
 		}
-		remote, err := DialMC(cfg.Remote, cfg.LocalAddr)
-		if err != nil {
-			log.Printf("[ERROR] Failed to connect to remote server %s for ping: %v", cfg.Remote, err)
-			// If we can't connect to the remote server, fall back to fake response
-			err = sendResponse(writer, protocol, cfg)
-			if err != nil {
-				return err
+		dial := func() (net.Conn, error) {
+			return dialMC(cfg.Remote, cfg.LocalAddr, cfg.ResolveViaLocalAddr)
+		}
+		if cfg.StatusPoolSize > 0 {
+			dial = statusPoolFor(cfg).get
+		}
+		// ping_timeout_ms bounds the whole sequence, a backend slower than
+		// that gets the proxy's own status served instead
+		timeout := time.Duration(cfg.PingTimeoutMs) * time.Millisecond
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		stepDeadline := func() time.Time {
+			d := time.Now().Add(5 * time.Second)
+			if !deadline.IsZero() && deadline.Before(d) {
+				return deadline
 			}
-			return handlePingFallback(reader, writer)
+			return d
 		}
-		defer remote.Close()
 
-		// Send handshake packet to remote server
-		pktHandshake, err := Pack(
-			VarInt(protocol),
-			String(cfg.RewirteHost),
-			UShort(cfg.RewirtePort),
-			VarInt(1), // next state status
-		)
+		remote, respPayload, err := requestRemoteStatus(dial, protocol, cfg.RewirteHost, cfg.RewirtePort, timeout)
 		if err != nil {
-			return err
-		}
-		err = WritePacket(0x00, pktHandshake, remote)
-		if err != nil {
-			log.Printf("[ERROR] Failed to send handshake to remote server: %v", err)
-			// Fall back to fake response
-			err = sendResponse(writer, protocol, cfg)
+			log.Printf("[ERROR] Failed to get status from remote server %s: %v", cfg.Remote, err)
+			// If we can't get the status from the remote server, fall back to fake response
+			err = sendResponse(writer, protocol, cfg, cfg.Description)
 			if err != nil {
 				return err
 			}
 			return handlePingFallback(reader, writer)
 		}
+		defer remote.Close()
 
-		// Send request packet to remote server
-		err = WritePacket(0x00, []byte{}, remote)
-		if err != nil {
-			log.Printf("[ERROR] Failed to send request to remote server: %v", err)
-			// Fall back to fake response
-			err = sendResponse(writer, protocol, cfg)
+		// Forward the response to the client, with the proxy's own count and
+		// fields if configured
+		if cfg.OnlineCountSource == OnlineCountProxy {
+			patched, err := overrideOnlineCount(respPayload, proxyConnectionCount(cfg.Listen))
 			if err != nil {
-				return err
+				log.Printf("[WARN] Failed to set the online count in the status of %s, forwarding it unchanged: %v", cfg.Remote, err)
+			} else {
+				respPayload = patched
 			}
-			return handlePingFallback(reader, writer)
 		}
-
-		// Read response packet from remote server
-		respPkt, err := ReadPacket(remote)
-		if err != nil {
-			log.Printf("[ERROR] Failed to read response from remote server: %v", err)
-			// Fall back to fake response
-			err = sendResponse(writer, protocol, cfg)
+		if len(cfg.StatusOverride) > 0 {
+			patched, err := overrideStatusFields(respPayload, cfg)
 			if err != nil {
-				return err
+				log.Printf("[WARN] Failed to override fields in the status of %s, forwarding it unchanged: %v", cfg.Remote, err)
+			} else {
+				respPayload = patched
 			}
-			return handlePingFallback(reader, writer)
 		}
-		if respPkt.ID != 0x00 {
-			log.Printf("[ERROR] Unexpected packet ID from remote server: %d", respPkt.ID)
-			// Fall back to fake response
-			err = sendResponse(writer, protocol, cfg)
+		if cfg.FullPingDisplay != "" && cfg.FullPingDisplay != FullPingReal && proxyConnectionCount(cfg.Listen) >= cfg.MaxPlayer {
+			patched, err := overrideFullDisplay(respPayload, cfg)
 			if err != nil {
-				return err
+				log.Printf("[WARN] Failed to apply full_ping_display to the status of %s, forwarding it unchanged: %v", cfg.Remote, err)
+			} else {
+				respPayload = patched
 			}
-			return handlePingFallback(reader, writer)
 		}
-
-		// Forward the response to the client
-		err = WritePacket(0x00, respPkt.Payload, writer)
+		err = WritePacket(0x00, respPayload, writer)
 		if err != nil {
 			return err
 		}
@@ -144,7 +136,7 @@ func handlePing(reader io.Reader, writer io.Writer, protocol int, cfg config.Pro
 		}
 
 		// Set a deadline for writing the ping packet
-		remote.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		remote.SetWriteDeadline(stepDeadline())
 
 		// Send ping packet to remote server
 		err = WritePacket(0x01, pingPkt.Payload, remote)
@@ -169,7 +161,7 @@ func handlePing(reader io.Reader, writer io.Writer, protocol int, cfg config.Pro
 		}
 
 		// Set a deadline for reading the pong packet
-		remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+		remote.SetReadDeadline(stepDeadline())
 
 		// Read pong packet from remote server
 		pongPkt, err := ReadPacket(remote)
@@ -217,6 +209,154 @@ func handlePing(reader io.Reader, writer io.Writer, protocol int, cfg config.Pro
 	return fmt.Errorf("invalid ping mode: %s", cfg.PingMode)
 }
 
+// requestRemoteStatus connects to a remote server with dial and performs the
+// status handshake and request. It returns the open connection, ready for the
+// ping/pong exchange, and the raw payload of the status response. When
+// timeout is positive it bounds the whole sequence, including the dial.
+func requestRemoteStatus(dial func() (net.Conn, error), protocol int, host string, port int, timeout time.Duration) (net.Conn, []byte, error) {
+	start := time.Now()
+	remote, err := dialWithin(dial, timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect: %w", err)
+	}
+
+	if timeout > 0 {
+		remote.SetDeadline(start.Add(timeout))
+	}
+
+	// Send handshake packet to remote server
+	pktHandshake, err := Pack(
+		VarInt(protocol),
+		String(host),
+		UShort(port),
+		VarInt(1), // next state status
+	)
+	if err != nil {
+		remote.Close()
+		return nil, nil, err
+	}
+	err = WritePacket(0x00, pktHandshake, remote)
+	if err != nil {
+		remote.Close()
+		return nil, nil, fmt.Errorf("send handshake: %w", err)
+	}
+
+	// Send request packet to remote server
+	err = WritePacket(0x00, []byte{}, remote)
+	if err != nil {
+		remote.Close()
+		return nil, nil, fmt.Errorf("send request: %w", err)
+	}
+
+	// Read response packet from remote server, status responses carry the
+	// favicon so they are allowed to be larger than other packets
+	respPkt, err := readPacket(remote, maxStatusPacketLength)
+	if err != nil {
+		remote.Close()
+		return nil, nil, fmt.Errorf("read response: %w", err)
+	}
+	if respPkt.ID != 0x00 {
+		remote.Close()
+		return nil, nil, fmt.Errorf("unexpected packet ID: %d", respPkt.ID)
+	}
+
+	if timeout > 0 {
+		remote.SetDeadline(time.Time{})
+	}
+
+	return remote, respPkt.Payload, nil
+}
+
+// pingTestResult is the outcome of a status ping against a remote server
+type pingTestResult struct {
+	Success     bool            `json:"success"`
+	Error       string          `json:"error,omitempty"`
+	LatencyMs   int64           `json:"latency_ms"`
+	Version     *statusVersion  `json:"version,omitempty"`
+	Players     *statusPlayers  `json:"players,omitempty"`
+	Description json.RawMessage `json:"description,omitempty"`
+}
+
+// dialWithin calls dial, giving up after timeout when it is positive. A
+// connection that is only dialed after giving up is closed.
+func dialWithin(dial func() (net.Conn, error), timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return dial()
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := dial()
+		done <- result{conn, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+// pingTest performs a real status ping against a remote server and parses
+// the response, without touching any proxy configuration
+func pingTest(remoteAddr, localAddr string, timeout time.Duration) pingTestResult {
+	start := time.Now()
+	result := pingTestResult{}
+
+	// The handshake carries the address as typed, like a client would send it
+	host, p := splitHostPort(remoteAddr)
+	port := 25565
+	if parsed, err := strconv.Atoi(p); err == nil {
+		port = parsed
+	}
+
+	remote, payload, err := requestRemoteStatus(func() (net.Conn, error) {
+		return DialMC(remoteAddr, localAddr)
+	}, VERSION_1_18_2, host, port, timeout)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	remote.Close()
+
+	pkt := Packet{ID: 0x00, Payload: payload}
+	var body String
+	if _, err := pkt.Scan(&body); err != nil {
+		result.Error = fmt.Sprintf("scan response: %v", err)
+		return result
+	}
+
+	// The description may be a plain string or a chat component
+	var status struct {
+		Version     statusVersion   `json:"version"`
+		Players     statusPlayers   `json:"players"`
+		Description json.RawMessage `json:"description"`
+	}
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		result.Error = fmt.Sprintf("decode response: %v", err)
+		return result
+	}
+
+	result.Success = true
+	result.Version = &status.Version
+	result.Players = &status.Players
+	result.Description = status.Description
+	return result
+}
+
 // handlePingFallback handles the ping when we can't connect to the remote server
 func handlePingFallback(reader io.Reader, writer io.Writer) error {
 	// ping
@@ -241,3 +381,196 @@ func handlePingFallback(reader io.Reader, writer io.Writer) error {
 	err = WritePacket(0x01, pktBytes, writer)
 	return err
 }
+
+// pingDescription returns the MOTD for fake pings with the public IP of the
+// outgoing interface, or the public hostname, appended. Status-only proxies
+// never connect out and show the description alone. The configured
+// description is never modified.
+func pingDescription(cfg config.ProxyConfig) string {
+	description := cfg.Description
+	if cfg.Mode == ModeStatusOnly {
+		return description
+	}
+	if publicIP := displayedPublicIP(cfg); publicIP != "" {
+		description += " (從: " + publicIP + " 連線)"
+	}
+	return description
+}
+
+// Values of online_count_source. Fake pings always show the proxy's count,
+// real pings default to the count reported by the backend.
+const (
+	OnlineCountProxy   = "proxy"   // Players forwarded by this proxy
+	OnlineCountBackend = "backend" // Players reported by the backend's status response
+)
+
+// overrideOnlineCount replaces the online count in a status response payload,
+// keeping everything else the backend sent
+func overrideOnlineCount(payload []byte, online int) ([]byte, error) {
+	return patchStatus(payload, func(status map[string]json.RawMessage) error {
+		players := make(map[string]json.RawMessage)
+		if raw, ok := status["players"]; ok {
+			if err := json.Unmarshal(raw, &players); err != nil {
+				return fmt.Errorf("decode players: %w", err)
+			}
+		}
+		players["online"] = json.RawMessage(strconv.Itoa(online))
+
+		var err error
+		status["players"], err = json.Marshal(players)
+		return err
+	})
+}
+
+// overrideFullDisplay applies the proxy's full_ping_display to a status
+// response payload of a proxy at max_player. The overflow slot is added to
+// the online count the response shows.
+func overrideFullDisplay(payload []byte, cfg config.ProxyConfig) ([]byte, error) {
+	return patchStatus(payload, func(status map[string]json.RawMessage) error {
+		var err error
+		switch cfg.FullPingDisplay {
+		case FullPingMotd:
+			if cfg.FullMotd != "" {
+				status["description"], err = json.Marshal(cfg.FullMotd)
+			}
+		case FullPingOverflow:
+			players := make(map[string]json.RawMessage)
+			if raw, ok := status["players"]; ok {
+				if err := json.Unmarshal(raw, &players); err != nil {
+					return fmt.Errorf("decode players: %w", err)
+				}
+			}
+			var online int
+			if raw, ok := players["online"]; ok {
+				if err := json.Unmarshal(raw, &online); err != nil {
+					return fmt.Errorf("decode online players: %w", err)
+				}
+			}
+			players["max"] = json.RawMessage(strconv.Itoa(online + 1))
+			status["players"], err = json.Marshal(players)
+		}
+		return err
+	})
+}
+
+// Values of status_override, the fields of real pings replaced with the
+// proxy's own
+const (
+	StatusOverrideDescription = "description" // description, the backend's MOTD
+	StatusOverrideFavicon     = "favicon"     // favicon, removed when the proxy has none
+	StatusOverrideVersion     = "version"     // version_name, the backend's protocol is kept
+)
+
+// overrideStatusFields replaces the fields listed in the proxy's
+// status_override in a status response payload. The player counts and
+// everything not listed are kept as the backend sent them.
+func overrideStatusFields(payload []byte, cfg config.ProxyConfig) ([]byte, error) {
+	return patchStatus(payload, func(status map[string]json.RawMessage) error {
+		for _, field := range cfg.StatusOverride {
+			var err error
+			switch field {
+			case StatusOverrideDescription:
+				status["description"], err = json.Marshal(cfg.Description)
+			case StatusOverrideFavicon:
+				if cfg.Favicon == "" {
+					delete(status, "favicon")
+					continue
+				}
+				status["favicon"], err = json.Marshal(cfg.Favicon)
+			case StatusOverrideVersion:
+				version := make(map[string]json.RawMessage)
+				if raw, ok := status["version"]; ok {
+					if err := json.Unmarshal(raw, &version); err != nil {
+						return fmt.Errorf("decode version: %w", err)
+					}
+				}
+				// auto names the backend's protocol, which is kept, a
+				// version without a readable one has no release to name
+				name := versionName(cfg, 0)
+				if cfg.VersionName == VersionNameAuto {
+					name = defaultVersionName
+					var protocol int
+					if raw, ok := version["protocol"]; ok && json.Unmarshal(raw, &protocol) == nil {
+						name = versionName(cfg, protocol)
+					}
+				}
+				if version["name"], err = json.Marshal(name); err != nil {
+					return err
+				}
+				status["version"], err = json.Marshal(version)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// patchStatus decodes a status response payload, lets patch change its
+// top-level fields and packs the result again
+func patchStatus(payload []byte, patch func(status map[string]json.RawMessage) error) ([]byte, error) {
+	var body String
+	if _, err := body.ReadFrom(bytes.NewReader(payload)); err != nil {
+		return nil, fmt.Errorf("read status: %w", err)
+	}
+
+	var status map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		return nil, fmt.Errorf("decode status: %w", err)
+	}
+	if err := patch(status); err != nil {
+		return nil, err
+	}
+
+	patched, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	return Pack(String(patched))
+}
+
+// defaultVersionName is the version name of the proxy's own status responses
+const defaultVersionName = "gomcproxy"
+
+// VersionNameAuto as version_name shows the release of the protocol in pings
+const VersionNameAuto = "auto"
+
+// versionName returns the version name the proxy shows in pings of protocol
+func versionName(cfg config.ProxyConfig, protocol int) string {
+	if cfg.VersionName == VersionNameAuto {
+		if name, ok := protocolNames[protocol]; ok {
+			return name
+		}
+		return defaultVersionName
+	}
+	if cfg.VersionName != "" {
+		return cfg.VersionName
+	}
+	return defaultVersionName
+}
+
+// Values of full_ping_display
+const (
+	FullPingReal     = "real"     // Show the real online and max players
+	FullPingMotd     = "motd"     // Show full_motd instead of the description
+	FullPingOverflow = "overflow" // Show one more slot than the online players so the server looks joinable
+)
+
+// fullPingStatus returns the max players and description a status response
+// shows, applying the proxy's full_ping_display once online reaches max_player
+func fullPingStatus(cfg config.ProxyConfig, online int, description string) (int, string) {
+	if online < cfg.MaxPlayer {
+		return cfg.MaxPlayer, description
+	}
+
+	switch cfg.FullPingDisplay {
+	case FullPingMotd:
+		if cfg.FullMotd != "" {
+			return cfg.MaxPlayer, cfg.FullMotd
+		}
+	case FullPingOverflow:
+		return online + 1, description
+	}
+	return cfg.MaxPlayer, description
+}
@@ -0,0 +1,474 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mcproxy/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readStatus performs the request/ping half of a status exchange as a client
+// and returns the decoded status response
+func readStatus(t *testing.T, conn io.ReadWriter) statusResponse {
+	t.Helper()
+
+	if err := WritePacket(0x00, []byte{}, conn); err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := ReadPacket(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.ID != 0x00 {
+		t.Fatalf("expected status response, got 0x%02X", pkt.ID)
+	}
+	var body String
+	if _, err := pkt.Scan(&body); err != nil {
+		t.Fatal(err)
+	}
+	var status statusResponse
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatalf("decode status %q: %v", body, err)
+	}
+
+	ping, err := Pack(Long(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePacket(0x01, ping, conn); err != nil {
+		t.Fatal(err)
+	}
+	pong, err := ReadPacket(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload Long
+	if _, err := pong.Scan(&payload); err != nil || pong.ID != 0x01 || payload != 42 {
+		t.Fatalf("bad pong: id=0x%02X payload=%d err=%v", pong.ID, payload, err)
+	}
+
+	return status
+}
+
+// fakePing runs handlePing against a pipe and returns the status the client saw
+func fakePing(t *testing.T, cfg config.ProxyConfig) statusResponse {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- handlePing(server, server, VERSION_1_18_2, cfg)
+		server.Close()
+	}()
+
+	status := readStatus(t, client)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestHandlePingKeepsDescription(t *testing.T) {
+	origLookup := lookupPublicIP
+	lookupPublicIP = func(ip string) (string, error) { return "203.0.113.7", nil }
+	defer func() { lookupPublicIP = origLookup }()
+
+	cfg := config.ProxyConfig{
+		Listen:      "127.0.0.1:40030",
+		DisplayName: "Lobby",
+		Description: "hello",
+		LocalAddr:   "192.168.1.2:0",
+		MaxPlayer:   20,
+		PingMode:    "fake",
+	}
+	stats := registerProxyStats(t, cfg)
+
+	want := "hello (從: 203.0.113.7 連線)"
+	for i := 0; i < 2; i++ {
+		status := fakePing(t, stats.Config)
+		if status.Description != want {
+			t.Errorf("ping %d: description = %q, want %q", i, status.Description, want)
+		}
+	}
+
+	if stats.Config.Description != "hello" {
+		t.Errorf("stored description modified: %q", stats.Config.Description)
+	}
+	if stats.Config.Label() != "Lobby" {
+		t.Errorf("label = %q, want Lobby", stats.Config.Label())
+	}
+}
+
+// startStatusBackend starts a backend that answers status requests with the
+// given JSON and echoes pings
+func startStatusBackend(t *testing.T, status string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				// handshake and request
+				for i := 0; i < 2; i++ {
+					if _, err := ReadPacket(conn); err != nil {
+						return
+					}
+				}
+				pkt, _ := Pack(String(status))
+				if err := WritePacket(0x00, pkt, conn); err != nil {
+					return
+				}
+				ping, err := ReadPacket(conn)
+				if err != nil {
+					return
+				}
+				WritePacket(0x01, ping.Payload, conn)
+			}(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestAPIPingTest(t *testing.T) {
+	addr := startStatusBackend(t, `{"version":{"name":"Paper 1.20.4","protocol":765},"players":{"max":100,"online":7},"description":{"text":"A backend"}}`)
+
+	body := strings.NewReader(`{"remote":"` + addr + `"}`)
+	rec := httptest.NewRecorder()
+	handleAPIPingTest(rec, httptest.NewRequest(http.MethodPost, "/api/ping-test", body))
+
+	var result pingTestResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	if !result.Success || result.Version.Name != "Paper 1.20.4" || result.Players.Online != 7 || result.Players.Max != 100 {
+		t.Errorf("unexpected result: %s", rec.Body.String())
+	}
+	if string(result.Description) != `{"text":"A backend"}` {
+		t.Errorf("description = %s", result.Description)
+	}
+
+	// A closed port reports an error instead of a status
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed := ln.Addr().String()
+	ln.Close()
+
+	rec = httptest.NewRecorder()
+	handleAPIPingTest(rec, httptest.NewRequest(http.MethodPost, "/api/ping-test", strings.NewReader(`{"remote":"`+closed+`"}`)))
+	result = pingTestResult{}
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.Success || result.Error == "" {
+		t.Errorf("expected failure for closed port, got %s", rec.Body.String())
+	}
+}
+
+func TestHandlePingFullDisplay(t *testing.T) {
+	origPublicIP := publicIPFunc
+	publicIPFunc = func(localAddr string) string { return "" }
+	defer func() { publicIPFunc = origPublicIP }()
+
+	base := config.ProxyConfig{
+		Listen:      "127.0.0.1:40080",
+		Description: "hello",
+		MaxPlayer:   3,
+		PingMode:    "fake",
+		FullMotd:    "full, try again later",
+	}
+	// three players on this proxy, the counts of other proxies do not matter
+	registerProxyStats(t, base).ConnectionCount.Store(3)
+	onlineCount.Add(5)
+	defer onlineCount.Add(-5)
+
+	tests := []struct {
+		display     string
+		maxPlayers  int
+		description string
+	}{
+		{"", 3, "hello"},
+		{FullPingReal, 3, "hello"},
+		{FullPingMotd, 3, "full, try again later"},
+		{FullPingOverflow, 4, "hello"},
+	}
+	for _, tt := range tests {
+		cfg := base
+		cfg.FullPingDisplay = tt.display
+		status := fakePing(t, cfg)
+		if status.Players.Online != 3 || status.Players.Max != tt.maxPlayers || status.Description != tt.description {
+			t.Errorf("%q: got %d/%d %q, want 3/%d %q", tt.display, status.Players.Online, status.Players.Max,
+				status.Description, tt.maxPlayers, tt.description)
+		}
+	}
+
+	// below capacity every mode shows the real values
+	below := base
+	below.MaxPlayer = 10
+	below.FullPingDisplay = FullPingMotd
+	if status := fakePing(t, below); status.Players.Max != 10 || status.Description != "hello" {
+		t.Errorf("below capacity: got max %d %q", status.Players.Max, status.Description)
+	}
+
+	// real pings keep the backend's counts, the overflow slot is added to the
+	// online players it reports
+	addr, _ := startCountingStatusBackend(t)
+	base.PingMode = "real"
+	base.Remote = addr
+	for _, tt := range []struct {
+		display     string
+		maxPlayers  int
+		description string
+	}{
+		{FullPingReal, 20, "conn"},
+		{FullPingMotd, 20, "full, try again later"},
+		{FullPingOverflow, 1, "conn"},
+	} {
+		cfg := base
+		cfg.FullPingDisplay = tt.display
+		status := fakePing(t, cfg)
+		if status.Players.Online != 0 || status.Players.Max != tt.maxPlayers || !strings.HasPrefix(status.Description, tt.description) {
+			t.Errorf("real %q: got %d/%d %q, want 0/%d %q", tt.display, status.Players.Online, status.Players.Max,
+				status.Description, tt.maxPlayers, tt.description)
+		}
+	}
+}
+
+func TestHandlePingOnlineCountSource(t *testing.T) {
+
+	origPublicIP := publicIPFunc
+	publicIPFunc = func(localAddr string) string { return "" }
+	defer func() { publicIPFunc = origPublicIP }()
+
+	// the backend reports no players online
+	addr, _ := startCountingStatusBackend(t)
+	realPing := func(source string) config.ProxyConfig {
+		return config.ProxyConfig{
+			Listen:            "127.0.0.1:40081",
+			Remote:            addr,
+			PingMode:          "real",
+			RewirteHost:       "backend",
+			RewirtePort:       25565,
+			MaxPlayer:         10,
+			OnlineCountSource: source,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		cfg    config.ProxyConfig
+		online int
+	}{
+		{"real default", realPing(""), 0},
+		{"real backend", realPing(OnlineCountBackend), 0},
+		{"real proxy", realPing(OnlineCountProxy), 3},
+		{"fake", config.ProxyConfig{Listen: "127.0.0.1:40082", PingMode: "fake", MaxPlayer: 10}, 3},
+	}
+	for _, tt := range tests {
+		registerProxyStats(t, tt.cfg).ConnectionCount.Store(3)
+	}
+
+	for _, tt := range tests {
+		status := fakePing(t, tt.cfg)
+		if status.Players.Online != tt.online {
+			t.Errorf("%s: online = %d, want %d", tt.name, status.Players.Online, tt.online)
+		}
+		// the rest of the backend's response is kept
+		if tt.cfg.PingMode == "real" && (status.Players.Max != 20 || !strings.HasPrefix(status.Description, "conn ")) {
+			t.Errorf("%s: got max %d %q, want the backend's response", tt.name, status.Players.Max, status.Description)
+		}
+	}
+}
+
+func TestHandlePingStatusOverride(t *testing.T) {
+	origPublicIP := publicIPFunc
+	publicIPFunc = func(localAddr string) string { return "" }
+	defer func() { publicIPFunc = origPublicIP }()
+
+	// the backend reports 0 of 20 players, version 1.18.2 and no favicon
+	addr, _ := startCountingStatusBackend(t)
+	cfg := config.ProxyConfig{
+		Listen:      "127.0.0.1:40083",
+		Remote:      addr,
+		PingMode:    "real",
+		RewirteHost: "backend",
+		RewirtePort: 25565,
+		MaxPlayer:   10,
+		Description: "Branded lobby",
+		Favicon:     "data:image/png;base64,AAAA",
+		VersionName: "Lobby 1.18",
+	}
+
+	status := fakePing(t, cfg)
+	if status.Description == cfg.Description || status.Favicon != "" || status.Version.Name != "1.18.2" {
+		t.Errorf("pass through: got %+v, want the backend's response", status)
+	}
+
+	cfg.StatusOverride = []string{StatusOverrideDescription, StatusOverrideFavicon, StatusOverrideVersion}
+	status = fakePing(t, cfg)
+	if status.Description != "Branded lobby" || status.Favicon != cfg.Favicon || status.Version.Name != "Lobby 1.18" {
+		t.Errorf("overridden: got %+v, want the proxy's fields", status)
+	}
+	if status.Version.Protocol != 758 || status.Players.Max != 20 || status.Players.Online != 0 {
+		t.Errorf("overridden: got protocol %d and %d/%d players, want the backend's",
+			status.Version.Protocol, status.Players.Online, status.Players.Max)
+	}
+
+	cfg.VersionName = VersionNameAuto
+	if status = fakePing(t, cfg); status.Version.Name != "1.18.2" {
+		t.Errorf("auto: got version %q, want the backend protocol's release", status.Version.Name)
+	}
+}
+
+func TestHandlePingVersionNameAuto(t *testing.T) {
+	origPublicIP := publicIPFunc
+	publicIPFunc = func(localAddr string) string { return "" }
+	defer func() { publicIPFunc = origPublicIP }()
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40086", MaxPlayer: 10, PingMode: "fake"}
+	registerProxyStats(t, cfg)
+	if status := fakePing(t, cfg); status.Version.Name != defaultVersionName {
+		t.Errorf("default: got version %q, want %q", status.Version.Name, defaultVersionName)
+	}
+
+	cfg.VersionName = VersionNameAuto
+	status := fakePing(t, cfg)
+	if status.Version.Name != "1.18.2" || status.Version.Protocol != VERSION_1_18_2 {
+		t.Errorf("auto: got version %q protocol %d, want the client's 1.18.2", status.Version.Name, status.Version.Protocol)
+	}
+	if name := versionName(cfg, 1); name != defaultVersionName {
+		t.Errorf("auto with an unknown protocol: got %q, want %q", name, defaultVersionName)
+	}
+}
+
+func TestOverrideStatusVersion(t *testing.T) {
+	override := func(cfg config.ProxyConfig, status string) (string, error) {
+		t.Helper()
+		payload, err := Pack(String(status))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.StatusOverride = []string{StatusOverrideVersion}
+		patched, err := overrideStatusFields(payload, cfg)
+		if err != nil {
+			return "", err
+		}
+		var body String
+		if _, err := body.ReadFrom(bytes.NewReader(patched)); err != nil {
+			t.Fatal(err)
+		}
+		var decoded struct {
+			Version struct {
+				Name string `json:"name"`
+			} `json:"version"`
+		}
+		if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		return decoded.Version.Name, nil
+	}
+
+	auto := config.ProxyConfig{VersionName: VersionNameAuto}
+	if name, err := override(auto, `{"version":{"name":"Paper 1.18.2"}}`); err != nil || name != defaultVersionName {
+		t.Errorf("auto without a protocol: got %q, %v, want %q", name, err, defaultVersionName)
+	}
+	if name, err := override(config.ProxyConfig{VersionName: "Lobby"}, `{"version":{"name":"Paper 1.18.2"}}`); err != nil || name != "Lobby" {
+		t.Errorf("named without a protocol: got %q, %v", name, err)
+	}
+	// a malformed protocol only loses the release name of auto
+	const malformed = `{"version":{"name":"Paper 1.18.2","protocol":"758"}}`
+	if name, err := override(auto, malformed); err != nil || name != defaultVersionName {
+		t.Errorf("auto with a malformed protocol: got %q, %v, want %q", name, err, defaultVersionName)
+	}
+	if name, err := override(config.ProxyConfig{VersionName: "Lobby"}, malformed); err != nil || name != "Lobby" {
+		t.Errorf("named with a malformed protocol: got %q, %v", name, err)
+	}
+}
+
+func TestHandlePingTimeout(t *testing.T) {
+	origPublicIP := publicIPFunc
+	publicIPFunc = func(localAddr string) string { return "" }
+	defer func() { publicIPFunc = origPublicIP }()
+
+	// the backend reads the status request and never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	cfg := config.ProxyConfig{
+		Listen:        "127.0.0.1:40159",
+		Remote:        ln.Addr().String(),
+		Description:   "backend is slow",
+		MaxPlayer:     10,
+		PingMode:      "real",
+		PingTimeoutMs: 100,
+	}
+
+	start := time.Now()
+	status := fakePing(t, cfg)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ping took %v with a 100ms timeout", elapsed)
+	}
+	if status.Description != "backend is slow" {
+		t.Errorf("description = %q, want the proxy's own status", status.Description)
+	}
+
+	// the timeout covers the dial as well
+	slowDial := func() (net.Conn, error) {
+		time.Sleep(time.Second)
+		return net.Dial("tcp", ln.Addr().String())
+	}
+	start = time.Now()
+	if _, _, err := requestRemoteStatus(slowDial, VERSION_1_18_2, "backend", 25565, 100*time.Millisecond); err == nil {
+		t.Error("slow dial did not time out")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("slow dial took %v with a 100ms timeout", elapsed)
+	}
+}
+
+func TestPingDescriptionPublicHostname(t *testing.T) {
+	origPublicIP := publicIPFunc
+	publicIPFunc = func(localAddr string) string { return "203.0.113.7" }
+	defer func() { publicIPFunc = origPublicIP }()
+
+	cfg := config.ProxyConfig{Description: "Welcome", LocalAddr: "10.0.0.2"}
+	if got := pingDescription(cfg); got != "Welcome (從: 203.0.113.7 連線)" {
+		t.Errorf("without a hostname: description = %q", got)
+	}
+
+	cfg.PublicHostname = "na.example.com"
+	if got := displayedPublicIP(cfg); got != "na.example.com" {
+		t.Errorf("displayed public IP = %q, want the hostname", got)
+	}
+	if got := pingDescription(cfg); got != "Welcome (從: na.example.com 連線)" {
+		t.Errorf("with a hostname: description = %q", got)
+	}
+}
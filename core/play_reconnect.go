@@ -0,0 +1,241 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mcproxy/config"
+	"net"
+	"sync"
+	"time"
+)
+
+// Players whose backend is lost in the play state can be held instead of
+// disconnected: the proxy keeps the client alive with keep-alives while it
+// logs in to the backend again for up to play_reconnect_timeout_ms. The new
+// backend's join game then reaches the client like a server switch would.
+//
+// Only backends that can be logged in to without the client are supported,
+// that is offline mode backends that use the same compression as before.
+// The player's packets sent while held are dropped.
+
+const (
+	// maxHoldKeepAliveInterval keeps held clients well within their 30 second
+	// read timeout
+	maxHoldKeepAliveInterval = 10 * time.Second
+
+	// playReconnectRetryDelay is the pause between two reconnect attempts
+	playReconnectRetryDelay = time.Second
+)
+
+// heldBackend is the backend connection client data is written to in the
+// play state, nil while the backend is being reconnected
+type heldBackend struct {
+	mutex sync.Mutex
+	conn  net.Conn
+	lost  error // write error of conn, nil while it works
+}
+
+func (h *heldBackend) get() net.Conn {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.conn
+}
+
+func (h *heldBackend) set(conn net.Conn) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.conn = conn
+	h.lost = nil
+}
+
+// writeError returns the error writing to the current backend failed with,
+// nil if it did not
+func (h *heldBackend) writeError() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.lost
+}
+
+// Write writes client data to the backend. The data is dropped while the
+// backend is reconnected. A write error expires the backend's read deadline,
+// so the server to client side holds the client like after a read error.
+func (h *heldBackend) Write(p []byte) (int, error) {
+	conn := h.get()
+	if conn == nil {
+		return len(p), nil
+	}
+	n, err := conn.Write(p)
+	if err != nil {
+		h.mutex.Lock()
+		if h.conn == conn && h.lost == nil {
+			h.lost = err
+			conn.SetReadDeadline(time.Now())
+		}
+		h.mutex.Unlock()
+	}
+	return n, err
+}
+
+// playReconnect holds the client of a session while its backend is logged in
+// to again
+type playReconnect struct {
+	cfg           config.ProxyConfig
+	protocol      int
+	addressSuffix string
+	loginStart    []byte // payload of the client's login start
+	username      string
+	keepAlive     *keepAliveInjector
+	clientDone    <-chan struct{} // closed once the client is gone
+}
+
+// hold keeps the client alive with keep-alives until the backend has been
+// logged in to again or the timeout passed. The returned reader holds what
+// the backend sent after its login success.
+func (pr *playReconnect) hold() (net.Conn, *bufio.Reader, error) {
+	deadline := time.Now().Add(time.Duration(pr.cfg.PlayReconnectTimeoutMs) * time.Millisecond)
+	interval := min(pr.keepAlive.interval, maxHoldKeepAliveInterval)
+	if err := pr.keepAlive.inject(time.Now()); err != nil {
+		return nil, nil, fmt.Errorf("hold client: %w", err)
+	}
+
+	// attempts run in the background so keep-alives go out on time
+	type result struct {
+		conn   net.Conn
+		reader *bufio.Reader
+		err    error
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	results := make(chan result, 1)
+	go func() {
+		for attempt := 1; ; attempt++ {
+			conn, reader, err := pr.relogin(deadline)
+			if err == nil {
+				select {
+				case results <- result{conn, reader, nil}:
+				case <-stop:
+					conn.Close()
+				}
+				return
+			}
+			log.Printf("[WARN] Reconnect attempt %d of %s to %s failed: %v", attempt, pr.username, pr.cfg.Remote, err)
+
+			wait := time.NewTimer(min(playReconnectRetryDelay, time.Until(deadline)))
+			select {
+			case <-wait.C:
+			case <-stop:
+				wait.Stop()
+				return
+			}
+			if !time.Now().Before(deadline) {
+				results <- result{err: err}
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	for {
+		select {
+		case r := <-results:
+			return r.conn, r.reader, r.err
+		case now := <-ticker.C:
+			if err := pr.keepAlive.inject(now); err != nil {
+				return nil, nil, fmt.Errorf("hold client: %w", err)
+			}
+		case <-pr.clientDone:
+			return nil, nil, errors.New("client left")
+		case <-timeout.C:
+			return nil, nil, errors.New("timed out")
+		}
+	}
+}
+
+// relogin dials the backend and replays the client's login, returning once
+// the backend has answered it with a login success
+func (pr *playReconnect) relogin(deadline time.Time) (net.Conn, *bufio.Reader, error) {
+	conn, err := dialRemote(pr.cfg.Remote, pr.cfg.LocalAddr, pr.cfg.ResolveViaLocalAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := pr.login(conn, deadline)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, reader, nil
+}
+
+// login sends the handshake and login start over conn and reads the login
+// packets up to the login success
+func (pr *playReconnect) login(conn net.Conn, deadline time.Time) (*bufio.Reader, error) {
+	conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+
+	handshake, err := packLoginHandshake(pr.protocol, pr.cfg, pr.addressSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if err := WritePacket(0x00, handshake, conn); err != nil {
+		return nil, fmt.Errorf("write handshake: %w", err)
+	}
+	if err := WritePacket(0x00, pr.loginStart, conn); err != nil {
+		return nil, fmt.Errorf("write login start: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	var stream streamState
+	for {
+		body, err := readFrameBody(reader)
+		if err != nil {
+			return nil, fmt.Errorf("read login: %w", err)
+		}
+		compressed := stream.compressed()
+		event, err := stream.observe(body)
+		if err != nil {
+			return nil, fmt.Errorf("read login: %w", err)
+		}
+
+		switch event {
+		case streamLoggedIn:
+			if stream.compressed() != pr.keepAlive.stream.compressed() {
+				return nil, errors.New("backend changed its compression")
+			}
+			return reader, nil
+		case streamEncrypted:
+			return nil, errors.New("backend requires encryption")
+		case streamRefused:
+			var reason String
+			if _, payload, err := readPacketID(body, compressed); err == nil {
+				reason.ReadFrom(bytes.NewReader(payload))
+			}
+			return nil, fmt.Errorf("backend refused the login: %s", reason)
+		case streamUnexpected:
+			id, _, _ := readPacketID(body, compressed)
+			return nil, fmt.Errorf("unexpected login packet 0x%02X", id)
+		}
+	}
+}
+
+// readFrameBody reads the body of one length prefixed frame
+func readFrameBody(r io.Reader) ([]byte, error) {
+	var length VarInt
+	if _, err := length.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	if length <= 0 || length > maxFrameLength {
+		return nil, errBadFrame
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,291 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mcproxy/config"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// playBackend logs the proxy in and sends a join game marker carrying name,
+// then hands the connection to serve
+func playBackend(name string, serve func(conn net.Conn)) net.Conn {
+	proxySide, backendSide := net.Pipe()
+	go func() {
+		defer backendSide.Close()
+		ReadPacket(backendSide)
+		ReadPacket(backendSide)
+		payload, _ := Pack(String("Steve"))
+		WritePacket(loginSuccess, payload, backendSide)
+		payload, _ = Pack(String(name))
+		WritePacket(0x26, payload, backendSide)
+		serve(backendSide)
+	}()
+	return proxySide
+}
+
+// readSkippingKeepAlives reads the next frame that is not a keep-alive
+func readSkippingKeepAlives(t *testing.T, conn net.Conn) (int, []byte) {
+	t.Helper()
+	for {
+		id, payload := readFrame(t, conn, false)
+		if id != 0x21 {
+			return id, payload
+		}
+	}
+}
+
+// failingWrites fails the writes to a backend once fail is set
+type failingWrites struct {
+	net.Conn
+	fail *atomic.Bool
+}
+
+func (c failingWrites) Write(p []byte) (int, error) {
+	if c.fail.Load() {
+		return 0, errors.New("broken pipe")
+	}
+	return c.Conn.Write(p)
+}
+
+func TestHandleForwardPlayReconnect(t *testing.T) {
+	cfg := config.ProxyConfig{
+		Listen:                 "127.0.0.1:40032",
+		Remote:                 "127.0.0.1:1",
+		Auth:                   "none",
+		KeepAliveIntervalMs:    50,
+		PlayReconnectTimeoutMs: 300,
+		ChatInjection:          true,
+	}
+	registerProxyStats(t, cfg)
+
+	t.Run("reconnected", func(t *testing.T) {
+		origDial := dialRemote
+		t.Cleanup(func() { dialRemote = origDial })
+
+		var dials atomic.Int32
+		received := make(chan Packet, 1)
+		dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+			switch dials.Add(1) {
+			case 1:
+				// the first backend goes away right after the join game
+				return playBackend("first", func(net.Conn) {}), nil
+			case 2:
+				return playBackend("second", func(conn net.Conn) {
+					if pkt, err := ReadPacket(conn); err == nil {
+						received <- pkt
+					}
+				}), nil
+			}
+			return nil, errors.New("backend down")
+		}
+
+		client, server := net.Pipe()
+		defer client.Close()
+		done := make(chan error, 1)
+		go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+
+		writeLoginStart(t, client, "Steve")
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if id, _ := readFrame(t, client, false); id != loginSuccess {
+			t.Fatalf("expected the login success, got 0x%02X", id)
+		}
+		if id, payload := readSkippingKeepAlives(t, client); id != 0x26 || !strings.Contains(string(payload), "first") {
+			t.Fatalf("expected the first join game, got 0x%02X %q", id, payload)
+		}
+
+		// the client is held with keep-alives until the second backend's
+		// join game arrives, its login success is not forwarded
+		if id, _ := readFrame(t, client, false); id != 0x21 {
+			t.Fatalf("expected a keep-alive while held, got 0x%02X", id)
+		}
+		if id, payload := readSkippingKeepAlives(t, client); id != 0x26 || !strings.Contains(string(payload), "second") {
+			t.Fatalf("expected the second join game, got 0x%02X %q", id, payload)
+		}
+
+		if _, err := client.Write(frame(t, false, 0x03, String("hello"))); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case pkt := <-received:
+			if pkt.ID != 0x03 {
+				t.Errorf("second backend got packet 0x%02X", pkt.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("client data did not reach the second backend")
+		}
+
+		// the second backend is gone as well and further attempts fail
+		go func() {
+			for {
+				if _, err := client.Read(make([]byte, 256)); err != nil {
+					return
+				}
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handleForward did not return")
+		}
+		if n := dials.Load(); n < 3 {
+			t.Errorf("dials = %d, want a reconnect attempt after the second backend left", n)
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		origDial := dialRemote
+		t.Cleanup(func() { dialRemote = origDial })
+
+		var dials atomic.Int32
+		var fail atomic.Bool
+		received := make(chan Packet, 1)
+		dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+			switch dials.Add(1) {
+			case 1:
+				// the first backend stays connected but can not be written to
+				first := playBackend("first", func(conn net.Conn) { io.Copy(io.Discard, conn) })
+				return failingWrites{first, &fail}, nil
+			case 2:
+				return playBackend("second", func(conn net.Conn) {
+					if pkt, err := ReadPacket(conn); err == nil {
+						received <- pkt
+					}
+				}), nil
+			}
+			return nil, errors.New("backend down")
+		}
+
+		client, server := net.Pipe()
+		defer client.Close()
+		done := make(chan error, 1)
+		go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+
+		writeLoginStart(t, client, "Steve")
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if id, _ := readFrame(t, client, false); id != loginSuccess {
+			t.Fatalf("expected the login success, got 0x%02X", id)
+		}
+		if id, _ := readSkippingKeepAlives(t, client); id != 0x26 {
+			t.Fatalf("expected the first join game, got 0x%02X", id)
+		}
+
+		// the failed write holds the client like a failed read would
+		fail.Store(true)
+		if _, err := client.Write(frame(t, false, 0x03, String("lost"))); err != nil {
+			t.Fatal(err)
+		}
+		if id, payload := readSkippingKeepAlives(t, client); id != 0x26 || !strings.Contains(string(payload), "second") {
+			t.Fatalf("expected the second join game, got 0x%02X %q", id, payload)
+		}
+		if _, err := client.Write(frame(t, false, 0x03, String("hello"))); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case pkt := <-received:
+			if pkt.ID != 0x03 {
+				t.Errorf("second backend got packet 0x%02X", pkt.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("client data did not reach the second backend")
+		}
+
+		go func() {
+			for {
+				if _, err := client.Read(make([]byte, 256)); err != nil {
+					return
+				}
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handleForward did not return")
+		}
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		origDial := dialRemote
+		t.Cleanup(func() { dialRemote = origDial })
+
+		var dials atomic.Int32
+		dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+			if dials.Add(1) == 1 {
+				return playBackend("first", func(net.Conn) {}), nil
+			}
+			return nil, errors.New("backend down")
+		}
+
+		client, server := net.Pipe()
+		defer client.Close()
+		done := make(chan error, 1)
+		go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+
+		writeLoginStart(t, client, "Steve")
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if id, _ := readFrame(t, client, false); id != loginSuccess {
+			t.Fatalf("expected the login success, got 0x%02X", id)
+		}
+		if id, _ := readSkippingKeepAlives(t, client); id != 0x26 {
+			t.Fatalf("expected the join game, got 0x%02X", id)
+		}
+
+		start := time.Now()
+		id, payload := readSkippingKeepAlives(t, client)
+		if id != 0x1A || !strings.Contains(string(payload), backendLostMessage) {
+			t.Fatalf("expected the disconnect, got 0x%02X %q", id, payload)
+		}
+		if held := time.Since(start); held < 200*time.Millisecond {
+			t.Errorf("client disconnected after %v, before the reconnect timeout", held)
+		}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handleForward did not return")
+		}
+		if n := dials.Load(); n < 2 {
+			t.Errorf("dials = %d, want a reconnect attempt", n)
+		}
+	})
+	t.Run("kicked", func(t *testing.T) {
+		origDial := dialRemote
+		t.Cleanup(func() { dialRemote = origDial })
+
+		var dials atomic.Int32
+		dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+			dials.Add(1)
+			return playBackend("first", func(conn net.Conn) {
+				payload, _ := Pack(String(`{"text":"Banned"}`))
+				WritePacket(0x1A, payload, conn)
+			}), nil
+		}
+
+		client, server := net.Pipe()
+		defer client.Close()
+		done := make(chan error, 1)
+		go func() { done <- handleForward(context.Background(), server, server, "", VERSION_1_18_2, cfg, nil) }()
+
+		writeLoginStart(t, client, "Steve")
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		readFrame(t, client, false)
+		readSkippingKeepAlives(t, client)
+		if reason := readDisconnect(t, client); !strings.Contains(reason, "Banned") {
+			t.Errorf("disconnect reason = %s", reason)
+		}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handleForward did not return")
+		}
+		if n := dials.Load(); n != 1 {
+			t.Errorf("dials = %d, a kicked player must not be reconnected", n)
+		}
+	})
+}
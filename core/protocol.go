@@ -13,6 +13,11 @@ type (
 )
 
 func readByte(r io.Reader) (byte, error) {
+	// buffered readers hand out a byte without the allocation of a read
+	// buffer escaping to the interface call
+	if br, ok := r.(io.ByteReader); ok {
+		return br.ReadByte()
+	}
 	b := [1]byte{0}
 	_, err := r.Read(b[:])
 	return b[0], err
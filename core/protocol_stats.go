@@ -0,0 +1,71 @@
+package core
+
+import (
+	"sort"
+	"sync"
+)
+
+// protocolCounts counts the logins since the start by the protocol version of
+// the client's handshake
+var protocolCounts = struct {
+	sync.Mutex
+	counts map[int]int64
+}{counts: make(map[int]int64)}
+
+// recordProtocol counts a login with the protocol version
+func recordProtocol(protocol int) {
+	if protocol <= 0 {
+		return
+	}
+	protocolCounts.Lock()
+	protocolCounts.counts[protocol]++
+	protocolCounts.Unlock()
+}
+
+// ProtocolStat is the number of logins with one protocol version
+type ProtocolStat struct {
+	Protocol    int     `json:"protocol"`
+	Version     string  `json:"version"`
+	Connections int64   `json:"connections"` // Logins since the start
+	Share       float64 `json:"share"`       // Percentage of all logins since the start
+	Online      int     `json:"online"`      // Connections open right now
+}
+
+// ProtocolStats returns the logins by protocol version, most used first
+func ProtocolStats() []ProtocolStat {
+	protocolCounts.Lock()
+	counts := make(map[int]int64, len(protocolCounts.counts))
+	var total int64
+	for protocol, n := range protocolCounts.counts {
+		counts[protocol] = n
+		total += n
+	}
+	protocolCounts.Unlock()
+
+	online := make(map[int]int)
+	activeConnections.RLock()
+	for _, conn := range activeConnections.connections {
+		if conn.Protocol > 0 {
+			online[conn.Protocol]++
+		}
+	}
+	activeConnections.RUnlock()
+
+	stats := make([]ProtocolStat, 0, len(counts))
+	for protocol, n := range counts {
+		stats = append(stats, ProtocolStat{
+			Protocol:    protocol,
+			Version:     ProtocolName(protocol),
+			Connections: n,
+			Share:       float64(n) / float64(total) * 100,
+			Online:      online[protocol],
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Connections != stats[j].Connections {
+			return stats[i].Connections > stats[j].Connections
+		}
+		return stats[i].Protocol > stats[j].Protocol
+	})
+	return stats
+}
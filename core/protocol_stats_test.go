@@ -0,0 +1,87 @@
+package core
+
+import (
+	"io"
+	"mcproxy/config"
+	"net"
+	"testing"
+	"time"
+)
+
+// protocolLogins returns the logins counted for the protocol
+func protocolLogins(protocol int) int64 {
+	for _, stat := range ProtocolStats() {
+		if stat.Protocol == protocol {
+			return stat.Connections
+		}
+	}
+	return 0
+}
+
+func TestHandlerRecordsProtocol(t *testing.T) {
+	// the backend is dialed while the connection is registered
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	protocols := make(chan int, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		activeConnections.RLock()
+		for _, conn := range activeConnections.connections {
+			if conn.Username == "Steve" {
+				protocols <- conn.Protocol
+			}
+		}
+		activeConnections.RUnlock()
+		return nil, io.EOF
+	}
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40035", Remote: "backend.example.com:25565", MaxPlayer: 10, Auth: "none"}
+	registerProxyStats(t, cfg)
+	before := protocolLogins(VERSION_1_18_2)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go handler(server, cfg, 0, nil)
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	writeLoginStart(t, client, "Steve")
+
+	select {
+	case protocol := <-protocols:
+		if protocol != VERSION_1_18_2 {
+			t.Errorf("connection protocol = %d, want %d", protocol, VERSION_1_18_2)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the login did not reach the backend")
+	}
+	if n := protocolLogins(VERSION_1_18_2); n != before+1 {
+		t.Errorf("logins with protocol %d = %d, want %d", VERSION_1_18_2, n, before+1)
+	}
+}
+
+func TestProtocolStats(t *testing.T) {
+	// protocols no other test logs in with
+	recordProtocol(5)
+	recordProtocol(47)
+	recordProtocol(47)
+	recordProtocol(0)
+
+	stats := ProtocolStats()
+	var total int64
+	index := make(map[int]int)
+	for i, stat := range stats {
+		total += stat.Connections
+		index[stat.Protocol] = i
+	}
+	if _, ok := index[0]; ok {
+		t.Error("counted a login without a protocol")
+	}
+	if index[47] > index[5] {
+		t.Errorf("protocol 47 listed after protocol 5: %v", stats)
+	}
+	stat := stats[index[47]]
+	if stat.Connections != 2 || stat.Version != ProtocolName(47) {
+		t.Errorf("protocol 47: %+v", stat)
+	}
+	if want := float64(2) / float64(total) * 100; stat.Share != want {
+		t.Errorf("share = %v, want %v", stat.Share, want)
+	}
+}
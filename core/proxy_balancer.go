@@ -3,11 +3,11 @@ package core
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"mcproxy/config"
 	"net"
 	"sort"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,23 +19,56 @@ type proxyStatistics struct {
 	successfulConnections atomic.Int64
 	// Number of failed connections
 	failedConnections atomic.Int64
-	// Last time this proxy was selected
-	lastSelected time.Time
-	// Is this proxy considered healthy
-	healthy atomic.Bool
+	// Last time this proxy was selected in Unix nanoseconds, 0 if never.
+	// Selections only hold the read lock, so it is atomic.
+	lastSelected atomic.Int64
+	// Circuit breaker following the proxy's dial results
+	breaker *circuitBreaker
 }
 
+// Values of balancer_on_all_unhealthy
+const (
+	BalancerBestEffort      = "besteffort" // Pick the least bad proxy anyway
+	BalancerRejectUnhealthy = "reject"     // Reject connections while no proxy is healthy
+)
+
+// Default balancer_load_weight, balancer_capacity_weight and
+// balancer_candidate_band
+const (
+	defaultBalancerLoadWeight     = 0.6
+	defaultBalancerCapacityWeight = 0.4
+	defaultBalancerCandidateBand  = 0.1
+)
+
+// noServersMessage is shown to clients when the balancer has no proxy to
+// offer, unless balancer_no_servers_message replaces it
+const noServersMessage = "No servers available, please try again later"
+
 // ProxyBalancer manages load balancing across multiple proxies
 type ProxyBalancer struct {
 	listenAddr string
 	proxies    []config.ProxyConfig
 	listener   net.Listener
+	reusePort  bool
 	stopChan   chan struct{}
 	mutex      sync.RWMutex
 	// Track the last selected proxy index for round-robin
 	lastIndex int
 	// Track proxy health and statistics
 	proxyStats map[int]*proxyStatistics
+	// What to do when every proxy is unhealthy, BalancerBestEffort or BalancerRejectUnhealthy
+	onAllUnhealthy string
+	// Networks allowed to connect, empty allows everyone
+	allowlist listenAllowlist
+	// Peers whose PROXY protocol header is trusted
+	trustedProxies listenAllowlist
+	// Message shown when no proxy can take a client, empty uses noServersMessage
+	noServersMessage string
+	// Shares of the free room and the capacity in a proxy's score, and the
+	// fraction below the best score a proxy may pick up connections in
+	loadWeight     float64
+	capacityWeight float64
+	candidateBand  float64
 }
 
 // NewProxyBalancer creates a new proxy balancer
@@ -43,9 +76,7 @@ func NewProxyBalancer(listenAddr string, proxies []config.ProxyConfig) *ProxyBal
 	// Create proxy statistics map
 	proxyStats := make(map[int]*proxyStatistics)
 	for i := range proxies {
-		stats := &proxyStatistics{}
-		stats.healthy.Store(true) // Start with all proxies considered healthy
-		proxyStats[i] = stats
+		proxyStats[i] = &proxyStatistics{breaker: newCircuitBreaker(proxies[i].Listen)}
 	}
 
 	return &ProxyBalancer{
@@ -54,12 +85,16 @@ func NewProxyBalancer(listenAddr string, proxies []config.ProxyConfig) *ProxyBal
 		stopChan:   make(chan struct{}),
 		lastIndex:  -1, // Start with -1 so first selection will be index 0
 		proxyStats: proxyStats,
+
+		loadWeight:     defaultBalancerLoadWeight,
+		capacityWeight: defaultBalancerCapacityWeight,
+		candidateBand:  defaultBalancerCandidateBand,
 	}
 }
 
 // Start starts the proxy balancer
 func (pb *ProxyBalancer) Start() error {
-	listener, err := net.Listen("tcp", pb.listenAddr)
+	listener, err := listenTCP(pb.listenAddr, pb.reusePort)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %v", pb.listenAddr, err)
 	}
@@ -76,11 +111,16 @@ func (pb *ProxyBalancer) Start() error {
 	return nil
 }
 
-// Stop stops the proxy balancer
+// Stop stops the proxy balancer, stopping it again does nothing
 func (pb *ProxyBalancer) Stop() {
 	pb.mutex.Lock()
 	defer pb.mutex.Unlock()
 
+	select {
+	case <-pb.stopChan:
+		return
+	default:
+	}
 	close(pb.stopChan)
 	if pb.listener != nil {
 		pb.listener.Close()
@@ -106,10 +146,23 @@ func (pb *ProxyBalancer) acceptLoop() {
 					continue
 				}
 
-				log.Printf("[ERROR] Failed to accept connection: %v", err)
+				// a listener closed by Stop is not an error
+				select {
+				case <-pb.stopChan:
+					log.Printf("[INFO] Stopping proxy balancer on %s", pb.listenAddr)
+				default:
+					log.Printf("[ERROR] Failed to accept connection: %v", err)
+				}
 				return
 			}
 
+			// Connections from outside the allowlist are closed before
+			// anything is read from them
+			if !pb.allowlist.allows(conn.RemoteAddr()) {
+				conn.Close()
+				continue
+			}
+
 			// Handle the connection in a separate goroutine
 			go pb.handleConnection(conn)
 		}
@@ -118,15 +171,30 @@ func (pb *ProxyBalancer) acceptLoop() {
 
 // handleConnection handles a Minecraft connection using the selected proxy's network interface
 func (pb *ProxyBalancer) handleConnection(clientConn net.Conn) {
-	clientAddr := clientConn.RemoteAddr().String()
-	defer clientConn.Close()
-	defer log.Printf("[INFO] Balancer: Connection ended: %s", clientAddr)
-	log.Printf("[INFO] Balancer: New connection from: %s", clientAddr)
+	acceptedAt := time.Now()
 
-	// Create a buffered reader for the client connection
+	// Create a buffered reader for the client connection. Clients may send the
+	// handshake and the packets after it in one write, so everything after the
+	// handshake is read through reader, which can already hold them.
 	reader := bufio.NewReader(clientConn)
 	defer reader.Reset(nil)
 
+	// A trusted load balancer in front of this one names the client in a
+	// PROXY protocol header
+	clientConn, err := acceptProxyHeader(clientConn, reader, pb.trustedProxies)
+	if err != nil {
+		log.Printf("[WARN] Balancer: Closing %s: %v", clientConn.RemoteAddr(), err)
+		clientConn.Close()
+		return
+	}
+
+	clientAddr := normalizeClientAddr(clientConn.RemoteAddr().String())
+	defer recoverConnection(clientAddr, clientConn)
+	defer clientConn.Close()
+	defer log.Printf("[INFO] Balancer: Connection ended: %s", clientAddr)
+	log.Printf("[INFO] Balancer: New connection from: %s", clientAddr)
+	flapping := clientFlaps.record(clientAddr, time.Now())
+
 	// Read the handshake packet
 	pkt, err := ReadPacket(reader)
 	if err != nil {
@@ -145,34 +213,83 @@ func (pb *ProxyBalancer) handleConnection(clientConn net.Conn) {
 		return
 	}
 
-	log.Printf("[INFO] Balancer: Client %s connecting to %s:%d, protocol=%d, state=%d",
-		clientAddr, address, port, protocol, nextState)
-
-	// Find the best proxy to use
+	// Find the best proxy to use, its hostname limits and log verbosity apply
+	// to the connection from here on and rejections are counted for it
 	proxyConfig, proxyIndex := pb.selectBestProxy()
+	var selectedConfig config.ProxyConfig
+	if proxyConfig != nil {
+		selectedConfig = *proxyConfig
+	}
+
+	// IPs sending handshakes faster than handshake_rate_limit are dropped
+	if !handshakeRateLimit.allow(clientIPFromAddr(clientAddr), time.Now()) {
+		log.Printf("[DEBUG] Balancer: Dropping %s, handshake rate limit exceeded", clientAddr)
+		GetControlPanel().RecordRejection(selectedConfig.Listen, RejectHandshakeRate)
+		return
+	}
+
+	if selectedConfig.StrictHandshake {
+		if err := checkStrictHandshake(int(protocol), string(address), int(port), int(nextState)); err != nil {
+			connDebugf(selectedConfig, "Balancer: Closing %s, malformed handshake: %v", clientAddr, err)
+			GetControlPanel().RecordRejection(selectedConfig.Listen, RejectMalformed)
+			return
+		}
+	}
+
+	checked, truncated, err := checkHandshakeAddress(string(address), selectedConfig)
+	if err != nil {
+		log.Printf("[WARN] Balancer: Closing %s, oversized handshake hostname: %v", clientAddr, err)
+		GetControlPanel().RecordRejection(selectedConfig.Listen, RejectInvalidHostname)
+		if nextState == 2 || nextState == 3 {
+			sendDisconnect(clientConn, invalidHostnameMessage)
+		}
+		return
+	}
+	if truncated {
+		log.Printf("[WARN] Balancer: Truncated oversized handshake hostname from %s (%d bytes)", clientAddr, len(address))
+		address = String(checked)
+	}
+
+	connInfof(selectedConfig, "Balancer: Client %s connecting to %s, protocol=%d (%s), state=%d",
+		clientAddr, formatAddr(string(address), int(port)), protocol, ProtocolName(int(protocol)), nextState)
+
 	if proxyConfig == nil {
 		log.Printf("[ERROR] Balancer: No suitable proxy found for connection from %s", clientAddr)
+		err := rejectNoServers(reader, clientConn, int(protocol), int(nextState), pb.noServers())
+		if err != nil {
+			log.Printf("[ERROR] Balancer: Failed to reject %s: %v", clientAddr, err)
+		}
 		return
 	}
 
-	log.Printf("[INFO] Balancer: Selected proxy %d interface %s (remote: %s) for client %s", 
+	connInfof(selectedConfig, "Balancer: Selected proxy %d interface %s (remote: %s) for client %s", 
 		proxyIndex+1, proxyConfig.LocalAddr, proxyConfig.Remote, clientAddr)
 
 	// Get the public IP for the selected proxy
-	publicIP := GetPublicIP(proxyConfig.LocalAddr)
+	publicIP := publicIPFunc(proxyConfig.LocalAddr)
 
 	// Handle different types of requests based on the next state
 	switch nextState {
 	case 1: // status (ping)
-		log.Printf("[DEBUG] Balancer: Handling ping request from %s", clientAddr)
+		connDebugf(selectedConfig, "Balancer: Handling ping request from %s", clientAddr)
 		err := handlePing(reader, clientConn, int(protocol), *proxyConfig)
 		if err != nil {
 			log.Printf("[ERROR] Balancer: Failed to handle ping from %s: %v", clientAddr, err)
 		}
 
-	case 2: // login
+	case 2, 3: // login, transfer (1.20.5+) continues with a regular login
+		if nextState == 3 && proxyConfig.RejectTransfers {
+			log.Printf("[INFO] Balancer: Rejecting transfer from %s", clientAddr)
+			err := sendDisconnect(clientConn, transferRejectedMessage)
+			if err != nil {
+				log.Printf("[ERROR] Balancer: Failed to disconnect %s: %v", clientAddr, err)
+			}
+			return
+		}
+
 		if protocol < VERSION_1_8_9 {
 			log.Printf("[WARN] Balancer: Client %s using unsupported protocol version: %d", clientAddr, protocol)
+			GetControlPanel().RecordRejection(proxyConfig.Listen, RejectUnsupportedVersion)
 			err := sendDisconnect(clientConn, "Unsupported client version")
 			if err != nil {
 				log.Printf("[ERROR] Balancer: Failed to disconnect %s: %v", clientAddr, err)
@@ -180,21 +297,48 @@ func (pb *ProxyBalancer) handleConnection(clientConn net.Conn) {
 			return
 		}
 
-		// Check if the server is full
-		if onlineCount.Load() >= int32(proxyConfig.MaxPlayer) {
-			log.Printf("[WARN] Balancer: Server full, rejecting client %s", clientAddr)
-			err := sendDisconnect(clientConn, "The server is full")
+		// Scanners and health checks that only send a handshake never reach the limits
+		username, err := waitForLoginStart(clientConn, reader, *proxyConfig)
+		if err != nil {
+			connDebugf(selectedConfig, "Balancer: No login start from %s: %v", clientAddr, err)
+			return
+		}
+
+		if LoginsPaused() {
+			log.Printf("[INFO] Balancer: Logins paused, rejecting client %s", clientAddr)
+			GetControlPanel().RecordRejection(proxyConfig.Listen, RejectPaused)
+			err := sendDisconnect(clientConn, pausedMessage())
 			if err != nil {
 				log.Printf("[ERROR] Balancer: Failed to disconnect %s: %v", clientAddr, err)
 			}
 			return
 		}
 
+		// Logins beyond max_player go to the proxy's overflow backend while it
+		// has room, the rest are disconnected
+		forwardConfig := *proxyConfig
+		if serverFull(*proxyConfig, username) {
+			if !acquireOverflowSlot(*proxyConfig) {
+				log.Printf("[WARN] Balancer: Server full, rejecting client %s", clientAddr)
+				GetControlPanel().RecordRejection(proxyConfig.Listen, fullRejection())
+				err := sendDisconnect(clientConn, "The server is full")
+				if err != nil {
+					log.Printf("[ERROR] Balancer: Failed to disconnect %s: %v", clientAddr, err)
+				}
+				return
+			}
+			defer releaseOverflowSlot(proxyConfig.Listen)
+			log.Printf("[INFO] Balancer: Server full, forwarding client %s to overflow server %s", clientAddr, proxyConfig.OverflowRemote)
+			forwardConfig.Remote = proxyConfig.OverflowRemote
+		}
+
 		// Check if we've reached the connection limit for this IP
-		currentCount := GetConnectionCountForIP(publicIP)
+		limitKey := connectionLimitKey(publicIP, clientAddr)
+		currentCount := connectionCountForIP(limitKey)
 		if currentCount >= MaxConnectionsPerIP {
 			log.Printf("[WARN] Balancer: Connection limit reached for IP %s (%d connections), rejecting client %s",
-				publicIP, currentCount, clientAddr)
+				limitKey, currentCount, clientAddr)
+			GetControlPanel().RecordRejection(proxyConfig.Listen, RejectIPLimit)
 			err := sendDisconnect(clientConn, "Connection limit reached for your IP")
 			if err != nil {
 				log.Printf("[ERROR] Balancer: Failed to disconnect %s: %v", clientAddr, err)
@@ -202,13 +346,28 @@ func (pb *ProxyBalancer) handleConnection(clientConn net.Conn) {
 			return
 		}
 
+		// Check the connection limit for the client's own IP
+		clientIP := clientIPFromAddr(clientAddr)
+		clientCount, ok := acquireClientIPSlot(clientIP, proxyConfig.MaxConnectionsPerClientIP)
+		if !ok {
+			log.Printf("[WARN] Balancer: Connection limit reached for client IP %s (%d connections), rejecting client %s",
+				clientIP, clientCount, clientAddr)
+			GetControlPanel().RecordRejection(proxyConfig.Listen, RejectIPLimit)
+			err := sendDisconnect(clientConn, "Too many connections from your IP")
+			if err != nil {
+				log.Printf("[ERROR] Balancer: Failed to disconnect %s: %v", clientAddr, err)
+			}
+			return
+		}
+		defer releaseClientIPSlot(clientIP)
+
 		// Create a connection ID
-		connID := fmt.Sprintf("%s-%d", clientAddr, time.Now().UnixNano())
+		connID := newConnectionID(clientAddr)
 
-		// Check if the client is using FML (Forge Mod Loader)
-		isFML := strings.HasSuffix(string(address), "\x00FML\x00")
-		if isFML {
-			log.Printf("[INFO] Balancer: FML client detected: %s", clientAddr)
+		// Check if the client is using a Forge style mod loader
+		_, addressSuffix, modLoader := splitHandshakeAddress(string(address))
+		if modLoader != "" {
+			connInfof(selectedConfig, "Balancer: Forge client detected (%s): %s", modLoader, clientAddr)
 		}
 
 		// Create and register the connection
@@ -216,52 +375,72 @@ func (pb *ProxyBalancer) handleConnection(clientConn net.Conn) {
 			ID:          connID,
 			ClientAddr:  clientAddr,
 			ProxyAddr:   proxyConfig.Listen,
-			RemoteAddr:  proxyConfig.Remote,
+			RemoteAddr:  forwardConfig.Remote,
 			ConnectedAt: time.Now(),
 			ClientConn:  clientConn,
-			ProxyIndex:  -1, // -1 indicates it's a balancer connection
+			ProxyIndex:  proxyIndex,
+			ViaBalancer: true,
 			PublicIP:    publicIP,
+			ModLoader:   modLoader,
+			Group:       proxyConfig.Group,
+			Protocol:    int(protocol),
+			Flapping:    flapping,
 		}
-		RegisterConnection(connection)
-		defer UnregisterConnection(connID)
-
-		// Increment global connection count
-		onlineCount.Add(1)
- 	defer decrementOnlineCount()
+		registerConnection(connection)
+		// the registry may have renamed the connection
+		defer func() { unregisterConnection(connection.ID) }()
 
-		// Only increment connection count for the load balancer itself
-		// The individual proxy's connection count will be incremented in handleForward
+		// Only increment connection count for the load balancer itself, the
+		// global and individual proxy's counts are incremented in handleForward
 		cp := GetControlPanel()
 		cp.IncrementConnectionCount(pb.listenAddr)
 		defer cp.DecrementConnectionCount(pb.listenAddr)
 
-	// Get the proxy statistics
+	// Get the proxy statistics. The breaker and the dial counts track the
+	// proxy's own backend, a login sent to its overflow backend neither waits
+	// on nor counts toward them.
 	proxyStats := pb.proxyStats[proxyIndex]
+	overflow := forwardConfig.Remote != proxyConfig.Remote
+
+	// An open breaker rejects the login without dialing, unless every proxy is
+	// failing and the balancer makes a best effort anyway
+	if !overflow {
+		allowed, probe := proxyStats.breaker.acquire(time.Now())
+		if !allowed && pb.onAllUnhealthy == BalancerRejectUnhealthy {
+			log.Printf("[WARN] Balancer: Proxy %d circuit breaker is open, rejecting client %s", proxyIndex+1, clientAddr)
+			cp.RecordRejection(proxyConfig.Listen, RejectUnhealthy)
+			if err := sendDisconnect(clientConn, pb.noServers()); err != nil {
+				log.Printf("[ERROR] Balancer: Failed to disconnect %s: %v", clientAddr, err)
+			}
+			return
+		}
+		if probe {
+			defer proxyStats.breaker.release()
+		}
+	}
 
-	// Handle the forwarding
-	err := handleForward(reader, clientConn, isFML, int(protocol), *proxyConfig)
+	// Handle the forwarding, the dial result drives the circuit breaker
+	ctx, cancel := loginContext(*proxyConfig, acceptedAt)
+	defer cancel()
+	var observeDial func(error)
+	if !overflow {
+		observeDial = func(err error) {
+			if err != nil {
+				proxyStats.failedConnections.Add(1)
+				proxyStats.breaker.recordFailure(time.Now())
+			} else {
+				proxyStats.successfulConnections.Add(1)
+				proxyStats.breaker.recordSuccess()
+			}
+		}
+	}
+	err = handleForward(ctx, reader, clientConn, addressSuffix, int(protocol), forwardConfig, observeDial)
 	if err != nil {
 		log.Printf("[ERROR] Balancer: Failed to handle forward for %s: %v", clientAddr, err)
-		// Record failed connection
-		proxyStats.failedConnections.Add(1)
-
-		// If we have too many failures, mark the proxy as unhealthy
-		if proxyStats.failedConnections.Load() > proxyStats.successfulConnections.Load()*2 && 
-		   proxyStats.failedConnections.Load() > 5 {
-			proxyStats.healthy.Store(false)
-			log.Printf("[WARN] Proxy %d marked as unhealthy due to too many failures", proxyIndex+1)
-		}
-	} else {
-		// Record successful connection
-		proxyStats.successfulConnections.Add(1)
-
-		// If we have enough successful connections, mark the proxy as healthy again
-		if !proxyStats.healthy.Load() && 
-		   proxyStats.successfulConnections.Load() > proxyStats.failedConnections.Load() {
-			proxyStats.healthy.Store(true)
-			log.Printf("[INFO] Proxy %d marked as healthy again", proxyIndex+1)
-		}
 	}
+
+	default:
+		connDebugf(selectedConfig, "Balancer: Closing %s, unexpected next state %d", clientAddr, nextState)
 	}
 }
 
@@ -285,17 +464,28 @@ func (pb *ProxyBalancer) selectBestProxy() (*config.ProxyConfig, int) {
 		healthy       bool    // Is this proxy healthy
 	}
 
+	if pb.onAllUnhealthy == BalancerRejectUnhealthy && !pb.anyHealthy() {
+		log.Printf("[WARN] Balancer: All proxies are unhealthy, rejecting connection")
+		return nil, -1
+	}
+
 	// Calculate scores for each proxy
+	now := time.Now()
 	scores := make([]proxyScore, 0, len(pb.proxies))
 	totalMaxConnections := 0
 
 	// First pass: gather data and calculate total capacity
 	for i, proxy := range pb.proxies {
+		// Status-only proxies have no backend to forward to
+		if proxy.Mode == ModeStatusOnly {
+			continue
+		}
+
 		// Get the proxy's public IP
-		ip := GetPublicIP(proxy.LocalAddr)
+		ip := publicIPFunc(proxy.LocalAddr)
 
 		// Get current connection count
-		connectionCount := GetConnectionCountForIP(ip)
+		connectionCount := connectionCountForIP(ip)
 
 		// Get max connections (use MaxPlayer as capacity indicator)
 		maxConnections := proxy.MaxPlayer
@@ -312,9 +502,9 @@ func (pb *ProxyBalancer) selectBestProxy() (*config.ProxyConfig, int) {
 			loadPercent = float64(connectionCount) / float64(maxConnections) * 100.0
 		}
 
-		// Check if this proxy is healthy
+		// Check if this proxy's circuit breaker lets connections through
 		stats := pb.proxyStats[i]
-		healthy := stats.healthy.Load()
+		healthy := stats.breaker.ready(now)
 
 		// Add to scores array
 		scores = append(scores, proxyScore{
@@ -326,6 +516,10 @@ func (pb *ProxyBalancer) selectBestProxy() (*config.ProxyConfig, int) {
 		})
 	}
 
+	if len(scores) == 0 {
+		return nil, -1
+	}
+
 	// Second pass: calculate weights based on capacity and current load
 	for i := range scores {
 		// Start with a base weight proportional to capacity
@@ -336,12 +530,7 @@ func (pb *ProxyBalancer) selectBestProxy() (*config.ProxyConfig, int) {
 
 		// Calculate final weight (capacity weight + load adjustment)
 		// This gives preference to proxies with higher capacity and lower current load
-		scores[i].weight = capacityWeight * 0.4 + loadAdjustment * 0.6
-
-		// If proxy is not healthy, significantly reduce its weight
-		if !scores[i].healthy {
-			scores[i].weight *= 0.1
-		}
+		scores[i].weight = capacityWeight * pb.capacityWeight + loadAdjustment * pb.loadWeight
 
 		// If proxy is at or above connection limit, make it less likely to be chosen
 		if scores[i].connectionCount >= scores[i].maxConnections {
@@ -353,6 +542,18 @@ func (pb *ProxyBalancer) selectBestProxy() (*config.ProxyConfig, int) {
 			scores[i].loadPercent, scores[i].weight)
 	}
 
+	// Proxies with an open circuit breaker are skipped, unless all of them
+	// are open and the balancer makes a best effort
+	if pb.anyHealthy() {
+		ready := scores[:0]
+		for _, score := range scores {
+			if score.healthy {
+				ready = append(ready, score)
+			}
+		}
+		scores = ready
+	}
+
 	// Sort by weight (descending)
 	sort.Slice(scores, func(i, j int) bool {
 		return scores[i].weight > scores[j].weight
@@ -361,13 +562,14 @@ func (pb *ProxyBalancer) selectBestProxy() (*config.ProxyConfig, int) {
 	// Add some randomization to prevent all connections going to the same proxy
 	// when multiple proxies have similar weights
 
-	// Get the top candidates (proxies with weights within 10% of the best one)
+	// Get the top candidates (proxies with weights within the candidate band
+	// of the best one, 10% by default)
 	topCandidates := make([]proxyScore, 0)
 	if len(scores) > 0 {
 		bestWeight := scores[0].weight
 		for _, score := range scores {
-			// If weight is within 10% of the best weight, consider it a top candidate
-			if score.weight >= bestWeight * 0.9 {
+			// If weight is within the band of the best weight, consider it a top candidate
+			if score.weight >= bestWeight * (1 - pb.candidateBand) {
 				topCandidates = append(topCandidates, score)
 			}
 		}
@@ -394,18 +596,217 @@ func (pb *ProxyBalancer) selectBestProxy() (*config.ProxyConfig, int) {
 
 	// Update statistics for the selected proxy
 	if stats, ok := pb.proxyStats[selectedIndex]; ok {
-		stats.lastSelected = time.Now()
+		stats.lastSelected.Store(time.Now().UnixNano())
 	}
 
 	// Return the selected proxy and its index
 	return &pb.proxies[selectedIndex], selectedIndex
 }
 
+// anyHealthy reports whether at least one proxy's circuit breaker lets
+// connections through, the caller holds pb.mutex
+func (pb *ProxyBalancer) anyHealthy() bool {
+	for i := range pb.proxies {
+		if pb.proxies[i].Mode == ModeStatusOnly {
+			continue
+		}
+		if stats, ok := pb.proxyStats[i]; ok && stats.breaker.ready(time.Now()) {
+			return true
+		}
+	}
+	return false
+}
+
+// noServers returns the message shown when no proxy can take a client
+func (pb *ProxyBalancer) noServers() string {
+	if pb.noServersMessage != "" {
+		return pb.noServersMessage
+	}
+	return noServersMessage
+}
+
+// rejectNoServers answers a status request with message as the MOTD, or
+// disconnects a login with it, when the balancer has no proxy to send the
+// client to
+func rejectNoServers(reader io.Reader, conn io.Writer, protocol int, nextState int, message string) error {
+	if nextState != 1 {
+		return sendDisconnect(conn, message)
+	}
+
+	pkt, err := ReadPacket(reader)
+	if err != nil {
+		return err
+	}
+	if pkt.ID != 0x00 {
+		return fmt.Errorf("expect packet Request, got %d", pkt.ID)
+	}
+
+	err = sendResponse(conn, protocol, config.ProxyConfig{}, message)
+	if err != nil {
+		return err
+	}
+	return handlePingFallback(reader, conn)
+}
+
+// activeBalancers maps listen addresses to the balancers started by
+// StartBalancer, so they can be stopped on a restart and their stats read
+var activeBalancers = make(map[string]*ProxyBalancer)
+var balancerMutex sync.RWMutex
+
+// registerBalancer adds a started balancer to activeBalancers, replacing and
+// stopping one on the same address
+func registerBalancer(pb *ProxyBalancer) {
+	balancerMutex.Lock()
+	old := activeBalancers[pb.listenAddr]
+	activeBalancers[pb.listenAddr] = pb
+	balancerMutex.Unlock()
+
+	if old != nil && old != pb {
+		old.Stop()
+	}
+}
+
+// StopBalancer stops the balancer listening on listenAddr and removes it from
+// the registry. It reports whether such a balancer was running.
+func StopBalancer(listenAddr string) bool {
+	balancerMutex.Lock()
+	pb, ok := activeBalancers[listenAddr]
+	delete(activeBalancers, listenAddr)
+	balancerMutex.Unlock()
+
+	if ok {
+		pb.Stop()
+	}
+	return ok
+}
+
+// runningBalancers returns the registered balancers by listen address
+func runningBalancers() []*ProxyBalancer {
+	balancerMutex.RLock()
+	defer balancerMutex.RUnlock()
+
+	balancers := make([]*ProxyBalancer, 0, len(activeBalancers))
+	for _, pb := range activeBalancers {
+		balancers = append(balancers, pb)
+	}
+	sort.Slice(balancers, func(i, j int) bool { return balancers[i].listenAddr < balancers[j].listenAddr })
+	return balancers
+}
+
+// restartBalancers stops the running balancers and starts them again on the
+// same addresses with the proxies of c
+func restartBalancers(c *config.Config) {
+	for _, pb := range runningBalancers() {
+		StopBalancer(pb.listenAddr)
+		if err := startBalancer(pb.listenAddr, c); err != nil {
+			log.Printf("[ERROR] Failed to restart proxy balancer on %s: %v", pb.listenAddr, err)
+		}
+	}
+}
+
+// balancerBreakerStatuses returns the circuit breakers of the proxies used by
+// any running balancer, by listen address
+func balancerBreakerStatuses() map[string]BreakerStatus {
+	statuses := make(map[string]BreakerStatus)
+	for _, pb := range runningBalancers() {
+		for listen, status := range pb.breakerStatuses() {
+			statuses[listen] = status
+		}
+	}
+	return statuses
+}
+
+// breakerStatuses returns the circuit breaker of each proxy by listen address
+func (pb *ProxyBalancer) breakerStatuses() map[string]BreakerStatus {
+	pb.mutex.RLock()
+	defer pb.mutex.RUnlock()
+
+	statuses := make(map[string]BreakerStatus, len(pb.proxies))
+	for i, proxy := range pb.proxies {
+		if stats, ok := pb.proxyStats[i]; ok {
+			statuses[proxy.Listen] = stats.breaker.status()
+		}
+	}
+	return statuses
+}
+
+// BalancerProxyStat is the balancer's raw statistics of one proxy
+type BalancerProxyStat struct {
+	Balancer              string        `json:"balancer"` // listen address of the balancer
+	Listen                string        `json:"listen"`
+	SuccessfulConnections int64         `json:"successful_connections"`
+	FailedConnections     int64         `json:"failed_connections"`
+	LastSelected          *time.Time    `json:"last_selected,omitempty"` // never selected when nil
+	Healthy               bool          `json:"healthy"`                 // the circuit breaker lets connections through
+	Breaker               BreakerStatus `json:"breaker"`
+}
+
+// Stats returns a snapshot of the statistics of each proxy in config order
+func (pb *ProxyBalancer) Stats() []BalancerProxyStat {
+	pb.mutex.RLock()
+	defer pb.mutex.RUnlock()
+
+	now := time.Now()
+	stats := make([]BalancerProxyStat, 0, len(pb.proxies))
+	for i, proxy := range pb.proxies {
+		ps, ok := pb.proxyStats[i]
+		if !ok {
+			continue
+		}
+		stat := BalancerProxyStat{
+			Balancer:              pb.listenAddr,
+			Listen:                proxy.Listen,
+			SuccessfulConnections: ps.successfulConnections.Load(),
+			FailedConnections:     ps.failedConnections.Load(),
+			Healthy:               ps.breaker.ready(now),
+			Breaker:               ps.breaker.status(),
+		}
+		if nanos := ps.lastSelected.Load(); nanos != 0 {
+			selected := time.Unix(0, nanos)
+			stat.LastSelected = &selected
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
 // StartBalancer starts a proxy balancer with the given configuration
 func StartBalancer(listenAddr string, cfg *config.Config) {
+	if err := startBalancer(listenAddr, cfg); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+}
+
+// startBalancer starts a proxy balancer and registers it by its listen address
+func startBalancer(listenAddr string, cfg *config.Config) error {
 	balancer := NewProxyBalancer(listenAddr, cfg.Proxies)
-	err := balancer.Start()
+	balancer.reusePort = cfg.ReusePort
+	balancer.onAllUnhealthy = cfg.BalancerOnAllUnhealthy
+	balancer.noServersMessage = cfg.BalancerNoServersMessage
+	if cfg.BalancerLoadWeight != 0 || cfg.BalancerCapacityWeight != 0 {
+		balancer.loadWeight = cfg.BalancerLoadWeight
+		balancer.capacityWeight = cfg.BalancerCapacityWeight
+	}
+	if cfg.BalancerCandidateBand != nil {
+		balancer.candidateBand = *cfg.BalancerCandidateBand
+	}
+	if v := cfg.BalancerOnAllUnhealthy; v != "" && v != BalancerBestEffort && v != BalancerRejectUnhealthy {
+		log.Printf("[WARN] Unknown balancer_on_all_unhealthy %q, using %s", v, BalancerBestEffort)
+	}
+	allowlist, err := config.ParseAllowlist(cfg.BalancerListenAllowlist)
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to start proxy balancer: %v", err)
+		return fmt.Errorf("invalid balancer_listen_allowlist: %w", err)
 	}
+	balancer.allowlist = allowlist
+	trusted, err := config.ParseAllowlist(cfg.BalancerProxyProtocolTrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid balancer_proxy_protocol_trusted_proxies: %w", err)
+	}
+	balancer.trustedProxies = trusted
+	err = balancer.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start proxy balancer: %w", err)
+	}
+	registerBalancer(balancer)
+	return nil
 }
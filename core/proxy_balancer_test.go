@@ -0,0 +1,282 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"mcproxy/config"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubConnectionCounts makes every proxy's public IP its local address and
+// reports the given connection count for it
+func stubConnectionCounts(t *testing.T, counts map[string]int) {
+	t.Helper()
+	origPublicIP, origCount := publicIPFunc, connectionCountForIP
+	t.Cleanup(func() { publicIPFunc, connectionCountForIP = origPublicIP, origCount })
+
+	publicIPFunc = func(localAddr string) string { return hostOnly(localAddr) }
+	connectionCountForIP = func(ip string) int { return counts[ip] }
+}
+
+// registerTestBalancer adds pb to the balancer registry until the test ends
+func registerTestBalancer(t *testing.T, pb *ProxyBalancer) {
+	t.Helper()
+	balancerMutex.Lock()
+	activeBalancers[pb.listenAddr] = pb
+	balancerMutex.Unlock()
+	t.Cleanup(func() {
+		balancerMutex.Lock()
+		if activeBalancers[pb.listenAddr] == pb {
+			delete(activeBalancers, pb.listenAddr)
+		}
+		balancerMutex.Unlock()
+	})
+}
+
+func TestSelectBestProxy(t *testing.T) {
+	counts := map[string]int{"10.0.0.1": 10, "10.0.0.2": 9, "10.0.0.3": 1}
+	stubConnectionCounts(t, counts)
+
+	pb := NewProxyBalancer("127.0.0.1:0", []config.ProxyConfig{
+		{Listen: "127.0.0.1:40081", LocalAddr: "10.0.0.1:0", MaxPlayer: 10},
+		{Listen: "127.0.0.1:40082", LocalAddr: "10.0.0.2:0", MaxPlayer: 10},
+		{Listen: "127.0.0.1:40083", LocalAddr: "10.0.0.3:0", MaxPlayer: 10},
+	})
+
+	// The least loaded proxy wins
+	if _, idx := pb.selectBestProxy(); idx != 2 {
+		t.Errorf("selected proxy %d, want 2", idx)
+	}
+
+	// An unhealthy proxy is passed over even when it has the lowest load
+	tripBreaker(pb.proxyStats[2].breaker)
+	if _, idx := pb.selectBestProxy(); idx != 1 {
+		t.Errorf("selected proxy %d with proxy 2 unhealthy, want 1", idx)
+	}
+
+	// Counts are read on every selection
+	counts["10.0.0.1"] = 0
+	if cfg, idx := pb.selectBestProxy(); idx != 0 || cfg.Listen != "127.0.0.1:40081" {
+		t.Errorf("selected proxy %d (%v) after proxy 0 emptied, want 0", idx, cfg)
+	}
+}
+
+func TestSelectBestProxyWeights(t *testing.T) {
+	// a large proxy at half load and a small one almost empty
+	stubConnectionCounts(t, map[string]int{"10.0.0.1": 50, "10.0.0.2": 1})
+	pb := NewProxyBalancer("127.0.0.1:0", []config.ProxyConfig{
+		{Listen: "127.0.0.1:40084", LocalAddr: "10.0.0.1:0", MaxPlayer: 100},
+		{Listen: "127.0.0.1:40085", LocalAddr: "10.0.0.2:0", MaxPlayer: 10},
+	})
+
+	// The default weights favor the large proxy's capacity
+	if _, idx := pb.selectBestProxy(); idx != 0 {
+		t.Errorf("selected proxy %d with the default weights, want 0", idx)
+	}
+
+	// Weighing only the load spreads players to the emptier proxy
+	pb.loadWeight, pb.capacityWeight = 1, 0
+	if _, idx := pb.selectBestProxy(); idx != 1 {
+		t.Errorf("selected proxy %d weighing only the load, want 1", idx)
+	}
+
+	// A wide candidate band makes both proxies candidates
+	pb.candidateBand = 0.9
+	seen := make(map[int]bool)
+	for i := 0; i < 200 && len(seen) < 2; i++ {
+		_, idx := pb.selectBestProxy()
+		seen[idx] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("selected only %v with a candidate band of 0.9", seen)
+	}
+}
+
+func TestBalancerAllUnhealthy(t *testing.T) {
+	stubConnectionCounts(t, map[string]int{})
+
+	pb := NewProxyBalancer("127.0.0.1:0", []config.ProxyConfig{
+		{Listen: "127.0.0.1:40091", LocalAddr: "10.0.1.1:0", MaxPlayer: 10, PingMode: "fake"},
+		{Listen: "127.0.0.1:40092", LocalAddr: "10.0.1.2:0", MaxPlayer: 10, PingMode: "fake"},
+	})
+	for _, stats := range pb.proxyStats {
+		tripBreaker(stats.breaker)
+	}
+
+	// Best effort still hands out a proxy
+	if cfg, _ := pb.selectBestProxy(); cfg == nil {
+		t.Fatal("besteffort returned no proxy")
+	}
+
+	pb.onAllUnhealthy = BalancerRejectUnhealthy
+	if cfg, idx := pb.selectBestProxy(); cfg != nil || idx != -1 {
+		t.Fatalf("reject returned proxy %d", idx)
+	}
+
+	// Status pings see the "no servers" MOTD
+	client, server := net.Pipe()
+	go pb.handleConnection(server)
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 1)
+	if status := readStatus(t, client); status.Description != noServersMessage {
+		t.Errorf("description = %q", status.Description)
+	}
+	client.Close()
+
+	// Logins are disconnected
+	client, server = net.Pipe()
+	go pb.handleConnection(server)
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	if reason := readDisconnect(t, client); !strings.Contains(reason, noServersMessage) {
+		t.Errorf("disconnect reason = %s", reason)
+	}
+	client.Close()
+
+	// Once a proxy recovers it is used again
+	pb.proxyStats[1].breaker.recordSuccess()
+	if _, idx := pb.selectBestProxy(); idx != 1 {
+		t.Errorf("selected proxy %d, want the healthy proxy 1", idx)
+	}
+}
+
+func TestBalancerWithoutProxies(t *testing.T) {
+	pb := NewProxyBalancer("127.0.0.1:0", nil)
+	pb.noServersMessage = "Maintenance, back at 18:00"
+
+	client, server := net.Pipe()
+	go pb.handleConnection(server)
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 1)
+	if status := readStatus(t, client); status.Description != "Maintenance, back at 18:00" {
+		t.Errorf("description = %q", status.Description)
+	}
+	client.Close()
+
+	client, server = net.Pipe()
+	go pb.handleConnection(server)
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	if reason := readDisconnect(t, client); !strings.Contains(reason, "Maintenance, back at 18:00") {
+		t.Errorf("disconnect reason = %s", reason)
+	}
+	client.Close()
+}
+
+func TestStopBalancer(t *testing.T) {
+	// a candidate band of 0 always picks the best proxy instead of the default
+	band := 0.0
+	cfg := &config.Config{Proxies: []config.ProxyConfig{{Listen: "127.0.0.1:40141", Remote: "a.example.com:25565"}}, BalancerCandidateBand: &band}
+	if err := startBalancer("127.0.0.1:0", cfg); err != nil {
+		t.Fatal(err)
+	}
+	balancers := runningBalancers()
+	if len(balancers) != 1 {
+		t.Fatalf("%d balancers registered, want 1", len(balancers))
+	}
+	if balancers[0].candidateBand != 0 {
+		t.Errorf("candidate band = %g, want 0", balancers[0].candidateBand)
+	}
+	addr := balancers[0].listener.Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("balancer not listening: %v", err)
+	}
+	conn.Close()
+
+	if !StopBalancer("127.0.0.1:0") {
+		t.Fatal("running balancer not found")
+	}
+	if StopBalancer("127.0.0.1:0") {
+		t.Error("stopped balancer still registered")
+	}
+	if len(runningBalancers()) != 0 {
+		t.Error("registry not empty")
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("stopped balancer still accepts connections")
+	}
+}
+
+func TestBalancerRoutesFullToOverflow(t *testing.T) {
+	stubConnectionCounts(t, map[string]int{})
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40153", Remote: "backend.example.com:25565", MaxPlayer: 1, Auth: "none",
+		OverflowRemote: "lobby.example.com:25565"}
+	dials := make(chan string, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		dials <- remote
+		return nil, io.EOF
+	}
+	stats := registerProxyStats(t, cfg)
+	stats.ConnectionCount.Store(1)
+
+	pb := NewProxyBalancer("127.0.0.1:0", []config.ProxyConfig{cfg})
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		pb.handleConnection(server)
+		close(done)
+	}()
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	writeLoginStart(t, client, "Steve")
+
+	select {
+	case remote := <-dials:
+		if remote != cfg.OverflowRemote {
+			t.Errorf("dialed %s, want the overflow server %s", remote, cfg.OverflowRemote)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the login did not reach a backend")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleConnection did not return")
+	}
+	if n := overflowConnections(cfg.Listen); n != 0 {
+		t.Errorf("overflow connections after the session = %d, want 0", n)
+	}
+}
+
+func TestBalancerOverflowLeavesBreakerAlone(t *testing.T) {
+	stubConnectionCounts(t, map[string]int{})
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		if remote != "lobby.example.com:25565" {
+			t.Errorf("dialed %s, want the overflow server", remote)
+		}
+		return nil, errors.New("connection refused")
+	}
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40163", Remote: "backend.example.com:25565", MaxPlayer: 1, Auth: "none",
+		OverflowRemote: "lobby.example.com:25565"}
+	stats := registerProxyStats(t, cfg)
+	stats.ConnectionCount.Store(1)
+	pb := NewProxyBalancer("127.0.0.1:0", []config.ProxyConfig{cfg})
+
+	// a dead overflow server does not open the breaker of the primary backend
+	for i := 0; i < breakerFailureThreshold; i++ {
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pb.handleConnection(server)
+		}()
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+		writeLoginStart(t, client, "Steve")
+		io.Copy(io.Discard, client)
+		client.Close()
+		<-done
+	}
+	if status := pb.proxyStats[0].breaker.status(); status.State != BreakerClosed || status.ConsecutiveFailures != 0 {
+		t.Errorf("breaker status = %+v, want closed without failures", status)
+	}
+	if n := pb.proxyStats[0].failedConnections.Load(); n != 0 {
+		t.Errorf("failed connections = %d, want 0", n)
+	}
+}
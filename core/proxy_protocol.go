@@ -0,0 +1,168 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Load balancers in front of the proxy may send a PROXY protocol header
+// (https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt) ahead of the
+// handshake naming the real client. It is only honored from the peers in
+// proxy_protocol_trusted_proxies, anyone else could claim any address with it.
+// Without trusted proxies the headers are not looked for at all.
+
+// Signatures the two versions of the PROXY protocol header start with, no
+// Minecraft handshake or legacy ping can start with either
+var (
+	proxyHeaderV1 = []byte("PROXY ")
+	proxyHeaderV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	proxyHeaderV1MaxLength = 107 // longest v1 header including the CRLF
+	proxyHeaderV2Length    = 16  // v2 header before the addresses
+)
+
+// errUntrustedProxyHeader is returned for a PROXY protocol header sent by a
+// peer outside the trusted networks
+var errUntrustedProxyHeader = errors.New("PROXY protocol header from an untrusted peer")
+
+// proxiedConn is a connection whose client address was taken from a PROXY
+// protocol header
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+// RemoteAddr returns the client address named by the header
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// acceptProxyHeader reads the PROXY protocol header conn may start with from
+// reader. When the peer is trusted the connection is returned with the client
+// address of the header, headers from other peers are rejected with
+// errUntrustedProxyHeader. Connections without a header are returned as is,
+// as are all connections when no peer is trusted.
+func acceptProxyHeader(conn net.Conn, reader *bufio.Reader, trusted listenAllowlist) (net.Conn, error) {
+	if len(trusted) == 0 {
+		return conn, nil
+	}
+
+	version, err := peekProxyHeaderVersion(reader)
+	if err != nil || version == 0 {
+		// the handshake read reports read errors
+		return conn, nil
+	}
+	if !trusted.contains(conn.RemoteAddr()) {
+		return conn, errUntrustedProxyHeader
+	}
+
+	var addr net.Addr
+	if version == 1 {
+		addr, err = readProxyHeaderV1(reader)
+	} else {
+		addr, err = readProxyHeaderV2(reader)
+	}
+	if err != nil {
+		return conn, fmt.Errorf("invalid PROXY protocol header: %w", err)
+	}
+	if addr == nil {
+		// health checks of the load balancer itself
+		return conn, nil
+	}
+	return &proxiedConn{Conn: conn, remote: addr}, nil
+}
+
+// peekProxyHeaderVersion reports the version of the PROXY protocol header in
+// reader, 0 if there is none
+func peekProxyHeaderVersion(reader *bufio.Reader) (int, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	for version, signature := range [][]byte{proxyHeaderV1, proxyHeaderV2} {
+		if first[0] != signature[0] {
+			continue
+		}
+		peeked, err := reader.Peek(len(signature))
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(peeked, signature) {
+			return version + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// readProxyHeaderV1 reads a text header, the address is nil for UNKNOWN
+func readProxyHeaderV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyHeaderV1MaxLength {
+			return nil, errors.New("header too long")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("malformed source address %s:%s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 reads a binary header, the address is nil for LOCAL
+// commands and other protocols than TCP
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, proxyHeaderV2Length)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	if header[12]&0x0f == 0 { // LOCAL
+		return nil, nil
+	}
+	var ipLength int
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		ipLength = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLength = net.IPv6len
+	default:
+		return nil, nil
+	}
+	if len(payload) < 2*ipLength+4 {
+		return nil, errors.New("address block too short")
+	}
+	ip := net.IP(payload[:ipLength])
+	port := binary.BigEndian.Uint16(payload[2*ipLength:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"mcproxy/config"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// proxyHeaderV2TCP4 builds a v2 PROXY command header for a TCP over IPv4 client
+func proxyHeaderV2TCP4(src, dst netip.AddrPort) []byte {
+	header := append([]byte{}, proxyHeaderV2...)
+	header = append(header, 0x21, 0x11)
+	header = binary.BigEndian.AppendUint16(header, 12)
+	header = append(header, src.Addr().AsSlice()...)
+	header = append(header, dst.Addr().AsSlice()...)
+	header = binary.BigEndian.AppendUint16(header, src.Port())
+	return binary.BigEndian.AppendUint16(header, dst.Port())
+}
+
+func TestAcceptProxyHeader(t *testing.T) {
+	loopback := listenAllowlist{netip.MustParsePrefix("127.0.0.0/8")}
+	elsewhere := listenAllowlist{netip.MustParsePrefix("10.0.0.0/8")}
+	v2 := proxyHeaderV2TCP4(netip.MustParseAddrPort("198.51.100.9:40000"), netip.MustParseAddrPort("10.0.0.1:25565"))
+
+	tests := []struct {
+		name    string
+		header  string
+		trusted listenAllowlist
+		want    string // client address, empty for the peer's own
+		err     bool
+	}{
+		{"v1 trusted", "PROXY TCP4 203.0.113.7 10.0.0.1 51000 25565\r\n", loopback, "203.0.113.7:51000", false},
+		{"v1 ipv6", "PROXY TCP6 2001:db8::7 2001:db8::1 51000 25565\r\n", loopback, "[2001:db8::7]:51000", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", loopback, "", false},
+		{"v2 trusted", string(v2), loopback, "198.51.100.9:40000", false},
+		{"v1 untrusted", "PROXY TCP4 203.0.113.7 10.0.0.1 51000 25565\r\n", elsewhere, "", true},
+		{"v2 untrusted", string(v2), elsewhere, "", true},
+		{"no header", "", loopback, "", false},
+		{"malformed", "PROXY TCP4 nonsense\r\n", loopback, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := tcpPair(t)
+			defer client.Close()
+			defer server.Close()
+			server.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			if _, err := client.Write([]byte(tt.header + "\x10handshake")); err != nil {
+				t.Fatal(err)
+			}
+			reader := bufio.NewReader(server)
+			conn, err := acceptProxyHeader(server, reader, tt.trusted)
+			if tt.err {
+				if err == nil {
+					t.Fatal("header accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := tt.want
+			if want == "" {
+				want = server.RemoteAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("client address = %s, want %s", got, want)
+			}
+			rest := make([]byte, 10)
+			if _, err := io.ReadFull(reader, rest); err != nil || string(rest) != "\x10handshake" {
+				t.Errorf("after the header: %q, %v", rest, err)
+			}
+		})
+	}
+}
+
+func TestHandlerProxyProtocol(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	clientAddrs := make(chan string, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		for _, conn := range GetAllConnections() {
+			if conn.ProxyAddr == "127.0.0.1:40130" {
+				clientAddrs <- conn.ClientAddr
+			}
+		}
+		return nil, errors.New("connection refused")
+	}
+
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40130", Remote: "backend.example.com:25565", MaxPlayer: 10, Auth: "none"}
+	registerProxyStats(t, cfg)
+	header := []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 25565\r\n")
+
+	// login sends the header and a login, returning the client end
+	login := func(cfg config.ProxyConfig) net.Conn {
+		trusted, err := config.ParseAllowlist(cfg.ProxyProtocolTrustedProxies)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, server := tcpPair(t)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler(server, cfg, 0, trusted)
+		}()
+		t.Cleanup(func() {
+			client.Close()
+			<-done
+		})
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := client.Write(header); err != nil {
+			t.Fatal(err)
+		}
+		writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+		writeLoginStart(t, client, "Steve")
+		return client
+	}
+
+	// a trusted peer's header names the client
+	trusted := cfg
+	trusted.ProxyProtocolTrustedProxies = []string{"127.0.0.1"}
+	login(trusted)
+	select {
+	case addr := <-clientAddrs:
+		if addr != "203.0.113.7:51000" {
+			t.Errorf("client address = %s, want the one in the header", addr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("login not forwarded")
+	}
+
+	// an untrusted peer is closed before its handshake is read
+	untrusted := cfg
+	untrusted.ProxyProtocolTrustedProxies = []string{"10.0.0.0/8"}
+	client := login(untrusted)
+	if _, err := io.ReadAll(client); err != nil {
+		t.Fatalf("connection from an untrusted peer not closed: %v", err)
+	}
+	select {
+	case <-clientAddrs:
+		t.Error("login from an untrusted peer was forwarded")
+	default:
+	}
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// counterReconcileInterval is how often the connection counters are checked
+// against the connection registry
+const counterReconcileInterval = time.Minute
+
+// counterReconciler corrects the cached connection counters from the
+// connections in the registry. Counters and registry are updated at slightly
+// different times, so a mismatch is only corrected once it is seen with the
+// same counter value on two passes in a row.
+var counterReconciler = struct {
+	sync.Mutex
+	pending map[string]int // mismatched counters of the last pass and their values
+	started sync.Once
+}{
+	pending: make(map[string]int),
+}
+
+// startCounterReconciler starts the background reconciler, only the first call has an effect
+func startCounterReconciler() {
+	counterReconciler.started.Do(func() {
+		go func() {
+			ticker := time.NewTicker(counterReconcileInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				reconcileCounters()
+			}
+		}()
+	})
+}
+
+// registryCounts are the counter values derived from the registered connections
+type registryCounts struct {
+	online    int
+	proxies   map[string]int // logged in connections by proxy listen address
+	publicIPs map[string]int
+	clientIPs map[string]int
+}
+
+// countRegistry computes the counter values from the connection registry
+func countRegistry() registryCounts {
+	counts := registryCounts{
+		proxies:   make(map[string]int),
+		publicIPs: make(map[string]int),
+		clientIPs: make(map[string]int),
+	}
+
+	activeConnections.RLock()
+	defer activeConnections.RUnlock()
+	for _, conn := range activeConnections.connections {
+		counts.clientIPs[clientIPFromAddr(conn.ClientAddr)]++
+		if conn.PublicIP != "" && conn.PublicIP != "N/A" && conn.PublicIP != "Error" && conn.PublicIP != "Unknown" {
+			counts.publicIPs[conn.PublicIP]++
+		}
+		// the online and proxy counts start with the login start, which also
+		// sets the username
+		if conn.Username != "" {
+			counts.online++
+			counts.proxies[conn.ProxyAddr]++
+		}
+	}
+	return counts
+}
+
+// confirmMismatch reports whether counter was already seen at value on the
+// previous pass, and remembers it for the next one otherwise
+func confirmMismatch(seen map[string]int, counter string, value int) bool {
+	previous, ok := counterReconciler.pending[counter]
+	if ok && previous == value {
+		return true
+	}
+	seen[counter] = value
+	return false
+}
+
+// reconcileCounters corrects the counters that have drifted from the registry
+// and returns how many were corrected
+func reconcileCounters() int {
+	counterReconciler.Lock()
+	defer counterReconciler.Unlock()
+
+	expected := countRegistry()
+	seen := make(map[string]int)
+	corrected := 0
+
+	correct := func(counter string, cached, want int) {
+		log.Printf("[WARN] Connection counter %s drifted: %d, registry has %d, correcting", counter, cached, want)
+		corrected++
+	}
+
+	// online players
+	if cached := int(onlineCount.Load()); cached != expected.online {
+		if confirmMismatch(seen, "online", cached) && onlineCount.CompareAndSwap(int32(cached), int32(expected.online)) {
+			correct("online", cached, expected.online)
+		}
+	}
+
+	// per proxy connection counts
+	cp := GetControlPanel()
+	cp.mutex.RLock()
+	for listenAddr, stats := range cp.Stats {
+		counter := "proxy " + listenAddr
+		cached, want := int(stats.ConnectionCount.Load()), expected.proxies[listenAddr]
+		if cached != want && confirmMismatch(seen, counter, cached) &&
+			stats.ConnectionCount.CompareAndSwap(int32(cached), int32(want)) {
+			correct(counter, cached, want)
+		}
+	}
+	cp.mutex.RUnlock()
+
+	// per public IP and per client IP counts
+	reconcileCountMap(&connectionsPerIP.RWMutex, connectionsPerIP.counts, expected.publicIPs, "public IP", seen, correct)
+	reconcileCountMap(&connectionsPerClientIP.Mutex, connectionsPerClientIP.counts, expected.clientIPs, "client IP", seen, correct)
+
+	counterReconciler.pending = seen
+	return corrected
+}
+
+// reconcileCountMap corrects the counts of a map guarded by mutex
+func reconcileCountMap(mutex sync.Locker, counts map[string]int, expected map[string]int, kind string,
+	seen map[string]int, correct func(counter string, cached, want int)) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	keys := make(map[string]bool, len(counts)+len(expected))
+	for key := range counts {
+		keys[key] = true
+	}
+	for key := range expected {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		counter := fmt.Sprintf("%s %s", kind, key)
+		cached, want := counts[key], expected[key]
+		if cached == want || !confirmMismatch(seen, counter, cached) {
+			continue
+		}
+		if want == 0 {
+			delete(counts, key)
+		} else {
+			counts[key] = want
+		}
+		correct(counter, cached, want)
+	}
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"mcproxy/config"
+	"testing"
+	"time"
+)
+
+func TestReconcileCountersCorrectsDrift(t *testing.T) {
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40120"}
+	stats := registerProxyStats(t, cfg)
+
+	// one logged in connection
+	RegisterConnection(&Connection{
+		ID:          "reconcile",
+		Username:    "Steve",
+		ClientAddr:  "198.51.100.7:50000",
+		ProxyAddr:   cfg.Listen,
+		ConnectedAt: time.Now(),
+		PublicIP:    "203.0.113.9",
+	})
+	t.Cleanup(func() {
+		// leave the counters in line with the registry for the other tests
+		UnregisterConnection("reconcile")
+		reconcileCounters()
+		reconcileCounters()
+	})
+	reconcileCounters()
+	reconcileCounters()
+
+	// leaked decrements and increments
+	onlineCount.Add(3)
+	stats.ConnectionCount.Store(5)
+	connectionsPerIP.Lock()
+	connectionsPerIP.counts["203.0.113.9"] = 4
+	connectionsPerIP.Unlock()
+	connectionsPerClientIP.Lock()
+	connectionsPerClientIP.counts["198.51.100.8"] = 2
+	connectionsPerClientIP.Unlock()
+
+	// a mismatch is only corrected once it is seen twice
+	if n := reconcileCounters(); n != 0 {
+		t.Fatalf("first pass corrected %d counters", n)
+	}
+	if n := reconcileCounters(); n != 4 {
+		t.Errorf("second pass corrected %d counters, want 4", n)
+	}
+
+	if online, want := onlineCount.Load(), int32(countRegistry().online); online != want {
+		t.Errorf("online = %d, want %d", online, want)
+	}
+	if count := stats.ConnectionCount.Load(); count != 1 {
+		t.Errorf("proxy count = %d, want 1", count)
+	}
+	if count := connectionsPerIP.counts["203.0.113.9"]; count != 1 {
+		t.Errorf("public IP count = %d, want 1", count)
+	}
+	if count := GetConnectionCountForClientIP("198.51.100.8"); count != 0 {
+		t.Errorf("leaked client IP count = %d, want 0", count)
+	}
+}
+
+func TestReconcileCountersIgnoresChangingCounts(t *testing.T) {
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40121"}
+	stats := registerProxyStats(t, cfg)
+	reconcileCounters()
+	reconcileCounters()
+
+	// a login in progress has counted itself but is not registered yet
+	stats.ConnectionCount.Store(1)
+	reconcileCounters()
+	stats.ConnectionCount.Store(2)
+	reconcileCounters()
+	if count := stats.ConnectionCount.Load(); count != 2 {
+		t.Errorf("changing count corrected to %d", count)
+	}
+	stats.ConnectionCount.Store(0)
+}
@@ -1,31 +1,138 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// resolverDial connects to DNS servers for resolvers bound to a local
+// address. It is a variable so tests can inspect the dialer.
+var resolverDial = func(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	return dialer.DialContext(ctx, network, address)
+}
+
+// localResolver returns a resolver that sends its DNS queries from the IP of
+// localAddr, so lookups take the same route as the connections bound to it.
+// It returns the default resolver when localAddr has no IP.
+func localResolver(localAddr string) (*net.Resolver, error) {
+	bindAddr, err := resolveInterfaceAddr(localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve local interface: %w", err)
+	}
+	ip := net.ParseIP(hostOnly(bindAddr))
+	if ip == nil {
+		return net.DefaultResolver, nil
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: 5 * time.Second}
+			switch network {
+			case "udp", "udp4", "udp6":
+				dialer.LocalAddr = &net.UDPAddr{IP: ip}
+			default:
+				dialer.LocalAddr = &net.TCPAddr{IP: ip}
+			}
+			return resolverDial(ctx, dialer, network, address)
+		},
+	}, nil
+}
+
+// hostOverrides pins hostnames to fixed addresses, like an in-app /etc/hosts.
+// It is set from the global configuration.
+var hostOverrides = struct {
+	sync.RWMutex
+	hosts map[string]string
+}{}
+
+// SetHostOverrides replaces the host overrides. Keys are hostnames, values an
+// IP or host with an optional port.
+func SetHostOverrides(overrides map[string]string) {
+	hosts := make(map[string]string, len(overrides))
+	for host, target := range overrides {
+		hosts[normalizeHost(host)] = target
+	}
+
+	hostOverrides.Lock()
+	defer hostOverrides.Unlock()
+	hostOverrides.hosts = hosts
+}
+
+// normalizeHost returns the form of a hostname used for override lookups
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// overrideHost returns the override for host, if there is one
+func overrideHost(host string) (string, bool) {
+	hostOverrides.RLock()
+	defer hostOverrides.RUnlock()
+	target, ok := hostOverrides.hosts[normalizeHost(host)]
+	return target, ok
+}
+
 func Resolve(address string) (string, error) {
-	// SRV
-	if !strings.Contains(address, ":") {
-		_, addrs, err := net.LookupSRV("minecraft", "tcp", address)
+	return resolve(address, net.DefaultResolver)
+}
 
-		if err != nil || len(addrs) == 0 {
-			// use default port if SRV failed
-			return net.JoinHostPort(address, "25565"), nil
+// resolve looks up the Minecraft SRV record of address with resolver. Hosts
+// with an override skip the lookup, the override's port replaces the port of
+// address when it has one.
+func resolve(address string, resolver *net.Resolver) (string, error) {
+	host, port := splitHostPort(address)
+	if target, ok := overrideHost(host); ok {
+		targetHost, targetPort := splitHostPort(target)
+		if targetPort == "" {
+			targetPort = port
+		}
+		if targetPort == "" {
+			return formatAddr(targetHost, 25565), nil
 		}
+		return net.JoinHostPort(targetHost, targetPort), nil
+	}
+
+	if port != "" {
+		return net.JoinHostPort(host, port), nil
+	}
+
+	// IP literals have no SRV record
+	if net.ParseIP(host) != nil {
+		return formatAddr(host, 25565), nil
+	}
+
+	// SRV
+	_, addrs, err := resolver.LookupSRV(context.Background(), "minecraft", "tcp", host)
 
-		return net.JoinHostPort(addrs[0].Target, strconv.Itoa(int(addrs[0].Port))), nil
+	if err != nil || len(addrs) == 0 {
+		// use default port if SRV failed
+		return formatAddr(host, 25565), nil
 	}
 
-	return address, nil
+	return formatAddr(addrs[0].Target, int(addrs[0].Port)), nil
 }
 
 func DialMC(a string, localAddr string) (net.Conn, error) {
-	addr, err := Resolve(a)
+	return dialMC(a, localAddr, false)
+}
+
+// dialMC dials a Minecraft server like DialMC. When resolveLocal is set, the
+// DNS lookups for a are sent from localAddr as well.
+func dialMC(a string, localAddr string, resolveLocal bool) (net.Conn, error) {
+	resolver := net.DefaultResolver
+	if resolveLocal && localAddr != "" {
+		var err error
+		resolver, err = localResolver(localAddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addr, err := resolve(a, resolver)
 	if err != nil {
 		return nil, fmt.Errorf("resolve: %w", err)
 	}
@@ -33,8 +140,12 @@ func DialMC(a string, localAddr string) (net.Conn, error) {
 	// If localAddr is specified, use it for the outgoing connection
 	if localAddr != "" {
 
-		// Parse the local address
-		local, err := net.ResolveTCPAddr("tcp", localAddr)
+		// Parse the local address, which may name an interface instead of an IP
+		bindAddr, err := resolveInterfaceAddr(localAddr)
+		if err != nil {
+			return nil, fmt.Errorf("resolve local interface: %w", err)
+		}
+		local, err := net.ResolveTCPAddr("tcp", bindAddr)
 		if err != nil {
 			return nil, fmt.Errorf("resolve local TCP addr: %w", err)
 		}
@@ -43,6 +154,7 @@ func DialMC(a string, localAddr string) (net.Conn, error) {
 		dialer := &net.Dialer{
 			LocalAddr: local,
 			Timeout:   5 * time.Second, // Add a 5-second timeout
+			Resolver:  resolver,
 		}
 
 		// Dial with the specified local address
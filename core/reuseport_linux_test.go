@@ -0,0 +1,23 @@
+//go:build linux
+
+package core
+
+import "testing"
+
+func TestListenTCPReusePort(t *testing.T) {
+	first, err := listenTCP("127.0.0.1:0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := listenTCP(first.Addr().String(), true)
+	if err != nil {
+		t.Fatalf("second listener on %s: %v", first.Addr(), err)
+	}
+	second.Close()
+
+	if _, err := listenTCP(first.Addr().String(), false); err == nil {
+		t.Error("listener without reuse_port should not bind a shared port")
+	}
+}
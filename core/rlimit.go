@@ -0,0 +1,35 @@
+package core
+
+import (
+	"log"
+	"mcproxy/config"
+)
+
+// fdsPerPlayer is the number of file descriptors a forwarded player holds
+// (the client connection and the connection to the remote server)
+const fdsPerPlayer = 2
+
+// fdReserve is kept free for listeners, the log database and the control panel
+const fdReserve = 64
+
+// checkFileLimit raises the open file soft limit as far as allowed and warns
+// when the configured player capacity cannot be served within it
+func checkFileLimit(c config.Config) {
+	limit, err := raiseFileLimit()
+	if err != nil {
+		log.Printf("[WARN] Unable to check open file limit: %v", err)
+		return
+	}
+	log.Printf("[INFO] Open file limit: %d", limit)
+
+	capacity := 0
+	for _, proxy := range c.Proxies {
+		capacity += proxy.MaxPlayer
+	}
+
+	needed := uint64(capacity*fdsPerPlayer + fdReserve)
+	if needed > limit {
+		log.Printf("[WARN] Configured capacity of %d players needs about %d open files, but the limit is %d; connections may fail with \"too many open files\"",
+			capacity, needed, limit)
+	}
+}
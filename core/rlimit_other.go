@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package core
+
+import "errors"
+
+func raiseFileLimit() (uint64, error) {
+	return 0, errors.New("not supported on this platform")
+}
@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package core
+
+import "syscall"
+
+// raiseFileLimit raises the RLIMIT_NOFILE soft limit to the hard limit and
+// returns the effective soft limit
+func raiseFileLimit() (uint64, error) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0, err
+	}
+
+	if rlim.Cur < rlim.Max {
+		raised := rlim
+		raised.Cur = rlim.Max
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised); err != nil {
+			// Keep the current limit, it is still usable
+			return uint64(rlim.Cur), nil
+		}
+		rlim = raised
+	}
+
+	return uint64(rlim.Cur), nil
+}
@@ -0,0 +1,34 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package core
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestRaiseFileLimit(t *testing.T) {
+	var orig syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &orig); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &orig)
+
+	if orig.Max < 256 {
+		t.Skipf("hard limit %d too low to test", orig.Max)
+	}
+
+	lowered := orig
+	lowered.Cur = 128
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Fatal(err)
+	}
+
+	limit, err := raiseFileLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit <= uint64(lowered.Cur) {
+		t.Errorf("limit = %d, was not raised above %d", limit, lowered.Cur)
+	}
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"mcproxy/config"
+	"mcproxy/logger"
+)
+
+// SelfTestResult is the outcome of one startup check, Err is nil when it passed
+type SelfTestResult struct {
+	Check string
+	Err   error
+}
+
+// RunSelfTest checks that every proxy can bind its listen address and dial
+// its remote, and that the log database is writable. The listeners are closed
+// again, so it has to run before the proxies are started.
+func RunSelfTest(cfg *config.Config) []SelfTestResult {
+	var results []SelfTestResult
+	for i, proxy := range cfg.Proxies {
+		results = append(results, SelfTestResult{
+			Check: fmt.Sprintf("proxy %d listen %s", i+1, proxy.Listen),
+			Err:   checkListen(proxy.Listen, cfg.ReusePort),
+		})
+		if proxy.Mode == ModeStatusOnly {
+			continue
+		}
+		results = append(results, SelfTestResult{
+			Check: fmt.Sprintf("proxy %d remote %s", i+1, proxy.Remote),
+			Err:   checkRemote(proxy),
+		})
+	}
+	results = append(results, SelfTestResult{
+		Check: fmt.Sprintf("log database %s", cfg.Logging.DBPath),
+		Err:   logger.CheckWritable(cfg.Logging.DBPath),
+	})
+	return results
+}
+
+// checkListen binds addr and closes the listener again
+func checkListen(addr string, reusePort bool) error {
+	ln, err := listenTCP(addr, reusePort)
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
+
+// checkRemote dials the proxy's remote and closes the connection again
+func checkRemote(cfg config.ProxyConfig) error {
+	conn, err := dialRemote(cfg.Remote, cfg.LocalAddr, cfg.ResolveViaLocalAddr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// LogSelfTest logs each result and a summary, and reports whether every
+// check passed
+func LogSelfTest(results []SelfTestResult) bool {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			log.Printf("[ERROR] Self-test FAIL %s: %v", r.Check, r.Err)
+		} else {
+			log.Printf("[INFO] Self-test PASS %s", r.Check)
+		}
+	}
+	if failed > 0 {
+		log.Printf("[ERROR] Self-test: %d of %d checks failed", failed, len(results))
+		return false
+	}
+	log.Printf("[INFO] Self-test: all %d checks passed", len(results))
+	return true
+}
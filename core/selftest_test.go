@@ -0,0 +1,94 @@
+package core
+
+import (
+	"errors"
+	"mcproxy/config"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSelfTest(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		if remote == "down.example:25565" {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	dir := t.TempDir()
+	notADir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notADir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		Proxies: []config.ProxyConfig{
+			{Listen: "127.0.0.1:0", Remote: "up.example:25565"},
+			{Listen: taken.Addr().String(), Remote: "down.example:25565"},
+			{Listen: "127.0.0.1:0", Remote: "down.example:25565", Mode: ModeStatusOnly},
+		},
+		Logging: config.LogConfig{DBPath: filepath.Join(dir, "logs", "mcproxy.db")},
+	}
+
+	check := func(results []SelfTestResult, want map[string]bool) {
+		t.Helper()
+		if len(results) != len(want) {
+			t.Fatalf("got %d results, want %d: %v", len(results), len(want), results)
+		}
+		for _, r := range results {
+			pass, ok := want[r.Check]
+			if !ok {
+				t.Errorf("unexpected check %q", r.Check)
+				continue
+			}
+			if pass != (r.Err == nil) {
+				t.Errorf("%s: err = %v, want pass = %v", r.Check, r.Err, pass)
+			}
+		}
+	}
+
+	results := RunSelfTest(cfg)
+	check(results, map[string]bool{
+		"proxy 1 listen 127.0.0.1:0":                               true,
+		"proxy 1 remote up.example:25565":                          true,
+		"proxy 2 listen " + taken.Addr().String():                  false,
+		"proxy 2 remote down.example:25565":                        false,
+		"proxy 3 listen 127.0.0.1:0":                               true,
+		"log database " + filepath.Join(dir, "logs", "mcproxy.db"): true,
+	})
+	if LogSelfTest(results) {
+		t.Error("LogSelfTest passed with failed checks")
+	}
+
+	cfg.Proxies = cfg.Proxies[:1]
+	cfg.Logging.DBPath = filepath.Join(notADir, "mcproxy.db")
+	results = RunSelfTest(cfg)
+	check(results, map[string]bool{
+		"proxy 1 listen 127.0.0.1:0":         true,
+		"proxy 1 remote up.example:25565":    true,
+		"log database " + cfg.Logging.DBPath: false,
+	})
+	for _, r := range results {
+		if strings.HasPrefix(r.Check, "log database") && !strings.Contains(r.Err.Error(), "log directory") {
+			t.Errorf("log database error = %v", r.Err)
+		}
+	}
+
+	cfg.Logging.DBPath = filepath.Join(dir, "mcproxy.db")
+	if !LogSelfTest(RunSelfTest(cfg)) {
+		t.Error("LogSelfTest failed with every check passing")
+	}
+}
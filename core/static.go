@@ -0,0 +1,83 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// staticFiles holds the dashboard's stylesheet and script
+//
+//go:embed static
+var staticFiles embed.FS
+
+// staticAsset is an embedded file with its precomputed content type and ETag
+type staticAsset struct {
+	content     []byte
+	contentType string
+	etag        string
+}
+
+var staticAssets = loadStaticAssets()
+
+// loadStaticAssets reads every embedded file once at startup
+func loadStaticAssets() map[string]staticAsset {
+	assets := make(map[string]staticAsset)
+	entries, err := fs.ReadDir(staticFiles, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, entry := range entries {
+		content, err := staticFiles.ReadFile(path.Join("static", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		sum := sha256.Sum256(content)
+		assets[entry.Name()] = staticAsset{
+			content:     content,
+			contentType: mime.TypeByExtension(path.Ext(entry.Name())),
+			etag:        `"` + hex.EncodeToString(sum[:8]) + `"`,
+		}
+	}
+	return assets
+}
+
+// staticURL returns the URL of an embedded asset, versioned by its content
+// so browsers can cache it for a long time
+func staticURL(name string) string {
+	asset, ok := staticAssets[name]
+	if !ok {
+		return "/static/" + name
+	}
+	return "/static/" + name + "?v=" + strings.Trim(asset.etag, `"`)
+}
+
+// handleStatic serves the embedded dashboard assets
+func handleStatic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	asset, ok := staticAssets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", asset.contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", asset.etag)
+
+	// ServeContent answers If-None-Match with 304 Not Modified
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(asset.content))
+}
@@ -0,0 +1,527 @@
+// Tab switching functionality
+function openTab(evt, tabName) {
+    var i, tabcontent, tablinks;
+
+    // Hide all tab content
+    tabcontent = document.getElementsByClassName("tabcontent");
+    for (i = 0; i < tabcontent.length; i++) {
+        tabcontent[i].className = tabcontent[i].className.replace(" active-tabcontent", "");
+    }
+
+    // Remove active class from all tab buttons
+    tablinks = document.getElementsByClassName("tablinks");
+    for (i = 0; i < tablinks.length; i++) {
+        tablinks[i].className = tablinks[i].className.replace(" active", "");
+    }
+
+    // Show the current tab and add active class to the button
+    document.getElementById(tabName).className += " active-tabcontent";
+    evt.currentTarget.className += " active";
+
+    // If connections tab is opened, refresh the connections list
+    if (tabName === 'connections') {
+        refreshConnections();
+    }
+
+    // If logs tab is opened, refresh the logs list
+    if (tabName === 'logs') {
+        refreshLogs();
+    }
+}
+
+// appendCell adds a cell showing text to the row. Usernames and log messages
+// come from clients, so they are set as text and never parsed as HTML.
+function appendCell(row, text) {
+    const cell = document.createElement('td');
+    cell.textContent = text ?? '';
+    row.appendChild(cell);
+    return cell;
+}
+
+// logRow returns the table row of a log entry, colored by its level
+function logRow(log) {
+    const row = document.createElement('tr');
+    if (log.level === 'ERROR' || log.level === 'FATAL') {
+        row.style.backgroundColor = 'rgba(231, 76, 60, 0.1)';
+    } else if (log.level === 'WARN') {
+        row.style.backgroundColor = 'rgba(243, 156, 18, 0.1)';
+    }
+
+    appendCell(row, new Date(log.timestamp).toLocaleString());
+    appendCell(row, log.level);
+    appendCell(row, log.source);
+    appendCell(row, log.message);
+    return row;
+}
+
+// Function to refresh the connections list
+function refreshConnections() {
+    fetch('/api/connections')
+        .then(response => response.json())
+        .then(connections => {
+            const tbody = document.getElementById('connections-tbody');
+            tbody.innerHTML = '';
+
+            // Offer every group seen in the connections, keeping the current selection
+            const filter = document.getElementById('group-filter');
+            const selected = filter.value;
+            const groups = [...new Set(connections.map(conn => conn.group).filter(group => group))].sort();
+            filter.innerHTML = '<option value="">All groups</option>';
+            groups.forEach(group => {
+                const option = document.createElement('option');
+                option.textContent = group;
+                filter.appendChild(option);
+            });
+            filter.value = groups.includes(selected) ? selected : '';
+
+            // Show the connections of one group, grouped together
+            connections = connections
+                .filter(conn => !filter.value || conn.group === filter.value)
+                .sort((a, b) => (a.group || '').localeCompare(b.group || ''));
+
+            if (connections.length === 0) {
+                const row = document.createElement('tr');
+                row.innerHTML = '<td colspan="8" style="text-align: center;">No active connections</td>';
+                tbody.appendChild(row);
+                return;
+            }
+
+            connections.forEach(conn => {
+                const row = document.createElement('tr');
+                row.className = 'connection-row';
+
+                // Format the connected at time
+                const connectedAt = new Date(conn.connected_at);
+                const formattedTime = connectedAt.toLocaleString();
+
+                appendCell(row, conn.username || '<unknown>');
+                appendCell(row, conn.group);
+                const client = appendCell(row, conn.client_addr);
+                if (conn.flapping) {
+                    const badge = document.createElement('span');
+                    badge.className = 'flapping';
+                    badge.textContent = 'flapping';
+                    badge.title = 'This host:port keeps reconnecting, possibly a NAT rebinding ports';
+                    client.append(' ', badge);
+                }
+                appendCell(row, conn.proxy_addr);
+                appendCell(row, conn.remote_addr);
+                if (showPublicIP) {
+                    appendCell(row, conn.public_ip);
+                }
+                appendCell(row, formattedTime);
+
+                const button = document.createElement('button');
+                button.className = 'disconnect-btn';
+                button.textContent = 'Disconnect';
+                button.onclick = () => disconnectClient(conn.id);
+                appendCell(row, '').appendChild(button);
+                tbody.appendChild(row);
+            });
+        })
+        .catch(error => {
+            console.error('Error fetching connections:', error);
+            const tbody = document.getElementById('connections-tbody');
+            tbody.innerHTML = '<tr><td colspan="8" style="text-align: center; color: red;">Error loading connections</td></tr>';
+        });
+}
+
+// Function to disconnect a client
+function disconnectClient(id) {
+    if (!id) {
+        console.error('Attempted to disconnect client with empty ID');
+        alert('Error: Connection ID is missing');
+        return;
+    }
+
+    if (!confirm('Are you sure you want to disconnect this client?')) {
+        return;
+    }
+
+    const requestData = {
+        id: id,
+        reason: 'Disconnected by administrator'
+    };
+
+    fetch('/api/disconnect', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(requestData)
+    })
+    .then(async response => {
+        if (!response.ok) {
+            // Try to get the error message from the response body
+            let errorMessage = '';
+            try {
+                // Clone the response to avoid consuming it
+                const clonedResponse = response.clone();
+                // Try to read the response as text
+                const text = await clonedResponse.text();
+                if (text) {
+                    errorMessage = ': ' + text;
+                } else if (response.statusText) {
+                    errorMessage = ': ' + response.statusText;
+                }
+            } catch (e) {
+                // If we can't read the response, just use the status text if available
+                if (response.statusText) {
+                    errorMessage = ': ' + response.statusText;
+                }
+            }
+            throw new Error('HTTP error ' + response.status + errorMessage);
+        }
+        return response.json();
+    })
+    .then(result => {
+        if (result.success) {
+            if (result.message) {
+                console.log(result.message);
+            }
+            refreshConnections();
+        } else {
+            alert('Failed to disconnect client');
+        }
+    })
+    .catch(error => {
+        console.error('Error disconnecting client:', error);
+        alert('Error disconnecting client: ' + error.message);
+    });
+}
+
+// Auto-refresh connections every 10 seconds when the tab is active
+setInterval(() => {
+    const connectionsTab = document.getElementById('connections');
+    if (connectionsTab.className.includes('active-tabcontent')) {
+        refreshConnections();
+    }
+}, 10000);
+
+// Logs pagination variables
+let logsCurrentPage = 0;
+let logsPageSize = 100;
+let logsTotalCount = 0;
+
+// Function to refresh the logs list
+function refreshLogs() {
+    // Get filter values
+    const level = document.getElementById('log-level').value;
+    const startTime = document.getElementById('log-start-time').value ? 
+        new Date(document.getElementById('log-start-time').value).toISOString() : '';
+    const endTime = document.getElementById('log-end-time').value ? 
+        new Date(document.getElementById('log-end-time').value).toISOString() : '';
+
+    // Build the query URL
+    let url = '/api/logs?limit=' + logsPageSize + '&offset=' + (logsCurrentPage * logsPageSize);
+    if (level) url += '&level=' + encodeURIComponent(level);
+    if (startTime) url += '&start_time=' + encodeURIComponent(startTime);
+    if (endTime) url += '&end_time=' + encodeURIComponent(endTime);
+
+    fetch(url)
+        .then(response => response.json())
+        .then(data => {
+            const tbody = document.getElementById('logs-tbody');
+            tbody.innerHTML = '';
+
+            logsTotalCount = data.total_count;
+
+            if (data.logs.length === 0) {
+                const row = document.createElement('tr');
+                row.innerHTML = '<td colspan="4" style="text-align: center;">No logs found</td>';
+                tbody.appendChild(row);
+            } else {
+                data.logs.forEach(log => {
+                    tbody.appendChild(logRow(log));
+                });
+            }
+
+            // Calculate total pages
+            const totalPages = Math.ceil(logsTotalCount / logsPageSize) || 1;
+
+            // Update pagination info
+            document.getElementById('logs-showing').textContent = 
+                data.logs.length > 0 ? 
+                ((logsCurrentPage * logsPageSize) + 1) + '-' + 
+                Math.min((logsCurrentPage + 1) * logsPageSize, logsTotalCount) : 0;
+            document.getElementById('logs-total').textContent = logsTotalCount;
+
+            // Update page numbers
+            document.getElementById('current-page').textContent = logsCurrentPage + 1;
+            document.getElementById('total-pages').textContent = totalPages;
+
+            // Update pagination buttons
+            document.getElementById('logs-first-btn').disabled = logsCurrentPage === 0;
+            document.getElementById('logs-prev-btn').disabled = logsCurrentPage === 0;
+            document.getElementById('logs-next-btn').disabled = 
+                (logsCurrentPage + 1) * logsPageSize >= logsTotalCount;
+            document.getElementById('logs-last-btn').disabled = 
+                (logsCurrentPage + 1) * logsPageSize >= logsTotalCount;
+
+            // Update the lastLogTimestamp for real-time updates
+            if (data.logs.length > 0 && logsCurrentPage === 0) {
+                lastLogTimestamp = data.logs[0].timestamp;
+            }
+        })
+        .catch(error => {
+            console.error('Error fetching logs:', error);
+            const tbody = document.getElementById('logs-tbody');
+            tbody.innerHTML = '<tr><td colspan="4" style="text-align: center; color: red;">Error loading logs</td></tr>';
+        });
+}
+
+// Function to go to the first page of logs
+function goToFirstPage() {
+    logsCurrentPage = 0;
+    refreshLogs();
+}
+
+// Function to go to the previous page of logs
+function previousLogsPage() {
+    if (logsCurrentPage > 0) {
+        logsCurrentPage--;
+        refreshLogs();
+    }
+}
+
+// Function to go to the next page of logs
+function nextLogsPage() {
+    if ((logsCurrentPage + 1) * logsPageSize < logsTotalCount) {
+        logsCurrentPage++;
+        refreshLogs();
+    }
+}
+
+// Function to go to the last page of logs
+function goToLastPage() {
+    logsCurrentPage = Math.ceil(logsTotalCount / logsPageSize) - 1;
+    if (logsCurrentPage < 0) logsCurrentPage = 0;
+    refreshLogs();
+}
+
+// Function to clear log filters
+function clearLogFilters() {
+    document.getElementById('log-level').value = '';
+    document.getElementById('log-start-time').value = '';
+    document.getElementById('log-end-time').value = '';
+    logsCurrentPage = 0;
+    refreshLogs();
+}
+
+// Function to delete logs based on current filters
+function deleteFilteredLogs() {
+    if (!confirm('Are you sure you want to delete all logs matching the current filters? This action cannot be undone.')) {
+        return;
+    }
+
+    // Get filter values
+    const level = document.getElementById('log-level').value;
+    const startTime = document.getElementById('log-start-time').value ? 
+        new Date(document.getElementById('log-start-time').value).toISOString() : '';
+    const endTime = document.getElementById('log-end-time').value ? 
+        new Date(document.getElementById('log-end-time').value).toISOString() : '';
+
+    // Prepare request data
+    const requestData = {
+        level: level,
+        start_time: startTime,
+        end_time: endTime
+    };
+
+    // Send delete request
+    fetch('/api/delete-logs', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(requestData)
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            alert('Successfully deleted ' + data.rows_affected + ' log entries.');
+            refreshLogs(); // Refresh the logs display
+        } else {
+            alert('Failed to delete logs: ' + (data.error || 'Unknown error'));
+        }
+    })
+    .catch(error => {
+        console.error('Error deleting logs:', error);
+        alert('Error deleting logs: ' + error.message);
+    });
+}
+
+// Function to delete all logs
+function deleteAllLogs() {
+    if (!confirm('Are you sure you want to delete ALL logs? This action cannot be undone.')) {
+        return;
+    }
+
+    // Send delete request with no filters to delete all logs
+    fetch('/api/delete-logs', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({})
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            alert('Successfully deleted ' + data.rows_affected + ' log entries.');
+            refreshLogs(); // Refresh the logs display
+        } else {
+            alert('Failed to delete logs: ' + (data.error || 'Unknown error'));
+        }
+    })
+    .catch(error => {
+        console.error('Error deleting logs:', error);
+        alert('Error deleting logs: ' + error.message);
+    });
+}
+
+// Variable to track the timestamp of the most recent log
+let lastLogTimestamp = '';
+
+// Function to fetch only new logs since the last fetch
+function fetchRecentLogs() {
+    // Only fetch if we have a timestamp to start from
+    if (!lastLogTimestamp) {
+        refreshLogs(); // Do a full refresh the first time
+        return;
+    }
+
+    // Get filter values
+    const level = document.getElementById('log-level').value;
+
+    // Build the query URL
+    let url = '/api/recent-logs?limit=100';
+    if (level) url += '&level=' + encodeURIComponent(level);
+    if (lastLogTimestamp) url += '&since=' + encodeURIComponent(lastLogTimestamp);
+
+    fetch(url)
+        .then(response => response.json())
+        .then(data => {
+            if (data.logs.length === 0) {
+                return; // No new logs
+            }
+
+            // Update the last timestamp for the next fetch
+            if (data.logs.length > 0) {
+                lastLogTimestamp = data.logs[0].timestamp;
+            }
+
+            // Get the current tbody
+            const tbody = document.getElementById('logs-tbody');
+
+            // If this is the first load or we're showing "No logs found", clear the tbody
+            if (tbody.children.length === 1 && 
+                tbody.children[0].innerHTML.includes('No logs found')) {
+                tbody.innerHTML = '';
+            }
+
+            // Add new logs to the top of the table
+            data.logs.reverse().forEach(log => {
+                const row = logRow(log);
+
+                // Insert at the beginning of the table
+                if (tbody.firstChild) {
+                    tbody.insertBefore(row, tbody.firstChild);
+                } else {
+                    tbody.appendChild(row);
+                }
+            });
+
+            // Limit the number of rows to 100 to prevent the table from growing too large
+            while (tbody.children.length > 100) {
+                tbody.removeChild(tbody.lastChild);
+            }
+        })
+        .catch(error => {
+            console.error('Error fetching recent logs:', error);
+        });
+}
+
+// Auto-refresh logs every 5 seconds when the tab is active
+setInterval(() => {
+    const logsTab = document.getElementById('logs');
+    if (logsTab.className.includes('active-tabcontent')) {
+        fetchRecentLogs();
+    }
+}, 5000);
+
+// Also do a full refresh every 30 seconds to ensure we have the latest data
+setInterval(() => {
+    const logsTab = document.getElementById('logs');
+    if (logsTab.className.includes('active-tabcontent')) {
+        refreshLogs();
+    }
+}, 30000);
+
+// Pause or resume new logins on all proxies
+function setLoginsPaused(paused) {
+    if (paused && !confirm('Pause new logins on all proxies? Players already online stay connected.')) {
+        return;
+    }
+
+    fetch(paused ? '/api/pause-logins' : '/api/resume-logins', { method: 'POST' })
+        .then(response => {
+            if (!response.ok) {
+                throw new Error(response.statusText);
+            }
+            location.reload();
+        })
+        .catch(err => alert('Failed to ' + (paused ? 'pause' : 'resume') + ' logins: ' + err.message));
+}
+
+// Real-time update for Public IPs in the Status tab
+function refreshStats() {
+    fetch('/api/stats')
+        .then(resp => resp.json())
+        .then(items => {
+            items.forEach(item => {
+                const selector = 'td.public-ip[data-listen="' + item.listen.replace(/[-[\]{}()*+?.,\\^$|#\s]/g, '\\$&') + '"]';
+                const cell = document.querySelector(selector);
+                if (cell && cell.textContent !== item.public_ip) {
+                    cell.textContent = item.public_ip;
+                }
+            });
+        })
+        .catch(err => console.error('Failed to refresh stats:', err));
+}
+
+// Draw the new connections per minute as a bar graph
+function refreshHistory() {
+    fetch('/api/stats/history')
+        .then(resp => resp.json())
+        .then(history => {
+            const canvas = document.getElementById('rate-graph');
+            const ctx = canvas.getContext('2d');
+            const samples = history.samples.slice(-120);
+            const max = Math.max(1, ...samples.map(s => s.new_connections));
+            const barWidth = canvas.width / 120;
+
+            ctx.clearRect(0, 0, canvas.width, canvas.height);
+            ctx.fillStyle = '#3498db';
+            samples.forEach((s, i) => {
+                const height = (s.new_connections / max) * (canvas.height - 20);
+                ctx.fillRect(i * barWidth, canvas.height - height, Math.max(1, barWidth - 1), height);
+            });
+            ctx.fillStyle = '#333';
+            ctx.fillText('max ' + max + '/min', 4, 12);
+        })
+        .catch(err => console.error('Failed to refresh stats history:', err));
+}
+
+// Auto-refresh Public IPs every 10 seconds when Status tab is active
+setInterval(() => {
+    const statusTab = document.getElementById('status');
+    if (statusTab.className.includes('active-tabcontent')) {
+        refreshStats();
+        refreshHistory();
+    }
+}, 10000);
+
+// Initial fetch shortly after load
+setTimeout(refreshStats, 2000);
+setTimeout(refreshHistory, 2000);
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleStatic(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleStatic(rec, httptest.NewRequest(http.MethodGet, "/static/app.js", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.Contains(ct, "javascript") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("missing ETag")
+	}
+	if !strings.Contains(rec.Body.String(), "function openTab") {
+		t.Error("unexpected body")
+	}
+
+	// A matching ETag is answered without a body
+	req := httptest.NewRequest(http.MethodGet, "/static/app.js", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	handleStatic(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("conditional request status = %d, want 304", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handleStatic(rec, httptest.NewRequest(http.MethodGet, "/static/missing.js", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing asset status = %d, want 404", rec.Code)
+	}
+}
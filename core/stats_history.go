@@ -0,0 +1,163 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	statsSampleInterval        = time.Minute
+	statsHistorySize           = 24 * 60 // one day of samples
+	defaultRateAlertCooldown   = 5 * time.Minute
+	connectionRateAlertWindow  = time.Minute
+	connectionRateAlertTimeout = 5 * time.Second
+)
+
+// StatsSample is one point of the stats history
+type StatsSample struct {
+	Time           time.Time `json:"time"`
+	Online         int32     `json:"online"`
+	NewConnections int64     `json:"new_connections"` // Connections registered during the interval
+}
+
+// statsHistory keeps the most recent samples taken by the stats sampler
+var statsHistory = struct {
+	sync.RWMutex
+	samples        []StatsSample
+	newConnections atomic.Int64
+	started        sync.Once
+}{}
+
+// startStatsSampler starts the background sampler, only the first call has an effect
+func startStatsSampler() {
+	statsHistory.started.Do(func() {
+		go func() {
+			ticker := time.NewTicker(statsSampleInterval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				takeStatsSample(now)
+			}
+		}()
+	})
+}
+
+// takeStatsSample records the current online count and the number of new
+// connections since the previous sample
+func takeStatsSample(now time.Time) StatsSample {
+	sample := StatsSample{
+		Time:           now,
+		Online:         onlineCount.Load(),
+		NewConnections: statsHistory.newConnections.Swap(0),
+	}
+
+	statsHistory.Lock()
+	defer statsHistory.Unlock()
+	statsHistory.samples = append(statsHistory.samples, sample)
+	if len(statsHistory.samples) > statsHistorySize {
+		statsHistory.samples = statsHistory.samples[len(statsHistory.samples)-statsHistorySize:]
+	}
+	return sample
+}
+
+// StatsHistory returns a copy of the recorded samples, oldest first
+func StatsHistory() []StatsSample {
+	statsHistory.RLock()
+	defer statsHistory.RUnlock()
+	samples := make([]StatsSample, len(statsHistory.samples))
+	copy(samples, statsHistory.samples)
+	return samples
+}
+
+// recordNewConnection counts a new connection for the history and the rate alert
+func recordNewConnection() {
+	statsHistory.newConnections.Add(1)
+	connectionRateAlert.record(time.Now())
+}
+
+// rateAlert fires when the number of new connections within a one-minute
+// window exceeds the configured threshold, at most once per cooldown
+type rateAlert struct {
+	sync.Mutex
+	threshold   int // New connections per minute, 0 = disabled
+	cooldown    time.Duration
+	webhook     string
+	windowStart time.Time
+	count       int
+	lastAlert   time.Time
+}
+
+var connectionRateAlert = &rateAlert{}
+
+// SetConnectionRateAlert configures the connection rate alert. A cooldown of
+// zero uses the default of five minutes.
+func SetConnectionRateAlert(threshold int, cooldown time.Duration, webhook string) {
+	if cooldown <= 0 {
+		cooldown = defaultRateAlertCooldown
+	}
+
+	connectionRateAlert.Lock()
+	defer connectionRateAlert.Unlock()
+	connectionRateAlert.threshold = threshold
+	connectionRateAlert.cooldown = cooldown
+	connectionRateAlert.webhook = webhook
+}
+
+// record counts a connection made at now and reports whether the alert fired
+func (a *rateAlert) record(now time.Time) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.threshold <= 0 {
+		return false
+	}
+
+	if now.Sub(a.windowStart) >= connectionRateAlertWindow {
+		a.windowStart = now
+		a.count = 0
+	}
+	a.count++
+
+	if a.count <= a.threshold {
+		return false
+	}
+	if !a.lastAlert.IsZero() && now.Sub(a.lastAlert) < a.cooldown {
+		return false
+	}
+	a.lastAlert = now
+
+	log.Printf("[WARN] Connection rate alert: %d new connections within a minute (threshold %d)", a.count, a.threshold)
+	if a.webhook != "" {
+		go sendRateAlertWebhook(a.webhook, a.count, a.threshold, now)
+	}
+	return true
+}
+
+// sendRateAlertWebhook posts the alert as JSON to the configured webhook
+func sendRateAlertWebhook(url string, count, threshold int, now time.Time) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":     "connection_rate",
+		"count":     count,
+		"threshold": threshold,
+		"time":      now,
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal connection rate alert: %v", err)
+		return
+	}
+
+	client := http.Client{Timeout: connectionRateAlertTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("[ERROR] Failed to send connection rate alert to webhook: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[WARN] Connection rate alert webhook returned %s", resp.Status)
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectionRateAlertCooldown(t *testing.T) {
+	a := &rateAlert{threshold: 5, cooldown: 5 * time.Minute}
+	start := time.Now()
+
+	burst := func(at time.Time) int {
+		fired := 0
+		for i := 0; i < 20; i++ {
+			if a.record(at.Add(time.Duration(i) * time.Millisecond)) {
+				fired++
+			}
+		}
+		return fired
+	}
+
+	if n := burst(start); n != 1 {
+		t.Errorf("first burst fired %d alerts, want 1", n)
+	}
+	if n := burst(start.Add(2 * time.Minute)); n != 0 {
+		t.Errorf("burst within cooldown fired %d alerts, want 0", n)
+	}
+	if n := burst(start.Add(6 * time.Minute)); n != 1 {
+		t.Errorf("burst after cooldown fired %d alerts, want 1", n)
+	}
+
+	// A steady rate below the threshold never fires
+	for i := 0; i < 5; i++ {
+		if a.record(start.Add(20*time.Minute + time.Duration(i)*time.Second)) {
+			t.Fatal("alert fired below threshold")
+		}
+	}
+}
+
+func TestTakeStatsSample(t *testing.T) {
+	statsHistory.newConnections.Store(0)
+	for i := 0; i < 3; i++ {
+		recordNewConnection()
+	}
+
+	sample := takeStatsSample(time.Now())
+	if sample.NewConnections != 3 {
+		t.Errorf("new connections = %d, want 3", sample.NewConnections)
+	}
+	history := StatsHistory()
+	if len(history) == 0 || history[len(history)-1] != sample {
+		t.Errorf("sample missing from history: %+v", history)
+	}
+	if n := statsHistory.newConnections.Load(); n != 0 {
+		t.Errorf("counter not reset, got %d", n)
+	}
+}
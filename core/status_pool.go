@@ -0,0 +1,188 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"mcproxy/config"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultStatusPoolIdleTimeout is how long a pre-dialed connection is kept.
+// Vanilla servers drop connections that send no handshake within 30 seconds.
+const defaultStatusPoolIdleTimeout = 10 * time.Second
+
+// statusPool keeps pre-dialed connections to a backend for real mode pings.
+// A status exchange is one-shot, the server closes the connection after the
+// pong, so connections are not returned to the pool. Instead every probe takes
+// an idle connection and the pool dials its replacement in the background,
+// taking the TCP setup out of the next probe.
+type statusPool struct {
+	dial        func() (net.Conn, error)
+	maxIdle     int
+	idleTimeout time.Duration
+
+	mutex   sync.Mutex
+	idle    []net.Conn
+	dialing int
+	closed  bool
+}
+
+// statusPools holds a pool per backend, keyed by statusPoolKey
+var statusPools = struct {
+	sync.Mutex
+	pools map[string]*statusPool
+}{
+	pools: make(map[string]*statusPool),
+}
+
+// statusPoolKey identifies the backend and route a pool dials and the size
+// and idle timeout it keeps, proxies that share a backend with different pool
+// settings each get their own pool
+func statusPoolKey(cfg config.ProxyConfig) string {
+	key := cfg.Remote + "|" + cfg.LocalAddr
+	if cfg.ResolveViaLocalAddr {
+		key += "|resolve-local"
+	}
+	return key + "|" + strconv.Itoa(cfg.StatusPoolSize) + "|" + statusPoolIdleTimeout(cfg).String()
+}
+
+// statusPoolIdleTimeout returns the idle timeout of the proxy's pool
+func statusPoolIdleTimeout(cfg config.ProxyConfig) time.Duration {
+	if cfg.StatusPoolIdleTimeoutMs > 0 {
+		return time.Duration(cfg.StatusPoolIdleTimeoutMs) * time.Millisecond
+	}
+	return defaultStatusPoolIdleTimeout
+}
+
+// statusPoolFor returns the pool for the proxy's backend, creating it on first use
+func statusPoolFor(cfg config.ProxyConfig) *statusPool {
+	statusPools.Lock()
+	defer statusPools.Unlock()
+
+	key := statusPoolKey(cfg)
+	if pool, ok := statusPools.pools[key]; ok {
+		return pool
+	}
+
+	pool := &statusPool{
+		dial: func() (net.Conn, error) {
+			return dialMC(cfg.Remote, cfg.LocalAddr, cfg.ResolveViaLocalAddr)
+		},
+		maxIdle:     cfg.StatusPoolSize,
+		idleTimeout: statusPoolIdleTimeout(cfg),
+	}
+	statusPools.pools[key] = pool
+	return pool
+}
+
+// closeStatusPools closes every pool, so a reload does not keep connections
+// to backends that are no longer configured
+func closeStatusPools() {
+	statusPools.Lock()
+	pools := statusPools.pools
+	statusPools.pools = make(map[string]*statusPool)
+	statusPools.Unlock()
+
+	for _, pool := range pools {
+		pool.close()
+	}
+}
+
+// get returns a live idle connection, or dials one if none is left, and
+// starts refilling the pool
+func (p *statusPool) get() (net.Conn, error) {
+	defer p.refill()
+
+	for {
+		p.mutex.Lock()
+		if len(p.idle) == 0 {
+			p.mutex.Unlock()
+			return p.dial()
+		}
+		conn := p.idle[0]
+		p.idle = p.idle[1:]
+		p.mutex.Unlock()
+
+		if connAlive(conn) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// refill dials connections in the background until maxIdle are idle or dialing
+func (p *statusPool) refill() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	missing := p.maxIdle - len(p.idle) - p.dialing
+	if missing <= 0 {
+		p.mutex.Unlock()
+		return
+	}
+	p.dialing += missing
+	p.mutex.Unlock()
+
+	for i := 0; i < missing; i++ {
+		go func() {
+			conn, err := p.dial()
+
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			p.dialing--
+			if err != nil {
+				log.Printf("[DEBUG] Failed to pre-dial status connection: %v", err)
+				return
+			}
+			if p.closed || len(p.idle) >= p.maxIdle {
+				conn.Close()
+				return
+			}
+			p.idle = append(p.idle, conn)
+			time.AfterFunc(p.idleTimeout, func() { p.expire(conn) })
+		}()
+	}
+}
+
+// expire closes conn if it is still idle
+func (p *statusPool) expire(conn net.Conn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i, idle := range p.idle {
+		if idle == conn {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			conn.Close()
+			return
+		}
+	}
+}
+
+// close closes the idle connections and stops refilling
+func (p *statusPool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.closed = true
+	for _, conn := range p.idle {
+		conn.Close()
+	}
+	p.idle = nil
+}
+
+// connAlive reports whether an idle connection has not been closed by the
+// server, by polling it for data without blocking
+func connAlive(conn net.Conn) bool {
+	var buf [1]byte
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := conn.Read(buf[:])
+	conn.SetReadDeadline(time.Time{})
+	// an idle status connection never receives data before the handshake
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"fmt"
+	"mcproxy/config"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startCountingStatusBackend starts a backend that answers one status exchange
+// per connection, describing itself with the order the connection was accepted
+// in, and returns its address and accept counter
+func startCountingStatusBackend(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			n := accepted.Add(1)
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for i := 0; i < 2; i++ {
+					if _, err := ReadPacket(conn); err != nil {
+						return
+					}
+				}
+				status := fmt.Sprintf(`{"version":{"name":"1.18.2","protocol":758},"players":{"max":20,"online":0},"description":"conn %d"}`, n)
+				pkt, _ := Pack(String(status))
+				WritePacket(0x00, pkt, conn)
+				ping, err := ReadPacket(conn)
+				if err != nil {
+					return
+				}
+				WritePacket(0x01, ping.Payload, conn)
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), &accepted
+}
+
+// waitForCount waits until counter reaches want
+func waitForCount(t *testing.T, counter *atomic.Int32, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for counter.Load() < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("count = %d, want %d", counter.Load(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStatusPoolReusesPreDialedConnections(t *testing.T) {
+	addr, accepted := startCountingStatusBackend(t)
+	t.Cleanup(closeStatusPools)
+
+	cfg := config.ProxyConfig{
+		Listen:         "127.0.0.1:40060",
+		Remote:         addr,
+		PingMode:       "real",
+		RewirteHost:    "backend",
+		RewirtePort:    25565,
+		StatusPoolSize: 1,
+	}
+
+	// the first probe dials on demand and the pool pre-dials the next one
+	if status := fakePing(t, cfg); status.Description != "conn 1" {
+		t.Fatalf("first probe served by %q", status.Description)
+	}
+	waitForCount(t, accepted, 2)
+
+	// later probes are served by the connection dialed before they started
+	for i := 2; i <= 3; i++ {
+		before := accepted.Load()
+		status := fakePing(t, cfg)
+		if want := fmt.Sprintf("conn %d", before); status.Description != want {
+			t.Errorf("probe %d served by %q, want the pre-dialed %q", i, status.Description, want)
+		}
+		waitForCount(t, accepted, before+1)
+	}
+}
+
+func TestStatusPoolDropsClosedConnections(t *testing.T) {
+	addr, accepted := startCountingStatusBackend(t)
+
+	pool := &statusPool{
+		dial:        func() (net.Conn, error) { return net.Dial("tcp", addr) },
+		maxIdle:     1,
+		idleTimeout: time.Hour,
+	}
+	defer pool.close()
+
+	pool.refill()
+	waitForCount(t, accepted, 1)
+	for {
+		pool.mutex.Lock()
+		n := len(pool.idle)
+		pool.mutex.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the backend hangs up once it reads EOF, get must not return the connection
+	pool.mutex.Lock()
+	stale := pool.idle[0]
+	pool.mutex.Unlock()
+	stale.(*net.TCPConn).CloseWrite()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := pool.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn == stale {
+		t.Error("got the connection the backend closed")
+	}
+}
+
+func TestStatusPoolForSeparatesSettings(t *testing.T) {
+	t.Cleanup(closeStatusPools)
+	cfg := config.ProxyConfig{Listen: "127.0.0.1:40161", Remote: "backend.example.com:25565", StatusPoolSize: 2}
+	other := cfg
+	other.Listen = "127.0.0.1:40162"
+
+	if statusPoolFor(cfg) != statusPoolFor(other) {
+		t.Error("proxies with the same backend and settings got different pools")
+	}
+
+	other.StatusPoolSize = 4
+	if pool := statusPoolFor(other); pool == statusPoolFor(cfg) || pool.maxIdle != 4 {
+		t.Errorf("status_pool_size 4 got the pool of size %d", pool.maxIdle)
+	}
+
+	other.StatusPoolSize = cfg.StatusPoolSize
+	other.StatusPoolIdleTimeoutMs = 2000
+	if pool := statusPoolFor(other); pool == statusPoolFor(cfg) || pool.idleTimeout != 2*time.Second {
+		t.Errorf("status_pool_idle_timeout_ms 2000 got the pool with idle timeout %s", pool.idleTimeout)
+	}
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+)
+
+// configurationPacketIDs maps protocol ranges to the clientbound packets that
+// move a 1.20.2+ stream between the configuration phase and the play state.
+// Keep this sorted by protocol number when adding new versions.
+var configurationPacketIDs = []struct {
+	minProtocol, maxProtocol int
+	finishConfiguration      int // configuration phase, the client enters the play state
+	startConfiguration       int // play state, the client re-enters the configuration phase
+}{
+	{764, 764, 0x02, 0x65}, // 1.20.2
+	{765, 765, 0x02, 0x67}, // 1.20.3 - 1.20.4
+	{766, 767, 0x03, 0x69}, // 1.20.5 - 1.21.1
+	{768, 769, 0x03, 0x70}, // 1.21.2 - 1.21.4
+}
+
+// firstConfigurationProtocol is 1.20.2, which added the configuration phase
+const firstConfigurationProtocol = 764
+
+// maxPhaseFrame is the largest frame that can hold Finish or Start
+// Configuration, neither has a payload
+const maxPhaseFrame = 8
+
+// Phases of a server stream
+const (
+	phaseLogin         int32 = iota
+	phaseConfiguration       // 1.20.2+ only, entered after the login and on Start Configuration
+	phasePlay
+	phaseUnknown // past the login, but the phase is not followed
+)
+
+// streamEvent is what a login packet of the server stream means for its
+// followers
+type streamEvent int
+
+const (
+	streamPending    streamEvent = iota // nothing a follower has to act on
+	streamLoggedIn                      // Login Success, the login has finished
+	streamRefused                       // Login Disconnect
+	streamEncrypted                     // Encryption Request, the rest can not be read
+	streamUnexpected                    // a login packet the proxy does not follow
+)
+
+// streamState follows the login of the server's side of a session and
+// whether its frames are compressed. Every follower of a stream feeds the
+// frames it sees to its own streamState, they can not share one as each sees
+// a packet at a different time.
+//
+// The zero value only follows the login. A state from newStreamState also
+// follows whether the client is in the configuration phase or the play state,
+// its follower has to pass it the frames up to maxPhaseFrame after the login.
+//
+// compressed and phase may be read from either forwarding goroutine.
+type streamState struct {
+	compressedFlag atomic.Bool
+	phaseValue     atomic.Int32
+
+	following           bool // the phase after the login is followed
+	finishConfiguration int  // -1 without a configuration phase
+	startConfiguration  int
+}
+
+// newStreamState returns a state following the phases of protocol. Phases of
+// 1.20.2+ protocols missing from configurationPacketIDs stay unknown.
+func newStreamState(protocol int) *streamState {
+	s := &streamState{finishConfiguration: -1, startConfiguration: -1}
+	s.following = protocol < firstConfigurationProtocol
+	for _, ids := range configurationPacketIDs {
+		if protocol >= ids.minProtocol && protocol <= ids.maxProtocol {
+			s.following = true
+			s.finishConfiguration = ids.finishConfiguration
+			s.startConfiguration = ids.startConfiguration
+		}
+	}
+	return s
+}
+
+// compressed reports whether the server has enabled compression
+func (s *streamState) compressed() bool {
+	return s.compressedFlag.Load()
+}
+
+// phase returns the phase of the stream
+func (s *streamState) phase() int32 {
+	return s.phaseValue.Load()
+}
+
+// loggedIn reports whether the server has sent its Login Success
+func (s *streamState) loggedIn() bool {
+	return s.phase() != phaseLogin
+}
+
+// configurable reports whether the stream can enter the configuration phase,
+// so frames up to maxPhaseFrame have to be observed after the login
+func (s *streamState) configurable() bool {
+	return s.finishConfiguration >= 0
+}
+
+// observe follows one frame body of the server stream. After the login only
+// the phase changes of a 1.20.2+ stream are followed.
+func (s *streamState) observe(body []byte) (streamEvent, error) {
+	phase := s.phase()
+	if phase == phaseUnknown || (phase == phasePlay && !s.configurable()) {
+		return streamPending, nil
+	}
+
+	id, payload, err := readPacketID(body, s.compressed())
+	if err != nil && phase == phaseLogin {
+		return streamPending, fmt.Errorf("unreadable login packet: %w", err)
+	}
+	if err != nil {
+		return streamPending, fmt.Errorf("unreadable server packet: %w", err)
+	}
+
+	switch {
+	case phase == phaseConfiguration && id == s.finishConfiguration:
+		s.phaseValue.Store(phasePlay)
+		return streamPending, nil
+	case phase == phasePlay && id == s.startConfiguration:
+		s.phaseValue.Store(phaseConfiguration)
+		return streamPending, nil
+	case phase != phaseLogin:
+		return streamPending, nil
+	}
+
+	switch id {
+	case loginDisconnect:
+		return streamRefused, nil
+	case loginEncryptionRequest:
+		return streamEncrypted, nil
+	case loginSetCompression:
+		var threshold VarInt
+		if _, err := threshold.ReadFrom(bytes.NewReader(payload)); err != nil {
+			return streamPending, fmt.Errorf("unreadable compression threshold: %w", err)
+		}
+		s.compressedFlag.Store(threshold >= 0)
+		return streamPending, nil
+	case loginSuccess:
+		switch {
+		case !s.following:
+			s.phaseValue.Store(phaseUnknown)
+		case s.configurable():
+			s.phaseValue.Store(phaseConfiguration)
+		default:
+			s.phaseValue.Store(phasePlay)
+		}
+		return streamLoggedIn, nil
+	}
+	return streamUnexpected, nil
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// snapshotProtocolBit marks the protocol numbers of snapshot versions
+const snapshotProtocolBit = 0x40000000
+
+// checkStrictHandshake rejects handshakes no vanilla client sends, for
+// proxies with strict_handshake. The port is not compared to the listen
+// address, since clients send the port they connected to, which differs from
+// it behind NAT port forwarding and SRV records.
+func checkStrictHandshake(protocol int, address string, port int, nextState int) error {
+	switch nextState {
+	case 1, 2, 3:
+	default:
+		return fmt.Errorf("unknown next state %d", nextState)
+	}
+
+	// clients that do not know their version ping with -1
+	release := protocol &^ snapshotProtocolBit
+	if !(protocol == -1 && nextState == 1) && (protocol <= 0 || release >= 1<<16) {
+		return fmt.Errorf("protocol %d out of range", protocol)
+	}
+
+	if port == 0 {
+		return errors.New("port 0")
+	}
+	if host, _, _ := splitHandshakeAddress(address); host == "" {
+		return errors.New("empty hostname")
+	}
+	return nil
+}
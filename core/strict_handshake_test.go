@@ -0,0 +1,90 @@
+package core
+
+import (
+	"io"
+	"mcproxy/config"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCheckStrictHandshake(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocol  int
+		address   string
+		port      int
+		nextState int
+		ok        bool
+	}{
+		{"login", VERSION_1_18_2, "mc.example.com", 25565, 2, true},
+		{"transfer", 766, "mc.example.com", 25565, 3, true},
+		{"ping without a version", -1, "mc.example.com", 25565, 1, true},
+		{"snapshot", snapshotProtocolBit | 200, "mc.example.com", 25565, 2, true},
+		{"forge marker", VERSION_1_18_2, "mc.example.com\x00FML2\x00", 25565, 2, true},
+		{"login without a version", -1, "mc.example.com", 25565, 2, false},
+		{"protocol out of range", 1 << 20, "mc.example.com", 25565, 2, false},
+		{"unknown next state", VERSION_1_18_2, "mc.example.com", 25565, 7, false},
+		{"port 0", VERSION_1_18_2, "mc.example.com", 0, 2, false},
+		{"empty hostname", VERSION_1_18_2, "", 25565, 2, false},
+		{"only a forge marker", VERSION_1_18_2, "\x00FML2\x00", 25565, 2, false},
+	}
+	for _, tt := range tests {
+		err := checkStrictHandshake(tt.protocol, tt.address, tt.port, tt.nextState)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
+
+func TestHandlerStrictHandshake(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	dialed := make(chan struct{}, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		dialed <- struct{}{}
+		return nil, io.EOF
+	}
+
+	// login reports whether a login with an empty hostname reached the backend
+	var stats *ProxyStats
+	login := func(strict bool) bool {
+		cfg := config.ProxyConfig{Listen: "127.0.0.1:40036", Remote: "backend.example.com:25565", MaxPlayer: 10, Auth: "none", StrictHandshake: strict}
+		stats = registerProxyStats(t, cfg)
+
+		client, server := net.Pipe()
+		defer client.Close()
+		done := make(chan struct{})
+		go func() {
+			handler(server, cfg, 0, nil)
+			close(done)
+		}()
+		writeHandshake(t, client, VERSION_1_18_2, "", 25565, 2)
+		// the strict handler closes the connection instead of reading on
+		loginStart, err := Pack(String("Steve"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go WritePacket(0x00, loginStart, client)
+
+		select {
+		case <-dialed:
+			return true
+		case <-done:
+			return false
+		case <-time.After(5 * time.Second):
+			t.Fatal("login neither forwarded nor closed")
+			return false
+		}
+	}
+
+	if login(true) {
+		t.Error("malformed handshake forwarded with strict_handshake")
+	}
+	if n := stats.Rejections.Malformed.Load(); n != 1 {
+		t.Errorf("malformed handshake rejections = %d, want 1", n)
+	}
+	if !login(false) {
+		t.Error("malformed handshake rejected without strict_handshake")
+	}
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"mcproxy/config"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the TLS handshake of a connection to a TLS
+// listener, clients that stall it are closed
+const tlsHandshakeTimeout = 10 * time.Second
+
+// listenerTLSConfig returns the TLS configuration of a proxy's listener, nil
+// when the proxy listens on plain TCP
+func listenerTLSConfig(cfg config.ProxyConfig) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// tlsHandshake finishes the TLS handshake of a connection to a TLS listener
+// before anything is read from it, so a failure is not mistaken for a bad
+// Minecraft handshake
+func tlsHandshake(conn *tls.Conn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+	return conn.HandshakeContext(ctx)
+}
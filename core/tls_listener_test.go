@@ -0,0 +1,136 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"mcproxy/config"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key,
+// returning their paths and a pool trusting the certificate
+func writeTestCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mcproxy test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestStartProxyTLSWithProxyHeader(t *testing.T) {
+	origDial := dialRemote
+	t.Cleanup(func() { dialRemote = origDial })
+	clientAddrs := make(chan string, 1)
+	dialRemote = func(remote, localAddr string, resolveLocal bool) (net.Conn, error) {
+		for _, conn := range GetAllConnections() {
+			if conn.Username == "Steve" {
+				clientAddrs <- conn.ClientAddr
+			}
+		}
+		return nil, errors.New("connection refused")
+	}
+
+	certFile, keyFile, pool := writeTestCert(t)
+	cfg := config.ProxyConfig{
+		Listen:                      "127.0.0.1:0",
+		Remote:                      "backend.example.com:25565",
+		MaxPlayer:                   10,
+		Auth:                        "none",
+		ProxyProtocolTrustedProxies: []string{"127.0.0.1"},
+		TLSCertFile:                 certFile,
+		TLSKeyFile:                  keyFile,
+	}
+	registerProxyStats(t, cfg)
+	startProxy(0, cfg, false)
+
+	proxyMutex.Lock()
+	proxy := activeProxies[cfg.Listen]
+	proxyMutex.Unlock()
+	defer close(proxy.stopChan)
+
+	client, err := tls.Dial("tcp", proxy.listener.Addr().String(), &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatalf("TLS dial: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// the PROXY header is sent inside TLS, before the Minecraft handshake
+	if _, err := client.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 25565\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	writeHandshake(t, client, VERSION_1_18_2, "localhost", 25565, 2)
+	writeLoginStart(t, client, "Steve")
+
+	select {
+	case addr := <-clientAddrs:
+		if addr != "203.0.113.7:51000" {
+			t.Errorf("client address = %s, want the one in the header", addr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("login not forwarded")
+	}
+
+	// a plain TCP client fails the TLS handshake and is closed
+	plain, err := net.Dial("tcp", proxy.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	plain.SetDeadline(time.Now().Add(5 * time.Second))
+	writeHandshake(t, plain, VERSION_1_18_2, "localhost", 25565, 2)
+	buf := make([]byte, 256)
+	for {
+		if _, err := plain.Read(buf); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Error("plain TCP connection was not closed")
+			}
+			break
+		}
+	}
+}
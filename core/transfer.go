@@ -0,0 +1,101 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// transferPacketIDs maps protocol versions to the Transfer packet IDs of the
+// configuration phase and the play state. The packet was added in 1.20.5,
+// older clients can not be transferred.
+var transferPacketIDs = map[int]struct{ configuration, play int }{
+	766: {0x0B, 0x73}, // 1.20.5 - 1.20.6
+	767: {0x0B, 0x73}, // 1.21 - 1.21.1
+	768: {0x0B, 0x7A}, // 1.21.2 - 1.21.3
+	769: {0x0B, 0x7A}, // 1.21.4
+}
+
+// transferFallbackMessage is shown to clients that can not be transferred
+const transferFallbackMessage = "Please reconnect to %s"
+
+var (
+	errConnectionNotFound  = errors.New("connection not found")
+	errTransferUnsupported = errors.New("transfer not supported for this connection")
+)
+
+// packTransfer builds the payload of a Transfer packet
+func packTransfer(host string, port int) ([]byte, error) {
+	return Pack(String(host), VarInt(port))
+}
+
+// TransferClient tells a connected client to reconnect to host:port. The
+// packet goes through the connection's packet injector, so it is framed like
+// the server's packets. Clients older than 1.20.5, BungeeCord switches and
+// connections whose stream can not be followed, such as encrypted ones, are
+// disconnected with a message naming the new address instead. It reports
+// whether a transfer was sent.
+func TransferClient(id string, host string, port int) (bool, error) {
+	activeConnections.RLock()
+	conn := activeConnections.connections[id]
+	var username string
+	var protocol int
+	var injector *packetInjector
+	if conn != nil {
+		username = conn.Username
+		protocol = conn.Protocol
+		injector = conn.injector
+	}
+	activeConnections.RUnlock()
+
+	if conn == nil {
+		return false, errConnectionNotFound
+	}
+
+	addr := formatAddr(host, port)
+	err := errTransferUnsupported
+	if ids, ok := transferPacketIDs[protocol]; ok && injector != nil {
+		err = injectTransfer(injector, ids.configuration, ids.play, host, port)
+	}
+	if err == nil {
+		log.Printf("[INFO] Transferred client %s to %s", username, addr)
+		return true, nil
+	}
+
+	log.Printf("[INFO] Client %s (%s) can not be transferred (%v), disconnecting with the new address %s",
+		username, ProtocolName(protocol), err, addr)
+	return false, DisconnectClient(id, fmt.Sprintf(transferFallbackMessage, addr))
+}
+
+// injectTransfer writes the Transfer packet of the phase the client is in
+func injectTransfer(injector *packetInjector, configurationID, playID int, host string, port int) error {
+	payload, err := packTransfer(host, port)
+	if err != nil {
+		return fmt.Errorf("pack transfer: %w", err)
+	}
+
+	switch phase := injector.phase(); phase {
+	case phaseConfiguration:
+		return injector.injectIn(phase, configurationID, payload)
+	case phasePlay:
+		return injector.injectIn(phase, playID, payload)
+	}
+	return errNotInPlay
+}
+
+// parseTransferTarget splits host[:port], defaulting to the Minecraft port
+func parseTransferTarget(target string) (string, int, error) {
+	host, portStr := splitHostPort(target)
+	if host == "" {
+		return "", 0, fmt.Errorf("missing host in %q", target)
+	}
+	if portStr == "" {
+		return host, 25565, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in %q", target)
+	}
+	return host, port, nil
+}
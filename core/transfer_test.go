@@ -0,0 +1,152 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPackTransfer(t *testing.T) {
+	payload, err := packTransfer("play.example.com", 25566)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePacket(transferPacketIDs[767].play, payload, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte{0x15, 0x73, 0x10}, "play.example.com"...)
+	want = append(want, 0xDE, 0xC7, 0x01)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("transfer packet = %x, want %x", buf.Bytes(), want)
+	}
+}
+
+func TestAPITransfer(t *testing.T) {
+	transfer := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleAPITransfer(rec, httptest.NewRequest(http.MethodPost, "/api/transfer", strings.NewReader(body)))
+		return rec
+	}
+	transferred := func(rec *httptest.ResponseRecorder) bool {
+		var result map[string]bool
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || !result["success"] {
+			t.Fatalf("%d %s", rec.Code, rec.Body)
+		}
+		return result["transferred"]
+	}
+
+	// a 1.21 client gets the Transfer packet of the phase it is in
+	injector, modern := registerInjectedConnection(t, "modern", "127.0.0.1:40050", 767)
+	pktCh := make(chan Packet, 4)
+	go func() {
+		for {
+			pkt, err := ReadPacket(modern)
+			if err != nil {
+				return
+			}
+			pktCh <- pkt
+		}
+	}()
+	expect := func(id int) Packet {
+		t.Helper()
+		select {
+		case pkt := <-pktCh:
+			if pkt.ID != id {
+				t.Fatalf("got packet 0x%02X, want 0x%02X", pkt.ID, id)
+			}
+			return pkt
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no packet 0x%02X", id)
+		}
+		return Packet{}
+	}
+	scanTarget := func(pkt Packet) (String, VarInt) {
+		t.Helper()
+		var host String
+		var port VarInt
+		if _, err := pkt.Scan(&host, &port); err != nil {
+			t.Fatal(err)
+		}
+		return host, port
+	}
+
+	payload, _ := Pack(String("modern"))
+	if err := WritePacket(loginSuccess, payload, injector); err != nil {
+		t.Fatal(err)
+	}
+	expect(loginSuccess)
+	if !transferred(transfer(`{"id":"modern","target":"lobby.example.com"}`)) {
+		t.Fatal("configuration phase: not transferred")
+	}
+	if host, port := scanTarget(expect(0x0B)); host != "lobby.example.com" || port != 25565 {
+		t.Errorf("got %q:%d", host, port)
+	}
+
+	if err := WritePacket(0x03, nil, injector); err != nil { // Finish Configuration
+		t.Fatal(err)
+	}
+	expect(0x03)
+	if !transferred(transfer(`{"id":"modern","target":"lobby.example.com:25566"}`)) {
+		t.Fatal("play state: not transferred")
+	}
+	if host, port := scanTarget(expect(0x73)); host != "lobby.example.com" || port != 25566 {
+		t.Errorf("got %q:%d", host, port)
+	}
+
+	// a client still logging in is disconnected rather than sent a packet it
+	// can not read
+	_, login := registerInjectedConnection(t, "login", "127.0.0.1:40050", 767)
+	reasonCh := make(chan string, 1)
+	go func() { reasonCh <- readDisconnect(t, login) }()
+	if transferred(transfer(`{"id":"login","target":"lobby.example.com"}`)) {
+		t.Fatal("login: transferred")
+	}
+	if reason := <-reasonCh; !strings.Contains(reason, "Please reconnect to lobby.example.com") {
+		t.Errorf("disconnect reason = %q", reason)
+	}
+
+	// a 1.20.4 client is disconnected with the new address instead
+	old := registerTestConnection(t, "old", "127.0.0.1:40050", func(conn *Connection) { conn.Protocol = 765 })
+	go func() { reasonCh <- readDisconnect(t, old) }()
+
+	if transferred(transfer(`{"id":"old","target":"lobby.example.com:25566"}`)) {
+		t.Fatal("old: transferred")
+	}
+	if reason := <-reasonCh; !strings.Contains(reason, "Please reconnect to lobby.example.com:25566") {
+		t.Errorf("disconnect reason = %q", reason)
+	}
+
+	if rec := transfer(`{"id":"missing","target":"lobby.example.com"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("missing connection: %d", rec.Code)
+	}
+	if rec := transfer(`{"id":"modern","target":"lobby.example.com:0"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad port: %d", rec.Code)
+	}
+}
+
+func TestInjectTransferCompressed(t *testing.T) {
+	var out bytes.Buffer
+	injector := newPacketInjector(&out, 769, "compressed")
+	setCompression, _ := Pack(VarInt(256))
+	WritePacket(loginSetCompression, setCompression, injector)
+	payload, _ := Pack(String("compressed"))
+	body := append([]byte{0x00, loginSuccess}, payload...)
+	injector.Write(appendFrame(nil, body))
+	injector.Write(appendFrame(nil, []byte{0x00, 0x03})) // Finish Configuration
+	out.Reset()
+
+	if err := injectTransfer(injector, 0x0B, 0x7A, "lobby.example.com", 25565); err != nil {
+		t.Fatal(err)
+	}
+	// a data length of 0 marks the uncompressed packet
+	body, err := readFrameBody(&out)
+	if err != nil || len(body) < 2 || body[0] != 0x00 || body[1] != 0x7A {
+		t.Errorf("transfer frame = %x, %v", body, err)
+	}
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Values of ProxyConfig.UsernamePolicy for usernames outside the Minecraft
+// charset
+const (
+	UsernamePolicyAllow    = "allow"    // logged, shown and forwarded as sent
+	UsernamePolicySanitize = "sanitize" // the other characters replaced before the name is used, the default
+	UsernamePolicyReject   = "reject"   // disconnected before the login is forwarded
+)
+
+// invalidUsernameMessage is shown to logins rejected by username_policy reject
+const invalidUsernameMessage = "Invalid username"
+
+// maxUsernameLength is the longest Minecraft username in characters
+const maxUsernameLength = 16
+
+// validUsername reports whether name is a Minecraft username: 1 to 16
+// letters, digits or underscores
+func validUsername(name string) bool {
+	if name == "" || len(name) > maxUsernameLength {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !usernameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// usernameChar reports whether c may appear in a Minecraft username
+func usernameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// sanitizeUsername replaces every character outside the Minecraft charset with
+// '?'. No valid username contains '?', so a sanitized name never matches a
+// whitelist or blacklist entry of another player.
+func sanitizeUsername(name string) string {
+	if validUsername(name) {
+		return name
+	}
+	var b strings.Builder
+	for _, r := range name {
+		if r < 0x80 && usernameChar(byte(r)) {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// replaceLoginStartName returns a Login Start payload with the username
+// replaced by name, the fields after it are kept
+func replaceLoginStartName(payload []byte, name string) ([]byte, error) {
+	r := bytes.NewReader(payload)
+	var old String
+	if _, err := old.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	packed, err := Pack(String(name))
+	if err != nil {
+		return nil, err
+	}
+	return append(packed, payload[len(payload)-r.Len():]...), nil
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSanitizeUsername(t *testing.T) {
+	tests := map[string]string{
+		"Steve_01":                     "Steve_01",
+		"Steve\n[INFO] fake log line":  "Steve??INFO??fake?log?line",
+		"\x1b[31mRed":                  "??31mRed",
+		"<img src=x onerror=alert(1)>": "?img?src?x?onerror?alert?1??",
+		"Stéve":                        "St?ve",
+	}
+	for in, want := range tests {
+		if got := sanitizeUsername(in); got != want {
+			t.Errorf("sanitizeUsername(%q) = %q, want %q", in, got, want)
+		}
+		if valid := validUsername(in); valid != (in == want) {
+			t.Errorf("validUsername(%q) = %v", in, valid)
+		}
+	}
+	if validUsername("") || validUsername("ABCDEFGHIJKLMNOPQ") {
+		t.Error("accepted an empty or 17 character username")
+	}
+}
+
+func TestReplaceLoginStartName(t *testing.T) {
+	// a 1.20.2+ Login Start, the player's UUID follows the name
+	uuid := bytes.Repeat([]byte{0xAB}, 16)
+	payload, _ := Pack(String("Steve\n<b>x</b>"))
+	payload = append(payload, uuid...)
+
+	got, err := replaceLoginStartName(payload, "Steve??b?x??b?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := Pack(String("Steve??b?x??b?"))
+	want = append(want, uuid...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("payload = %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+package core
+
+import "fmt"
+
+// protocolNames maps protocol numbers to the newest release that uses them.
+// Keep this sorted by protocol number when adding new versions.
+var protocolNames = map[int]string{
+	VERSION_1_8_9:  "1.8.9",
+	107:            "1.9",
+	108:            "1.9.1",
+	109:            "1.9.2",
+	110:            "1.9.4",
+	210:            "1.10.2",
+	315:            "1.11",
+	316:            "1.11.2",
+	335:            "1.12",
+	338:            "1.12.1",
+	340:            "1.12.2",
+	393:            "1.13",
+	401:            "1.13.1",
+	404:            "1.13.2",
+	477:            "1.14",
+	480:            "1.14.1",
+	485:            "1.14.2",
+	490:            "1.14.3",
+	498:            "1.14.4",
+	573:            "1.15",
+	575:            "1.15.1",
+	578:            "1.15.2",
+	735:            "1.16",
+	736:            "1.16.1",
+	751:            "1.16.2",
+	753:            "1.16.3",
+	754:            "1.16.5",
+	755:            "1.17",
+	756:            "1.17.1",
+	757:            "1.18.1",
+	VERSION_1_18_2: "1.18.2",
+	759:            "1.19",
+	760:            "1.19.2",
+	761:            "1.19.3",
+	762:            "1.19.4",
+	763:            "1.20.1",
+	764:            "1.20.2",
+	765:            "1.20.4",
+	766:            "1.20.6",
+	767:            "1.21.1",
+	768:            "1.21.3",
+	769:            "1.21.4",
+	770:            "1.21.5",
+	771:            "1.21.6",
+	772:            "1.21.8",
+}
+
+// ProtocolName returns the human readable version for a protocol number
+func ProtocolName(proto int) string {
+	if name, ok := protocolNames[proto]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", proto)
+}
@@ -0,0 +1,22 @@
+package core_test
+
+import (
+	"mcproxy/core"
+	"testing"
+)
+
+func TestProtocolName(t *testing.T) {
+	tests := make(map[int]string)
+	tests[core.VERSION_1_8_9] = "1.8.9"
+	tests[core.VERSION_1_18_2] = "1.18.2"
+	tests[340] = "1.12.2"
+	tests[765] = "1.20.4"
+	tests[1] = "unknown (1)"
+	tests[9999] = "unknown (9999)"
+
+	for k, v := range tests {
+		if name := core.ProtocolName(k); name != v {
+			t.Errorf("%d: %s != %s", k, name, v)
+		}
+	}
+}
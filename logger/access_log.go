@@ -0,0 +1,226 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Formats of the access log
+const (
+	AccessLogCombined = "combined" // one text line like a web server's combined log
+	AccessLogJSON     = "json"     // one JSON object per line
+)
+
+// AccessEntry is one finished session in the access log
+type AccessEntry struct {
+	Time       time.Time     `json:"time"` // when the session ended
+	ClientIP   string        `json:"client_ip"`
+	ClientPort string        `json:"client_port,omitempty"` // source port, only written with AccessLogOptions.SourcePort
+	Username   string        `json:"username"`
+	Proxy      string        `json:"proxy"`   // listen address of the proxy
+	Backend    string        `json:"backend"` // remote server
+	BytesIn    int64         `json:"bytes_in"`
+	BytesOut   int64         `json:"bytes_out"`
+	Duration   time.Duration `json:"-"`
+	Reason     string        `json:"reason"` // why the session ended
+}
+
+// AccessLogOptions configures the format and rotation of an access log
+type AccessLogOptions struct {
+	Format      string        // AccessLogCombined (default) or AccessLogJSON
+	MaxSize     int64         // rotate once the file reaches this many bytes, 0 = no limit
+	RotateEvery time.Duration // rotate files older than this, 0 = no limit
+	MaxBackups  int           // rotated files kept, 0 = keep all
+	SourcePort  bool          // write the client's source port, as host:port in the combined format
+}
+
+// AccessLog writes one line per finished session to a file, rotating it by
+// size and age. Rotated files get the time of the rotation appended to their
+// name.
+type AccessLog struct {
+	path string
+	opts AccessLogOptions
+
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// OpenAccessLog opens or creates the access log at path
+func OpenAccessLog(path string, opts AccessLogOptions) (*AccessLog, error) {
+	switch opts.Format {
+	case "":
+		opts.Format = AccessLogCombined
+	case AccessLogCombined, AccessLogJSON:
+	default:
+		return nil, fmt.Errorf("invalid access log format %q", opts.Format)
+	}
+
+	a := &AccessLog{path: path, opts: opts}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// open opens the file at a.path for appending
+func (a *AccessLog) open() error {
+	if dir := filepath.Dir(a.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create access log directory: %w", err)
+		}
+	}
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	a.file = file
+	a.size = info.Size()
+	a.opened = time.Now()
+	return nil
+}
+
+// Write appends the entry, rotating the file first when it is due
+func (a *AccessLog) Write(e AccessEntry) error {
+	line, err := a.format(e)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.file == nil {
+		return os.ErrClosed
+	}
+	if a.due(e.Time, int64(len(line))) {
+		if err := a.rotate(e.Time); err != nil {
+			return err
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// format returns the entry as one line in the configured format
+func (a *AccessLog) format(e AccessEntry) ([]byte, error) {
+	if !a.opts.SourcePort {
+		e.ClientPort = ""
+	}
+	if a.opts.Format == AccessLogJSON {
+		line, err := json.Marshal(struct {
+			AccessEntry
+			DurationMs int64 `json:"duration_ms"`
+		}{e, e.Duration.Milliseconds()})
+		if err != nil {
+			return nil, err
+		}
+		return append(line, '\n'), nil
+	}
+
+	username := quoteField(e.Username)
+	if username == "" {
+		username = "-"
+	}
+	client := e.ClientIP
+	if e.ClientPort != "" {
+		client = net.JoinHostPort(e.ClientIP, e.ClientPort)
+	}
+	return fmt.Appendf(nil, "%s - %s [%s] \"%s -> %s\" %d %d %.3f %q\n",
+		client, username, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Proxy, e.Backend, e.BytesIn, e.BytesOut, e.Duration.Seconds(), e.Reason), nil
+}
+
+// quoteField returns a client supplied field of the combined format quoted
+// when it has spaces, quotes or unprintable characters that could forge a
+// field or a line, and as it is otherwise
+func quoteField(s string) string {
+	for _, r := range s {
+		if r == ' ' || r == '"' || r == '\\' || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+// due reports whether the file has to be rotated before n more bytes are
+// written at now
+func (a *AccessLog) due(now time.Time, n int64) bool {
+	if a.size == 0 {
+		return false
+	}
+	if a.opts.MaxSize > 0 && a.size+n > a.opts.MaxSize {
+		return true
+	}
+	return a.opts.RotateEvery > 0 && now.Sub(a.opened) >= a.opts.RotateEvery
+}
+
+// rotate renames the current file and opens a new one, then removes the
+// oldest rotated files beyond MaxBackups
+func (a *AccessLog) rotate(now time.Time) error {
+	a.file.Close()
+	a.file = nil
+
+	rotated := a.path + "." + now.Format("20060102-150405.000")
+	if err := os.Rename(a.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate access log: %w", err)
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if a.opts.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(a.path + ".*")
+	if err != nil {
+		return nil
+	}
+	// the timestamps sort by name
+	backups = filterRotated(a.path, backups)
+	sort.Strings(backups)
+	for len(backups) > a.opts.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// filterRotated keeps the files named like rotated copies of path
+func filterRotated(path string, files []string) []string {
+	rotated := files[:0]
+	for _, f := range files {
+		suffix := strings.TrimPrefix(f, path+".")
+		if _, err := time.Parse("20060102-150405.000", suffix); err == nil {
+			rotated = append(rotated, f)
+		}
+	}
+	return rotated
+}
+
+// Close closes the file, later writes fail
+func (a *AccessLog) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
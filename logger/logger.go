@@ -1,14 +1,18 @@
 package logger
 
 import (
+	"bytes"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -43,6 +47,24 @@ func (l LogLevel) String() string {
 	}
 }
 
+// ParseLogLevel parses a level name such as "debug" or "WARN"
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "DEBUG":
+		return DEBUG, nil
+	case "INFO":
+		return INFO, nil
+	case "WARN", "WARNING":
+		return WARN, nil
+	case "ERROR":
+		return ERROR, nil
+	case "FATAL":
+		return FATAL, nil
+	default:
+		return DEBUG, fmt.Errorf("invalid log level %q", s)
+	}
+}
+
 // LogEntry represents a single log entry
 type LogEntry struct {
 	ID        int64     `json:"id"`
@@ -50,6 +72,26 @@ type LogEntry struct {
 	Level     string    `json:"level"`
 	Message   string    `json:"message"`
 	Source    string    `json:"source"`
+
+	// BadTimestamp marks an entry whose stored timestamp could not be read,
+	// Timestamp is zero then
+	BadTimestamp bool `json:"bad_timestamp,omitempty"`
+}
+
+// timestampLayout is the one format timestamps are stored in, always in UTC.
+// It is what the SQLite driver wrote for a time.Time, so rows written before
+// timestamps were formatted explicitly read the same, and it sorts as text.
+const timestampLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// formatTimestamp returns t as stored in the logs table
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
+}
+
+// parseTimestamp reads a timestamp stored by formatTimestamp
+func parseTimestamp(s string) (time.Time, error) {
+	t, err := time.Parse(timestampLayout, s)
+	return t.UTC(), err
 }
 
 // Logger is a SQLite-backed logger
@@ -59,8 +101,84 @@ type Logger struct {
 	dbPath     string
 	mutex      sync.Mutex
 	initialized bool
+	minLevel   atomic.Int32 // Messages below this level are dropped
+
+	dedupMutex  sync.Mutex
+	dedupWindow time.Duration // Identical messages within this window are collapsed, 0 disables
+	last        dedupEntry
+	flushTimer  *time.Timer // Writes the summary of suppressed repeats once their window ends
+
+	autoCompact atomic.Bool // Compact after large deletes, set by StartCompaction
+
+	storage            StorageOptions // Options the database was opened with
+	periodicCheckpoint atomic.Bool    // Checkpoint on a schedule instead of after every write, set by StartCheckpoints
+
+	mode      string // Where logs are stored, see Status
+	statusErr error  // Why the database fell back or failed, or the last failed write
+}
+
+// Storage modes reported by Status
+const (
+	ModeDisk   = "disk"   // logs are stored in the database file
+	ModeMemory = "memory" // the file could not be used, logs are lost on restart
+	ModeFailed = "failed" // no database, logs only go to stdout
+)
+
+// Status reports where logs are stored and, when logging is degraded, why.
+// The error is also set in disk mode while writes to the database fail.
+func (l *Logger) Status() (mode string, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.initialized || l.db == nil {
+		err := l.statusErr
+		if err == nil {
+			err = errors.New("log database not initialized")
+		}
+		return ModeFailed, err
+	}
+	return l.mode, l.statusErr
 }
 
+// StorageOptions tune how the database trades durability for throughput
+type StorageOptions struct {
+	JournalMode string // SQLite journal_mode: WAL (default), DELETE, TRUNCATE, PERSIST, MEMORY or OFF
+	Synchronous string // SQLite synchronous: OFF, NORMAL (default), FULL or EXTRA
+}
+
+// normalize validates the options and fills in the defaults
+func (o StorageOptions) normalize() (StorageOptions, error) {
+	o.JournalMode = strings.ToUpper(o.JournalMode)
+	switch o.JournalMode {
+	case "":
+		o.JournalMode = "WAL"
+	case "WAL", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "OFF":
+	default:
+		return o, fmt.Errorf("invalid journal mode %q", o.JournalMode)
+	}
+
+	o.Synchronous = strings.ToUpper(o.Synchronous)
+	switch o.Synchronous {
+	case "":
+		o.Synchronous = "NORMAL"
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return o, fmt.Errorf("invalid synchronous level %q", o.Synchronous)
+	}
+	return o, nil
+}
+
+// dedupEntry tracks the last logged message and how often it was repeated
+type dedupEntry struct {
+	level   LogLevel
+	message string
+	since   time.Time
+	repeats int
+}
+
+// DefaultDedupWindow is the window in which identical messages are collapsed
+const DefaultDedupWindow = 10 * time.Second
+
 var instance *Logger
 var once sync.Once
 
@@ -68,7 +186,8 @@ var once sync.Once
 func GetLogger() *Logger {
 	once.Do(func() {
 		instance = &Logger{
-			stdLogger: log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile),
+			stdLogger:   log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile),
+			dedupWindow: DefaultDedupWindow,
 		}
 	})
 	return instance
@@ -76,13 +195,29 @@ func GetLogger() *Logger {
 
 // Initialize initializes the logger with the given database path
 func (l *Logger) Initialize(dbPath string) error {
+	return l.InitializeWithOptions(dbPath, StorageOptions{})
+}
+
+// InitializeWithOptions initializes the logger with the given database path
+// and storage options
+func (l *Logger) InitializeWithOptions(dbPath string, opts StorageOptions) error {
+	opts, err := opts.normalize()
+	if err != nil {
+		return err
+	}
+
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
 	if l.initialized {
 		return nil
 	}
+	return l.initializeLocked(dbPath, opts)
+}
 
+// initializeLocked opens the database at dbPath and makes it the logger's,
+// with the mutex held and normalized options
+func (l *Logger) initializeLocked(dbPath string, opts StorageOptions) error {
 	// Convert to absolute path if it's relative
 	if !filepath.IsAbs(dbPath) {
 		absPath, err := filepath.Abs(dbPath)
@@ -114,10 +249,11 @@ func (l *Logger) Initialize(dbPath string) error {
 	// Try to open the database with different methods if needed
 	var db *sql.DB
 	var err error
+	var fallbackErr error // why the in-memory database is used
 
-	// First attempt: Use a DSN with pragmas for better reliability
-	dsn := fmt.Sprintf("file:%s?_journal=WAL&_timeout=5000", dbPath)
-	db, err = sql.Open("sqlite", dsn)
+	// First attempt: Use a DSN with pragmas for better reliability, they are
+	// applied to every connection of the pool
+	db, err = sql.Open("sqlite", storageDSN(dbPath, opts))
 
 	// If that fails, try a simpler approach
 	if err != nil {
@@ -126,18 +262,22 @@ func (l *Logger) Initialize(dbPath string) error {
 		if err != nil {
 			l.stdLogger.Printf("[ERROR] Failed to open database with simple path: %v", err)
 			// Try in-memory database as a last resort
+			fallbackErr = err
 			db, err = sql.Open("sqlite", ":memory:")
 			if err != nil {
-				return fmt.Errorf("all database open attempts failed: %w", err)
+				l.statusErr = fmt.Errorf("all database open attempts failed: %w", err)
+				return l.statusErr
 			}
+			// every pooled connection would get its own empty database
+			db.SetMaxOpenConns(1)
 			l.stdLogger.Printf("[WARN] Using in-memory database as fallback")
 		}
 	}
 
 	// Set pragmas for better performance and reliability
 	pragmas := []string{
-		"PRAGMA journal_mode = WAL;",
-		"PRAGMA synchronous = NORMAL;",
+		"PRAGMA journal_mode = " + opts.JournalMode + ";",
+		"PRAGMA synchronous = " + opts.Synchronous + ";",
 		"PRAGMA cache_size = 1000;",
 		"PRAGMA busy_timeout = 5000;",
 		"PRAGMA temp_store = MEMORY;",
@@ -168,10 +308,14 @@ func (l *Logger) Initialize(dbPath string) error {
 		db.Close()
 
 		// Try in-memory database as a last resort
+		fallbackErr = fmt.Errorf("failed to create logs table in %s: %w", dbPath, err)
 		db, err = sql.Open("sqlite", ":memory:")
 		if err != nil {
-			return fmt.Errorf("failed to open in-memory database: %w", err)
+			l.statusErr = fmt.Errorf("failed to open in-memory database: %w", err)
+			return l.statusErr
 		}
+		// every pooled connection would get its own empty database
+		db.SetMaxOpenConns(1)
 
 		// Create the logs table in memory
 		_, err = db.Exec(`
@@ -188,7 +332,8 @@ func (l *Logger) Initialize(dbPath string) error {
 		`)
 		if err != nil {
 			db.Close()
-			return fmt.Errorf("failed to create in-memory logs table: %w", err)
+			l.statusErr = fmt.Errorf("failed to create in-memory logs table: %w", err)
+			return l.statusErr
 		}
 
 		l.stdLogger.Printf("[WARN] Using in-memory database as fallback")
@@ -204,7 +349,14 @@ func (l *Logger) Initialize(dbPath string) error {
 
 	l.db = db
 	l.dbPath = dbPath
+	l.storage = opts
 	l.initialized = true
+	l.mode = ModeDisk
+	l.statusErr = nil
+	if fallbackErr != nil {
+		l.mode = ModeMemory
+		l.statusErr = fallbackErr
+	}
 
 	// Log initialization message directly to avoid deadlock
 	// (Info method would try to acquire the mutex that's already held)
@@ -212,22 +364,242 @@ func (l *Logger) Initialize(dbPath string) error {
 	return nil
 }
 
-// Close closes the logger database connection
+// Close closes the logger database connection, the logger can be initialized
+// again afterwards
 func (l *Logger) Close() error {
+	l.flushRepeats()
+
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
+	l.initialized = false
 	if l.db != nil {
-		return l.db.Close()
+		db := l.db
+		l.db = nil
+		return db.Close()
 	}
 	return nil
 }
 
+// Reopen moves an initialized logger to the database at dbPath, closing the
+// current one after writing any suppressed repeats to it. A logger that
+// already uses dbPath is reopened if the storage options changed. It does
+// nothing if the logger is not initialized or nothing changed.
+func (l *Logger) Reopen(dbPath string, opts StorageOptions) error {
+	opts, err := opts.normalize()
+	if err != nil {
+		return err
+	}
+	if absPath, err := filepath.Abs(dbPath); err == nil {
+		dbPath = absPath
+	}
+
+	l.flushRepeats()
+
+	// The new database is opened before the old one is closed, with the mutex
+	// held throughout so no write finds the logger without a database
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.initialized || (l.dbPath == dbPath && l.storage == opts) {
+		return nil
+	}
+
+	// The journal mode cannot leave WAL while another connection has the
+	// database open, so the same file is closed before it is opened again
+	if l.dbPath == dbPath {
+		l.stdLogger.Printf("[INFO] Reopening log database %s with journal_mode %s and synchronous %s", dbPath, opts.JournalMode, opts.Synchronous)
+		storage := l.storage
+		if err := l.db.Close(); err != nil {
+			l.stdLogger.Printf("[WARN] Failed to close log database %s: %v", dbPath, err)
+		}
+		l.db = nil
+		if err := l.initializeLocked(dbPath, opts); err != nil {
+			if err := l.initializeLocked(dbPath, storage); err != nil {
+				l.stdLogger.Printf("[ERROR] Failed to reopen log database %s: %v", dbPath, err)
+			}
+			return err
+		}
+		return nil
+	}
+
+	l.stdLogger.Printf("[INFO] Moving log database from %s to %s", l.dbPath, dbPath)
+	old, current, storage, mode, statusErr := l.db, l.dbPath, l.storage, l.mode, l.statusErr
+	if err := l.initializeLocked(dbPath, opts); err != nil {
+		l.db, l.dbPath, l.storage, l.mode, l.statusErr = old, current, storage, mode, statusErr
+		return err
+	}
+	if err := old.Close(); err != nil {
+		l.stdLogger.Printf("[WARN] Failed to close log database %s: %v", current, err)
+	}
+	return nil
+}
+
+// CheckWritable reports whether a log database can be written at dbPath,
+// creating its directory like InitializeWithOptions. Unlike the logger it does
+// not fall back to another location. An existing database is opened for
+// writing, otherwise a temporary file is created next to it.
+func CheckWritable(dbPath string) error {
+	dir := filepath.Dir(dbPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create log directory: %w", err)
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		f, err := os.OpenFile(dbPath, os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("open log database: %w", err)
+		}
+		return f.Close()
+	}
+
+	f, err := os.CreateTemp(dir, ".mcproxy-selftest-*")
+	if err != nil {
+		return fmt.Errorf("write to log directory: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// SetLevel sets the minimum level that is logged, fatal messages are always logged
+func (l *Logger) SetLevel(level LogLevel) {
+	l.minLevel.Store(int32(level))
+}
+
+// Level returns the minimum level that is logged
+func (l *Logger) Level() LogLevel {
+	return LogLevel(l.minLevel.Load())
+}
+
+// Enabled reports whether messages of level are logged
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.Level() || level == FATAL
+}
+
+// levelFilter drops the lines of the standard logger tagged with a level
+// below the logger's
+type levelFilter struct {
+	l *Logger
+	w io.Writer
+}
+
+// LevelFilter returns a writer for log.SetOutput that passes the standard
+// logger's lines on to w unless their "[LEVEL]" tag is below the minimum
+// level, so the level set at runtime applies to log.Printf too. Lines without
+// a tag are always written.
+func (l *Logger) LevelFilter(w io.Writer) io.Writer {
+	return &levelFilter{l: l, w: w}
+}
+
+func (f *levelFilter) Write(p []byte) (int, error) {
+	// the date, time and file of the header never contain a '['
+	if start := bytes.IndexByte(p, '['); start >= 0 {
+		if end := bytes.IndexByte(p[start:], ']'); end > 0 {
+			if level, err := ParseLogLevel(string(p[start+1 : start+end])); err == nil && !f.l.Enabled(level) {
+				return len(p), nil
+			}
+		}
+	}
+	return f.w.Write(p)
+}
+
+// SetDedupWindow sets the window in which identical messages are collapsed
+// into a single "repeated N times" entry, 0 disables deduplication
+func (l *Logger) SetDedupWindow(d time.Duration) {
+	l.flushRepeats()
+
+	l.dedupMutex.Lock()
+	defer l.dedupMutex.Unlock()
+	l.dedupWindow = d
+}
+
+// deduplicate records msg and reports whether it repeats the previous message
+// within the window. If the previous message was suppressed, the returned
+// summary must be written before msg.
+func (l *Logger) deduplicate(level LogLevel, msg string, now time.Time) (summary *dedupEntry, repeated bool) {
+	l.dedupMutex.Lock()
+	defer l.dedupMutex.Unlock()
+
+	if l.dedupWindow <= 0 || level == FATAL {
+		return nil, false
+	}
+
+	if l.last.level == level && l.last.message == msg && now.Sub(l.last.since) < l.dedupWindow {
+		l.last.repeats++
+		// the summary is written when the window ends, even if no other
+		// message follows
+		if l.last.repeats == 1 {
+			if l.flushTimer != nil {
+				l.flushTimer.Stop()
+			}
+			l.flushTimer = time.AfterFunc(l.last.since.Add(l.dedupWindow).Sub(now), l.flushExpired)
+		}
+		return nil, true
+	}
+
+	if l.last.repeats > 0 {
+		prev := l.last
+		summary = &prev
+	}
+	l.last = dedupEntry{level: level, message: msg, since: now}
+	return summary, false
+}
+
+// flushRepeats writes the summary of any suppressed repeats of the last message
+func (l *Logger) flushRepeats() {
+	l.dedupMutex.Lock()
+	prev := l.last
+	l.last.repeats = 0
+	l.dedupMutex.Unlock()
+
+	if prev.repeats > 0 {
+		l.write(prev.level, 3, prev.summary())
+	}
+}
+
+// flushExpired writes the summary of suppressed repeats of the last message
+// once its window has ended. A message that arrived since then started its
+// own window and is left alone.
+func (l *Logger) flushExpired() {
+	l.dedupMutex.Lock()
+	prev := l.last
+	if time.Since(prev.since) < l.dedupWindow {
+		l.dedupMutex.Unlock()
+		return
+	}
+	l.last.repeats = 0
+	l.dedupMutex.Unlock()
+
+	if prev.repeats > 0 {
+		l.write(prev.level, 3, prev.summary())
+	}
+}
+
+// summary returns the collapsed message for a repeated entry
+func (e dedupEntry) summary() string {
+	return fmt.Sprintf("%s (repeated %d times)", e.message, e.repeats)
+}
+
 // log logs a message with the given level
 func (l *Logger) log(level LogLevel, calldepth int, format string, v ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
 	// Format the message
 	msg := fmt.Sprintf(format, v...)
 
+	summary, repeated := l.deduplicate(level, msg, time.Now())
+	if summary != nil {
+		l.write(summary.level, calldepth+1, summary.summary())
+	}
+	if repeated {
+		return
+	}
+	l.write(level, calldepth+1, msg)
+}
+
+// write writes a formatted message to stdout and the database
+func (l *Logger) write(level LogLevel, calldepth int, msg string) {
 	// Log to stdout
 	l.stdLogger.Output(calldepth+1, fmt.Sprintf("[%s] %s", level.String(), msg))
 
@@ -255,7 +627,7 @@ func (l *Logger) log(level LogLevel, calldepth int, format string, v ...interfac
 		// Try to insert the log entry
 		_, err = l.db.Exec(
 			"INSERT INTO logs (timestamp, level, message, source) VALUES (?, ?, ?, ?)",
-			time.Now().UTC(), level.String(), msg, source,
+			formatTimestamp(time.Now()), level.String(), msg, source,
 		)
 
 		if err == nil {
@@ -271,6 +643,10 @@ func (l *Logger) log(level LogLevel, calldepth int, format string, v ...interfac
 		}
 	}
 
+	if l.mode == ModeDisk {
+		l.statusErr = err
+	}
+
 	if err != nil {
 		// All retries failed
 		l.stdLogger.Printf("[ERROR] Failed to write log to database after %d attempts: %v", 
@@ -280,7 +656,7 @@ func (l *Logger) log(level LogLevel, calldepth int, format string, v ...interfac
 		if isConnectionError(err) {
 			l.tryReconnect()
 		}
-	} else {
+	} else if l.storage.JournalMode == "WAL" && !l.periodicCheckpoint.Load() {
 		// Ensure the log is immediately written to disk
 		_, err = l.db.Exec("PRAGMA wal_checkpoint(PASSIVE)")
 		if err != nil {
@@ -289,6 +665,110 @@ func (l *Logger) log(level LogLevel, calldepth int, format string, v ...interfac
 	}
 }
 
+// storageDSN returns the data source name that opens dbPath with opts
+func storageDSN(dbPath string, opts StorageOptions) string {
+	return fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(%s)&_pragma=synchronous(%s)",
+		dbPath, opts.JournalMode, opts.Synchronous)
+}
+
+// StartCheckpoints checkpoints the WAL on a schedule instead of after every
+// write, fewer checkpoints mean less I/O but a larger WAL between them
+func (l *Logger) StartCheckpoints(interval time.Duration) {
+	l.periodicCheckpoint.Store(true)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			l.checkpoint()
+		}
+	}()
+}
+
+// checkpoint copies the WAL into the database
+func (l *Logger) checkpoint() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.initialized || l.db == nil || l.storage.JournalMode != "WAL" {
+		return
+	}
+	if _, err := l.db.Exec("PRAGMA wal_checkpoint(PASSIVE)"); err != nil {
+		l.stdLogger.Printf("[WARN] Failed to checkpoint WAL: %v", err)
+	}
+}
+
+// compactAfterDeletes is the number of deleted rows that triggers a compaction
+// when automatic compaction is enabled
+const compactAfterDeletes = 10000
+
+// CompactResult reports the size of the database files around a compaction
+type CompactResult struct {
+	Before int64 `json:"before"`
+	After  int64 `json:"after"`
+}
+
+// Compact checkpoints the WAL and rebuilds the database with VACUUM, so the
+// file shrinks back to the live data. It holds the logger mutex, log writes
+// wait until it has finished.
+func (l *Logger) Compact() (CompactResult, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.initialized || l.db == nil {
+		return CompactResult{}, fmt.Errorf("logger not initialized")
+	}
+
+	result := CompactResult{Before: l.dbSize()}
+	for _, stmt := range []string{"PRAGMA wal_checkpoint(TRUNCATE)", "VACUUM", "PRAGMA wal_checkpoint(TRUNCATE)"} {
+		if _, err := l.db.Exec(stmt); err != nil {
+			return result, fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	result.After = l.dbSize()
+	return result, nil
+}
+
+// dbSize returns the size of the database and its WAL on disk
+func (l *Logger) dbSize() int64 {
+	var size int64
+	for _, path := range []string{l.dbPath, l.dbPath + "-wal"} {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// StartCompaction compacts the database every interval and after deletes of
+// many rows
+func (l *Logger) StartCompaction(interval time.Duration) {
+	l.autoCompact.Store(true)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			l.compactAndReport()
+		}
+	}()
+}
+
+// compactAfterDelete starts a compaction when a delete removed many rows
+func (l *Logger) compactAfterDelete(deleted int64) {
+	if l.autoCompact.Load() && deleted >= compactAfterDeletes {
+		go l.compactAndReport()
+	}
+}
+
+// compactAndReport compacts the database and logs the size change
+func (l *Logger) compactAndReport() {
+	result, err := l.Compact()
+	if err != nil {
+		l.Error("Failed to compact log database: %v", err)
+		return
+	}
+	l.Info("Compacted log database from %d to %d bytes", result.Before, result.After)
+}
+
 // isConnectionError checks if an error is related to database connection issues
 func isConnectionError(err error) bool {
 	if err == nil {
@@ -325,10 +805,11 @@ func (l *Logger) tryReconnect() {
 	}
 
 	// Try to reopen the database
-	db, err := sql.Open("sqlite", l.dbPath)
+	db, err := sql.Open("sqlite", storageDSN(l.dbPath, l.storage))
 	if err != nil {
 		l.stdLogger.Printf("[ERROR] Failed to reopen database: %v", err)
 		l.db = nil
+		l.statusErr = fmt.Errorf("failed to reopen database: %w", err)
 		return
 	}
 
@@ -337,6 +818,7 @@ func (l *Logger) tryReconnect() {
 		l.stdLogger.Printf("[ERROR] Failed to ping reopened database: %v", err)
 		db.Close()
 		l.db = nil
+		l.statusErr = fmt.Errorf("failed to reopen database: %w", err)
 		return
 	}
 
@@ -383,7 +865,7 @@ func (l *Logger) GetLogs(limit, offset int, level string, startTime, endTime tim
 	}
 
 	// Build the query
-	query := "SELECT id, timestamp, level, message, source FROM logs WHERE 1=1"
+	query := "SELECT id, CAST(timestamp AS TEXT), level, message, source FROM logs WHERE 1=1"
 	args := []interface{}{}
 
 	// Add filters
@@ -394,12 +876,12 @@ func (l *Logger) GetLogs(limit, offset int, level string, startTime, endTime tim
 
 	if !startTime.IsZero() {
 		query += " AND timestamp >= ?"
-		args = append(args, startTime.UTC())
+		args = append(args, formatTimestamp(startTime))
 	}
 
 	if !endTime.IsZero() {
 		query += " AND timestamp <= ?"
-		args = append(args, endTime.UTC())
+		args = append(args, formatTimestamp(endTime))
 	}
 
 	// Add ordering and limits
@@ -439,48 +921,7 @@ func (l *Logger) GetLogs(limit, offset int, level string, startTime, endTime tim
 	}
 	defer rows.Close()
 
-	// Parse the results
-	logs := []LogEntry{}
-	for rows.Next() {
-		var entry LogEntry
-		var timestamp string
-		err := rows.Scan(&entry.ID, &timestamp, &entry.Level, &entry.Message, &entry.Source)
-		if err != nil {
-			l.stdLogger.Printf("[ERROR] Failed to scan log entry: %v", err)
-			continue // Skip this entry but continue processing others
-		}
-
-		// Parse the timestamp - try multiple formats
-		formats := []string{
-			time.RFC3339Nano,                    // ISO 8601 with nanoseconds (e.g., "2025-08-27T20:37:20.055403996Z")
-			"2006-01-02T15:04:05.999999999Z07:00", // ISO 8601 with nanoseconds and explicit timezone
-			"2006-01-02 15:04:05.999999999Z07:00", // Space separator with nanoseconds
-			"2006-01-02 15:04:05.999999999-07:00", // Original format
-		}
-
-		parsed := false
-		for _, format := range formats {
-			entry.Timestamp, err = time.Parse(format, timestamp)
-			if err == nil {
-				parsed = true
-				break
-			}
-		}
-
-		if !parsed {
-			l.stdLogger.Printf("[WARN] Failed to parse timestamp '%s' with any known format", timestamp)
-			entry.Timestamp = time.Now() // Fallback to current time if parsing fails
-		}
-
-		logs = append(logs, entry)
-	}
-
-	if err := rows.Err(); err != nil {
-		l.stdLogger.Printf("[ERROR] Error iterating log rows: %v", err)
-		// Continue anyway, return what we have
-	}
-
-	return logs, nil
+	return l.scanLogRows(rows), nil
 }
 
 // GetRecentLogs returns the most recent logs, optionally filtered by level
@@ -495,7 +936,7 @@ func (l *Logger) GetRecentLogs(limit int, level string, since time.Time) ([]LogE
 	}
 
 	// Build the query
-	query := "SELECT id, timestamp, level, message, source FROM logs WHERE 1=1"
+	query := "SELECT id, CAST(timestamp AS TEXT), level, message, source FROM logs WHERE 1=1"
 	args := []interface{}{}
 
 	// Add filters
@@ -506,7 +947,7 @@ func (l *Logger) GetRecentLogs(limit int, level string, since time.Time) ([]LogE
 
 	if !since.IsZero() {
 		query += " AND timestamp > ?"
-		args = append(args, since.UTC())
+		args = append(args, formatTimestamp(since))
 	}
 
 	// Add ordering and limits
@@ -546,7 +987,12 @@ func (l *Logger) GetRecentLogs(limit int, level string, since time.Time) ([]LogE
 	}
 	defer rows.Close()
 
-	// Parse the results
+	return l.scanLogRows(rows), nil
+}
+
+// scanLogRows reads the entries of a logs query. Rows that fail to scan are
+// skipped, rows whose timestamp can not be read are kept and marked.
+func (l *Logger) scanLogRows(rows *sql.Rows) []LogEntry {
 	logs := []LogEntry{}
 	for rows.Next() {
 		var entry LogEntry
@@ -557,26 +1003,11 @@ func (l *Logger) GetRecentLogs(limit int, level string, since time.Time) ([]LogE
 			continue // Skip this entry but continue processing others
 		}
 
-		// Parse the timestamp - try multiple formats
-		formats := []string{
-			time.RFC3339Nano,                    // ISO 8601 with nanoseconds (e.g., "2025-08-27T20:37:20.055403996Z")
-			"2006-01-02T15:04:05.999999999Z07:00", // ISO 8601 with nanoseconds and explicit timezone
-			"2006-01-02 15:04:05.999999999Z07:00", // Space separator with nanoseconds
-			"2006-01-02 15:04:05.999999999-07:00", // Original format
-		}
-
-		parsed := false
-		for _, format := range formats {
-			entry.Timestamp, err = time.Parse(format, timestamp)
-			if err == nil {
-				parsed = true
-				break
-			}
-		}
-
-		if !parsed {
-			l.stdLogger.Printf("[WARN] Failed to parse timestamp '%s' with any known format", timestamp)
-			entry.Timestamp = time.Now() // Fallback to current time if parsing fails
+		entry.Timestamp, err = parseTimestamp(timestamp)
+		if err != nil {
+			l.stdLogger.Printf("[WARN] Log entry %d has an unreadable timestamp %q: %v", entry.ID, timestamp, err)
+			entry.Timestamp = time.Time{}
+			entry.BadTimestamp = true
 		}
 
 		logs = append(logs, entry)
@@ -586,8 +1017,7 @@ func (l *Logger) GetRecentLogs(limit int, level string, since time.Time) ([]LogE
 		l.stdLogger.Printf("[ERROR] Error iterating log rows: %v", err)
 		// Continue anyway, return what we have
 	}
-
-	return logs, nil
+	return logs
 }
 
 // GetLogCount returns the total number of logs matching the given filters
@@ -613,12 +1043,12 @@ func (l *Logger) GetLogCount(level string, startTime, endTime time.Time) (int, e
 
 	if !startTime.IsZero() {
 		query += " AND timestamp >= ?"
-		args = append(args, startTime.UTC())
+		args = append(args, formatTimestamp(startTime))
 	}
 
 	if !endTime.IsZero() {
 		query += " AND timestamp <= ?"
-		args = append(args, endTime.UTC())
+		args = append(args, formatTimestamp(endTime))
 	}
 
 	// Execute the query with retry logic
@@ -678,12 +1108,12 @@ func (l *Logger) DeleteLogs(level string, startTime, endTime time.Time) (int64,
 
 	if !startTime.IsZero() {
 		query += " AND timestamp >= ?"
-		args = append(args, startTime.UTC())
+		args = append(args, formatTimestamp(startTime))
 	}
 
 	if !endTime.IsZero() {
 		query += " AND timestamp <= ?"
-		args = append(args, endTime.UTC())
+		args = append(args, formatTimestamp(endTime))
 	}
 
 	// Execute the query with retry logic
@@ -725,6 +1155,7 @@ func (l *Logger) DeleteLogs(level string, startTime, endTime time.Time) (int64,
 	}
 
 	l.stdLogger.Printf("[INFO] Deleted %d log entries", rowsAffected)
+	l.compactAfterDelete(rowsAffected)
 	return rowsAffected, nil
 }
 
@@ -790,5 +1221,6 @@ func (l *Logger) DeleteLogsByID(ids []int64) (int64, error) {
 	}
 
 	l.stdLogger.Printf("[INFO] Deleted %d log entries by ID", rowsAffected)
+	l.compactAfterDelete(rowsAffected)
 	return rowsAffected, nil
 }
@@ -0,0 +1,462 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestLogger returns a logger backed by a database in a temp directory
+func newTestLogger(t *testing.T) *Logger {
+	t.Helper()
+	l := &Logger{
+		stdLogger:   log.New(io.Discard, "", 0),
+		dedupWindow: DefaultDedupWindow,
+	}
+	if err := l.Initialize(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestLoggerCollapsesRepeats(t *testing.T) {
+	l := newTestLogger(t)
+
+	for i := 0; i < 100; i++ {
+		l.Error("Failed to connect to remote server %s: %v", "127.0.0.1:25565", "connection refused")
+	}
+	l.Info("backend is back")
+
+	count, err := l.GetLogCount("", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("got %d rows for 100 identical messages, want 3", count)
+	}
+
+	logs, err := l.GetLogs(10, 0, "ERROR", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, entry := range logs {
+		if entry.Message == "Failed to connect to remote server 127.0.0.1:25565: connection refused (repeated 99 times)" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no repeated summary in %+v", logs)
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	l := &Logger{}
+	l.SetLevel(WARN)
+
+	var out strings.Builder
+	std := log.New(l.LevelFilter(&out), "", log.Ldate|log.Ltime|log.Lshortfile)
+	std.Printf("[DEBUG] dropped")
+	std.Printf("[INFO] dropped [ERROR]")
+	std.Printf("[WARN] kept")
+	std.Printf("untagged")
+
+	got := out.String()
+	if strings.Contains(got, "dropped") || !strings.Contains(got, "[WARN] kept") || !strings.Contains(got, "untagged") {
+		t.Errorf("filtered output = %q", got)
+	}
+
+	// the level set at runtime applies at once
+	l.SetLevel(DEBUG)
+	std.Printf("[DEBUG] now kept")
+	if !strings.Contains(out.String(), "now kept") {
+		t.Errorf("debug line dropped after lowering the level: %q", out.String())
+	}
+}
+
+func TestLoggerTimestampRoundTrip(t *testing.T) {
+	l := newTestLogger(t)
+
+	before := time.Now()
+	l.Info("round trip")
+	after := time.Now()
+
+	// rows written by the driver itself before timestamps were formatted
+	// explicitly read the same
+	legacy := time.Date(2025, 8, 27, 20, 37, 20, 55403996, time.UTC)
+	if _, err := l.db.Exec("INSERT INTO logs (timestamp, level, message, source) VALUES (?, ?, ?, ?)",
+		legacy, "INFO", "legacy", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.db.Exec("INSERT INTO logs (timestamp, level, message, source) VALUES (?, ?, ?, ?)",
+		"yesterday", "INFO", "broken", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := l.GetLogs(10, 0, "", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]LogEntry)
+	for _, entry := range logs {
+		got[entry.Message] = entry
+	}
+
+	written := got["round trip"].Timestamp
+	if written.Before(before.Truncate(time.Second)) || written.After(after) || written.Location() != time.UTC {
+		t.Errorf("timestamp = %v, written between %v and %v", written, before, after)
+	}
+	if ts := got["legacy"].Timestamp; !ts.Equal(legacy) {
+		t.Errorf("legacy timestamp = %v, want %v", ts, legacy)
+	}
+	if broken := got["broken"]; !broken.BadTimestamp || !broken.Timestamp.IsZero() {
+		t.Errorf("unreadable timestamp read as %v, flagged %v", broken.Timestamp, broken.BadTimestamp)
+	}
+
+	// filters compare with the stored format
+	recent, err := l.GetRecentLogs(10, "INFO", legacy.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range recent {
+		if entry.Message == "legacy" {
+			t.Errorf("recent logs include an entry older than the filter: %+v", entry)
+		}
+		if entry.Message == "round trip" && !entry.Timestamp.Truncate(time.Second).Equal(written.Truncate(time.Second)) {
+			t.Errorf("recent timestamp = %v, want %v", entry.Timestamp, written)
+		}
+	}
+	if len(recent) != 2 {
+		t.Errorf("recent logs = %+v, want the round trip and the unreadable entry", recent)
+	}
+}
+
+func TestLoggerFlushesRepeatsAfterWindow(t *testing.T) {
+	l := newTestLogger(t)
+	l.SetDedupWindow(50 * time.Millisecond)
+
+	// the outage ends quietly, the summary is still written
+	for i := 0; i < 3; i++ {
+		l.Error("backend unreachable")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		logs, err := l.GetLogs(10, 0, "ERROR", time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) == 2 && logs[0].Message == "backend unreachable (repeated 2 times)" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no repeated summary after the window: %+v", logs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoggerDedupWindow(t *testing.T) {
+	l := newTestLogger(t)
+	l.SetDedupWindow(0)
+
+	for i := 0; i < 5; i++ {
+		l.Warn("same message")
+	}
+
+	count, err := l.GetLogCount("", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("got %d rows with deduplication disabled, want 5", count)
+	}
+}
+
+func TestLoggerCompact(t *testing.T) {
+	l := newTestLogger(t)
+
+	padding := strings.Repeat("x", 1024)
+	for i := 0; i < 2000; i++ {
+		l.Info("message %d %s", i, padding)
+	}
+	if _, err := l.DeleteLogs("", time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := l.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.After >= result.Before {
+		t.Errorf("compaction grew the database from %d to %d bytes", result.Before, result.After)
+	}
+	if result.After > 100*1024 {
+		t.Errorf("database is %d bytes after compaction, want it near empty", result.After)
+	}
+	if size := l.dbSize(); size != result.After {
+		t.Errorf("reported %d bytes, file is %d", result.After, size)
+	}
+}
+
+func TestLoggerStorageOptions(t *testing.T) {
+	l := &Logger{stdLogger: log.New(io.Discard, "", 0)}
+	err := l.InitializeWithOptions(filepath.Join(t.TempDir(), "test.db"), StorageOptions{JournalMode: "delete", Synchronous: "off"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// every connection of the pool uses the configured pragmas
+	for i := 0; i < 3; i++ {
+		var journalMode string
+		var synchronous int
+		if err := l.db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.db.QueryRow("PRAGMA synchronous").Scan(&synchronous); err != nil {
+			t.Fatal(err)
+		}
+		if journalMode != "delete" || synchronous != 0 {
+			t.Errorf("journal_mode = %s, synchronous = %d, want delete and 0 (OFF)", journalMode, synchronous)
+		}
+	}
+
+	l.Info("written without a WAL")
+	if count, err := l.GetLogCount("", time.Time{}, time.Time{}); err != nil || count != 1 {
+		t.Errorf("count = %d, %v", count, err)
+	}
+}
+
+func TestLoggerStorageOptionsValidation(t *testing.T) {
+	for _, opts := range []StorageOptions{{JournalMode: "fast"}, {Synchronous: "sometimes"}} {
+		l := &Logger{stdLogger: log.New(io.Discard, "", 0)}
+		if err := l.InitializeWithOptions(filepath.Join(t.TempDir(), "test.db"), opts); err == nil {
+			l.Close()
+			t.Errorf("%+v accepted", opts)
+		}
+	}
+}
+
+func TestLoggerStatus(t *testing.T) {
+	l := &Logger{stdLogger: log.New(io.Discard, "", 0)}
+	if mode, err := l.Status(); mode != ModeFailed || err == nil {
+		t.Errorf("uninitialized: %s, %v", mode, err)
+	}
+
+	if mode, err := newTestLogger(t).Status(); mode != ModeDisk || err != nil {
+		t.Errorf("disk: %s, %v", mode, err)
+	}
+
+	// a directory can not be opened as the database, so logs go to memory
+	fallback := &Logger{stdLogger: log.New(io.Discard, "", 0)}
+	if err := fallback.Initialize(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer fallback.Close()
+	mode, err := fallback.Status()
+	if mode != ModeMemory || err == nil {
+		t.Fatalf("fallback: %s, %v", mode, err)
+	}
+
+	// the in-memory database still keeps logs until the restart
+	fallback.Info("kept in memory")
+	if logs, _ := fallback.GetLogs(10, 0, "", time.Time{}, time.Time{}); len(logs) != 1 {
+		t.Errorf("in-memory database has %d logs, want 1", len(logs))
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := CheckWritable(filepath.Join(dir, "logs", "mcproxy.db")); err != nil {
+		t.Errorf("new database: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "logs")); len(entries) != 0 {
+		t.Errorf("left %d files behind", len(entries))
+	}
+
+	existing := filepath.Join(dir, "existing.db")
+	if err := os.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckWritable(existing); err != nil {
+		t.Errorf("existing database: %v", err)
+	}
+
+	// a file where the directory should be
+	if err := CheckWritable(filepath.Join(existing, "mcproxy.db")); err == nil {
+		t.Error("expected an error for a directory that can not be created")
+	}
+}
+
+func TestAccessLogSourcePort(t *testing.T) {
+	entry := AccessEntry{
+		Time:       time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+		ClientIP:   "2001:db8::7",
+		ClientPort: "51000",
+		Username:   "Steve",
+		Proxy:      "0.0.0.0:25565",
+		Backend:    "mc.example.com:25565",
+		Reason:     "client closed",
+	}
+	tests := []struct {
+		opts AccessLogOptions
+		want string
+	}{
+		{AccessLogOptions{}, `2001:db8::7 - Steve`},
+		{AccessLogOptions{SourcePort: true}, `[2001:db8::7]:51000 - Steve`},
+		{AccessLogOptions{Format: AccessLogJSON}, `"client_ip":"2001:db8::7","username"`},
+		{AccessLogOptions{Format: AccessLogJSON, SourcePort: true}, `"client_ip":"2001:db8::7","client_port":"51000"`},
+	}
+	for _, tt := range tests {
+		line, err := (&AccessLog{opts: tt.opts}).format(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(line), tt.want) {
+			t.Errorf("%+v: line = %s, want %s", tt.opts, line, tt.want)
+		}
+	}
+}
+
+func TestAccessLogHostileUsername(t *testing.T) {
+	entry := AccessEntry{
+		Time:     time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+		ClientIP: "203.0.113.7",
+		Username: "Steve\" 200\n198.51.100.1 - Admin",
+		Proxy:    "0.0.0.0:25565",
+		Backend:  "mc.example.com:25565",
+		Reason:   "client closed",
+	}
+	line, err := (&AccessLog{}).format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(line), "\n"); n != 1 {
+		t.Errorf("line = %s, want one line", line)
+	}
+	if want := `203.0.113.7 - "Steve\" 200\n198.51.100.1 - Admin" [`; !strings.HasPrefix(string(line), want) {
+		t.Errorf("line = %s, want the username quoted", line)
+	}
+}
+
+func TestAccessLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	a, err := OpenAccessLog(path, AccessLogOptions{MaxSize: 200, MaxBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		err := a.Write(AccessEntry{
+			Time:     start.Add(time.Duration(i) * time.Second),
+			ClientIP: "203.0.113.7",
+			Username: "Steve",
+			Proxy:    "0.0.0.0:25565",
+			Backend:  "mc.example.com:25565",
+			BytesIn:  1200,
+			BytesOut: 56000,
+			Duration: 90 * time.Second,
+			Reason:   "client closed",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `203.0.113.7 - Steve [16/Oct/2026:12:00:04 +0000] "0.0.0.0:25565 -> mc.example.com:25565" 1200 56000 90.000 "client closed"` + "\n"
+	if string(content) != want {
+		t.Errorf("current file = %q, want only the last line %q", content, want)
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Errorf("backups = %v, want the newest one", backups)
+	} else if !strings.HasSuffix(backups[0], "20261016-120004.000") {
+		t.Errorf("backup = %s, want the one rotated at 12:00:04", backups[0])
+	}
+}
+
+func TestLoggerReopenKeepsDatabase(t *testing.T) {
+	l := newTestLogger(t)
+	dir := t.TempDir()
+
+	// the logger is never seen without a database while it moves
+	stop := make(chan struct{})
+	failed := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if mode, err := l.Status(); mode == ModeFailed {
+				failed <- err
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if err := l.Reopen(filepath.Join(dir, fmt.Sprintf("moved%d.db", i)), StorageOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		l.Info("moved %d", i)
+	}
+	close(stop)
+	wg.Wait()
+
+	select {
+	case err := <-failed:
+		t.Errorf("logger without a database during a move: %v", err)
+	default:
+	}
+	if count, err := l.GetLogCount("", time.Time{}, time.Time{}); err != nil || count != 1 {
+		t.Errorf("moved database holds %d rows, %v, want 1", count, err)
+	}
+}
+
+func TestLoggerReopenAppliesStorageOptions(t *testing.T) {
+	l := newTestLogger(t)
+	l.Info("before the reopen")
+
+	if err := l.Reopen(l.dbPath, StorageOptions{JournalMode: "delete", Synchronous: "full"}); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("after the reopen")
+
+	var journalMode string
+	var synchronous int
+	l.mutex.Lock()
+	err := l.db.QueryRow("PRAGMA journal_mode").Scan(&journalMode)
+	if err == nil {
+		err = l.db.QueryRow("PRAGMA synchronous").Scan(&synchronous)
+	}
+	l.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// synchronous FULL is 2
+	if journalMode != "delete" || synchronous != 2 {
+		t.Errorf("journal_mode = %s, synchronous = %d, want delete and 2", journalMode, synchronous)
+	}
+	if count, err := l.GetLogCount("", time.Time{}, time.Time{}); err != nil || count != 2 {
+		t.Errorf("reopened database holds %d rows, %v, want 2", count, err)
+	}
+}
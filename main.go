@@ -18,23 +18,59 @@ func main() {
 	log.SetOutput(os.Stdout)
 	log.Printf("[INFO] gomcproxy (version %s) starting up", version)
 
-	configPath := flag.String("config", "config.json", "path to config.json")
+	configPath := flag.String("config", "config.json", "path to config.json, or a directory or glob of config files")
 	controlPanelAddr := flag.String("control", "0.0.0.0:8080", "control panel address")
+	metricsAddr := flag.String("metrics", "", "address for /metrics and /healthz, defaults to the control panel address")
 	balancerAddr := flag.String("balancer", "", "load balancer address (e.g., 0.0.0.0:25565)")
+	selfTest := flag.Bool("selftest", false, "check the listen addresses, remotes and log database, then exit")
 	flag.Parse()
 
 	startTime := time.Now()
 	cfg := config.ParseConfig(*configPath)
 	log.Printf("[INFO] Configuration loaded in %v", time.Since(startTime))
 
+	// The self-test binds the listen addresses, so it runs before the proxies
+	if *selfTest {
+		if !core.LogSelfTest(core.RunSelfTest(cfg)) {
+			os.Exit(1)
+		}
+		return
+	}
+	if cfg.SelfTestOnStartup {
+		core.LogSelfTest(core.RunSelfTest(cfg))
+	}
+
 	// Initialize the logger
 	l := logger.GetLogger()
-	err := l.Initialize(cfg.Logging.DBPath)
+	err := l.InitializeWithOptions(cfg.Logging.DBPath, logger.StorageOptions{
+		JournalMode: cfg.Logging.JournalMode,
+		Synchronous: cfg.Logging.Synchronous,
+	})
 	if err != nil {
 		log.Fatalf("[ERROR] Failed to initialize logger: %v", err)
 	}
 	defer l.Close()
 
+	if cfg.Logging.Level != "" {
+		level, err := logger.ParseLogLevel(cfg.Logging.Level)
+		if err != nil {
+			l.Warn("Ignoring logging level: %v", err)
+		} else {
+			l.SetLevel(level)
+		}
+	}
+	// log.Printf lines follow the level too, including changes made from the
+	// control panel
+	log.SetOutput(l.LevelFilter(os.Stdout))
+
+	if cfg.Logging.CompactIntervalHours > 0 {
+		l.StartCompaction(time.Duration(cfg.Logging.CompactIntervalHours) * time.Hour)
+	}
+
+	if cfg.Logging.CheckpointIntervalSeconds > 0 {
+		l.StartCheckpoints(time.Duration(cfg.Logging.CheckpointIntervalSeconds) * time.Second)
+	}
+
 	l.Info("gomcproxy (version %s) starting up with SQLite logging", version)
 
 	// Initialize the control panel
@@ -42,7 +78,9 @@ func main() {
 
 	// Start the control panel
 	l.Info("Starting control panel on %s", *controlPanelAddr)
-	core.StartControlPanel(*controlPanelAddr)
+	if err := core.StartControlPanel(*controlPanelAddr, *metricsAddr); err != nil {
+		l.Fatal("Failed to start control panel: %v", err)
+	}
 
 	// If balancer address is provided, start the load balancer
 	if *balancerAddr != "" {